@@ -1,17 +1,93 @@
 package main
 
 import (
+	"edge/internal/constant"
+	"edge/internal/edge-registry/option"
 	"edge/internal/edge-registry/server"
+	"edge/pkg/grpcauth"
 	"edge/pkg/util"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
 
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	clientCAFile  = flag.String("client-ca-file", "", "CA to verify client certificates, clients without a certificate signed by it are rejected")
+	tlsCertFile   = flag.String("tls-cert-file", "", "server certificate, serve in plaintext if empty")
+	tlsKeyFile    = flag.String("tls-private-key-file", "", "server private key")
+	tokenAuthFile = flag.String("token-auth-file", "", "file containing the bearer token required from clients")
+
+	signingCertFile = flag.String("cluster-signing-cert-file", "", "CA certificate to sign node certificates requested on join")
+	signingKeyFile  = flag.String("cluster-signing-key-file", "", "CA private key to sign node certificates requested on join")
+
+	autoApproveNodes = flag.String("auto-approve-nodes", "", "comma separated node name patterns, e.g. 'shop-*', joined nodes matching them are approved without review")
+
+	vkTemplateFile    = flag.String("vk-template-file", "", "virtual-kubelet manifest template, usually mounted from a ConfigMap, the built-in template is used if empty or missing")
+	vkImage           = flag.String("vk-image", option.DefaultVirtualKubeletImage, "virtual-kubelet image")
+	vkImagePullPolicy = flag.String("vk-image-pull-policy", string(corev1.PullAlways), "virtual-kubelet image pull policy")
+	vkDNSServers      = flag.String("vk-dns-servers", option.DefaultVirtualKubeletDNSServer, "comma separated nameservers of virtual-kubelet, use the cluster DNS if empty")
+	vkRequests        = flag.String("vk-requests", "", "resource requests of virtual-kubelet, e.g. 'cpu=100m,memory=128Mi'")
+	vkLimits          = flag.String("vk-limits", "", "resource limits of virtual-kubelet, e.g. 'cpu=500m,memory=512Mi'")
+	vkTolerations     = flag.String("vk-tolerations", "", "comma separated tolerations of virtual-kubelet, e.g. 'dedicated=edge:NoSchedule,node-role.kubernetes.io/master:NoSchedule'")
 )
 
 func main() {
-	er, err := server.CreateEdgeRegistry(util.SetupSignalHandler())
+	flag.Parse()
+	auth := grpcauth.Config{
+		CAFile:   *clientCAFile,
+		CertFile: *tlsCertFile,
+		KeyFile:  *tlsKeyFile,
+	}
+	if *tokenAuthFile != "" {
+		token, err := ioutil.ReadFile(*tokenAuthFile)
+		if err != nil {
+			logrus.Fatal("read token-auth-file failed,err=", err)
+		}
+		auth.Token = strings.TrimSpace(string(token))
+	}
+	vk, err := virtualKubeletOptions()
+	if err != nil {
+		logrus.Fatal("invalid virtual-kubelet options,err=", err)
+	}
+	er, err := server.CreateEdgeRegistry(util.SetupSignalHandler(),
+		option.WithAuth(auth),
+		option.WithSigner(*signingCertFile, *signingKeyFile),
+		option.WithAutoApproveNodePatterns(option.ParseList(*autoApproveNodes)),
+		option.WithVirtualKubelet(vk),
+	)
 	if err != nil {
 		logrus.Fatal("CreateEdgeRegistry failed,err=", err)
 	}
 	//er.Run(":80")
+	go er.RunTunnel(constant.TunnelDefaultAddress)
 	er.RunGrpc(":80")
 }
+
+func virtualKubeletOptions() (option.VirtualKubeletOptions, error) {
+	vk := option.VirtualKubeletOptions{
+		TemplateFile:    *vkTemplateFile,
+		Image:           *vkImage,
+		ImagePullPolicy: corev1.PullPolicy(*vkImagePullPolicy),
+		DNSServers:      option.ParseList(*vkDNSServers),
+	}
+	switch vk.ImagePullPolicy {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+	default:
+		return vk, fmt.Errorf("unknown image pull policy %s", vk.ImagePullPolicy)
+	}
+	var err error
+	if vk.Resources.Requests, err = option.ParseResourceList(*vkRequests); err != nil {
+		return vk, err
+	}
+	if vk.Resources.Limits, err = option.ParseResourceList(*vkLimits); err != nil {
+		return vk, err
+	}
+	if vk.Tolerations, err = option.ParseTolerations(*vkTolerations); err != nil {
+		return vk, err
+	}
+	return vk, nil
+}
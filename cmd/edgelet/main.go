@@ -3,11 +3,18 @@ package main
 import (
 	"edge/internal/constant"
 	"edge/internal/edgelet"
+	"edge/internal/edgelet/upgrade"
+	"edge/pkg/common"
 	"flag"
+	"os"
+
+	"github.com/sirupsen/logrus"
 )
 
 var (
 	listenAddr = flag.String("address", constant.EdgeletDefaultAddress, "edgelet listen address, default is "+constant.EdgeletDefaultAddress)
+	//升级时由edgelet通过systemd-run启动, 不需要手动使用
+	upgradeWatch = flag.String("upgrade-watch", "", "internal: watch the upgrade recorded in the state file and roll back if the new version is not healthy")
 )
 
 var (
@@ -16,5 +23,21 @@ var (
 
 func main() {
 	flag.Parse()
+	if *upgradeWatch != "" {
+		watchUpgrade(*upgradeWatch)
+		return
+	}
 	edgelet.Run(*listenAddr, buildVersion)
 }
+
+func watchUpgrade(statePath string) {
+	common.InitLogger()
+	m, err := upgrade.NewManager(constant.EdgeletUpgradePath, "", 0)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := m.Watch(statePath); err != nil {
+		logrus.Error(err)
+		os.Exit(1)
+	}
+}
@@ -6,15 +6,22 @@ const (
 )
 
 const (
-	DockerCopyEdgeletCmd = "docker run --rm -v /root:/myapp %s cp /usr/local/bin/edgelet /myapp"
-	UpdageEdgeletCmd     = "mv /root/edgelet /usr/bin/ && systemctl restart edgelet"
-
-	DockerCopyEdgectlCmd = "docker run --rm -v /root:/myapp %s cp /usr/local/bin/edgectl /myapp"
-	UpdateEdgectlCmd     = "mv /root/edgectl /usr/bin/"
+	EdgeletBinaryPath = "/usr/bin/edgelet"
+	EdgectlBinaryPath = "/usr/bin/edgectl"
+	RestartEdgeletCmd = "systemctl restart edgelet"
+	//升级的暂存目录, 同时保存升级的状态
+	EdgeletUpgradePath = "/data/edgelet/upgrade"
+	//节点上允许远程执行的action的配置文件, 以及每次执行的审计日志
+	EdgeletActionsFile    = "/data/edgelet/.conf/actions.json"
+	EdgeletActionAuditLog = "/data/edgelet/audit/actions.log"
 )
 
 const (
 	EdgeletDefaultAddress = ":10350"
-	EdgeletDurablePath    = "/data/edgelet/"
-	EdgeletCfgPath        = "/data/edgelet/.conf"
+	//edge-registry接受edgelet反向通道的地址
+	TunnelDefaultAddress = ":10351"
+	EdgeletDurablePath   = "/data/edgelet/"
+	EdgeletCfgPath       = "/data/edgelet/.conf"
+	//本机edgectl访问edgelet的unix socket, 只有root可以访问, 不需要证书和token
+	EdgeletSocket = "/var/run/edgelet.sock"
 )
@@ -3,6 +3,10 @@ package manifests
 const VIRTUAL_KUBELET = "virtual_kubelet.yaml"
 
 //因为virtual-kubelet需要和apiserver进行交互，创建Node
+//edge-registry没有配置模板文件时使用, 自定义模板可以使用的参数:
+//  .NodeName .Image .ImagePullPolicy .DNSServers(列表)
+//  .Resources .Tolerations .Labels .Taints(json, 可以直接作为yaml的值)
+//  .Params(节点join时传入的自定义参数, 使用 {{index .Params "key"}})
 const VirtualKubeletYaml = `
 ---
 apiVersion: apps/v1
@@ -12,6 +16,9 @@ metadata:
   namespace: edge-cluster
   labels:
     k8s-app: vk-{{.NodeName}}
+  annotations:
+    edge.io/node-labels: {{printf "%q" .Labels}}
+    edge.io/node-taints: {{printf "%q" .Taints}}
 spec:
   replicas: 1
   selector:
@@ -24,14 +31,15 @@ spec:
     spec:
       containers:
       - name: virtual-kubelet
-        image: registry.edge.com/cloud-native/virtual-kubelet:latest
+        image: {{.Image}}
         command:
         - /home/virtual-kubelet
         args:
         - --nodename={{.NodeName}}
         - --provider-config=/home/vk-config/cci.toml
         - --provider=edge
-        imagePullPolicy: Always
+        imagePullPolicy: {{.ImagePullPolicy}}
+        resources: {{.Resources}}
         env:
         - name: CLUSTER_POD_IP
           valueFrom:
@@ -46,10 +54,15 @@ spec:
             mountPath: /home/vk-config
           - name: localtime
             mountPath: /etc/localtime    
+      {{- if .DNSServers}}
       dnsPolicy: None
       dnsConfig:
         nameservers:
-        - 10.96.0.12
+        {{- range .DNSServers}}
+        - {{.}}
+        {{- end}}
+      {{- end}}
+      tolerations: {{.Tolerations}}
       volumes:
       - name: kube-config
         hostPath:
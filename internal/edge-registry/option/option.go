@@ -1,14 +1,56 @@
 package option
 
+import (
+	"edge/pkg/grpcauth"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
 type EdgeRegistryOption interface {
 	apply(*EdgeRegistryOptions)
 }
 
 type EdgeRegistryOptions struct {
+	//grpc, REST和tunnel的证书和token, 证书为空时使用明文
+	Auth grpcauth.Config
+	//签发节点证书的CA, 为空时join不能申请证书
+	SigningCertFile string
+	SigningKeyFile  string
+	//名称匹配这些规则(path.Match格式)的节点join时不需要审批
+	AutoApproveNodePatterns []string
+	//为每个节点创建的virtual-kubelet
+	VirtualKubelet VirtualKubeletOptions
+}
+
+//VirtualKubeletOptions 渲染virtual-kubelet模板的参数, 对所有节点生效
+type VirtualKubeletOptions struct {
+	//模板文件, 通常挂载自ConfigMap, 为空或不存在时使用内置模板
+	TemplateFile    string
+	Image           string
+	ImagePullPolicy corev1.PullPolicy
+	Resources       corev1.ResourceRequirements
+	//为空时使用集群的DNS
+	DNSServers  []string
+	Tolerations []corev1.Toleration
 }
 
-func NewDefaultOptions() *EdgeRegistryOptions {
-	return &EdgeRegistryOptions{}
+const (
+	DefaultVirtualKubeletImage     = "registry.edge.com/cloud-native/virtual-kubelet:latest"
+	DefaultVirtualKubeletDNSServer = "10.96.0.12"
+)
+
+func NewDefaultOptions(opts ...EdgeRegistryOption) *EdgeRegistryOptions {
+	eo := &EdgeRegistryOptions{
+		VirtualKubelet: VirtualKubeletOptions{
+			Image:           DefaultVirtualKubeletImage,
+			ImagePullPolicy: corev1.PullAlways,
+			DNSServers:      []string{DefaultVirtualKubeletDNSServer},
+		},
+	}
+	for _, opt := range opts {
+		opt.apply(eo)
+	}
+	return eo
 }
 
 type funcServerOption struct {
@@ -24,3 +66,28 @@ func newFuncServerOption(f func(*EdgeRegistryOptions)) *funcServerOption {
 		f: f,
 	}
 }
+
+func WithAuth(auth grpcauth.Config) EdgeRegistryOption {
+	return newFuncServerOption(func(eo *EdgeRegistryOptions) {
+		eo.Auth = auth
+	})
+}
+
+func WithSigner(certFile, keyFile string) EdgeRegistryOption {
+	return newFuncServerOption(func(eo *EdgeRegistryOptions) {
+		eo.SigningCertFile = certFile
+		eo.SigningKeyFile = keyFile
+	})
+}
+
+func WithAutoApproveNodePatterns(patterns []string) EdgeRegistryOption {
+	return newFuncServerOption(func(eo *EdgeRegistryOptions) {
+		eo.AutoApproveNodePatterns = patterns
+	})
+}
+
+func WithVirtualKubelet(vk VirtualKubeletOptions) EdgeRegistryOption {
+	return newFuncServerOption(func(eo *EdgeRegistryOptions) {
+		eo.VirtualKubelet = vk
+	})
+}
@@ -0,0 +1,77 @@
+package option
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+//ParseList 解析逗号分隔的命令行参数, 忽略空项
+func ParseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+//ParseResourceList 格式为 cpu=100m,memory=128Mi
+func ParseResourceList(s string) (corev1.ResourceList, error) {
+	items := ParseList(s)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	rl := corev1.ResourceList{}
+	for _, item := range items {
+		name, value, ok := cut(item, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid resource %q, must be name=quantity", item)
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource %q, err=%v", item, err)
+		}
+		rl[corev1.ResourceName(name)] = q
+	}
+	return rl, nil
+}
+
+//ParseTolerations 格式与kubectl taint相同: key=value:Effect 或 key:Effect, 多个用逗号分隔
+//没有value时容忍该key的所有taint, effect为空时容忍所有effect
+func ParseTolerations(s string) ([]corev1.Toleration, error) {
+	var tolerations []corev1.Toleration
+	for _, item := range ParseList(s) {
+		kv, effect, _ := cut(item, ":")
+		key, value, hasValue := cut(kv, "=")
+		t := corev1.Toleration{
+			Key:      key,
+			Operator: corev1.TolerationOpExists,
+			Effect:   corev1.TaintEffect(effect),
+		}
+		if hasValue {
+			t.Operator = corev1.TolerationOpEqual
+			t.Value = value
+		}
+		switch t.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("invalid toleration %q, unknown effect %s", item, effect)
+		}
+		if t.Key == "" && t.Operator == corev1.TolerationOpEqual {
+			return nil, fmt.Errorf("invalid toleration %q, key is required with a value", item)
+		}
+		tolerations = append(tolerations, t)
+	}
+	return tolerations, nil
+}
+
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/pkg/errdefs"
+	"edge/pkg/grpcauth"
+	"edge/pkg/protoerr"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/kubernetes"
+)
+
+//isNodeIdentity 调用者是否使用edge-registry签发的节点证书
+func isNodeIdentity(ctx context.Context) (string, bool) {
+	return grpcauth.PeerNodeName(ctx)
+}
+
+//requireAdmin 节点证书只能用于节点自己的join/reset, 不能管理token和审批节点
+func requireAdmin(ctx context.Context) error {
+	if nodeName, ok := isNodeIdentity(ctx); ok {
+		return status.Errorf(codes.PermissionDenied, "node %s is not allowed to call this method", nodeName)
+	}
+	return nil
+}
+
+//authorizeNode 使用节点证书时只能操作证书对应的节点
+func authorizeNode(ctx context.Context, nodeName string) error {
+	if certNode, ok := isNodeIdentity(ctx); ok && certNode != nodeName {
+		return status.Errorf(codes.PermissionDenied, "node %s is not allowed to operate node %s", certNode, nodeName)
+	}
+	return nil
+}
+
+//validateNodeSpec 校验join时指定的节点标签, taint和virtual-kubelet模板的参数
+func validateNodeSpec(req *pb.CreateNodeRequest) error {
+	if err := validateNodeLabels(req.Labels); err != nil {
+		return err
+	}
+	if err := validateNodeParams(req.Params); err != nil {
+		return err
+	}
+	_, err := nodeTaints(req.Taints)
+	return err
+}
+
+//useJoinToken bootstrap token只能注册新的节点, 不能用来冒充已经注册的节点.
+//等待审批的节点没有证书, 使用第一次join时的私钥再次join, 此时不再消耗token的次数
+func useJoinToken(ctx context.Context, cs kubernetes.Interface, req *pb.CreateNodeRequest, fingerprint string) (*bootstrapToken, error) {
+	r, err := getRegistration(ctx, cs, req.NodeName)
+	if err == nil {
+		if fingerprint != "" && r.KeyFingerprint == fingerprint {
+			return nil, nil
+		}
+		return nil, errdefs.InvalidInputf("node %s has already registered, join with the node certificate or delete the node first", req.NodeName)
+	}
+	if !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+	if existEdgeNode(ctx, cs, req.NodeName) || existVirtualKubelet(ctx, cs, req.NodeName) {
+		return nil, errdefs.InvalidInputf("node %s already exists, join with the node certificate or delete the node first", req.NodeName)
+	}
+	return useBootstrapToken(ctx, cs, req.Token)
+}
+
+//registerNode 记录节点的信息并决定审批状态
+//升级前已经join的节点和匹配自动审批规则的节点直接通过, 其他新节点等待审批
+func (e *EdgeRegistryServer) registerNode(ctx context.Context, req *pb.CreateNodeRequest, token *bootstrapToken, fingerprint string) (*nodeRegistration, error) {
+	cs := k8sClient()
+	r, err := getRegistration(ctx, cs, req.NodeName)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+		r = &nodeRegistration{
+			NodeName:    req.NodeName,
+			State:       NodePending,
+			RequestedAt: time.Now(),
+		}
+		if existEdgeNode(ctx, cs, req.NodeName) || existVirtualKubelet(ctx, cs, req.NodeName) {
+			r.State = NodeApproved
+			r.Reason = "registered before approval was required"
+		}
+	}
+	if r.State == NodePending {
+		switch {
+		case token != nil && token.AutoApprove:
+			r.State = NodeApproved
+			r.Reason = fmt.Sprintf("auto-approved by bootstrap token %s", token.ID)
+		case matchNodePatterns(e.edgeConfig.AutoApproveNodePatterns, req.NodeName):
+			r.State = NodeApproved
+			r.Reason = "auto-approved by node name pattern"
+		}
+	}
+	r.IP = req.Ip
+	r.OS = req.Os
+	r.Arch = req.Arch
+	r.EdgeletVersion = req.EdgeletVersion
+	if fingerprint != "" {
+		r.KeyFingerprint = fingerprint
+	}
+	//以节点最近一次join的参数为准
+	taints, err := nodeTaints(req.Taints)
+	if err != nil {
+		return nil, err
+	}
+	r.Labels = req.Labels
+	r.Taints = taints
+	r.Params = req.Params
+	if err := saveRegistration(ctx, cs, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (e *EdgeRegistryServer) ListRegistrations(ctx context.Context, req *pb.ListRegistrationsRequest) (*pb.ListRegistrationsResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.ListRegistrationsResponse{}
+	registrations, err := listRegistrations(ctx, k8sClient(), req.State)
+	if err != nil {
+		logrus.Error("listRegistrations failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	for _, r := range registrations {
+		resp.Registrations = append(resp.Registrations, &pb.NodeRegistration{
+			NodeName:       r.NodeName,
+			Ip:             r.IP,
+			Os:             r.OS,
+			Arch:           r.Arch,
+			EdgeletVersion: r.EdgeletVersion,
+			State:          r.State,
+			Reason:         r.Reason,
+			RequestedAt:    r.RequestedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	return resp, nil
+}
+
+//ApproveNode 审批通过后创建virtual-kubelet, 节点不需要重新join
+func (e *EdgeRegistryServer) ApproveNode(ctx context.Context, req *pb.ApproveNodeRequest) (*pb.ApproveNodeResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.ApproveNodeResponse{}
+	r, err := getRegistration(ctx, k8sClient(), req.NodeName)
+	if err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	r.State = NodeApproved
+	r.Reason = "approved"
+	if err := saveRegistration(ctx, k8sClient(), r); err != nil {
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	if _, err := e.createEdgeNode(ctx, r); err != nil {
+		logrus.Error("createEdgeNode failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	e.applyNodeSpecAsync(r)
+	logrus.Infof("node %s approved", req.NodeName)
+	return resp, nil
+}
+
+//RejectNode 拒绝的节点再次join时直接返回错误, 已经创建的virtual-kubelet会被删除
+func (e *EdgeRegistryServer) RejectNode(ctx context.Context, req *pb.RejectNodeRequest) (*pb.RejectNodeResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.RejectNodeResponse{}
+	r, err := getRegistration(ctx, k8sClient(), req.NodeName)
+	if err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	if r.State == NodeApproved {
+		if err := e.removeEdgeNode(ctx, req.NodeName); err != nil {
+			logrus.Error("removeEdgeNode failed,err=", err)
+			resp.Error = protoerr.InternalErr(err)
+			return resp, nil
+		}
+	}
+	r.State = NodeRejected
+	r.Reason = req.Reason
+	if r.Reason == "" {
+		r.Reason = "rejected"
+	}
+	if err := saveRegistration(ctx, k8sClient(), r); err != nil {
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	logrus.Infof("node %s rejected, reason=%s", req.NodeName, r.Reason)
+	return resp, nil
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_useJoinToken(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "approved"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-creating", Namespace: constant.EdgeNameSpace}},
+	)
+	token, err := createBootstrapToken(ctx, cs, time.Hour, 0, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pendingKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	pendingCSR := newTestCSRWithKey(t, "pending", pendingKey)
+	fingerprint, err := csrKeyFingerprint(pendingCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := saveRegistration(ctx, cs, &nodeRegistration{NodeName: "pending", State: NodePending, KeyFingerprint: fingerprint}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		nodeName  string
+		csr       []byte
+		ok        bool
+		wantToken bool
+	}{
+		{"new node", "new", newTestCSR(t, "new"), true, true},
+		{"existing node", "approved", newTestCSR(t, "approved"), false, false},
+		{"existing virtual-kubelet", "creating", newTestCSR(t, "creating"), false, false},
+		{"pending node with the same key", "pending", newTestCSRWithKey(t, "pending", pendingKey), true, false},
+		{"pending node with another key", "pending", newTestCSR(t, "pending"), false, false},
+		{"pending node without csr", "pending", nil, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fingerprint string
+			if tt.csr != nil {
+				fingerprint, _ = csrKeyFingerprint(tt.csr)
+			}
+			req := &pb.CreateNodeRequest{NodeName: tt.nodeName, Token: token.String(), Csr: tt.csr}
+			got, err := useJoinToken(ctx, cs, req, fingerprint)
+			if (err == nil) != tt.ok {
+				t.Fatalf("useJoinToken() err=%v, want ok=%v", err, tt.ok)
+			}
+			if (got != nil) != tt.wantToken {
+				t.Errorf("useJoinToken() token=%v, want token used=%v", got, tt.wantToken)
+			}
+		})
+	}
+}
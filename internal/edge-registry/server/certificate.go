@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edge/pkg/errdefs"
+	"edge/pkg/grpcauth"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	//节点证书的有效期, 过期后需要重新join
+	nodeCertificateDuration = 365 * 24 * time.Hour
+)
+
+//certSigner 使用集群CA签发节点证书
+type certSigner struct {
+	caCert *x509.Certificate
+	caPEM  []byte
+	key    crypto.Signer
+}
+
+func newCertSigner(certFile, keyFile string) (*certSigner, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key in %s", keyFile)
+	}
+	caPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	return &certSigner{caCert: caCert, caPEM: caPEM, key: key}, nil
+}
+
+//parseCSR 解析PEM格式的CSR并校验签名, 签名证明调用者持有CSR中公钥对应的私钥
+func parseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errdefs.InvalidInput("csr is not a PEM encoded CERTIFICATE REQUEST")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, errdefs.InvalidInputf("parse csr failed, err=%v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errdefs.InvalidInputf("csr signature is invalid, err=%v", err)
+	}
+	return csr, nil
+}
+
+//csrKeyFingerprint CSR公钥的sha256, 等待审批的节点再次join时用于确认是同一个节点
+func csrKeyFingerprint(csrPEM []byte) (string, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return "", errdefs.InvalidInputf("unsupported csr public key, err=%v", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//sign 校验节点的CSR并签发同时用于服务端和客户端的证书, CN必须是节点名称.
+//不使用CSR中的SAN, 证书只包含节点名称和节点上报的IP
+func (s *certSigner) sign(csrPEM []byte, nodeName, ip string) ([]byte, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if csr.Subject.CommonName != nodeName {
+		return nil, errdefs.InvalidInputf("csr common name %q does not match node name %q", csr.Subject.CommonName, nodeName)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: nodeName, Organization: []string{grpcauth.NodeOrganization}},
+		DNSNames:     []string{nodeName},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(nodeCertificateDuration),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		template.IPAddresses = []net.IP{parsed}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
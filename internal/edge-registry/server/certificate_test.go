@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSigner(t *testing.T) *certSigner {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "edge-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	signer, err := newCertSigner(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+//newTestCSR CSR中额外包含edge-registry的名称和IP, 签发的证书不能包含它们
+func newTestCSR(t *testing.T, commonName string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return newTestCSRWithKey(t, commonName, key)
+}
+
+func newTestCSRWithKey(t *testing.T, commonName string, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: commonName},
+		DNSNames:    []string{commonName, "edge-registry.edge-cluster"},
+		IPAddresses: []net.IP{net.ParseIP("10.96.0.10")},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func Test_certSigner_sign(t *testing.T) {
+	signer := newTestSigner(t)
+	tests := []struct {
+		name     string
+		csr      []byte
+		nodeName string
+		ok       bool
+	}{
+		{"valid", newTestCSR(t, "node1"), "node1", true},
+		{"common name mismatch", newTestCSR(t, "node2"), "node1", false},
+		{"not pem", []byte("csr"), "node1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, err := signer.sign(tt.csr, tt.nodeName, "192.168.1.10")
+			if (err == nil) != tt.ok {
+				t.Fatalf("sign() err=%v, want ok=%v", err, tt.ok)
+			}
+			if err != nil {
+				return
+			}
+			block, _ := pem.Decode(certPEM)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pool := x509.NewCertPool()
+			pool.AddCert(signer.caCert)
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err != nil {
+				t.Errorf("issued certificate does not verify, err=%v", err)
+			}
+			if cert.Subject.CommonName != tt.nodeName {
+				t.Errorf("common name = %s, want %s", cert.Subject.CommonName, tt.nodeName)
+			}
+			if !reflect.DeepEqual(cert.DNSNames, []string{tt.nodeName}) || len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "192.168.1.10" {
+				t.Errorf("SANs = %v %v, want only the node name and the reported ip", cert.DNSNames, cert.IPAddresses)
+			}
+		})
+	}
+}
@@ -3,8 +3,12 @@ package server
 import (
 	"context"
 	"edge/api/edge-proto/pb"
+	"edge/pkg/errdefs"
+	"edge/pkg/grpcauth"
 	"edge/pkg/protoerr"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
@@ -12,8 +16,18 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+var createNodeMethod = "/" + pb.EdgeRegistryService_ServiceDesc.ServiceName + "/CreateNode"
+
 func (e *EdgeRegistryServer) RunGrpc(address string) {
-	grpcServer := grpc.NewServer()
+	//使用bootstrap token join的节点还没有证书
+	opts, err := grpcauth.ServerOptions(e.edgeConfig.Auth, grpcauth.PublicMethods(createNodeMethod))
+	if err != nil {
+		logrus.Fatal("failed to load grpc credentials: ", err)
+	}
+	if !e.edgeConfig.Auth.TLSEnabled() {
+		logrus.Warn("tls is not configured, EdgeRegistry listens on ", address, " in plaintext")
+	}
+	grpcServer := grpc.NewServer(opts...)
 	//健康检测
 	health := health.NewServer()
 	health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
@@ -41,23 +55,90 @@ func (e *EdgeRegistryServer) CreateNode(ctx context.Context, req *pb.CreateNodeR
 		resp.Error = protoerr.ParamErr("NodeName is empty")
 		return resp, nil
 	}
-	exist, err := createEdgeNode(ctx, req.NodeName)
+	//在使用bootstrap token之前校验, 参数错误不消耗token的次数
+	if err := validateNodeSpec(req); err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	var fingerprint string
+	if len(req.Csr) > 0 {
+		f, err := csrKeyFingerprint(req.Csr)
+		if err != nil {
+			resp.Error = toPbError(err)
+			return resp, nil
+		}
+		fingerprint = f
+	}
+	//没有bootstrap token或使用节点证书时, 调用者必须已经通过证书或token认证, 例如已经join过的节点
+	var token *bootstrapToken
+	if _, ok := isNodeIdentity(ctx); ok || req.Token == "" {
+		if err := grpcauth.Authorize(ctx, e.edgeConfig.Auth); err != nil {
+			return nil, err
+		}
+		if err := authorizeNode(ctx, req.NodeName); err != nil {
+			return nil, err
+		}
+	} else {
+		t, err := useJoinToken(ctx, k8sClient(), req, fingerprint)
+		if err != nil {
+			logrus.Errorf("node %s join with bootstrap token failed, err=%v", req.NodeName, err)
+			resp.Error = toPbError(err)
+			return resp, nil
+		}
+		token = t
+	}
+	r, err := e.registerNode(ctx, req, token, fingerprint)
+	if err != nil {
+		logrus.Error("registerNode failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	if r.State == NodeRejected {
+		resp.Error = protoerr.ParamErr(fmt.Sprintf("node %s is rejected: %s", req.NodeName, r.Reason))
+		return resp, nil
+	}
+	//审批通过后才签发证书, 等待审批的节点使用相同的私钥再次join获取证书
+	if len(req.Csr) > 0 && r.State == NodeApproved {
+		if e.signer == nil {
+			resp.Error = protoerr.ParamErr("EdgeRegistry is not configured to sign certificates")
+			return resp, nil
+		}
+		cert, err := e.signer.sign(req.Csr, req.NodeName, req.Ip)
+		if err != nil {
+			logrus.Errorf("sign certificate for node %s failed, err=%v", req.NodeName, err)
+			resp.Error = toPbError(err)
+			return resp, nil
+		}
+		resp.Certificate = cert
+		resp.CaCertificate = e.signer.caPEM
+	}
+	if r.State == NodePending {
+		logrus.Infof("node %s from %s is waiting for approval", req.NodeName, req.Ip)
+		resp.Pending = true
+		return resp, nil
+	}
+	exist, err := e.createEdgeNode(ctx, r)
 	if err != nil {
 		logrus.Error("createEdgeNode failed,err=", err)
 		resp.Error = protoerr.InternalErr(err)
 		return resp, nil
 	}
+	//已经存在的节点再次join时也需要调整标签和taint
+	e.applyNodeSpecAsync(r)
 	resp.Exist = exist
 	return resp, nil
 }
 
 func (e *EdgeRegistryServer) DeleteNode(ctx context.Context, req *pb.DeleteNodeRequest) (*pb.DeleteNodeResponse, error) {
+	if err := authorizeNode(ctx, req.NodeName); err != nil {
+		return nil, err
+	}
 	resp := &pb.DeleteNodeResponse{}
 	if req.NodeName == "" {
 		resp.Error = protoerr.ParamErr("NodeName is empty")
 		return resp, nil
 	}
-	if err := deleteEdgeNode(ctx, req.NodeName); err != nil {
+	if err := e.deleteEdgeNode(ctx, req.NodeName); err != nil {
 		logrus.Error("deleteEdgeNode failed,err=", err)
 		resp.Error = protoerr.InternalErr(err)
 		return resp, nil
@@ -66,14 +147,119 @@ func (e *EdgeRegistryServer) DeleteNode(ctx context.Context, req *pb.DeleteNodeR
 }
 
 func (e *EdgeRegistryServer) GetNode(ctx context.Context, req *pb.GetNodeRequest) (*pb.GetNodeResponse, error) {
+	if err := authorizeNode(ctx, req.NodeName); err != nil {
+		return nil, err
+	}
 	resp := &pb.GetNodeResponse{}
 	if req.NodeName == "" {
 		resp.Error = protoerr.ParamErr("NodeName is empty")
 		return resp, nil
 	}
-	exist := existEdgeNode(ctx, req.NodeName)
+	exist := existEdgeNode(ctx, k8sClient(), req.NodeName)
 	if !exist {
 		resp.Error = protoerr.NotFoundErr("node not exist")
+		return resp, nil
+	}
+	node, err := describeEdgeNode(ctx, k8sClient(), req.NodeName)
+	if err != nil {
+		logrus.Error("describeEdgeNode failed,err=", err)
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	resp.Node = node
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) ListNodes(ctx context.Context, req *pb.ListNodesRequest) (*pb.ListNodesResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.ListNodesResponse{}
+	nodes, err := listEdgeNodes(ctx, k8sClient())
+	if err != nil {
+		logrus.Error("listEdgeNodes failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	resp.Nodes = nodes
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) CreateToken(ctx context.Context, req *pb.CreateTokenRequest) (*pb.CreateTokenResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.CreateTokenResponse{}
+	if req.Ttl < 0 || req.UsageLimit < 0 {
+		resp.Error = protoerr.ParamErr("ttl and usageLimit must not be negative")
+		return resp, nil
+	}
+	t, err := createBootstrapToken(ctx, k8sClient(), time.Duration(req.Ttl)*time.Second, int(req.UsageLimit), req.Description, req.AutoApprove)
+	if err != nil {
+		logrus.Error("createBootstrapToken failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
 	}
+	resp.Token = t.String()
+	resp.Info = tokenInfo(t)
 	return resp, nil
 }
+
+func (e *EdgeRegistryServer) ListTokens(ctx context.Context, req *pb.ListTokensRequest) (*pb.ListTokensResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.ListTokensResponse{}
+	tokens, err := listBootstrapTokens(ctx, k8sClient())
+	if err != nil {
+		logrus.Error("listBootstrapTokens failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	for _, t := range tokens {
+		resp.Tokens = append(resp.Tokens, tokenInfo(t))
+	}
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) DeleteToken(ctx context.Context, req *pb.DeleteTokenRequest) (*pb.DeleteTokenResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.DeleteTokenResponse{}
+	if req.TokenId == "" {
+		resp.Error = protoerr.ParamErr("TokenId is empty")
+		return resp, nil
+	}
+	if err := deleteBootstrapToken(ctx, k8sClient(), req.TokenId); err != nil {
+		logrus.Error("deleteBootstrapToken failed,err=", err)
+		resp.Error = toPbError(err)
+	}
+	return resp, nil
+}
+
+//tokenInfo 不包含token的secret部分
+func tokenInfo(t *bootstrapToken) *pb.BootstrapToken {
+	info := &pb.BootstrapToken{
+		Id:          t.ID,
+		Description: t.Description,
+		UsageLimit:  int32(t.UsageLimit),
+		UsageCount:  int32(t.UsageCount),
+		AutoApprove: t.AutoApprove,
+	}
+	if !t.Expiration.IsZero() {
+		info.Expires = t.Expiration.UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+func toPbError(err error) *pb.Error {
+	switch {
+	case errdefs.IsInvalidInput(err):
+		return protoerr.ParamErr(err.Error())
+	case errdefs.IsNotFound(err):
+		return protoerr.NotFoundErr(err.Error())
+	default:
+		return protoerr.InternalErr(err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 const (
@@ -15,6 +16,9 @@ const (
 var (
 	cs   *kubernetes.Clientset
 	once sync.Once
+
+	applier     *kubeclient.Applier
+	applierOnce sync.Once
 )
 
 func k8sClient() *kubernetes.Clientset {
@@ -28,6 +32,22 @@ func k8sClient() *kubernetes.Clientset {
 	return cs
 }
 
+//k8sApplier 用于创建任意类型的资源, 包括CRD
+func k8sApplier() *kubeclient.Applier {
+	applierOnce.Do(func() {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			panic(err.Error())
+		}
+		a, err := kubeclient.NewApplierForConfig(config)
+		if err != nil {
+			panic(err.Error())
+		}
+		applier = a
+	})
+	return applier
+}
+
 func initResource() error {
 	common.InitLogger()
 	return nil
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	virtualKubeletPrefix = "vk-"
+	//virtual-kubelet的Deployment和pod上的标签, 值为vk-<nodeName>
+	virtualKubeletAppLabel = "k8s-app"
+
+	//还没有创建virtual-kubelet(例如等待审批)
+	virtualKubeletNotCreated = "NotCreated"
+	//Deployment存在但没有pod
+	virtualKubeletNoPod = "NoPod"
+)
+
+//edgeNode 一个边缘节点相关的所有对象, 都可能不存在
+type edgeNode struct {
+	name         string
+	node         *corev1.Node
+	deployment   *appsv1.Deployment
+	vkPods       []corev1.Pod
+	registration *nodeRegistration
+	podCount     int
+}
+
+//describeEdgeNode Node, virtual-kubelet和注册记录都不存在时返回NotFound
+func describeEdgeNode(ctx context.Context, cs kubernetes.Interface, nodeName string) (*pb.NodeInfo, error) {
+	n := &edgeNode{name: nodeName}
+	node, err := cs.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err == nil {
+		n.node = node
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	deployment, err := cs.AppsV1().Deployments(constant.EdgeNameSpace).Get(ctx, virtualKubeletPrefix+nodeName, metav1.GetOptions{})
+	if err == nil {
+		n.deployment = deployment
+	} else if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	registration, err := getRegistration(ctx, cs, nodeName)
+	if err == nil {
+		n.registration = registration
+	} else if !errdefs.IsNotFound(err) {
+		return nil, err
+	}
+	if n.node == nil && n.deployment == nil && n.registration == nil {
+		return nil, errdefs.NotFoundf("node %s not found", nodeName)
+	}
+
+	vkPods, err := cs.CoreV1().Pods(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{
+		LabelSelector: virtualKubeletAppLabel + "=" + virtualKubeletPrefix + nodeName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.vkPods = vkPods.Items
+	pods, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", nodeName).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	n.podCount = countActivePods(pods.Items, nodeName)
+	return n.info(), nil
+}
+
+//listEdgeNodes 边缘节点为所有有virtual-kubelet或注册记录的节点
+func listEdgeNodes(ctx context.Context, cs kubernetes.Interface) ([]*pb.NodeInfo, error) {
+	edgeNodes := map[string]*edgeNode{}
+	get := func(name string) *edgeNode {
+		if n, ok := edgeNodes[name]; ok {
+			return n
+		}
+		n := &edgeNode{name: name}
+		edgeNodes[name] = n
+		return n
+	}
+
+	deployments, err := cs.AppsV1().Deployments(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		if name := deployments.Items[i].Name; strings.HasPrefix(name, virtualKubeletPrefix) {
+			get(strings.TrimPrefix(name, virtualKubeletPrefix)).deployment = &deployments.Items[i]
+		}
+	}
+	registrations, err := listRegistrations(ctx, cs, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range registrations {
+		get(r.NodeName).registration = r
+	}
+
+	nodes, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes.Items {
+		if n, ok := edgeNodes[nodes.Items[i].Name]; ok {
+			n.node = &nodes.Items[i]
+		}
+	}
+	vkPods, err := cs.CoreV1().Pods(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{LabelSelector: virtualKubeletAppLabel})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range vkPods.Items {
+		if n, ok := edgeNodes[strings.TrimPrefix(pod.Labels[virtualKubeletAppLabel], virtualKubeletPrefix)]; ok {
+			n.vkPods = append(n.vkPods, pod)
+		}
+	}
+	pods, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if n, ok := edgeNodes[pod.Spec.NodeName]; ok && isActivePod(&pod) {
+			n.podCount++
+		}
+	}
+
+	var infos []*pb.NodeInfo
+	for _, n := range edgeNodes {
+		infos = append(infos, n.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].NodeName < infos[j].NodeName })
+	return infos, nil
+}
+
+func isActivePod(pod *corev1.Pod) bool {
+	return pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+func countActivePods(pods []corev1.Pod, nodeName string) int {
+	count := 0
+	for i := range pods {
+		if pods[i].Spec.NodeName == nodeName && isActivePod(&pods[i]) {
+			count++
+		}
+	}
+	return count
+}
+
+func (n *edgeNode) info() *pb.NodeInfo {
+	info := &pb.NodeInfo{
+		NodeName: n.name,
+		Ready:    string(corev1.ConditionUnknown),
+		PodCount: int32(n.podCount),
+	}
+	if n.node != nil {
+		for _, c := range n.node.Status.Conditions {
+			if c.Type == corev1.NodeReady {
+				info.Ready = string(c.Status)
+				if !c.LastHeartbeatTime.IsZero() {
+					info.LastHeartbeatTime = c.LastHeartbeatTime.UTC().Format(time.RFC3339)
+				}
+			}
+		}
+		info.EdgeletVersion = n.node.Status.NodeInfo.KubeletVersion
+		for _, addr := range n.node.Status.Addresses {
+			if addr.Type == corev1.NodeInternalIP {
+				info.Ip = addr.Address
+			}
+		}
+	}
+	if r := n.registration; r != nil {
+		info.Approval = r.State
+		if r.EdgeletVersion != "" {
+			info.EdgeletVersion = r.EdgeletVersion
+		}
+		if info.Ip == "" {
+			info.Ip = r.IP
+		}
+	} else if n.deployment != nil {
+		//审批功能之前join的节点
+		info.Approval = NodeApproved
+	}
+	info.VirtualKubeletStatus, info.VirtualKubeletReady, info.VirtualKubeletRestart = n.virtualKubeletStatus()
+	return info
+}
+
+//virtualKubeletStatus 与kubectl get pod的STATUS列类似, 优先显示容器等待或退出的原因
+func (n *edgeNode) virtualKubeletStatus() (string, bool, int32) {
+	if n.deployment == nil {
+		return virtualKubeletNotCreated, false, 0
+	}
+	if len(n.vkPods) == 0 {
+		return virtualKubeletNoPod, false, 0
+	}
+	//有多个pod时(如滚动更新)使用最新创建的
+	pod := n.vkPods[0]
+	for _, p := range n.vkPods[1:] {
+		if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = p
+		}
+	}
+	status := string(pod.Status.Phase)
+	if pod.Status.Reason != "" {
+		status = pod.Status.Reason
+	}
+	var restarts int32
+	for _, cs := range pod.Status.ContainerStatuses {
+		restarts += cs.RestartCount
+		if cs.State.Waiting != nil && cs.State.Waiting.Reason != "" {
+			status = cs.State.Waiting.Reason
+		} else if cs.State.Terminated != nil && cs.State.Terminated.Reason != "" {
+			status = cs.State.Terminated.Reason
+		}
+	}
+	if pod.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+	ready := false
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			ready = c.Status == corev1.ConditionTrue
+		}
+	}
+	return status, ready, restarts
+}
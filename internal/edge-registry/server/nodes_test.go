@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_listEdgeNodes(t *testing.T) {
+	heartbeat := metav1.NewTime(time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC))
+	objects := []runtime.Object{
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue, LastHeartbeatTime: heartbeat}},
+				NodeInfo:   corev1.NodeSystemInfo{KubeletVersion: "v1.0.0"},
+			},
+		},
+		//不是边缘节点
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-node1", Namespace: constant.EdgeNameSpace}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-node2", Namespace: constant.EdgeNameSpace}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vk-node1-x", Namespace: constant.EdgeNameSpace, Labels: map[string]string{"k8s-app": "vk-node1"}},
+			Status: corev1.PodStatus{
+				Phase:      corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vk-node2-x", Namespace: constant.EdgeNameSpace, Labels: map[string]string{"k8s-app": "vk-node2"}},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					RestartCount: 3,
+					State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}},
+			},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job1", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node1"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	}
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset(objects...)
+	if err := saveRegistration(ctx, cs, &nodeRegistration{NodeName: "node3", IP: "10.0.0.3", EdgeletVersion: "v1.1.0", State: NodePending}); err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := listEdgeNodes(ctx, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("listEdgeNodes() got %d nodes, want 3", len(nodes))
+	}
+	tests := []struct {
+		name      string
+		ready     string
+		vkStatus  string
+		vkReady   bool
+		restarts  int32
+		pods      int32
+		approval  string
+		version   string
+		heartbeat string
+	}{
+		{"node1", "True", "Running", true, 0, 1, NodeApproved, "v1.0.0", "2022-05-01T00:00:00Z"},
+		{"node2", "Unknown", "CrashLoopBackOff", false, 3, 0, NodeApproved, "", ""},
+		{"node3", "Unknown", virtualKubeletNotCreated, false, 0, 0, NodePending, "v1.1.0", ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nodes[i]
+			if got.NodeName != tt.name || got.Ready != tt.ready || got.VirtualKubeletStatus != tt.vkStatus ||
+				got.VirtualKubeletReady != tt.vkReady || got.VirtualKubeletRestart != tt.restarts || got.PodCount != tt.pods ||
+				got.Approval != tt.approval || got.EdgeletVersion != tt.version || got.LastHeartbeatTime != tt.heartbeat {
+				t.Errorf("got %+v", got)
+			}
+			described, err := describeEdgeNode(ctx, cs, tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *described != *got {
+				t.Errorf("describeEdgeNode() = %+v, want %+v", described, got)
+			}
+		})
+	}
+
+	if _, err := describeEdgeNode(ctx, cs, "master-x"); !errdefs.IsNotFound(err) {
+		t.Errorf("describeEdgeNode() err=%v, want not found", err)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"edge/pkg/kubeclient"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+/*
+	join时指定的标签和taint在virtual-kubelet注册Node之后设置
+	Node的注解中记录上一次设置的内容, 再次join时删除不再指定的标签和taint, 不影响其他方式添加的
+*/
+const (
+	joinLabelsAnnotation = "edge.io/join-labels"
+	joinTaintsAnnotation = "edge.io/join-taints"
+
+	//virtual-kubelet的镜像可能需要较长时间拉取
+	nodeRegisterTimeout  = 10 * time.Minute
+	nodeRegisterInterval = 2 * time.Second
+)
+
+//applyNodeSpecAsync join和审批不等待virtual-kubelet注册Node
+func (e *EdgeRegistryServer) applyNodeSpecAsync(r *nodeRegistration) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), nodeRegisterTimeout)
+		defer cancel()
+		if err := applyNodeSpec(ctx, k8sClient(), r); err != nil {
+			logrus.Errorf("apply labels and taints to node %s failed, err=%v", r.NodeName, err)
+			return
+		}
+		logrus.Infof("node %s labels=%v taints=%v applied", r.NodeName, r.Labels, r.Taints)
+	}()
+}
+
+//applyNodeSpec 等待Node注册后, 将Node上join设置的标签和taint调整为注册记录中的
+func applyNodeSpec(ctx context.Context, cs kubernetes.Interface, r *nodeRegistration) error {
+	err := wait.PollImmediateUntil(nodeRegisterInterval, func() (bool, error) {
+		_, err := cs.CoreV1().Nodes().Get(ctx, r.NodeName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			logrus.Warnf("get node %s failed, err=%v", r.NodeName, err)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return err
+	}
+
+	labelsData, err := json.Marshal(r.Labels)
+	if err != nil {
+		return err
+	}
+	taintsData, err := json.Marshal(r.Taints)
+	if err != nil {
+		return err
+	}
+	err = kubeclient.PatchNode(cs, r.NodeName, func(n *corev1.Node) {
+		removeStaleJoinSpec(n, r.Labels, r.Taints)
+		if n.Annotations == nil {
+			n.Annotations = map[string]string{}
+		}
+		n.Annotations[joinLabelsAnnotation] = string(labelsData)
+		n.Annotations[joinTaintsAnnotation] = string(taintsData)
+	})
+	if err != nil {
+		return err
+	}
+	return kubeclient.MarkNode(cs, r.NodeName, r.Labels, r.Taints)
+}
+
+//removeStaleJoinSpec 删除上一次join设置但这一次没有指定的标签和taint
+func removeStaleJoinSpec(n *corev1.Node, labels map[string]string, taints []corev1.Taint) {
+	var lastLabels map[string]string
+	if data := n.Annotations[joinLabelsAnnotation]; data != "" {
+		if err := json.Unmarshal([]byte(data), &lastLabels); err != nil {
+			logrus.Warnf("invalid annotation %s of node %s, err=%v", joinLabelsAnnotation, n.Name, err)
+		}
+	}
+	for k := range lastLabels {
+		if _, ok := labels[k]; !ok {
+			delete(n.Labels, k)
+		}
+	}
+
+	var lastTaints []corev1.Taint
+	if data := n.Annotations[joinTaintsAnnotation]; data != "" {
+		if err := json.Unmarshal([]byte(data), &lastTaints); err != nil {
+			logrus.Warnf("invalid annotation %s of node %s, err=%v", joinTaintsAnnotation, n.Name, err)
+		}
+	}
+	stale := func(t *corev1.Taint) bool {
+		for i := range lastTaints {
+			if !lastTaints[i].MatchTaint(t) {
+				continue
+			}
+			for j := range taints {
+				if taints[j].MatchTaint(t) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	}
+	var kept []corev1.Taint
+	for i := range n.Spec.Taints {
+		if !stale(&n.Spec.Taints[i]) {
+			kept = append(kept, n.Spec.Taints[i])
+		}
+	}
+	n.Spec.Taints = kept
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"edge/pkg/kubeclient"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_applyNodeSpec(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cs := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "node1",
+			Labels: map[string]string{kubeclient.LabelHostname: "node1", "type": "virtual-kubelet"},
+		},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{{Key: "virtual-kubelet.io/provider", Value: "edge", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	})
+
+	steps := []struct {
+		name       string
+		labels     map[string]string
+		taints     []corev1.Taint
+		wantLabels map[string]string
+		wantTaints []string
+	}{
+		{
+			name:       "first join",
+			labels:     map[string]string{"site": "shop-1", "gpu": "true"},
+			taints:     []corev1.Taint{{Key: "dedicated", Value: "edge", Effect: corev1.TaintEffectNoSchedule}},
+			wantLabels: map[string]string{"type": "virtual-kubelet", "site": "shop-1", "gpu": "true"},
+			wantTaints: []string{"virtual-kubelet.io/provider", "dedicated"},
+		},
+		{
+			name:       "rejoin removes stale labels and taints",
+			labels:     map[string]string{"site": "shop-2"},
+			taints:     []corev1.Taint{{Key: "unstable", Effect: corev1.TaintEffectPreferNoSchedule}},
+			wantLabels: map[string]string{"type": "virtual-kubelet", "site": "shop-2", "gpu": ""},
+			wantTaints: []string{"virtual-kubelet.io/provider", "unstable"},
+		},
+		{
+			name:       "rejoin without labels and taints",
+			wantLabels: map[string]string{"type": "virtual-kubelet", "site": ""},
+			wantTaints: []string{"virtual-kubelet.io/provider"},
+		},
+	}
+	for _, step := range steps {
+		r := &nodeRegistration{NodeName: "node1", Labels: step.labels, Taints: step.taints}
+		if err := applyNodeSpec(ctx, cs, r); err != nil {
+			t.Fatalf("%s: applyNodeSpec() err=%v", step.name, err)
+		}
+		node, err := cs.CoreV1().Nodes().Get(ctx, "node1", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range step.wantLabels {
+			if node.Labels[k] != v {
+				t.Errorf("%s: label %s=%q, want %q", step.name, k, node.Labels[k], v)
+			}
+		}
+		var taints []string
+		for _, taint := range node.Spec.Taints {
+			taints = append(taints, taint.Key)
+		}
+		if len(taints) != len(step.wantTaints) {
+			t.Errorf("%s: taints=%v, want %v", step.name, taints, step.wantTaints)
+			continue
+		}
+		for _, want := range step.wantTaints {
+			found := false
+			for _, taint := range taints {
+				found = found || taint == want
+			}
+			if !found {
+				t.Errorf("%s: taints=%v, want %v", step.name, taints, step.wantTaints)
+			}
+		}
+	}
+}
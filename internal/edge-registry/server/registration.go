@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"encoding/json"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+/*
+	节点的注册记录保存在edge-cluster下名称为edge-node-<nodeName>的ConfigMap中
+	只有审批通过的节点才会创建vk-<nodeName>的Deployment
+*/
+const (
+	registrationPrefix = "edge-node-"
+	registrationLabel  = "edge.io/node-registration"
+
+	registrationNodeNameKey       = "nodeName"
+	registrationIPKey             = "ip"
+	registrationOSKey             = "os"
+	registrationArchKey           = "arch"
+	registrationEdgeletVersionKey = "edgeletVersion"
+	registrationStateKey          = "state"
+	registrationReasonKey         = "reason"
+	registrationRequestedAtKey    = "requestedAt"
+	registrationKeyFingerprintKey = "keyFingerprint"
+	//以下为json格式
+	registrationLabelsKey = "labels"
+	registrationTaintsKey = "taints"
+	registrationParamsKey = "params"
+	//上一次创建virtual-kubelet时模板中的资源类型, 模板删除某种资源后仍然可以清理
+	registrationAppliedKindsKey = "appliedKinds"
+)
+
+//节点的审批状态
+const (
+	NodePending  = "Pending"
+	NodeApproved = "Approved"
+	NodeRejected = "Rejected"
+)
+
+type nodeRegistration struct {
+	NodeName       string
+	IP             string
+	OS             string
+	Arch           string
+	EdgeletVersion string
+	State          string
+	Reason         string
+	RequestedAt    time.Time
+	//join时CSR公钥的sha256, 等待审批的节点还没有证书, 再次join时使用相同的私钥证明身份
+	KeyFingerprint string
+	//join时指定的节点标签, taint和virtual-kubelet模板的参数, 审批通过后创建virtual-kubelet时使用
+	Labels map[string]string
+	Taints []corev1.Taint
+	Params map[string]string
+	//上一次创建virtual-kubelet时模板中的资源类型
+	AppliedKinds []schema.GroupVersionKind
+}
+
+func registrationToConfigMap(r *nodeRegistration) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registrationPrefix + r.NodeName,
+			Namespace: constant.EdgeNameSpace,
+			Labels:    map[string]string{registrationLabel: "true"},
+		},
+		Data: map[string]string{
+			registrationNodeNameKey:       r.NodeName,
+			registrationIPKey:             r.IP,
+			registrationOSKey:             r.OS,
+			registrationArchKey:           r.Arch,
+			registrationEdgeletVersionKey: r.EdgeletVersion,
+			registrationStateKey:          r.State,
+			registrationReasonKey:         r.Reason,
+			registrationRequestedAtKey:    r.RequestedAt.UTC().Format(time.RFC3339),
+			registrationKeyFingerprintKey: r.KeyFingerprint,
+		},
+	}
+	for key, v := range map[string]interface{}{
+		registrationLabelsKey:       r.Labels,
+		registrationTaintsKey:       r.Taints,
+		registrationParamsKey:       r.Params,
+		registrationAppliedKindsKey: r.AppliedKinds,
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		cm.Data[key] = string(data)
+	}
+	return cm, nil
+}
+
+func configMapToRegistration(cm *corev1.ConfigMap) *nodeRegistration {
+	r := &nodeRegistration{
+		NodeName:       cm.Data[registrationNodeNameKey],
+		IP:             cm.Data[registrationIPKey],
+		OS:             cm.Data[registrationOSKey],
+		Arch:           cm.Data[registrationArchKey],
+		EdgeletVersion: cm.Data[registrationEdgeletVersionKey],
+		State:          cm.Data[registrationStateKey],
+		Reason:         cm.Data[registrationReasonKey],
+		KeyFingerprint: cm.Data[registrationKeyFingerprintKey],
+	}
+	r.RequestedAt, _ = time.Parse(time.RFC3339, cm.Data[registrationRequestedAtKey])
+	//升级前的注册记录没有这些字段
+	for key, v := range map[string]interface{}{
+		registrationLabelsKey:       &r.Labels,
+		registrationTaintsKey:       &r.Taints,
+		registrationParamsKey:       &r.Params,
+		registrationAppliedKindsKey: &r.AppliedKinds,
+	} {
+		if data := cm.Data[key]; data != "" {
+			if err := json.Unmarshal([]byte(data), v); err != nil {
+				logrus.Warnf("invalid %s of node registration %s, err=%v", key, cm.Name, err)
+			}
+		}
+	}
+	return r
+}
+
+func getRegistration(ctx context.Context, cs kubernetes.Interface, nodeName string) (*nodeRegistration, error) {
+	cm, err := cs.CoreV1().ConfigMaps(constant.EdgeNameSpace).Get(ctx, registrationPrefix+nodeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, errdefs.NotFoundf("node %s has not registered", nodeName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return configMapToRegistration(cm), nil
+}
+
+//saveRegistration 不存在时创建, 存在时覆盖
+func saveRegistration(ctx context.Context, cs kubernetes.Interface, r *nodeRegistration) error {
+	cm, err := registrationToConfigMap(r)
+	if err != nil {
+		return err
+	}
+	_, err = cs.CoreV1().ConfigMaps(constant.EdgeNameSpace).Update(ctx, cm, metav1.UpdateOptions{})
+	if errors.IsNotFound(err) {
+		_, err = cs.CoreV1().ConfigMaps(constant.EdgeNameSpace).Create(ctx, cm, metav1.CreateOptions{})
+	}
+	return err
+}
+
+//listRegistrations state为空时返回所有节点
+func listRegistrations(ctx context.Context, cs kubernetes.Interface, state string) ([]*nodeRegistration, error) {
+	cms, err := cs.CoreV1().ConfigMaps(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{
+		LabelSelector: registrationLabel + "=true",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var registrations []*nodeRegistration
+	for i := range cms.Items {
+		r := configMapToRegistration(&cms.Items[i])
+		if state == "" || r.State == state {
+			registrations = append(registrations, r)
+		}
+	}
+	sort.Slice(registrations, func(i, j int) bool { return registrations[i].NodeName < registrations[j].NodeName })
+	return registrations, nil
+}
+
+func deleteRegistration(ctx context.Context, cs kubernetes.Interface, nodeName string) error {
+	err := cs.CoreV1().ConfigMaps(constant.EdgeNameSpace).Delete(ctx, registrationPrefix+nodeName, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+//matchNodePatterns 节点名称是否匹配自动审批的规则, 规则为path.Match的格式, 如 "shop-*"
+func matchNodePatterns(patterns []string, nodeName string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, nodeName); ok {
+			return true
+		}
+	}
+	return false
+}
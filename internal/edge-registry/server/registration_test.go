@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"edge/pkg/errdefs"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_registration(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+	if _, err := getRegistration(ctx, cs, "node1"); !errdefs.IsNotFound(err) {
+		t.Fatalf("getRegistration() err=%v, want not found", err)
+	}
+	for _, r := range []*nodeRegistration{
+		{NodeName: "node2", State: NodeApproved, RequestedAt: time.Now()},
+		{
+			NodeName: "node1", IP: "10.0.0.1", OS: "linux", Arch: "arm64", State: NodePending, RequestedAt: time.Now(),
+			Labels:       map[string]string{"zone": "shop"},
+			Taints:       []corev1.Taint{{Key: "dedicated", Value: "edge", Effect: corev1.TaintEffectNoSchedule}},
+			AppliedKinds: []schema.GroupVersionKind{{Group: "apps", Version: "v1", Kind: "Deployment"}},
+		},
+	} {
+		if err := saveRegistration(ctx, cs, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := getRegistration(ctx, cs, "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IP != "10.0.0.1" || r.Arch != "arm64" || r.State != NodePending ||
+		r.Labels["zone"] != "shop" || len(r.Taints) != 1 || r.Taints[0].Effect != corev1.TaintEffectNoSchedule ||
+		len(r.AppliedKinds) != 1 || r.AppliedKinds[0].Kind != "Deployment" {
+		t.Errorf("getRegistration() = %+v", r)
+	}
+	r.State = NodeRejected
+	if err := saveRegistration(ctx, cs, r); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		state string
+		want  []string
+	}{
+		{"", []string{"node1", "node2"}},
+		{NodeRejected, []string{"node1"}},
+		{NodePending, nil},
+	}
+	for _, tt := range tests {
+		registrations, err := listRegistrations(ctx, cs, tt.state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range registrations {
+			got = append(got, r.NodeName)
+		}
+		if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+			t.Errorf("listRegistrations(%q) = %v, want %v", tt.state, got, tt.want)
+		}
+	}
+
+	if err := deleteRegistration(ctx, cs, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteRegistration(ctx, cs, "node1"); err != nil {
+		t.Errorf("delete twice err=%v", err)
+	}
+}
+
+func Test_matchNodePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		nodeName string
+		want     bool
+	}{
+		{"no patterns", nil, "shop-1", false},
+		{"glob", []string{"shop-*"}, "shop-1", true},
+		{"no match", []string{"shop-*"}, "factory-1", false},
+		{"any pattern", []string{"lab", "factory-?"}, "factory-1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchNodePatterns(tt.patterns, tt.nodeName); got != tt.want {
+				t.Errorf("matchNodePatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,18 +3,21 @@ package server
 import (
 	"context"
 	"edge/internal/constant"
-	"edge/internal/constant/manifests"
 	"edge/pkg/kubeclient"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
 )
 
-func createEdgeNode(ctx context.Context, nodeName string) (bool, error) {
-	option := map[string]string{
-		"NodeName": nodeName,
-	}
+const virtualKubeletFieldManager = "edge-registry"
+
+//createEdgeNode 使用注册记录中的标签, taint和参数渲染virtual-kubelet模板
+func (e *EdgeRegistryServer) createEdgeNode(ctx context.Context, r *nodeRegistration) (bool, error) {
+	nodeName := r.NodeName
 	isNeedCreate := false
 	_, err := k8sClient().CoreV1().Nodes().Get(context.TODO(), nodeName, v1.GetOptions{})
 	if err != nil {
@@ -37,19 +40,71 @@ func createEdgeNode(ctx context.Context, nodeName string) (bool, error) {
 		return true, nil
 	}
 
-	if err := kubeclient.CreateResourceWithFile(k8sClient(), manifests.VirtualKubeletYaml, option); err != nil {
-		//rollback
-		kubeclient.DeleteResourceWithFile(k8sClient(), manifests.VirtualKubeletYaml, option)
+	tmpl, err := virtualKubeletTemplate(&e.edgeConfig.VirtualKubelet)
+	if err != nil {
+		return false, err
+	}
+	values, err := newVirtualKubeletValues(&e.edgeConfig.VirtualKubelet, nodeName, r)
+	if err != nil {
 		return false, err
 	}
+	manifest, err := kubeclient.ParseString(tmpl, values)
+	if err != nil {
+		return false, err
+	}
+	//模板中删除的资源在重新创建时一起删除, 包括上一次创建时模板中有但是现在已经删除的资源类型
+	result, err := k8sApplier().Apply(ctx, manifest, kubeclient.ApplyOptions{
+		FieldManager: virtualKubeletFieldManager,
+		Force:        true,
+		PruneSet:     virtualKubeletPrefix + nodeName,
+		PruneKinds:   r.AppliedKinds,
+	})
+	if err != nil {
+		//rollback, 只删除这次创建的对象, 已经存在的对象(例如正在运行的virtual-kubelet)保留
+		if derr := k8sApplier().DeleteObjects(ctx, result.Created, kubeclient.DeleteOptions{}); derr != nil {
+			logrus.Errorf("rollback virtual-kubelet of node %s failed, err=%v", nodeName, derr)
+		}
+		return false, err
+	}
+	r.AppliedKinds = appliedKinds(result.Applied)
+	if err := saveRegistration(ctx, k8sClient(), r); err != nil {
+		logrus.Warnf("save applied kinds of node %s failed, err=%v", nodeName, err)
+	}
 	return false, nil
 }
 
-func deleteEdgeNode(ctx context.Context, nodeName string) error {
-	option := map[string]string{
-		"NodeName": nodeName,
+func appliedKinds(objs []*unstructured.Unstructured) []schema.GroupVersionKind {
+	var kinds []schema.GroupVersionKind
+	seen := map[schema.GroupVersionKind]bool{}
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		if !seen[gvk] {
+			seen[gvk] = true
+			kinds = append(kinds, gvk)
+		}
 	}
-	if err := kubeclient.DeleteResourceWithFile(k8sClient(), manifests.VirtualKubeletYaml, option); err != nil {
+	return kinds
+}
+
+//deleteEdgeNode 节点reset时删除节点和注册记录, 再次join需要重新审批
+func (e *EdgeRegistryServer) deleteEdgeNode(ctx context.Context, nodeName string) error {
+	if err := e.removeEdgeNode(ctx, nodeName); err != nil {
+		return err
+	}
+	return deleteRegistration(ctx, k8sClient(), nodeName)
+}
+
+//removeEdgeNode 删除virtual-kubelet和kubernetes的Node
+func (e *EdgeRegistryServer) removeEdgeNode(ctx context.Context, nodeName string) error {
+	tmpl, err := virtualKubeletTemplate(&e.edgeConfig.VirtualKubelet)
+	if err != nil {
+		return err
+	}
+	values, err := newVirtualKubeletValues(&e.edgeConfig.VirtualKubelet, nodeName, nil)
+	if err != nil {
+		return err
+	}
+	if err := kubeclient.DeleteResourceWithFile(k8sApplier(), tmpl, values); err != nil {
 		return err
 	}
 	if err := k8sClient().CoreV1().Nodes().Delete(ctx, nodeName, v1.DeleteOptions{}); err != nil {
@@ -61,10 +116,15 @@ func deleteEdgeNode(ctx context.Context, nodeName string) error {
 	return nil
 }
 
-func existEdgeNode(ctx context.Context, nodeName string) bool {
-	_, err := k8sClient().CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
+func existEdgeNode(ctx context.Context, cs kubernetes.Interface, nodeName string) bool {
+	_, err := cs.CoreV1().Nodes().Get(ctx, nodeName, v1.GetOptions{})
 	if err != nil {
 		return false
 	}
 	return true
 }
+
+func existVirtualKubelet(ctx context.Context, cs kubernetes.Interface, nodeName string) bool {
+	_, err := cs.AppsV1().Deployments(constant.EdgeNameSpace).Get(ctx, "vk-"+nodeName, v1.GetOptions{})
+	return err == nil
+}
@@ -0,0 +1,521 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"edge/internal/edgelet/upgrade"
+	"edge/pkg/errdefs"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+/*
+	批量升级(rollout): 按节点名称排序后分为大小为BatchSize的批次, 依次升级
+	1. 第一个批次是canary, 其中任何节点失败都会停止rollout
+	2. 同时升级的节点和已经失败的节点都视为不可用, 总数不超过MaxUnavailable, 失败的节点达到MaxUnavailable时停止rollout
+	3. 暂停和中止在当前正在升级的节点结束后生效, 节点上已经开始的升级任务不会被取消
+	rollout只保存在内存中, edge-registry重启后丢失, 节点上的升级任务不受影响
+*/
+
+//rollout的状态
+const (
+	RolloutRunning   = "Running"
+	RolloutPaused    = "Paused"
+	RolloutSucceeded = "Succeeded"
+	RolloutFailed    = "Failed"
+	RolloutAborted   = "Aborted"
+)
+
+//rollout中节点的阶段, 升级结束后为节点上升级任务的阶段
+const (
+	rolloutNodePending   = "Pending"
+	rolloutNodeUpgrading = "Upgrading"
+	rolloutNodeSkipped   = "Skipped"
+	rolloutNodeTimeout   = "Timeout"
+	rolloutNodeFailed    = "Failed"
+)
+
+//UpdateRollout的操作
+const (
+	rolloutActionPause  = "pause"
+	rolloutActionResume = "resume"
+	rolloutActionAbort  = "abort"
+)
+
+const (
+	defaultRolloutNodeTimeout = 10 * time.Minute
+	//保留的已经结束的rollout数量
+	maxFinishedRollouts = 20
+)
+
+//edgeadmDialer 连接节点上edgelet的Edgeadm服务, 返回的函数用于关闭连接
+type edgeadmDialer func(ctx context.Context, nodeName string) (pb.EdgeadmClient, func(), error)
+
+type rolloutManager struct {
+	dial edgeadmDialer
+	//查询节点上升级任务的间隔
+	pollInterval time.Duration
+
+	mu       sync.Mutex
+	rollouts map[string]*rollout
+}
+
+type rollout struct {
+	id             string
+	req            *pb.CreateRolloutRequest
+	nodeTimeout    time.Duration
+	batchSize      int
+	maxUnavailable int
+	createdAt      time.Time
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	state   string
+	message string
+	nodes   []*rolloutNode
+	//已经失败的节点数量
+	failed int
+	//所有节点结束, 中止或停止后仍然等待正在升级的节点
+	done bool
+}
+
+type rolloutNode struct {
+	name    string
+	batch   int
+	phase   string
+	jobID   string
+	message string
+}
+
+func newRolloutManager(dial edgeadmDialer) *rolloutManager {
+	return &rolloutManager{
+		dial:         dial,
+		pollInterval: 2 * time.Second,
+		rollouts:     map[string]*rollout{},
+	}
+}
+
+func validateRollout(req *pb.CreateRolloutRequest) error {
+	if req.Component != pb.EdgeComponent_EDGELET && req.Component != pb.EdgeComponent_EDGECTL {
+		return errdefs.InvalidInput("only edgelet and edgectl can be upgraded by rollout")
+	}
+	if req.Image == "" || req.Sha256 == "" {
+		return errdefs.InvalidInput("image and sha256 are required")
+	}
+	if (req.Selector == "") == (len(req.NodeNames) == 0) {
+		return errdefs.InvalidInput("specify either a label selector or node names")
+	}
+	if req.BatchSize < 0 || req.MaxUnavailable < 0 || req.NodeTimeout < 0 {
+		return errdefs.InvalidInput("batchSize, maxUnavailable and nodeTimeout must not be negative")
+	}
+	return nil
+}
+
+//resolveRolloutNodes 选择rollout的节点, 使用标签选择时只包括已经创建virtual-kubelet的边缘节点
+func resolveRolloutNodes(ctx context.Context, cs kubernetes.Interface, req *pb.CreateRolloutRequest) ([]string, error) {
+	names := map[string]bool{}
+	if len(req.NodeNames) > 0 {
+		for _, name := range req.NodeNames {
+			if name != "" {
+				names[name] = true
+			}
+		}
+	} else {
+		selector, err := labels.Parse(req.Selector)
+		if err != nil {
+			return nil, errdefs.InvalidInputf("invalid label selector %s, err=%v", req.Selector, err)
+		}
+		deployments, err := cs.AppsV1().Deployments(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		edgeNodes := map[string]bool{}
+		for _, d := range deployments.Items {
+			if strings.HasPrefix(d.Name, virtualKubeletPrefix) {
+				edgeNodes[strings.TrimPrefix(d.Name, virtualKubeletPrefix)] = true
+			}
+		}
+		nodes, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes.Items {
+			if edgeNodes[n.Name] {
+				names[n.Name] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, errdefs.InvalidInput("no edge node is selected")
+	}
+	var result []string
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func newRolloutID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("rollout-%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(b))
+}
+
+//create 创建rollout并在后台执行, 节点已经校验和排序
+func (m *rolloutManager) create(req *pb.CreateRolloutRequest, nodeNames []string) *rollout {
+	r := &rollout{
+		id:             newRolloutID(),
+		req:            req,
+		nodeTimeout:    time.Duration(req.NodeTimeout) * time.Second,
+		batchSize:      int(req.BatchSize),
+		maxUnavailable: int(req.MaxUnavailable),
+		createdAt:      time.Now(),
+		state:          RolloutRunning,
+	}
+	r.cond = sync.NewCond(&r.mu)
+	if r.nodeTimeout == 0 {
+		r.nodeTimeout = defaultRolloutNodeTimeout
+	}
+	if r.batchSize == 0 {
+		r.batchSize = 1
+	}
+	if r.maxUnavailable == 0 {
+		r.maxUnavailable = 1
+	}
+	for i, name := range nodeNames {
+		r.nodes = append(r.nodes, &rolloutNode{name: name, batch: i / r.batchSize, phase: rolloutNodePending})
+	}
+
+	m.mu.Lock()
+	m.pruneLocked()
+	m.rollouts[r.id] = r
+	m.mu.Unlock()
+	logrus.Infof("rollout %s of %s %s started on %d nodes", r.id, req.Component, req.Image, len(r.nodes))
+	go m.run(r)
+	return r
+}
+
+func (m *rolloutManager) get(id string) (*rollout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.rollouts[id]
+	if !ok {
+		return nil, errdefs.NotFoundf("rollout %s not found", id)
+	}
+	return r, nil
+}
+
+//list 按创建时间从新到旧排列
+func (m *rolloutManager) list() []*rollout {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var rollouts []*rollout
+	for _, r := range m.rollouts {
+		rollouts = append(rollouts, r)
+	}
+	sort.Slice(rollouts, func(i, j int) bool { return rollouts[i].createdAt.After(rollouts[j].createdAt) })
+	return rollouts
+}
+
+func (m *rolloutManager) pruneLocked() {
+	var finished []*rollout
+	for _, r := range m.rollouts {
+		if r.finished() {
+			finished = append(finished, r)
+		}
+	}
+	if len(finished) < maxFinishedRollouts {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].createdAt.Before(finished[j].createdAt) })
+	for _, r := range finished[:len(finished)-maxFinishedRollouts+1] {
+		delete(m.rollouts, r.id)
+	}
+}
+
+//update 暂停, 继续或中止rollout
+func (m *rolloutManager) update(id, action string) (*rollout, error) {
+	r, err := m.get(id)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch action {
+	case rolloutActionPause, rolloutActionResume, rolloutActionAbort:
+	default:
+		return nil, errdefs.InvalidInputf("unknown action %s, should be one of pause, resume and abort", action)
+	}
+	if r.state != RolloutRunning && r.state != RolloutPaused {
+		return nil, errdefs.InvalidInputf("rollout %s is already %s", id, r.state)
+	}
+	switch action {
+	case rolloutActionPause:
+		r.state = RolloutPaused
+	case rolloutActionResume:
+		r.state = RolloutRunning
+	case rolloutActionAbort:
+		r.state = RolloutAborted
+		r.message = "aborted by user"
+		r.skipPending()
+	}
+	logrus.Infof("rollout %s %s", id, r.state)
+	r.cond.Broadcast()
+	return r, nil
+}
+
+//run 依次执行每个批次, 上一个批次的节点全部结束后才开始下一个批次
+func (m *rolloutManager) run(r *rollout) {
+	var wg sync.WaitGroup
+	inflight := 0
+	for i, n := range r.nodes {
+		newBatch := i > 0 && n.batch != r.nodes[i-1].batch
+		r.mu.Lock()
+		for r.state == RolloutPaused || (r.state == RolloutRunning && !r.canStart(newBatch, inflight)) {
+			//canStart可能停止了rollout
+			if r.state != RolloutPaused && r.state != RolloutRunning {
+				break
+			}
+			r.cond.Wait()
+		}
+		if r.state != RolloutRunning {
+			r.mu.Unlock()
+			break
+		}
+		inflight++
+		n.phase = rolloutNodeUpgrading
+		r.mu.Unlock()
+
+		wg.Add(1)
+		go func(n *rolloutNode) {
+			defer wg.Done()
+			ok := m.upgradeNode(r, n)
+			r.mu.Lock()
+			inflight--
+			if !ok {
+				r.failed++
+			}
+			r.cond.Broadcast()
+			r.mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done = true
+	if r.state == RolloutRunning || r.state == RolloutPaused {
+		if r.failed > 0 {
+			r.halt(fmt.Sprintf("%d of %d nodes failed", r.failed, len(r.nodes)))
+		} else {
+			r.state = RolloutSucceeded
+		}
+	}
+	logrus.Infof("rollout %s %s %s", r.id, r.state, r.message)
+}
+
+//canStart 是否可以开始升级下一个节点, 需要停止rollout时设置状态, 调用者需要持有r.mu
+func (r *rollout) canStart(newBatch bool, inflight int) bool {
+	if r.batchFailed(0) {
+		r.halt("canary batch failed")
+		return false
+	}
+	if r.failed >= r.maxUnavailable {
+		r.halt(fmt.Sprintf("%d nodes failed, reaching maxUnavailable %d", r.failed, r.maxUnavailable))
+		return false
+	}
+	if newBatch && inflight > 0 {
+		return false
+	}
+	return inflight+r.failed < r.maxUnavailable
+}
+
+//halt 调用者需要持有r.mu
+func (r *rollout) halt(message string) {
+	r.state = RolloutFailed
+	r.message = message
+	r.skipPending()
+	r.cond.Broadcast()
+}
+
+//skipPending 调用者需要持有r.mu
+func (r *rollout) skipPending() {
+	for _, n := range r.nodes {
+		if n.phase == rolloutNodePending {
+			n.phase = rolloutNodeSkipped
+		}
+	}
+}
+
+//batchFailed 调用者需要持有r.mu
+func (r *rollout) batchFailed(batch int) bool {
+	for _, n := range r.nodes {
+		if n.batch == batch && n.phase != rolloutNodePending && n.phase != rolloutNodeUpgrading && n.phase != upgrade.PhaseVerified {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rollout) finished() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+func (r *rollout) setNode(n *rolloutNode, phase, message string) {
+	r.mu.Lock()
+	n.phase = phase
+	n.message = message
+	r.mu.Unlock()
+}
+
+//upgradeNode 转发升级请求并等待节点上的升级任务结束, edgelet重启期间的连接错误会重试
+func (m *rolloutManager) upgradeNode(r *rollout, n *rolloutNode) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.nodeTimeout)
+	defer cancel()
+	client, closeConn, err := m.dial(ctx, n.name)
+	if err != nil {
+		r.setNode(n, rolloutNodeFailed, fmt.Sprintf("connect edgelet failed, err=%v", err))
+		return false
+	}
+	defer closeConn()
+	resp, err := client.Upgrade(ctx, &pb.UpgradeRequest{
+		Component: r.req.Component,
+		Image:     r.req.Image,
+		Sha256:    r.req.Sha256,
+		Signature: r.req.Signature,
+	})
+	if err == nil && resp.Error != nil {
+		err = fmt.Errorf("%s", resp.Error.Msg)
+	}
+	if err != nil {
+		r.setNode(n, rolloutNodeFailed, fmt.Sprintf("upgrade failed, err=%v", err))
+		return false
+	}
+	r.mu.Lock()
+	n.jobID = resp.JobId
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		status, err := client.GetUpgradeStatus(ctx, &pb.GetUpgradeStatusRequest{JobId: resp.JobId})
+		if err == nil && status.Error != nil {
+			err = fmt.Errorf("%s", status.Error.Msg)
+		}
+		if err != nil {
+			lastErr = err
+		} else {
+			job := status.Job
+			if job.Finished {
+				r.setNode(n, job.Phase, job.Message)
+				return job.Phase == upgrade.PhaseVerified
+			}
+			r.setNode(n, rolloutNodeUpgrading, job.Phase)
+		}
+		select {
+		case <-ctx.Done():
+			r.setNode(n, rolloutNodeTimeout, fmt.Sprintf("upgrade job %s is not finished in %s, last err=%v", resp.JobId, r.nodeTimeout, lastErr))
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *rollout) toPB() *pb.Rollout {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ro := &pb.Rollout{
+		Id:             r.id,
+		Component:      r.req.Component,
+		Image:          r.req.Image,
+		Selector:       r.req.Selector,
+		BatchSize:      int32(r.batchSize),
+		MaxUnavailable: int32(r.maxUnavailable),
+		State:          r.state,
+		Message:        r.message,
+		CreateTime:     r.createdAt.UTC().Format(time.RFC3339),
+	}
+	for _, n := range r.nodes {
+		ro.Nodes = append(ro.Nodes, &pb.RolloutNode{
+			NodeName: n.name,
+			Batch:    int32(n.batch),
+			Phase:    n.phase,
+			JobId:    n.jobID,
+			Message:  n.message,
+		})
+	}
+	return ro
+}
+
+func (e *EdgeRegistryServer) CreateRollout(ctx context.Context, req *pb.CreateRolloutRequest) (*pb.CreateRolloutResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.CreateRolloutResponse{}
+	if err := validateRollout(req); err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	nodeNames, err := resolveRolloutNodes(ctx, k8sClient(), req)
+	if err != nil {
+		logrus.Error("resolveRolloutNodes failed,err=", err)
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	resp.Rollout = e.rollouts.create(req, nodeNames).toPB()
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) GetRollout(ctx context.Context, req *pb.GetRolloutRequest) (*pb.GetRolloutResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.GetRolloutResponse{}
+	r, err := e.rollouts.get(req.Id)
+	if err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	resp.Rollout = r.toPB()
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) ListRollouts(ctx context.Context, req *pb.ListRolloutsRequest) (*pb.ListRolloutsResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.ListRolloutsResponse{}
+	for _, r := range e.rollouts.list() {
+		resp.Rollouts = append(resp.Rollouts, r.toPB())
+	}
+	return resp, nil
+}
+
+func (e *EdgeRegistryServer) UpdateRollout(ctx context.Context, req *pb.UpdateRolloutRequest) (*pb.UpdateRolloutResponse, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &pb.UpdateRolloutResponse{}
+	r, err := e.rollouts.update(req.Id, strings.ToLower(req.Action))
+	if err != nil {
+		resp.Error = toPbError(err)
+		return resp, nil
+	}
+	resp.Rollout = r.toPB()
+	return resp, nil
+}
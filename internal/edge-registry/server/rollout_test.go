@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"edge/internal/edgelet/upgrade"
+	"edge/pkg/errdefs"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+//fakeEdgeadm 模拟节点上的升级任务, failNodes中的节点回滚
+type fakeEdgeadm struct {
+	pb.EdgeadmClient
+	nodeName  string
+	failNodes map[string]bool
+	//不为空时, 关闭前升级任务不会结束
+	gate <-chan struct{}
+}
+
+func (f *fakeEdgeadm) Upgrade(ctx context.Context, in *pb.UpgradeRequest, opts ...grpc.CallOption) (*pb.UpgradeResponse, error) {
+	return &pb.UpgradeResponse{JobId: "job-" + f.nodeName}, nil
+}
+
+func (f *fakeEdgeadm) GetUpgradeStatus(ctx context.Context, in *pb.GetUpgradeStatusRequest, opts ...grpc.CallOption) (*pb.GetUpgradeStatusResponse, error) {
+	if f.gate != nil {
+		select {
+		case <-f.gate:
+		default:
+			return &pb.GetUpgradeStatusResponse{Job: &pb.UpgradeJob{Id: in.JobId, Phase: upgrade.PhaseRestarting}}, nil
+		}
+	}
+	job := &pb.UpgradeJob{Id: in.JobId, Phase: upgrade.PhaseVerified, Finished: true}
+	if f.failNodes[f.nodeName] {
+		job.Phase = upgrade.PhaseRolledBack
+	}
+	return &pb.GetUpgradeStatusResponse{Job: job}, nil
+}
+
+func newTestRolloutManager(failNodes map[string]bool, gate <-chan struct{}) *rolloutManager {
+	m := newRolloutManager(func(ctx context.Context, nodeName string) (pb.EdgeadmClient, func(), error) {
+		return &fakeEdgeadm{nodeName: nodeName, failNodes: failNodes, gate: gate}, func() {}, nil
+	})
+	m.pollInterval = 5 * time.Millisecond
+	return m
+}
+
+func waitRollout(t *testing.T, r *rollout) *pb.Rollout {
+	deadline := time.Now().Add(5 * time.Second)
+	for !r.finished() {
+		if time.Now().After(deadline) {
+			t.Fatalf("rollout is not finished: %+v", r.toPB())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return r.toPB()
+}
+
+func nodePhases(ro *pb.Rollout) map[string]string {
+	phases := map[string]string{}
+	for _, n := range ro.Nodes {
+		phases[n.NodeName] = n.Phase
+	}
+	return phases
+}
+
+func Test_rolloutRun(t *testing.T) {
+	nodes := []string{"n1", "n2", "n3", "n4", "n5"}
+	tests := []struct {
+		name           string
+		batchSize      int32
+		maxUnavailable int32
+		failNodes      map[string]bool
+		wantState      string
+		wantPhases     map[string]string
+	}{
+		{
+			name:           "all nodes upgraded",
+			batchSize:      2,
+			maxUnavailable: 2,
+			wantState:      RolloutSucceeded,
+			wantPhases:     map[string]string{"n1": "Verified", "n2": "Verified", "n3": "Verified", "n4": "Verified", "n5": "Verified"},
+		},
+		{
+			name:           "canary batch failed",
+			batchSize:      2,
+			maxUnavailable: 3,
+			failNodes:      map[string]bool{"n1": true},
+			wantState:      RolloutFailed,
+			wantPhases:     map[string]string{"n1": "RolledBack", "n2": "Verified", "n3": "Skipped", "n4": "Skipped", "n5": "Skipped"},
+		},
+		{
+			name:           "failures reach maxUnavailable",
+			batchSize:      2,
+			maxUnavailable: 2,
+			failNodes:      map[string]bool{"n3": true, "n4": true},
+			wantState:      RolloutFailed,
+			wantPhases:     map[string]string{"n1": "Verified", "n2": "Verified", "n3": "RolledBack", "n4": "RolledBack", "n5": "Skipped"},
+		},
+		{
+			name:           "failures below maxUnavailable",
+			batchSize:      1,
+			maxUnavailable: 2,
+			failNodes:      map[string]bool{"n3": true},
+			wantState:      RolloutFailed,
+			wantPhases:     map[string]string{"n1": "Verified", "n2": "Verified", "n3": "RolledBack", "n4": "Verified", "n5": "Verified"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestRolloutManager(tt.failNodes, nil)
+			r := m.create(&pb.CreateRolloutRequest{
+				Component:      pb.EdgeComponent_EDGELET,
+				BatchSize:      tt.batchSize,
+				MaxUnavailable: tt.maxUnavailable,
+			}, nodes)
+			ro := waitRollout(t, r)
+			if ro.State != tt.wantState {
+				t.Errorf("state = %s(%s), want %s", ro.State, ro.Message, tt.wantState)
+			}
+			if got := nodePhases(ro); !reflect.DeepEqual(got, tt.wantPhases) {
+				t.Errorf("phases = %v, want %v", got, tt.wantPhases)
+			}
+		})
+	}
+}
+
+func waitNodePhase(t *testing.T, r *rollout, nodeName, phase string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for nodePhases(r.toPB())[nodeName] != phase {
+		if time.Now().After(deadline) {
+			t.Fatalf("node %s is not %s: %+v", nodeName, phase, r.toPB())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func Test_rolloutPause(t *testing.T) {
+	gate := make(chan struct{})
+	var once sync.Once
+	release := func() { once.Do(func() { close(gate) }) }
+	defer release()
+
+	m := newTestRolloutManager(nil, gate)
+	r := m.create(&pb.CreateRolloutRequest{Component: pb.EdgeComponent_EDGELET}, []string{"n1", "n2", "n3"})
+	waitNodePhase(t, r, "n1", rolloutNodeUpgrading)
+	if _, err := m.update(r.id, rolloutActionPause); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	//暂停时第一个节点结束后不会开始下一个节点
+	waitNodePhase(t, r, "n1", upgrade.PhaseVerified)
+	time.Sleep(50 * time.Millisecond)
+	if got := nodePhases(r.toPB()); got["n2"] != rolloutNodePending {
+		t.Fatalf("phases while paused = %v", got)
+	}
+	if _, err := m.update(r.id, rolloutActionResume); err != nil {
+		t.Fatal(err)
+	}
+	ro := waitRollout(t, r)
+	if ro.State != RolloutSucceeded {
+		t.Errorf("state = %s, want %s", ro.State, RolloutSucceeded)
+	}
+	if _, err := m.update(r.id, rolloutActionAbort); !errdefs.IsInvalidInput(err) {
+		t.Errorf("want invalid input error to abort a finished rollout, got %v", err)
+	}
+}
+
+func Test_rolloutAbort(t *testing.T) {
+	gate := make(chan struct{})
+	m := newTestRolloutManager(nil, gate)
+	r := m.create(&pb.CreateRolloutRequest{Component: pb.EdgeComponent_EDGELET}, []string{"n1", "n2"})
+	waitNodePhase(t, r, "n1", rolloutNodeUpgrading)
+	if _, err := m.update(r.id, rolloutActionAbort); err != nil {
+		t.Fatal(err)
+	}
+	//正在升级的节点继续等待结果
+	close(gate)
+	ro := waitRollout(t, r)
+	if ro.State != RolloutAborted {
+		t.Errorf("state = %s, want %s", ro.State, RolloutAborted)
+	}
+	if got := nodePhases(ro); got["n1"] != upgrade.PhaseVerified || got["n2"] != rolloutNodeSkipped {
+		t.Errorf("phases = %v, want n1 %s and n2 %s", got, upgrade.PhaseVerified, rolloutNodeSkipped)
+	}
+}
+
+func Test_resolveRolloutNodes(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "shop-2", Labels: map[string]string{"region": "east"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "shop-1", Labels: map[string]string{"region": "east"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "shop-3", Labels: map[string]string{"region": "west"}}},
+		//不是边缘节点
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master", Labels: map[string]string{"region": "east"}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-shop-1", Namespace: constant.EdgeNameSpace}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-shop-2", Namespace: constant.EdgeNameSpace}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "vk-shop-3", Namespace: constant.EdgeNameSpace}},
+	)
+	tests := []struct {
+		name    string
+		req     *pb.CreateRolloutRequest
+		want    []string
+		invalid bool
+	}{
+		{
+			name: "label selector",
+			req:  &pb.CreateRolloutRequest{Selector: "region=east"},
+			want: []string{"shop-1", "shop-2"},
+		},
+		{
+			name: "node names",
+			req:  &pb.CreateRolloutRequest{NodeNames: []string{"shop-3", "shop-1", "shop-3"}},
+			want: []string{"shop-1", "shop-3"},
+		},
+		{
+			name:    "no node matches",
+			req:     &pb.CreateRolloutRequest{Selector: "region=north"},
+			invalid: true,
+		},
+		{
+			name:    "invalid selector",
+			req:     &pb.CreateRolloutRequest{Selector: "region in (east"},
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveRolloutNodes(context.Background(), cs, tt.req)
+			if tt.invalid {
+				if !errdefs.IsInvalidInput(err) {
+					t.Fatalf("want invalid input error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
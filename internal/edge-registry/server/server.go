@@ -1,51 +1,163 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"edge/api/edge-proto/pb"
 	"edge/internal/edge-registry/option"
+	"edge/pkg/grpcauth"
 	"edge/pkg/protoerr"
+	"edge/pkg/tunnel"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 type EdgeRegistryServer struct {
 	edgeConfig *option.EdgeRegistryOptions
+	signer     *certSigner
 	stopCh     <-chan struct{}
+	//edgelet的反向通道, 也用于访问节点上的edgelet
+	tunnel   *tunnel.Server
+	rollouts *rolloutManager
 }
 
-func CreateEdgeRegistry(stopCh <-chan struct{}) (*EdgeRegistryServer, error) {
+func CreateEdgeRegistry(stopCh <-chan struct{}, opts ...option.EdgeRegistryOption) (*EdgeRegistryServer, error) {
 	if err := initResource(); err != nil {
 		return nil, err
 	}
 
-	return &EdgeRegistryServer{
-		edgeConfig: option.NewDefaultOptions(),
+	edgeConfig := option.NewDefaultOptions(opts...)
+	es := &EdgeRegistryServer{
+		edgeConfig: edgeConfig,
 		stopCh:     stopCh,
-	}, nil
+		tunnel:     tunnel.NewServer(edgeConfig.Auth.Token),
+	}
+	es.rollouts = newRolloutManager(es.dialEdgeadm)
+	if es.edgeConfig.SigningCertFile != "" {
+		signer, err := newCertSigner(es.edgeConfig.SigningCertFile, es.edgeConfig.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		es.signer = signer
+	}
+	return es, nil
 }
 
 func (es *EdgeRegistryServer) Run(address string) {
+	server := &http.Server{
+		Addr:    address,
+		Handler: es.router(),
+	}
+	auth := es.edgeConfig.Auth
+	if auth.TLSEnabled() {
+		tlsConfig, err := auth.ServerTLSConfig()
+		if err != nil {
+			logrus.Fatal("failed to load http credentials: ", err)
+		}
+		//使用bootstrap token join的节点还没有证书
+		if tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		server.TLSConfig = tlsConfig
+	}
+	if !auth.Authenticated() {
+		logrus.Warn("neither client certificate nor token is configured, EdgeRegistry on ", address, " only accepts join with bootstrap token")
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Fatal("failed to serve:", err)
+		}
+	}()
+
+	<-es.stopCh
+	logrus.Info("Received a program exit signal")
+	server.Shutdown(context.Background())
+}
+
+//router REST接口与grpc接口使用相同的认证和权限检查
+func (es *EdgeRegistryServer) router() *gin.Engine {
 	r := gin.Default()
 	registry := r.Group("/edge/registry")
 	{
-		registry.POST("/node", createNode)
-		registry.DELETE("/node", deleteNode)
-		registry.GET("/node/:nodeName", describeNode)
+		registry.POST("/node", es.createNode)
+		registry.DELETE("/node", es.authenticate, es.deleteNode)
+		registry.GET("/node/:nodeName", es.authenticate, es.describeNode)
+		registry.GET("/nodes", es.authenticate, es.listNodes)
 		registry.GET("/ping", healthCheck)
 	}
+	return r
+}
 
-	go r.Run(address)
+//grpcContext 把HTTP请求的客户端证书和Authorization放到context中, 以便使用grpcauth和grpc接口的权限检查
+func grpcContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if r.TLS != nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: *r.TLS}})
+	}
+	if value := r.Header.Get("Authorization"); value != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", value))
+	}
+	return ctx
+}
 
-	<-es.stopCh
-	logrus.Info("Received a program exit signal")
+//authenticate 校验客户端证书或token, 与grpc不同, 没有配置任何认证方式时拒绝所有请求
+func (es *EdgeRegistryServer) authenticate(c *gin.Context) {
+	auth := es.edgeConfig.Auth
+	if !auth.Authenticated() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "EdgeRegistry has no client certificate or token configured"})
+		return
+	}
+	if err := grpcauth.Authorize(grpcContext(c.Request), auth); err != nil {
+		c.AbortWithStatusJSON(grpcHTTPStatus(err), gin.H{"error": status.Convert(err).Message()})
+		return
+	}
+	c.Next()
+}
+
+//grpcHTTPStatus grpc接口返回的认证和权限错误对应的HTTP状态码
+func grpcHTTPStatus(err error) int {
+	switch status.Code(err) {
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+//pbHTTPStatus 响应中的pb.Error对应的HTTP状态码
+func pbHTTPStatus(perr *pb.Error) int {
+	if perr == nil {
+		return http.StatusOK
+	}
+	switch perr.Code {
+	case pb.ErrorCode_PARAMETER_FAILED:
+		return http.StatusBadRequest
+	case pb.ErrorCode_NO_RESULT:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 /*
 1、创建一个deploy和svc给virtual-kubelet ? (svc能否只用一个)
 */
-func createNode(c *gin.Context) {
+func (es *EdgeRegistryServer) createNode(c *gin.Context) {
 	req := &pb.JoinRequest{}
 	resp := &pb.JoinResponse{}
 	if err := c.BindJSON(req); err != nil {
@@ -58,39 +170,56 @@ func createNode(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, resp)
 		return
 	}
+	//与grpc的CreateNode相同, 没有bootstrap token时调用者必须通过证书或token认证
+	if req.Token == "" {
+		es.authenticate(c)
+		if c.IsAborted() {
+			return
+		}
+	}
 
-	logrus.Info("request:", req)
-	exist, err := createEdgeNode(c.Request.Context(), req.NodeName)
+	logrus.Info("join request of node ", req.NodeName)
+	cnreq := &pb.CreateNodeRequest{NodeName: req.NodeName, Token: req.Token, Labels: req.Labels, Taints: req.Taints}
+	r, err := es.CreateNode(grpcContext(c.Request), cnreq)
 	if err != nil {
-		logrus.Error("createEdgeNode failed,err=", err)
-		resp.Error = protoerr.InternalErr(err)
-		c.JSON(http.StatusInternalServerError, resp)
+		c.JSON(grpcHTTPStatus(err), gin.H{"error": status.Convert(err).Message()})
 		return
 	}
-	resp.Exist = exist
-	c.JSON(http.StatusOK, resp)
+	resp.Error = r.Error
+	resp.Exist = r.Exist
+	resp.Pending = r.Pending
+	c.JSON(pbHTTPStatus(resp.Error), resp)
 }
 
-func deleteNode(c *gin.Context) {
+func (es *EdgeRegistryServer) deleteNode(c *gin.Context) {
 	resp := &pb.ResetResponse{}
-
-	nodeName := c.Query("name")
-	if nodeName == "" {
-		resp.Error = protoerr.ParamErr("NodeName is empty")
-		c.JSON(http.StatusBadRequest, resp)
+	r, err := es.DeleteNode(grpcContext(c.Request), &pb.DeleteNodeRequest{NodeName: c.Query("name")})
+	if err != nil {
+		c.JSON(grpcHTTPStatus(err), gin.H{"error": status.Convert(err).Message()})
 		return
 	}
-	if err := deleteEdgeNode(c.Request.Context(), nodeName); err != nil {
-		logrus.Error("deleteEdgeNode failed,err=", err)
-		resp.Error = protoerr.InternalErr(err)
-		c.JSON(http.StatusInternalServerError, resp)
+	resp.Error = r.Error
+	c.JSON(pbHTTPStatus(resp.Error), resp)
+}
+
+//describeNode 与grpc的GetNode相同, 节点证书只能查看节点自己
+func (es *EdgeRegistryServer) describeNode(c *gin.Context) {
+	r, err := es.GetNode(grpcContext(c.Request), &pb.GetNodeRequest{NodeName: c.Param("nodeName")})
+	if err != nil {
+		c.JSON(grpcHTTPStatus(err), gin.H{"error": status.Convert(err).Message()})
 		return
 	}
-	c.JSON(http.StatusOK, resp)
+	c.JSON(pbHTTPStatus(r.Error), r)
 }
 
-func describeNode(c *gin.Context) {
-
+//listNodes 与grpc的ListNodes相同, 只有管理员可以查看所有节点
+func (es *EdgeRegistryServer) listNodes(c *gin.Context) {
+	r, err := es.ListNodes(grpcContext(c.Request), &pb.ListNodesRequest{})
+	if err != nil {
+		c.JSON(grpcHTTPStatus(err), gin.H{"error": status.Convert(err).Message()})
+		return
+	}
+	c.JSON(pbHTTPStatus(r.Error), r)
 }
 
 func healthCheck(c *gin.Context) {
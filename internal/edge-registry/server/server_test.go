@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edge/internal/edge-registry/option"
+	"edge/pkg/grpcauth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+//nodeTLS 模拟客户端出示了节点证书
+func nodeTLS(nodeName string) *tls.ConnectionState {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: nodeName, Organization: []string{grpcauth.NodeOrganization}}}
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+}
+
+type restCase struct {
+	name   string
+	auth   grpcauth.Config
+	method string
+	path   string
+	body   string
+	token  string
+	tls    *tls.ConnectionState
+	code   int
+}
+
+func (tt restCase) run(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	es := &EdgeRegistryServer{edgeConfig: &option.EdgeRegistryOptions{Auth: tt.auth}}
+	req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+	if tt.token != "" {
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+	}
+	req.TLS = tt.tls
+	w := httptest.NewRecorder()
+	es.router().ServeHTTP(w, req)
+	if w.Code != tt.code {
+		t.Errorf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body.String(), tt.code)
+	}
+}
+
+var (
+	tokenAuth = grpcauth.Config{Token: "secret"}
+	certAuth  = grpcauth.Config{CAFile: "ca.crt", CertFile: "server.crt", KeyFile: "server.key"}
+)
+
+//REST接口与grpc使用相同的认证, 没有配置认证方式时不允许匿名修改节点
+func Test_restAuth(t *testing.T) {
+	tests := []restCase{
+		{name: "ping", method: http.MethodGet, path: "/edge/registry/ping", code: http.StatusOK},
+		{name: "delete without auth configured", method: http.MethodDelete, path: "/edge/registry/node?name=node1", code: http.StatusUnauthorized},
+		{name: "delete without token", auth: tokenAuth, method: http.MethodDelete, path: "/edge/registry/node?name=node1", code: http.StatusUnauthorized},
+		{name: "delete with wrong token", auth: tokenAuth, method: http.MethodDelete, path: "/edge/registry/node?name=node1", token: "guess", code: http.StatusUnauthorized},
+		{name: "delete without client certificate", auth: certAuth, method: http.MethodDelete, path: "/edge/registry/node?name=node1", code: http.StatusUnauthorized},
+		{name: "delete another node", auth: certAuth, method: http.MethodDelete, path: "/edge/registry/node?name=node1", tls: nodeTLS("node2"), code: http.StatusForbidden},
+		{name: "delete without name", auth: tokenAuth, method: http.MethodDelete, path: "/edge/registry/node", token: "secret", code: http.StatusBadRequest},
+		{name: "join without auth configured", method: http.MethodPost, path: "/edge/registry/node", body: `{"nodeName":"node1"}`, code: http.StatusUnauthorized},
+		{name: "join without token", auth: tokenAuth, method: http.MethodPost, path: "/edge/registry/node", body: `{"nodeName":"node1"}`, code: http.StatusUnauthorized},
+		{name: "join as another node", auth: certAuth, method: http.MethodPost, path: "/edge/registry/node", body: `{"nodeName":"node1"}`, tls: nodeTLS("node2"), code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, tt.run)
+	}
+}
+
+//查看节点需要认证, 节点证书只能查看节点自己, 不能列出所有节点
+func Test_restNodesAuth(t *testing.T) {
+	tests := []restCase{
+		{name: "list without auth configured", method: http.MethodGet, path: "/edge/registry/nodes", code: http.StatusUnauthorized},
+		{name: "list without token", auth: tokenAuth, method: http.MethodGet, path: "/edge/registry/nodes", code: http.StatusUnauthorized},
+		{name: "list with node certificate", auth: certAuth, method: http.MethodGet, path: "/edge/registry/nodes", tls: nodeTLS("node1"), code: http.StatusForbidden},
+		{name: "describe without auth configured", method: http.MethodGet, path: "/edge/registry/node/node1", code: http.StatusUnauthorized},
+		{name: "describe with wrong token", auth: tokenAuth, method: http.MethodGet, path: "/edge/registry/node/node1", token: "guess", code: http.StatusUnauthorized},
+		{name: "describe another node", auth: certAuth, method: http.MethodGet, path: "/edge/registry/node/node1", tls: nodeTLS("node2"), code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, tt.run)
+	}
+}
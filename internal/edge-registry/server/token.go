@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+/*
+	bootstrap token与kubeadm的格式相同: <id>.<secret>, 保存在edge-cluster下名称为bootstrap-token-<id>的Secret中
+	Secret的类型与kubernetes的bootstrap token不同, 避免被kube-controller-manager处理
+*/
+const (
+	bootstrapTokenSecretPrefix = "bootstrap-token-"
+	bootstrapTokenSecretType   = corev1.SecretType("bootstrap.edge.io/token")
+
+	bootstrapTokenIDKey          = "token-id"
+	bootstrapTokenSecretKey      = "token-secret"
+	bootstrapTokenExpirationKey  = "expiration"
+	bootstrapTokenUsageLimitKey  = "usage-limit"
+	bootstrapTokenUsageCountKey  = "usage-count"
+	bootstrapTokenDescriptionKey = "description"
+	bootstrapTokenAutoApproveKey = "auto-approve"
+
+	bootstrapTokenChars     = "0123456789abcdefghijklmnopqrstuvwxyz"
+	bootstrapTokenIDLen     = 6
+	bootstrapTokenSecretLen = 16
+)
+
+var bootstrapTokenRegexp = regexp.MustCompile(`^([a-z0-9]{6})\.([a-z0-9]{16})$`)
+
+type bootstrapToken struct {
+	ID          string
+	Secret      string
+	Description string
+	//为零值时不过期
+	Expiration time.Time
+	//为0时不限制使用次数
+	UsageLimit int
+	UsageCount int
+	//使用这个token join的节点不需要审批
+	AutoApprove bool
+}
+
+func (t *bootstrapToken) String() string {
+	return t.ID + "." + t.Secret
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(bootstrapTokenChars)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = bootstrapTokenChars[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+func parseBootstrapToken(token string) (id, secret string, err error) {
+	match := bootstrapTokenRegexp.FindStringSubmatch(token)
+	if match == nil {
+		return "", "", errdefs.InvalidInput("bootstrap token does not match the format [a-z0-9]{6}.[a-z0-9]{16}")
+	}
+	return match[1], match[2], nil
+}
+
+func tokenToSecret(t *bootstrapToken) *corev1.Secret {
+	data := map[string][]byte{
+		bootstrapTokenIDKey:         []byte(t.ID),
+		bootstrapTokenSecretKey:     []byte(t.Secret),
+		bootstrapTokenUsageLimitKey: []byte(strconv.Itoa(t.UsageLimit)),
+		bootstrapTokenUsageCountKey: []byte(strconv.Itoa(t.UsageCount)),
+	}
+	if !t.Expiration.IsZero() {
+		data[bootstrapTokenExpirationKey] = []byte(t.Expiration.UTC().Format(time.RFC3339))
+	}
+	if t.Description != "" {
+		data[bootstrapTokenDescriptionKey] = []byte(t.Description)
+	}
+	if t.AutoApprove {
+		data[bootstrapTokenAutoApproveKey] = []byte("true")
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bootstrapTokenSecretPrefix + t.ID,
+			Namespace: constant.EdgeNameSpace,
+		},
+		Type: bootstrapTokenSecretType,
+		Data: data,
+	}
+}
+
+func secretToToken(secret *corev1.Secret) (*bootstrapToken, error) {
+	t := &bootstrapToken{
+		ID:          string(secret.Data[bootstrapTokenIDKey]),
+		Secret:      string(secret.Data[bootstrapTokenSecretKey]),
+		Description: string(secret.Data[bootstrapTokenDescriptionKey]),
+		AutoApprove: string(secret.Data[bootstrapTokenAutoApproveKey]) == "true",
+	}
+	if v := secret.Data[bootstrapTokenExpirationKey]; len(v) > 0 {
+		expiration, err := time.Parse(time.RFC3339, string(v))
+		if err != nil {
+			return nil, fmt.Errorf("secret %s has invalid expiration %q", secret.Name, v)
+		}
+		t.Expiration = expiration
+	}
+	for key, value := range map[string]*int{bootstrapTokenUsageLimitKey: &t.UsageLimit, bootstrapTokenUsageCountKey: &t.UsageCount} {
+		if v := secret.Data[key]; len(v) > 0 {
+			n, err := strconv.Atoi(string(v))
+			if err != nil {
+				return nil, fmt.Errorf("secret %s has invalid %s %q", secret.Name, key, v)
+			}
+			*value = n
+		}
+	}
+	return t, nil
+}
+
+//createBootstrapToken ttl为0时不过期, usageLimit为0时不限制使用次数
+func createBootstrapToken(ctx context.Context, cs kubernetes.Interface, ttl time.Duration, usageLimit int, description string, autoApprove bool) (*bootstrapToken, error) {
+	id, err := randomString(bootstrapTokenIDLen)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(bootstrapTokenSecretLen)
+	if err != nil {
+		return nil, err
+	}
+	t := &bootstrapToken{
+		ID:          id,
+		Secret:      secret,
+		Description: description,
+		UsageLimit:  usageLimit,
+		AutoApprove: autoApprove,
+	}
+	if ttl > 0 {
+		t.Expiration = time.Now().Add(ttl)
+	}
+	if _, err := cs.CoreV1().Secrets(constant.EdgeNameSpace).Create(ctx, tokenToSecret(t), metav1.CreateOptions{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func listBootstrapTokens(ctx context.Context, cs kubernetes.Interface) ([]*bootstrapToken, error) {
+	secrets, err := cs.CoreV1().Secrets(constant.EdgeNameSpace).List(ctx, metav1.ListOptions{
+		FieldSelector: "type=" + string(bootstrapTokenSecretType),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*bootstrapToken
+	for i := range secrets.Items {
+		t, err := secretToToken(&secrets.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	return tokens, nil
+}
+
+func deleteBootstrapToken(ctx context.Context, cs kubernetes.Interface, id string) error {
+	err := cs.CoreV1().Secrets(constant.EdgeNameSpace).Delete(ctx, bootstrapTokenSecretPrefix+id, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return errdefs.NotFoundf("bootstrap token %s not found", id)
+	}
+	return err
+}
+
+//useBootstrapToken 校验token并增加使用次数, 过期或用完的token返回错误
+func useBootstrapToken(ctx context.Context, cs kubernetes.Interface, token string) (*bootstrapToken, error) {
+	id, secret, err := parseBootstrapToken(token)
+	if err != nil {
+		return nil, err
+	}
+	invalid := errdefs.InvalidInputf("bootstrap token %s is invalid, expired or used up", id)
+	var used *bootstrapToken
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		s, err := cs.CoreV1().Secrets(constant.EdgeNameSpace).Get(ctx, bootstrapTokenSecretPrefix+id, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return invalid
+		}
+		if err != nil {
+			return err
+		}
+		if s.Type != bootstrapTokenSecretType {
+			return invalid
+		}
+		t, err := secretToToken(s)
+		if err != nil {
+			return err
+		}
+		if subtle.ConstantTimeCompare([]byte(t.Secret), []byte(secret)) != 1 {
+			return invalid
+		}
+		if !t.Expiration.IsZero() && time.Now().After(t.Expiration) {
+			return invalid
+		}
+		if t.UsageLimit > 0 && t.UsageCount >= t.UsageLimit {
+			return invalid
+		}
+		s.Data[bootstrapTokenUsageCountKey] = []byte(strconv.Itoa(t.UsageCount + 1))
+		if _, err := cs.CoreV1().Secrets(constant.EdgeNameSpace).Update(ctx, s, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		t.UsageCount++
+		used = t
+		return nil
+	})
+	return used, err
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_useBootstrapToken(t *testing.T) {
+	ctx := context.Background()
+	cs := fake.NewSimpleClientset()
+	once, err := createBootstrapToken(ctx, cs, time.Hour, 1, "once", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlimited, err := createBootstrapToken(ctx, cs, 0, 0, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := createBootstrapToken(ctx, cs, time.Hour, 0, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := cs.CoreV1().Secrets(constant.EdgeNameSpace).Get(ctx, bootstrapTokenSecretPrefix+expired.ID, metav1.GetOptions{})
+	secret.Data[bootstrapTokenExpirationKey] = []byte(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	cs.CoreV1().Secrets(constant.EdgeNameSpace).Update(ctx, secret, metav1.UpdateOptions{})
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"first use", once.String(), true},
+		{"usage limit reached", once.String(), false},
+		{"unlimited", unlimited.String(), true},
+		{"unlimited again", unlimited.String(), true},
+		{"expired", expired.String(), false},
+		{"wrong secret", once.ID + ".0123456789abcdef", false},
+		{"unknown id", "zzzzzz.0123456789abcdef", false},
+		{"malformed", "not-a-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := useBootstrapToken(ctx, cs, tt.token)
+			if (err == nil) != tt.ok {
+				t.Fatalf("useBootstrapToken() err=%v, want ok=%v", err, tt.ok)
+			}
+			if err != nil && !errdefs.IsInvalidInput(err) {
+				t.Errorf("err=%v, want invalid input", err)
+			}
+		})
+	}
+
+	tokens, err := listBootstrapTokens(ctx, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("listBootstrapTokens() got %d tokens, want 3", len(tokens))
+	}
+	for _, tok := range tokens {
+		if tok.ID == unlimited.ID && (tok.UsageCount != 2 || !tok.AutoApprove) {
+			t.Errorf("token %s usage count = %d, auto approve = %v", tok.ID, tok.UsageCount, tok.AutoApprove)
+		}
+	}
+	if err := deleteBootstrapToken(ctx, cs, once.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteBootstrapToken(ctx, cs, once.ID); !errdefs.IsNotFound(err) {
+		t.Errorf("delete twice err=%v, want not found", err)
+	}
+}
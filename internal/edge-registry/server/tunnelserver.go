@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"edge/pkg/grpcauth"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+//RunTunnel 接受edgelet的反向通道, virtual-kubelet通过HTTP CONNECT按节点名称访问NAT后面的edgelet
+func (e *EdgeRegistryServer) RunTunnel(address string) {
+	server := &http.Server{
+		Addr:    address,
+		Handler: e.tunnel,
+	}
+	auth := e.edgeConfig.Auth
+	if auth.TLSEnabled() {
+		tlsConfig, err := auth.ServerTLSConfig()
+		if err != nil {
+			logrus.Fatal("failed to load tunnel credentials: ", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+	if !auth.Authenticated() {
+		logrus.Warn("neither client certificate nor token is configured, tunnel on ", address, " rejects all agents")
+	} else if auth.CAFile == "" {
+		logrus.Warn("client certificate is not required, tunnel on ", address, " trusts the node name sent by agents with the token")
+	}
+	go func() {
+		logrus.Info("EdgeRegistry tunnel listen:", address)
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logrus.Fatal("failed to serve tunnel:", err)
+		}
+	}()
+
+	<-e.stopCh
+	server.Shutdown(context.Background())
+}
+
+//dialEdgeadm 节点有反向通道时通过通道访问, 否则直接访问注册时上报的IP
+//edgelet的证书包含节点名称和IP, 两种方式都可以校验
+func (e *EdgeRegistryServer) dialEdgeadm(ctx context.Context, nodeName string) (pb.EdgeadmClient, func(), error) {
+	var conn *grpc.ClientConn
+	var err error
+	if e.tunnel.Connected(nodeName) {
+		conn, err = grpcauth.Dial(nodeName, e.edgeConfig.Auth, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return e.tunnel.DialNode(ctx, nodeName)
+		}))
+	} else {
+		r, rerr := getRegistration(ctx, k8sClient(), nodeName)
+		if rerr != nil {
+			return nil, nil, fmt.Errorf("node %s has no tunnel, and its address is unknown: %v", nodeName, rerr)
+		}
+		if r.IP == "" {
+			return nil, nil, fmt.Errorf("node %s has no tunnel, and its address is unknown", nodeName)
+		}
+		conn, err = grpcauth.Dial(r.IP+constant.EdgeletDefaultAddress, e.edgeConfig.Auth)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return pb.NewEdgeadmClient(conn), func() { conn.Close() }, nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"edge/api/edge-proto/pb"
+	"edge/internal/constant/manifests"
+	"edge/internal/edge-registry/option"
+	"edge/pkg/errdefs"
+	"encoding/json"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+//virtualKubeletValues 渲染virtual-kubelet模板的参数
+type virtualKubeletValues struct {
+	NodeName        string
+	Image           string
+	ImagePullPolicy string
+	DNSServers      []string
+	//以下为json格式, json也是合法的yaml, 可以直接写在模板中
+	Resources   string
+	Tolerations string
+	Labels      string
+	Taints      string
+	//节点join时传入的自定义参数
+	Params map[string]string
+}
+
+//virtualKubeletTemplate 每次创建时重新读取模板文件, 更新ConfigMap后不需要重启edge-registry
+func virtualKubeletTemplate(vk *option.VirtualKubeletOptions) (string, error) {
+	if vk.TemplateFile == "" {
+		return manifests.VirtualKubeletYaml, nil
+	}
+	data, err := os.ReadFile(vk.TemplateFile)
+	if os.IsNotExist(err) {
+		logrus.Debugf("virtual-kubelet template %s not found, use the built-in template", vk.TemplateFile)
+		return manifests.VirtualKubeletYaml, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//newVirtualKubeletValues r为空时只用于删除, 只需要名称正确
+func newVirtualKubeletValues(vk *option.VirtualKubeletOptions, nodeName string, r *nodeRegistration) (*virtualKubeletValues, error) {
+	values := &virtualKubeletValues{
+		NodeName:        nodeName,
+		Image:           vk.Image,
+		ImagePullPolicy: string(vk.ImagePullPolicy),
+		DNSServers:      vk.DNSServers,
+		Params:          map[string]string{},
+	}
+	labels, taints := map[string]string{}, []corev1.Taint{}
+	if r != nil {
+		if r.Labels != nil {
+			labels = r.Labels
+		}
+		if r.Taints != nil {
+			taints = r.Taints
+		}
+		if r.Params != nil {
+			//注册记录可能在校验参数之前保存
+			if err := validateNodeParams(r.Params); err != nil {
+				return nil, err
+			}
+			values.Params = r.Params
+		}
+	}
+	tolerations := vk.Tolerations
+	if tolerations == nil {
+		tolerations = []corev1.Toleration{}
+	}
+	for field, v := range map[*string]interface{}{
+		&values.Resources:   vk.Resources,
+		&values.Tolerations: tolerations,
+		&values.Labels:      labels,
+		&values.Taints:      taints,
+	} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		*field = string(data)
+	}
+	return values, nil
+}
+
+//validateNodeLabels 校验join时指定的标签, 与kubectl label的规则相同
+func validateNodeLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			return errdefs.InvalidInputf("invalid label key %q: %v", k, errs)
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+			return errdefs.InvalidInputf("invalid label value %q: %v", v, errs)
+		}
+	}
+	return nil
+}
+
+//validateNodeParams 参数直接渲染到yaml模板中, 使用标签值的格式, 避免换行, 引号和"---"注入其他对象
+func validateNodeParams(params map[string]string) error {
+	for k, v := range params {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			return errdefs.InvalidInputf("invalid param key %q: %v", k, errs)
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+			return errdefs.InvalidInputf("invalid value %q of param %s: %v", v, k, errs)
+		}
+	}
+	return nil
+}
+
+//nodeTaints 将join时指定的taint转换为kubernetes的taint
+func nodeTaints(taints []*pb.Taint) ([]corev1.Taint, error) {
+	var result []corev1.Taint
+	for _, t := range taints {
+		if errs := validation.IsQualifiedName(t.Key); len(errs) != 0 {
+			return nil, errdefs.InvalidInputf("invalid taint key %q: %v", t.Key, errs)
+		}
+		if t.Value != "" {
+			if errs := validation.IsValidLabelValue(t.Value); len(errs) != 0 {
+				return nil, errdefs.InvalidInputf("invalid taint value %q: %v", t.Value, errs)
+			}
+		}
+		switch effect := corev1.TaintEffect(t.Effect); effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+			result = append(result, corev1.Taint{Key: t.Key, Value: t.Value, Effect: effect})
+		default:
+			return nil, errdefs.InvalidInputf("invalid taint effect %q of key %s", t.Effect, t.Key)
+		}
+	}
+	return result, nil
+}
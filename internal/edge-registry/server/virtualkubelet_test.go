@@ -0,0 +1,153 @@
+package server
+
+import (
+	"edge/api/edge-proto/pb"
+	"edge/internal/edge-registry/option"
+	"edge/pkg/errdefs"
+	"edge/pkg/kubeclient"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+//renderDeployment 渲染模板并返回其中的Deployment
+func renderDeployment(t *testing.T, vk *option.VirtualKubeletOptions, r *nodeRegistration) *appsv1.Deployment {
+	tmpl, err := virtualKubeletTemplate(vk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := newVirtualKubeletValues(vk, r.NodeName, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := kubeclient.ParseString(tmpl, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range regexp.MustCompile(`(?m)^-{3,}$`).Split(string(data), -1) {
+		d := &appsv1.Deployment{}
+		if err := yaml.Unmarshal([]byte(item), d); err != nil {
+			t.Fatalf("invalid yaml %s, err=%v", item, err)
+		}
+		if d.Kind == "Deployment" {
+			return d
+		}
+	}
+	t.Fatalf("no deployment in %s", data)
+	return nil
+}
+
+func Test_virtualKubeletTemplate(t *testing.T) {
+	r := &nodeRegistration{
+		NodeName: "node1",
+		Labels:   map[string]string{"zone": "shop"},
+		Taints:   []corev1.Taint{{Key: "dedicated", Value: "edge", Effect: corev1.TaintEffectNoSchedule}},
+		Params:   map[string]string{"edgeport": "9002"},
+	}
+
+	defaults := option.NewDefaultOptions().VirtualKubelet
+	d := renderDeployment(t, &defaults, r)
+	spec := d.Spec.Template.Spec
+	if d.Name != "vk-node1" || spec.Containers[0].Image != option.DefaultVirtualKubeletImage ||
+		spec.DNSPolicy != corev1.DNSNone || spec.DNSConfig.Nameservers[0] != option.DefaultVirtualKubeletDNSServer {
+		t.Errorf("default deployment = %+v", d)
+	}
+	if d.Annotations["edge.io/node-labels"] != `{"zone":"shop"}` ||
+		d.Annotations["edge.io/node-taints"] != `[{"key":"dedicated","value":"edge","effect":"NoSchedule"}]` {
+		t.Errorf("annotations = %v", d.Annotations)
+	}
+
+	vk := option.VirtualKubeletOptions{
+		Image:           "registry.local/virtual-kubelet:v1",
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		},
+		Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+	}
+	d = renderDeployment(t, &vk, r)
+	spec = d.Spec.Template.Spec
+	c := spec.Containers[0]
+	if c.Image != vk.Image || c.ImagePullPolicy != corev1.PullIfNotPresent || c.Resources.Limits.Memory().String() != "256Mi" {
+		t.Errorf("container = %+v", c)
+	}
+	if spec.DNSPolicy != "" || spec.DNSConfig != nil || len(spec.Tolerations) != 1 {
+		t.Errorf("pod spec = %+v", spec)
+	}
+
+	//模板文件中使用节点参数
+	vk.TemplateFile = filepath.Join(t.TempDir(), "virtual-kubelet.yaml")
+	custom := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: vk-{{.NodeName}}
+  labels:
+    port: "{{index .Params "edgeport"}}"
+    missing: "{{index .Params "missing"}}"
+`
+	if err := os.WriteFile(vk.TemplateFile, []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d = renderDeployment(t, &vk, r)
+	if d.Labels["port"] != "9002" || d.Labels["missing"] != "" {
+		t.Errorf("labels = %v", d.Labels)
+	}
+}
+
+func Test_nodeTaints(t *testing.T) {
+	tests := []struct {
+		name    string
+		taints  []*pb.Taint
+		wantErr bool
+	}{
+		{"valid", []*pb.Taint{{Key: "dedicated", Value: "edge", Effect: "NoSchedule"}, {Key: "edge.io/unstable", Effect: "NoExecute"}}, false},
+		{"no effect", []*pb.Taint{{Key: "dedicated"}}, true},
+		{"invalid effect", []*pb.Taint{{Key: "dedicated", Effect: "Never"}}, true},
+		{"invalid key", []*pb.Taint{{Key: "a b", Effect: "NoSchedule"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taints, err := nodeTaints(tt.taints)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nodeTaints() err=%v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errdefs.IsInvalidInput(err) {
+				t.Errorf("nodeTaints() err=%v, want invalid input", err)
+			}
+			if err == nil && len(taints) != len(tt.taints) {
+				t.Errorf("nodeTaints() = %v", taints)
+			}
+		})
+	}
+}
+
+func Test_validateNodeParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]string
+		wantErr bool
+	}{
+		{"valid", map[string]string{"edgeport": "9002", "edge.io/zone": "shop-1"}, false},
+		{"empty value", map[string]string{"edgeport": ""}, false},
+		{"newline in value", map[string]string{"edgeport": "9002\n---\nkind: ClusterRoleBinding"}, true},
+		{"quote in value", map[string]string{"edgeport": `9002"`}, true},
+		{"invalid key", map[string]string{"edge port": "9002"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNodeSpec(&pb.CreateNodeRequest{NodeName: "node1", Params: tt.params})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateNodeSpec() err=%v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errdefs.IsInvalidInput(err) {
+				t.Errorf("validateNodeSpec() err=%v, want invalid input", err)
+			}
+		})
+	}
+}
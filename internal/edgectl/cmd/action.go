@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lithammer/dedent"
+	"github.com/spf13/cobra"
+)
+
+var actionExample = dedent.Dedent(`
+	# list the actions configured on this node
+	edgectl action list
+
+	# run an action with parameters
+	edgectl action run restart-service --param unit=docker
+	`)
+
+type actionOptions struct {
+	params map[string]string //action的参数
+	writer io.Writer
+	stderr io.Writer
+}
+
+//NewActionCMD 执行edgelet配置文件中定义的action, 代替任意的shell命令
+func NewActionCMD(out, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
+	ao := &actionOptions{writer: out, stderr: stderr}
+	cmd := &cobra.Command{
+		Use:     "action",
+		Short:   "List and run the actions allowed on the edge node",
+		Example: actionExample,
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.EdgeletAddress == "" {
+				return fmt.Errorf("edgelet address is empty")
+			}
+			return nil
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the actions configured on the node",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return actionRunner(cfg, ao, func(client pb.EdgeadmClient) error {
+				return listActions(client, ao.writer)
+			})
+		},
+	}
+	runCmd := &cobra.Command{
+		Use:   "run [action]",
+		Short: "Run an action on the node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return actionRunner(cfg, ao, func(client pb.EdgeadmClient) error {
+				return runAction(client, ao, args[0])
+			})
+		},
+	}
+	runCmd.Flags().StringToStringVar(&ao.params, "param", nil, "Parameters of the action, e.g. --param unit=docker.")
+	cmd.AddCommand(listCmd, runCmd)
+	return cmd
+}
+
+func actionRunner(cfg *EdgeCtlConfig, ao *actionOptions, run func(client pb.EdgeadmClient) error) error {
+	conn, err := cfg.dialEdgelet()
+	if err != nil {
+		fmt.Fprintf(ao.stderr, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	if err := run(pb.NewEdgeadmClient(conn)); err != nil {
+		fmt.Fprintln(ao.stderr, err)
+	}
+	return nil
+}
+
+func listActions(client pb.EdgeadmClient, out io.Writer) error {
+	resp, err := client.ListActions(context.Background(), &pb.ListActionsRequest{})
+	if err != nil {
+		return fmt.Errorf("list actions failed, err=%v", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("list actions failed, err=%v", resp.Error.Msg)
+	}
+	w := tabwriter.NewWriter(out, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPARAMS\tUSER\tTIMEOUT\tDESCRIPTION")
+	for _, a := range resp.Actions {
+		params := make([]string, 0, len(a.Params))
+		for _, p := range a.Params {
+			param := p.Name
+			if p.Pattern != "" {
+				param += "=" + p.Pattern
+			}
+			if !p.Required {
+				param = "[" + param + "]"
+			}
+			params = append(params, param)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Name, strings.Join(params, " "), a.User, a.Timeout, a.Description)
+	}
+	return w.Flush()
+}
+
+func runAction(client pb.EdgeadmClient, ao *actionOptions, name string) error {
+	resp, err := client.RunAction(context.Background(), &pb.RunActionRequest{Name: name, Params: ao.params})
+	if err != nil {
+		return fmt.Errorf("run action %s failed, err=%v", name, err)
+	}
+	fmt.Fprint(ao.writer, resp.Stdout)
+	fmt.Fprint(ao.stderr, resp.Stderr)
+	if resp.Error != nil {
+		return fmt.Errorf("run action %s failed, err=%v", name, resp.Error.Msg)
+	}
+	if resp.ExitCode != 0 {
+		return fmt.Errorf("action %s exited with code %d", name, resp.ExitCode)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"edge/internal/constant"
+	"edge/pkg/grpcauth"
 	"edge/pkg/util"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+
+	"google.golang.org/grpc"
 )
 
 var (
@@ -14,13 +17,21 @@ var (
 	configfile = filepath.Join(configDir, "config.json")
 )
 
+//配置中包含bearer token, 只允许当前用户读写
+const configFileMode = 0600
+
 type EdgeCtlConfig struct {
+	//默认通过本机的unix socket访问edgelet, 访问其他节点的edgelet时使用tcp地址和Auth中的证书
 	EdgeletAddress string
+	//edge-registry的地址, 用于管理bootstrap token
+	RegistryAddress string
+	Auth            grpcauth.Config
 }
 
 func NewEdgeCtlConfig() (*EdgeCtlConfig, error) {
 	conf := EdgeCtlConfig{
-		EdgeletAddress: constant.EdgeletDefaultAddress,
+		EdgeletAddress:  grpcauth.UnixPrefix + constant.EdgeletSocket,
+		RegistryAddress: constant.CenterDomain,
 	}
 	if err := conf.configReady(); err != nil {
 		return nil, err
@@ -34,7 +45,7 @@ func (ecc *EdgeCtlConfig) configReady() error {
 		if err != nil {
 			return err
 		}
-		f, err := os.OpenFile(configfile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+		f, err := os.OpenFile(configfile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, configFileMode)
 		if err != nil {
 			return err
 		}
@@ -45,7 +56,7 @@ func (ecc *EdgeCtlConfig) configReady() error {
 		}
 		f.Write(data)
 	} else {
-		f, err := os.OpenFile(configfile, os.O_RDONLY, 0755)
+		f, err := os.OpenFile(configfile, os.O_RDONLY, configFileMode)
 		if err != nil {
 			return err
 		}
@@ -62,12 +73,24 @@ func (ecc *EdgeCtlConfig) configReady() error {
 	return nil
 }
 
+func (ecc *EdgeCtlConfig) dialEdgelet() (*grpc.ClientConn, error) {
+	return grpcauth.Dial(ecc.EdgeletAddress, ecc.Auth)
+}
+
+func (ecc *EdgeCtlConfig) dialRegistry() (*grpc.ClientConn, error) {
+	return grpcauth.Dial(ecc.RegistryAddress, ecc.Auth)
+}
+
 func (ecc *EdgeCtlConfig) Save() error {
-	f, err := os.OpenFile(configfile, os.O_WRONLY|os.O_TRUNC, 0755)
+	f, err := os.OpenFile(configfile, os.O_WRONLY|os.O_TRUNC, configFileMode)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	//旧版本创建的配置文件为0755
+	if err := f.Chmod(configFileMode); err != nil {
+		return err
+	}
 	data, err := json.Marshal(ecc)
 	if err != nil {
 		return err
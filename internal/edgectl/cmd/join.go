@@ -5,35 +5,47 @@ import (
 	"edge/api/edge-proto/pb"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"google.golang.org/grpc"
 )
 
 var (
 	joinWorkerNodeDoneMsg = dedent.Dedent(`
 		This node has joined the cluster:
-		* Certificate signing request was sent to apiserver and a response was received.
-		* The Edgectl was informed of the new secure connection details.
+		* Certificate signing request was sent to edge-registry and a response was received.
+		* The Edgelet was informed of the new secure connection details.
 
 		
+		`)
+	joinPendingMsg = dedent.Dedent(`
+		This node has registered to the cluster and is waiting for approval.
+		The virtual node will be created once an administrator approves it.
+		Run 'edgectl join' again with the same flags after approval to get the node certificate.
 		`)
 	joinLongDescription = dedent.Dedent(`
 		When joining a cloud initialized cluster, we need to establish
-		bidirectional trust. This is split into discovery (having the Node
-		trust the Kubernetes Control Plane) and TLS bootstrap (having the
-		Kubernetes Control Plane trust the Node).
+		bidirectional trust. The --token flag takes a bootstrap token created
+		by 'edgectl token create': edgelet sends a certificate signing request
+		with it, edge-registry validates the token and signs the certificate,
+		and edgelet uses the certificate for later calls to the cloud.
+
+		Without --token, edgelet joins with the credentials in its config.
 
-		Often times the same token is used for both parts. In this case, the
-		--token flag can be used instead of specifying each token individually.
+		--labels and --taints are applied to the node once it registers.
+		Joining again reconciles them: labels and taints set by a previous
+		join but not given this time are removed from the node.
 		`)
 )
 
 type joinOptions struct {
 	nodeName        string //node的名字
 	registryAddress string //云端的地址
+	token           string //bootstrap token
+	labels          map[string]string
+	taints          []string //key=value:Effect
 	stdout          io.Writer
 	stderr          io.Writer
 }
@@ -47,7 +59,7 @@ func NewJoinCMD(stdout, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
 		Short: "edge join to cloud-cluster",
 		Long:  joinLongDescription,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return joinRunner(cfg.EdgeletAddress, joinOptions)
+			return joinRunner(cfg, joinOptions)
 		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if cfg.EdgeletAddress == "" {
@@ -56,7 +68,8 @@ func NewJoinCMD(stdout, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
 			if joinOptions.nodeName == "" {
 				return fmt.Errorf("please enter node-name")
 			}
-			return nil
+			_, err := parseTaints(joinOptions.taints)
+			return err
 		},
 	}
 	addJoinFlags(cmd.Flags(), joinOptions)
@@ -77,18 +90,64 @@ func addJoinFlags(flagSet *pflag.FlagSet, joinOptions *joinOptions) {
 		&joinOptions.registryAddress, "registry-address", "",
 		"Specify the cloud-cluster registry address.",
 	)
+	flagSet.StringVar(
+		&joinOptions.token, "token", "",
+		"Specify the bootstrap token created by 'edgectl token create'.",
+	)
+	flagSet.StringToStringVar(
+		&joinOptions.labels, "labels", nil,
+		"Labels to add to the node, e.g. 'site=shop-1,gpu=true'.",
+	)
+	flagSet.StringSliceVar(
+		&joinOptions.taints, "taints", nil,
+		"Taints to add to the node in the format of 'key=value:Effect' or 'key:Effect', e.g. 'dedicated=edge:NoSchedule'.",
+	)
+}
+
+//parseTaints 格式与kubectl taint相同, effect为NoSchedule, PreferNoSchedule或NoExecute
+func parseTaints(specs []string) ([]*pb.Taint, error) {
+	var taints []*pb.Taint
+	for _, spec := range specs {
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid taint %q, must be key=value:Effect or key:Effect", spec)
+		}
+		t := &pb.Taint{Key: spec[:i], Effect: spec[i+1:]}
+		if j := strings.Index(t.Key, "="); j >= 0 {
+			t.Key, t.Value = t.Key[:j], t.Key[j+1:]
+		}
+		if t.Key == "" {
+			return nil, fmt.Errorf("invalid taint %q, key is empty", spec)
+		}
+		switch t.Effect {
+		case "NoSchedule", "PreferNoSchedule", "NoExecute":
+		default:
+			return nil, fmt.Errorf("invalid taint %q, unknown effect %q", spec, t.Effect)
+		}
+		taints = append(taints, t)
+	}
+	return taints, nil
 }
 
-func joinRunner(edgeletAddress string, opt *joinOptions) error {
-	conn, err := grpc.Dial(edgeletAddress, grpc.WithInsecure()) //grpc.WithTransportCredentials(insecure.NewCredentials()))
+func joinRunner(cfg *EdgeCtlConfig, opt *joinOptions) error {
+	conn, err := cfg.dialEdgelet()
 	if err != nil {
-		fmt.Fprintf(opt.stderr, "connect edgeletAddress %s failed, err=%v\n", edgeletAddress, err)
+		fmt.Fprintf(opt.stderr, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	taints, err := parseTaints(opt.taints)
+	if err != nil {
+		fmt.Fprintln(opt.stderr, err)
 		return nil
 	}
 	client := pb.NewEdgeadmClient(conn)
 	resp, err := client.Join(context.Background(), &pb.JoinRequest{
 		NodeName:     opt.nodeName,
 		CloudAddress: opt.registryAddress,
+		Token:        opt.token,
+		Labels:       opt.labels,
+		Taints:       taints,
 	})
 	if err != nil {
 		fmt.Fprintf(opt.stderr, "Join failed, err=%v\n", err)
@@ -98,6 +157,10 @@ func joinRunner(edgeletAddress string, opt *joinOptions) error {
 		fmt.Fprintf(opt.stderr, "Join failed, err=%v\n", resp.Error.Msg)
 		return nil
 	}
+	if resp.Pending {
+		fmt.Fprintln(opt.stdout, joinPendingMsg)
+		return nil
+	}
 	fmt.Fprintln(opt.stdout, joinWorkerNodeDoneMsg)
 	return nil
 }
@@ -8,7 +8,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"google.golang.org/grpc"
 )
 
 var (
@@ -26,7 +25,7 @@ func NewResetCMD(stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
 		Use:   "reset",
 		Short: "Performs a best effort revert of changes made to this host by 'edgectl join'",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return resetRunner(cfg.EdgeletAddress, resetOptions)
+			return resetRunner(cfg, resetOptions)
 		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if cfg.EdgeletAddress == "" {
@@ -50,10 +49,10 @@ func addResetFlags(flagSet *pflag.FlagSet, ro *resetOptions) {
 	// )
 }
 
-func resetRunner(edgeletAddress string, opt *resetOptions) error {
-	conn, err := grpc.Dial(edgeletAddress, grpc.WithInsecure())
+func resetRunner(cfg *EdgeCtlConfig, opt *resetOptions) error {
+	conn, err := cfg.dialEdgelet()
 	if err != nil {
-		fmt.Fprintf(opt.writer, "connect edgeletAddress %s failed, err=%v\n", edgeletAddress, err)
+		fmt.Fprintf(opt.writer, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
 		return nil
 	}
 	client := pb.NewEdgeadmClient(conn)
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//newRolloutCMD 管理edge-registry上的批量升级, 使用 edgectl upgrade --nodeName/--selector 创建
+func newRolloutCMD(cfg *EdgeCtlConfig, uo *upgradeOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rollout",
+		Short: "Manage upgrades of many nodes orchestrated by edge-registry",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.RegistryAddress == "" {
+				return fmt.Errorf("registry address is empty")
+			}
+			return nil
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List rollouts",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutRunner(cfg, uo, func(client pb.EdgeRegistryServiceClient) error {
+				return listRollouts(client, uo.writer)
+			})
+		},
+	}
+	getCmd := &cobra.Command{
+		Use:   "get [rollout-id]",
+		Short: "Show a rollout and the upgrade of each node",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rolloutRunner(cfg, uo, func(client pb.EdgeRegistryServiceClient) error {
+				resp, err := client.GetRollout(context.Background(), &pb.GetRolloutRequest{Id: args[0]})
+				if err != nil {
+					return fmt.Errorf("get rollout %s failed, err=%v", args[0], err)
+				}
+				if resp.Error != nil {
+					return fmt.Errorf("get rollout %s failed, err=%v", args[0], resp.Error.Msg)
+				}
+				return printRollout(uo.writer, resp.Rollout)
+			})
+		},
+	}
+	cmd.AddCommand(listCmd, getCmd)
+	for _, action := range []string{"pause", "resume", "abort"} {
+		action := action
+		cmd.AddCommand(&cobra.Command{
+			Use:   action + " [rollout-id]",
+			Short: fmt.Sprintf("%s a rollout, nodes being upgraded are not interrupted", action),
+			Args:  cobra.ExactArgs(1),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return rolloutRunner(cfg, uo, func(client pb.EdgeRegistryServiceClient) error {
+					resp, err := client.UpdateRollout(context.Background(), &pb.UpdateRolloutRequest{Id: args[0], Action: action})
+					if err != nil {
+						return fmt.Errorf("%s rollout %s failed, err=%v", action, args[0], err)
+					}
+					if resp.Error != nil {
+						return fmt.Errorf("%s rollout %s failed, err=%v", action, args[0], resp.Error.Msg)
+					}
+					fmt.Fprintf(uo.writer, "rollout %s is %s\n", args[0], resp.Rollout.State)
+					return nil
+				})
+			},
+		})
+	}
+	return cmd
+}
+
+func rolloutRunner(cfg *EdgeCtlConfig, uo *upgradeOptions, run func(client pb.EdgeRegistryServiceClient) error) error {
+	conn, err := cfg.dialRegistry()
+	if err != nil {
+		fmt.Fprintf(uo.stderr, "connect registryAddress %s failed, err=%v\n", cfg.RegistryAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	if err := run(pb.NewEdgeRegistryServiceClient(conn)); err != nil {
+		fmt.Fprintln(uo.stderr, err)
+	}
+	return nil
+}
+
+//createRollout 通过edge-registry升级--nodeName或--selector指定的节点
+func createRollout(cfg *EdgeCtlConfig, uo *upgradeOptions, component pb.EdgeComponent, signature []byte) error {
+	return rolloutRunner(cfg, uo, func(client pb.EdgeRegistryServiceClient) error {
+		resp, err := client.CreateRollout(context.Background(), &pb.CreateRolloutRequest{
+			Component:      component,
+			Image:          uo.image,
+			Sha256:         uo.sha256,
+			Signature:      signature,
+			Selector:       uo.selector,
+			NodeNames:      uo.nodeNames,
+			BatchSize:      uo.batchSize,
+			MaxUnavailable: uo.maxUnavailable,
+			NodeTimeout:    int64(uo.nodeTimeout / time.Second),
+		})
+		if err != nil {
+			return fmt.Errorf("create rollout failed, err=%v", err)
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("create rollout failed, err=%v", resp.Error.Msg)
+		}
+		ro := resp.Rollout
+		fmt.Fprintf(uo.writer, "rollout %s started on %d nodes\n", ro.Id, len(ro.Nodes))
+		if !uo.wait {
+			fmt.Fprintf(uo.writer, "use 'edgectl upgrade rollout get %s' to check the progress\n", ro.Id)
+			return nil
+		}
+		return waitRollout(client, uo, ro)
+	})
+}
+
+//waitRollout 输出节点阶段的变化, 直到rollout不再运行
+func waitRollout(client pb.EdgeRegistryServiceClient, uo *upgradeOptions, ro *pb.Rollout) error {
+	deadline := time.Now().Add(uo.timeout)
+	phases := map[string]string{}
+	for {
+		for _, n := range ro.Nodes {
+			if phases[n.NodeName] != n.Phase {
+				phases[n.NodeName] = n.Phase
+				fmt.Fprintf(uo.writer, "%s\t%s\t%s\n", n.NodeName, n.Phase, n.Message)
+			}
+		}
+		if ro.State != "Running" && ro.State != "Paused" {
+			fmt.Fprintf(uo.writer, "rollout %s %s %s\n", ro.Id, ro.State, ro.Message)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("rollout %s is still %s after %s", ro.Id, ro.State, uo.timeout)
+		}
+		time.Sleep(2 * time.Second)
+		resp, err := client.GetRollout(context.Background(), &pb.GetRolloutRequest{Id: ro.Id})
+		if err != nil {
+			return fmt.Errorf("get rollout %s failed, err=%v", ro.Id, err)
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("get rollout %s failed, err=%v", ro.Id, resp.Error.Msg)
+		}
+		ro = resp.Rollout
+	}
+}
+
+func listRollouts(client pb.EdgeRegistryServiceClient, out io.Writer) error {
+	resp, err := client.ListRollouts(context.Background(), &pb.ListRolloutsRequest{})
+	if err != nil {
+		return fmt.Errorf("list rollouts failed, err=%v", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("list rollouts failed, err=%v", resp.Error.Msg)
+	}
+	w := tabwriter.NewWriter(out, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tCOMPONENT\tIMAGE\tSTATE\tNODES\tCREATED")
+	for _, ro := range resp.Rollouts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", ro.Id, ro.Component, ro.Image, ro.State, len(ro.Nodes), ro.CreateTime)
+	}
+	return w.Flush()
+}
+
+func printRollout(out io.Writer, ro *pb.Rollout) error {
+	fmt.Fprintf(out, "Rollout:\t%s\nComponent:\t%s\nImage:\t%s\nState:\t%s %s\nBatchSize:\t%d\nMaxUnavailable:\t%d\n\n",
+		ro.Id, ro.Component, ro.Image, ro.State, ro.Message, ro.BatchSize, ro.MaxUnavailable)
+	w := tabwriter.NewWriter(out, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE\tBATCH\tPHASE\tJOB\tMESSAGE")
+	for _, n := range ro.Nodes {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", n.NodeName, n.Batch, n.Phase, n.JobId, n.Message)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type tokenOptions struct {
+	ttl         time.Duration //token的有效期
+	usageLimit  int32         //token可以使用的次数
+	description string
+	autoApprove bool //使用token join的节点不需要审批
+	stdout      io.Writer
+	stderr      io.Writer
+}
+
+//NewTokenCMD 在云端管理join使用的bootstrap token, 需要Auth中有访问edge-registry的证书或token
+func NewTokenCMD(stdout, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
+	to := &tokenOptions{stdout: stdout, stderr: stderr}
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage bootstrap tokens used by 'edgectl join'",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.RegistryAddress == "" {
+				return fmt.Errorf("registry address is empty")
+			}
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVar(&cfg.RegistryAddress, "registry-address", cfg.RegistryAddress, "Specify the cloud-cluster registry address.")
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a bootstrap token",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tokenRunner(cfg, to, func(client pb.EdgeRegistryServiceClient) error {
+				return createToken(client, to)
+			})
+		},
+	}
+	createCmd.Flags().DurationVar(&to.ttl, "ttl", 24*time.Hour, "The duration before the token is expired, 0 means never expire.")
+	createCmd.Flags().Int32Var(&to.usageLimit, "usage-limit", 1, "The number of joins the token can be used for, 0 means unlimited.")
+	createCmd.Flags().StringVar(&to.description, "description", "", "A human friendly description of how this token is used.")
+	createCmd.Flags().BoolVar(&to.autoApprove, "auto-approve", false, "Approve nodes joining with this token without review.")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List bootstrap tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tokenRunner(cfg, to, func(client pb.EdgeRegistryServiceClient) error {
+				return listTokens(client, to)
+			})
+		},
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete [token-id]...",
+		Short: "Delete bootstrap tokens",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return tokenRunner(cfg, to, func(client pb.EdgeRegistryServiceClient) error {
+				return deleteTokens(client, to, args)
+			})
+		},
+	}
+	cmd.AddCommand(createCmd, listCmd, deleteCmd)
+	return cmd
+}
+
+func tokenRunner(cfg *EdgeCtlConfig, to *tokenOptions, run func(client pb.EdgeRegistryServiceClient) error) error {
+	conn, err := cfg.dialRegistry()
+	if err != nil {
+		fmt.Fprintf(to.stderr, "connect registryAddress %s failed, err=%v\n", cfg.RegistryAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	if err := run(pb.NewEdgeRegistryServiceClient(conn)); err != nil {
+		fmt.Fprintln(to.stderr, err)
+	}
+	return nil
+}
+
+func createToken(client pb.EdgeRegistryServiceClient, to *tokenOptions) error {
+	resp, err := client.CreateToken(context.Background(), &pb.CreateTokenRequest{
+		Ttl:         int64(to.ttl / time.Second),
+		UsageLimit:  to.usageLimit,
+		Description: to.description,
+		AutoApprove: to.autoApprove,
+	})
+	if err != nil {
+		return fmt.Errorf("create token failed, err=%v", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("create token failed, err=%v", resp.Error.Msg)
+	}
+	fmt.Fprintln(to.stdout, resp.Token)
+	return nil
+}
+
+func listTokens(client pb.EdgeRegistryServiceClient, to *tokenOptions) error {
+	resp, err := client.ListTokens(context.Background(), &pb.ListTokensRequest{})
+	if err != nil {
+		return fmt.Errorf("list tokens failed, err=%v", err)
+	}
+	if resp.Error != nil {
+		return fmt.Errorf("list tokens failed, err=%v", resp.Error.Msg)
+	}
+	w := tabwriter.NewWriter(to.stdout, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "ID\tEXPIRES\tUSAGES\tDESCRIPTION")
+	for _, t := range resp.Tokens {
+		expires := t.Expires
+		if expires == "" {
+			expires = "<never>"
+		}
+		limit := "unlimited"
+		if t.UsageLimit > 0 {
+			limit = strconv.Itoa(int(t.UsageLimit))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%s\t%s\n", t.Id, expires, t.UsageCount, limit, t.Description)
+	}
+	return w.Flush()
+}
+
+func deleteTokens(client pb.EdgeRegistryServiceClient, to *tokenOptions, ids []string) error {
+	for _, id := range ids {
+		resp, err := client.DeleteToken(context.Background(), &pb.DeleteTokenRequest{TokenId: id})
+		if err != nil {
+			return fmt.Errorf("delete token %s failed, err=%v", id, err)
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("delete token %s failed, err=%v", id, resp.Error.Msg)
+		}
+		fmt.Fprintf(to.stdout, "bootstrap token %s deleted\n", id)
+	}
+	return nil
+}
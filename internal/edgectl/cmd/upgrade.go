@@ -5,29 +5,53 @@ import (
 	"edge/api/edge-proto/pb"
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/lithammer/dedent"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 )
 
 var (
 	edgeletUpgradeDescription = dedent.Dedent(`
-	when upgrade edgelet match error 'signal: terminated', it might be edgelet is upgrading itself.
-	can use 'edgectl version' to check version later
+	edgelet restarts itself after the new binary is installed, and rolls back to the previous
+	version automatically if it is not healthy in time.
+	use 'edgectl upgrade status --watch' to follow the upgrade across the restart.
+	`)
+
+	upgradeExample = dedent.Dedent(`
+	# upgrade edgelet on this node
+	edgectl upgrade --component edgelet --image <image> --sha256 <sha256> --wait
+
+	# upgrade edgelet on all nodes labeled region=east through edge-registry, two nodes per batch
+	edgectl upgrade --component edgelet --image <image> --sha256 <sha256> --selector region=east --batch-size 2 --max-unavailable 2
 	`)
 )
 
 type upgradeOptions struct {
-	nodeName  string   //node的名字
-	component string   //组件的名字
-	image     string   //镜像的名字
-	shellCmds []string //自定义命令
-	writer    io.Writer
-	stderr    io.Writer
+	component string //组件的名字
+	image     string //镜像的名字
+	sha256    string //新版本二进制的SHA-256
+	signFile  string //签名文件
+	wait      bool   //等待升级任务结束
+	watch     bool   //status持续输出任务的变化
+	timeout   time.Duration
+	//指定节点时通过edge-registry批量升级
+	nodeNames      []string
+	selector       string
+	batchSize      int32
+	maxUnavailable int32
+	nodeTimeout    time.Duration
+	writer         io.Writer
+	stderr         io.Writer
+}
+
+//remote 是否通过edge-registry升级其他节点
+func (uo *upgradeOptions) remote() bool {
+	return len(uo.nodeNames) > 0 || uo.selector != ""
 }
 
 func NewUpgradeCMD(out, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
@@ -35,13 +59,18 @@ func NewUpgradeCMD(out, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
 	upgradeOptions.writer = out
 	upgradeOptions.stderr = stderr
 	cmd := &cobra.Command{
-		Use:   "upgrade",
-		Short: "upgrade a component on edge",
+		Use:     "upgrade",
+		Short:   "upgrade a component on edge",
+		Example: upgradeExample,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return upgradeRunner(cfg.EdgeletAddress, upgradeOptions)
+			return upgradeRunner(cfg, upgradeOptions)
 		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
-			if cfg.EdgeletAddress == "" {
+			if upgradeOptions.remote() {
+				if cfg.RegistryAddress == "" {
+					return fmt.Errorf("registry address is empty")
+				}
+			} else if cfg.EdgeletAddress == "" {
 				return fmt.Errorf("edgelet address is empty")
 			}
 			if upgradeOptions.component == "" {
@@ -50,10 +79,40 @@ func NewUpgradeCMD(out, stderr io.Writer, cfg *EdgeCtlConfig) *cobra.Command {
 			if upgradeOptions.image == "" {
 				return fmt.Errorf("upgrade need specify image ")
 			}
+			component := strings.ToLower(upgradeOptions.component)
+			if (component == "edgelet" || component == "edgectl") && upgradeOptions.sha256 == "" {
+				return fmt.Errorf("upgrade %s need specify sha256 of the new binary", component)
+			}
 			return nil
 		},
 	}
 	addUpgradeFlags(cmd.Flags(), upgradeOptions)
+	cmd.PersistentFlags().DurationVar(&upgradeOptions.timeout, "timeout", 10*time.Minute, "How long to wait for the upgrade job with --wait or --watch.")
+	cmd.PersistentFlags().StringVar(&cfg.RegistryAddress, "registry-address", cfg.RegistryAddress, "Specify the cloud-cluster registry address, used with --nodeName or --selector.")
+
+	statusCmd := &cobra.Command{
+		Use:   "status [job-id]",
+		Short: "Show the status of an upgrade job, the latest job if job-id is omitted",
+		Args:  cobra.MaximumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if cfg.EdgeletAddress == "" {
+				return fmt.Errorf("edgelet address is empty")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			jobID := ""
+			if len(args) > 0 {
+				jobID = args[0]
+			}
+			if upgradeOptions.watch {
+				return watchUpgradeJob(cfg, upgradeOptions, jobID)
+			}
+			return upgradeStatusRunner(cfg, upgradeOptions, jobID)
+		},
+	}
+	statusCmd.Flags().BoolVarP(&upgradeOptions.watch, "watch", "w", false, "Follow the job until it is finished, edgelet restarting itself is tolerated.")
+	cmd.AddCommand(statusCmd, newRolloutCMD(cfg, upgradeOptions))
 	return cmd
 }
 
@@ -62,30 +121,50 @@ func newUpgradeOptions() *upgradeOptions {
 }
 
 func addUpgradeFlags(flagSet *pflag.FlagSet, uo *upgradeOptions) {
-	flagSet.StringVar(&uo.nodeName, "nodeName", "", "Specify the node name to upgrade the edgeNode component.")
 	flagSet.StringVar(&uo.component, "component", "", "Specify the component to upgrade")
 	flagSet.StringVar(&uo.image, "image", "", "Specify the image to upgrade component.")
-	flagSet.StringArrayVar(&uo.shellCmds, "cmd", nil, "Customize the upgrade shell command.")
-	flagSet.MarkHidden("cmd")
+	flagSet.StringVar(&uo.sha256, "sha256", "", "SHA-256 of the new binary, required to upgrade edgelet and edgectl.")
+	flagSet.StringVar(&uo.signFile, "signature-file", "", "File of the ed25519 signature of the binary's SHA-256 digest, required if edgelet is configured with a public key.")
+	flagSet.BoolVar(&uo.wait, "wait", false, "Wait for the upgrade job or rollout to finish and show its progress.")
+	flagSet.StringSliceVar(&uo.nodeNames, "nodeName", nil, "Upgrade these nodes through edge-registry instead of this node.")
+	flagSet.StringVar(&uo.selector, "selector", "", "Upgrade the edge nodes matching this label selector through edge-registry, e.g. 'region=east'.")
+	flagSet.Int32Var(&uo.batchSize, "batch-size", 1, "Number of nodes in each batch of a rollout, the first batch is the canary.")
+	flagSet.Int32Var(&uo.maxUnavailable, "max-unavailable", 1, "Maximum number of nodes upgrading or failed at the same time, the rollout stops when this many nodes failed.")
+	flagSet.DurationVar(&uo.nodeTimeout, "node-timeout", 10*time.Minute, "How long edge-registry waits for the upgrade of each node.")
 }
 
-func upgradeRunner(edgeletAddress string, opt *upgradeOptions) error {
-	conn, err := grpc.Dial(edgeletAddress, grpc.WithInsecure())
-	if err != nil {
-		fmt.Fprintf(opt.stderr, "connect edgeletAddress %s failed, err=%v\n", edgeletAddress, err)
-		return nil
+func upgradeRunner(cfg *EdgeCtlConfig, opt *upgradeOptions) error {
+	var signature []byte
+	if opt.signFile != "" {
+		var err error
+		signature, err = os.ReadFile(opt.signFile)
+		if err != nil {
+			fmt.Fprintf(opt.stderr, "read signature file %s failed, err=%v\n", opt.signFile, err)
+			return nil
+		}
 	}
-	client := pb.NewEdgeadmClient(conn)
 
 	component := pb.EdgeComponent_UNKNOW
 	if value, ok := pb.EdgeComponent_value[strings.ToUpper(opt.component)]; ok {
 		component = pb.EdgeComponent(value)
 	}
-	ctx := metadata.AppendToOutgoingContext(context.Background(), "node", opt.nodeName)
+	if opt.remote() {
+		return createRollout(cfg, opt, component, signature)
+	}
+
+	conn, err := cfg.dialEdgelet()
+	if err != nil {
+		fmt.Fprintf(opt.stderr, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	client := pb.NewEdgeadmClient(conn)
+	ctx := context.Background()
 	req := &pb.UpgradeRequest{
 		Component: component,
 		Image:     opt.image,
-		ShellCmds: opt.shellCmds,
+		Sha256:    opt.sha256,
+		Signature: signature,
 	}
 	resp, err := client.Upgrade(ctx, req)
 	if err != nil {
@@ -94,11 +173,106 @@ func upgradeRunner(edgeletAddress string, opt *upgradeOptions) error {
 	}
 	if resp.Error != nil {
 		fmt.Fprintf(opt.stderr, "upgrade %s failed, err=%v\n", opt.component, resp.Error.Msg)
-		if component == pb.EdgeComponent_EDGELET {
-			fmt.Println(edgeletUpgradeDescription)
-		}
 		return nil
 	}
-	fmt.Printf("Upgrade Component %s Success !\n", opt.component)
+	fmt.Fprintf(opt.writer, "upgrade job %s started\n", resp.JobId)
+	if opt.wait {
+		return watchUpgradeJob(cfg, opt, resp.JobId)
+	}
+	if component == pb.EdgeComponent_EDGELET {
+		fmt.Fprintln(opt.writer, edgeletUpgradeDescription)
+	}
 	return nil
 }
+
+func upgradeStatusRunner(cfg *EdgeCtlConfig, opt *upgradeOptions, jobID string) error {
+	conn, err := cfg.dialEdgelet()
+	if err != nil {
+		fmt.Fprintf(opt.stderr, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
+		return nil
+	}
+	defer conn.Close()
+	resp, err := pb.NewEdgeadmClient(conn).GetUpgradeStatus(context.Background(), &pb.GetUpgradeStatusRequest{JobId: jobID})
+	if err != nil {
+		fmt.Fprintf(opt.stderr, "get upgrade status match err=%v\n", err)
+		return nil
+	}
+	if resp.Error != nil {
+		fmt.Fprintf(opt.stderr, "get upgrade status failed, err=%v\n", resp.Error.Msg)
+		return nil
+	}
+	job := resp.Job
+	w := tabwriter.NewWriter(opt.writer, 10, 4, 3, ' ', 0)
+	fmt.Fprintln(w, "JOB\tCOMPONENT\tIMAGE\tPHASE\tUPDATED\tMESSAGE")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", job.Id, job.Component, job.Image, job.Phase, job.UpdateTime, job.Message)
+	return w.Flush()
+}
+
+//watchUpgradeJob 输出任务的每个阶段直到结束, edgelet升级自身时连接会断开, 重新连接后继续watch
+func watchUpgradeJob(cfg *EdgeCtlConfig, opt *upgradeOptions, jobID string) error {
+	deadline := time.Now().Add(opt.timeout)
+	lastPhase := ""
+	for {
+		job, err := watchUpgradeOnce(cfg, opt, jobID, &lastPhase)
+		if job != nil && job.Finished {
+			return nil
+		}
+		if err != nil {
+			if _, ok := err.(jobError); ok {
+				fmt.Fprintf(opt.stderr, "watch upgrade failed, err=%v\n", err)
+				return nil
+			}
+			fmt.Fprintf(opt.stderr, "connection to edgelet lost, retrying, err=%v\n", err)
+		}
+		if job != nil {
+			jobID = job.Id
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintf(opt.stderr, "upgrade job %s is not finished in %s, check it later with 'edgectl upgrade status %s'\n", jobID, opt.timeout, jobID)
+			return nil
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+//jobError 服务端返回的错误, 例如任务不存在, 不需要重试
+type jobError struct{ msg string }
+
+func (e jobError) Error() string { return e.msg }
+
+//watchUpgradeOnce 返回最后收到的任务
+func watchUpgradeOnce(cfg *EdgeCtlConfig, opt *upgradeOptions, jobID string, lastPhase *string) (*pb.UpgradeJob, error) {
+	conn, err := cfg.dialEdgelet()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := pb.NewEdgeadmClient(conn).WatchUpgrade(ctx, &pb.WatchUpgradeRequest{JobId: jobID})
+	if err != nil {
+		return nil, err
+	}
+	var job *pb.UpgradeJob
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return job, nil
+		}
+		if err != nil {
+			return job, err
+		}
+		if resp.Error != nil {
+			return job, jobError{resp.Error.Msg}
+		}
+		job = resp.Job
+		//重新连接后会再次收到当前阶段
+		if job.Phase != *lastPhase {
+			*lastPhase = job.Phase
+			fmt.Fprintf(opt.writer, "%s\t%s\t%s\n", job.UpdateTime, job.Phase, job.Message)
+		}
+		if job.Finished {
+			return job, nil
+		}
+	}
+}
@@ -9,7 +9,6 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -31,7 +30,7 @@ func NewVersionCMD(stderr io.Writer, version string, cfg *EdgeCtlConfig) *cobra.
 		Short: "Show the version of the edge component",
 		Long:  `Show the version of the edge component`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return versionRunner(stderr, cfg.EdgeletAddress, vo)
+			return versionRunner(stderr, cfg, vo)
 		},
 		PreRunE: func(cmd *cobra.Command, args []string) error {
 			if cfg.EdgeletAddress == "" {
@@ -52,11 +51,11 @@ func addVersionFlags(flagSet *pflag.FlagSet, vo *versionOptions) {
 	flagSet.StringVar(&vo.nodeName, "nodeName", "", "If you wanna get edge version on cloud, you should specify nodeName.")
 }
 
-func versionRunner(stderr io.Writer, edgeletAddress string, vo *versionOptions) error {
+func versionRunner(stderr io.Writer, cfg *EdgeCtlConfig, vo *versionOptions) error {
 	ver := version{}
-	conn, err := grpc.Dial(edgeletAddress, grpc.WithInsecure())
+	conn, err := cfg.dialEdgelet()
 	if err != nil {
-		fmt.Fprintf(stderr, "connect edgeletAddress %s failed, err=%v\n", edgeletAddress, err)
+		fmt.Fprintf(stderr, "connect edgeletAddress %s failed, err=%v\n", cfg.EdgeletAddress, err)
 		return nil
 	}
 	client := pb.NewEdgeadmClient(conn)
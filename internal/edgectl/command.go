@@ -35,6 +35,8 @@ func NewEdgeCtlCommand(in io.Reader, stdout, stderr io.Writer, version string) *
 	cmds.AddCommand(cmd.NewUpgradeCMD(stdout, stderr, edgectlConf))
 	cmds.AddCommand(cmd.NewInitCmd(edgectlConf))
 	cmds.AddCommand(cmd.NewVersionCMD(stderr, version, edgectlConf))
+	cmds.AddCommand(cmd.NewTokenCMD(stdout, stderr, edgectlConf))
+	cmds.AddCommand(cmd.NewActionCMD(stdout, stderr, edgectlConf))
 	return cmds
 }
 
@@ -0,0 +1,285 @@
+package action
+
+import (
+	"context"
+	"edge/pkg/errdefs"
+	"edge/pkg/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"regexp"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	节点上可以远程执行的操作只能是配置文件中定义的action, 例如:
+	{
+	  "actions": [
+	    {
+	      "name": "restart-service",
+	      "description": "restart a systemd service",
+	      "command": ["systemctl", "restart", "${unit}"],
+	      "params": [{"name": "unit", "pattern": "docker|containerd", "required": true}],
+	      "timeout": "1m",
+	      "user": "root"
+	    }
+	  ]
+	}
+	command直接执行, 不经过shell, 参数只能替换${name}且必须匹配pattern.
+	配置文件每次调用时读取, 修改后不需要重启edgelet. 每次调用(包括被拒绝的)都记录到审计日志
+*/
+
+const (
+	DefaultTimeout = time.Minute
+	//参数没有配置pattern时允许的值, 不能以"-"开头, 避免被命令当作选项
+	defaultPattern = `([A-Za-z0-9._:/=][A-Za-z0-9._:/=-]*)?`
+)
+
+//Param action的参数
+type Param struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	//参数值必须完整匹配的正则表达式, 为空时使用defaultPattern
+	Pattern  string `json:"pattern,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	//没有传入时使用的值
+	Default string `json:"default,omitempty"`
+
+	re *regexp.Regexp
+}
+
+//Action 一个允许执行的命令模板
+type Action struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Command     []string `json:"command"`
+	Params      []Param  `json:"params,omitempty"`
+	//如 "30s", 为空时使用DefaultTimeout
+	Timeout string `json:"timeout,omitempty"`
+	//执行命令的用户, 必须配置
+	User string `json:"user"`
+
+	timeout time.Duration
+}
+
+type Config struct {
+	Actions []Action `json:"actions"`
+}
+
+//Result 命令执行的结果, 命令返回非0时ExitCode不为0, 不作为错误
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+type Manager struct {
+	configFile string
+	audit      *auditLog
+
+	//测试时替换
+	run func(ctx context.Context, a *Action, args []string) (*Result, error)
+}
+
+//NewManager configFile不存在时没有可以执行的action
+func NewManager(configFile, auditFile string) *Manager {
+	return &Manager{
+		configFile: configFile,
+		audit:      &auditLog{path: auditFile},
+		run:        runCommand,
+	}
+}
+
+//LoadConfig 读取并校验配置文件, 文件不存在时返回空配置
+func LoadConfig(file string) (*Config, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parse action config %s failed, err=%v", file, err)
+	}
+	names := map[string]bool{}
+	for i := range c.Actions {
+		a := &c.Actions[i]
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("invalid action %q in %s, err=%v", a.Name, file, err)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("duplicate action %q in %s", a.Name, file)
+		}
+		names[a.Name] = true
+	}
+	sort.Slice(c.Actions, func(i, j int) bool { return c.Actions[i].Name < c.Actions[j].Name })
+	return c, nil
+}
+
+func (a *Action) validate() error {
+	if a.Name == "" {
+		return errors.New("name is empty")
+	}
+	if len(a.Command) == 0 || a.Command[0] == "" {
+		return errors.New("command is empty")
+	}
+	if a.User == "" {
+		return errors.New("user is empty")
+	}
+	a.timeout = DefaultTimeout
+	if a.Timeout != "" {
+		timeout, err := time.ParseDuration(a.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %s", a.Timeout)
+		}
+		a.timeout = timeout
+	}
+	declared := map[string]bool{}
+	for i := range a.Params {
+		p := &a.Params[i]
+		if p.Name == "" || declared[p.Name] {
+			return fmt.Errorf("param name %q is empty or duplicate", p.Name)
+		}
+		declared[p.Name] = true
+		pattern := p.Pattern
+		if pattern == "" {
+			pattern = defaultPattern
+		}
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid pattern of param %s, err=%v", p.Name, err)
+		}
+		p.re = re
+		if p.Default != "" && !re.MatchString(p.Default) {
+			return fmt.Errorf("default value of param %s does not match %s", p.Name, pattern)
+		}
+	}
+	//命令中只能引用声明的参数
+	for _, arg := range a.Command {
+		var undeclared []string
+		os.Expand(arg, func(name string) string {
+			if !declared[name] {
+				undeclared = append(undeclared, name)
+			}
+			return ""
+		})
+		if len(undeclared) > 0 {
+			return fmt.Errorf("command references undeclared params %v", undeclared)
+		}
+	}
+	return nil
+}
+
+//args 校验参数并替换命令中的${name}
+func (a *Action) args(params map[string]string) ([]string, error) {
+	values := map[string]string{}
+	for _, p := range a.Params {
+		v, ok := params[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, errdefs.InvalidInputf("param %s of action %s is required", p.Name, a.Name)
+			}
+			v = p.Default
+		}
+		if !p.re.MatchString(v) {
+			return nil, errdefs.InvalidInputf("param %s=%q of action %s is not allowed", p.Name, v, a.Name)
+		}
+		values[p.Name] = v
+	}
+	for name := range params {
+		if _, ok := values[name]; !ok {
+			return nil, errdefs.InvalidInputf("unknown param %s of action %s", name, a.Name)
+		}
+	}
+	args := make([]string, len(a.Command))
+	for i, arg := range a.Command {
+		args[i] = os.Expand(arg, func(name string) string { return values[name] })
+	}
+	return args, nil
+}
+
+//List 配置的所有action
+func (m *Manager) List() ([]Action, error) {
+	c, err := LoadConfig(m.configFile)
+	if err != nil {
+		return nil, err
+	}
+	return c.Actions, nil
+}
+
+//Run 执行action, caller是调用者的身份, 只用于审计
+func (m *Manager) Run(ctx context.Context, caller, name string, params map[string]string) (*Result, error) {
+	start := time.Now()
+	record := &auditRecord{Time: start, Caller: caller, Action: name, Params: params}
+	result, err := m.runAction(ctx, record, name, params)
+	record.Duration = time.Since(start).String()
+	if err != nil {
+		record.Error = err.Error()
+	} else {
+		record.ExitCode = result.ExitCode
+	}
+	m.audit.write(record)
+	return result, err
+}
+
+func (m *Manager) runAction(ctx context.Context, record *auditRecord, name string, params map[string]string) (*Result, error) {
+	c, err := LoadConfig(m.configFile)
+	if err != nil {
+		return nil, err
+	}
+	var action *Action
+	for i := range c.Actions {
+		if c.Actions[i].Name == name {
+			action = &c.Actions[i]
+			break
+		}
+	}
+	if action == nil {
+		return nil, errdefs.NotFoundf("action %s not found", name)
+	}
+	args, err := action.args(params)
+	if err != nil {
+		return nil, err
+	}
+	record.User = action.User
+	record.Command = args
+	logrus.Infof("run action %s for %s as %s: %q", name, record.Caller, action.User, args)
+	ctx, cancel := context.WithTimeout(ctx, action.timeout)
+	defer cancel()
+	result, err := m.run(ctx, action, args)
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, fmt.Errorf("action %s timed out after %s", name, action.timeout)
+	}
+	return result, err
+}
+
+func runCommand(ctx context.Context, a *Action, args []string) (*Result, error) {
+	u, err := user.Lookup(a.User)
+	if err != nil {
+		return nil, fmt.Errorf("lookup user %s of action %s failed, err=%v", a.User, a.Name, err)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = "/"
+	cmd.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "HOME=" + u.HomeDir, "USER=" + u.Username}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+	log := logrus.WithField("action", a.Name)
+	result, err := util.RunCommand(ctx, cmd, func(stream, line string) {
+		log.Infof("%s: %s", stream, line)
+	})
+	if result == nil {
+		return nil, err
+	}
+	return &Result{Stdout: result.Stdout, Stderr: result.Stderr, ExitCode: result.ExitCode}, err
+}
@@ -0,0 +1,216 @@
+package action
+
+import (
+	"context"
+	"edge/pkg/errdefs"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+  "actions": [
+    {
+      "name": "restart-service",
+      "command": ["systemctl", "restart", "${unit}"],
+      "params": [{"name": "unit", "pattern": "docker|containerd", "required": true}],
+      "timeout": "30s",
+      "user": "root"
+    },
+    {
+      "name": "prune-images",
+      "command": ["docker", "image", "prune", "--filter", "until=${until}", "-f"],
+      "params": [{"name": "until", "pattern": "[0-9]+h", "default": "24h"}],
+      "user": "root"
+    },
+    {
+      "name": "service-status",
+      "command": ["systemctl", "status", "${unit}"],
+      "params": [{"name": "unit", "required": true}],
+      "user": "root"
+    }
+  ]
+}`
+
+func newTestManager(t *testing.T, config string) (*Manager, string) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "actions.json")
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auditFile := filepath.Join(dir, "audit", "actions.log")
+	return NewManager(configFile, auditFile), auditFile
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		action   string
+		params   map[string]string
+		wantArgs []string
+		notFound bool
+		invalid  bool
+	}{
+		{
+			name:     "param replaced",
+			action:   "restart-service",
+			params:   map[string]string{"unit": "docker"},
+			wantArgs: []string{"systemctl", "restart", "docker"},
+		},
+		{
+			name:     "default param",
+			action:   "prune-images",
+			wantArgs: []string{"docker", "image", "prune", "--filter", "until=24h", "-f"},
+		},
+		{
+			name:     "unknown action",
+			action:   "reboot",
+			notFound: true,
+		},
+		{
+			name:    "required param missing",
+			action:  "restart-service",
+			invalid: true,
+		},
+		{
+			name:    "param does not match the whole pattern",
+			action:  "restart-service",
+			params:  map[string]string{"unit": "docker; rm -rf /"},
+			invalid: true,
+		},
+		{
+			name:     "default pattern",
+			action:   "service-status",
+			params:   map[string]string{"unit": "edgelet.service"},
+			wantArgs: []string{"systemctl", "status", "edgelet.service"},
+		},
+		{
+			name:    "default pattern rejects options",
+			action:  "service-status",
+			params:  map[string]string{"unit": "--kill-who=all"},
+			invalid: true,
+		},
+		{
+			name:    "unknown param",
+			action:  "prune-images",
+			params:  map[string]string{"all": "true"},
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, auditFile := newTestManager(t, testConfig)
+			var gotArgs []string
+			m.run = func(ctx context.Context, a *Action, args []string) (*Result, error) {
+				gotArgs = args
+				return &Result{Stdout: "ok"}, nil
+			}
+			_, err := m.Run(context.Background(), "admin", tt.action, tt.params)
+			switch {
+			case tt.notFound:
+				if !errdefs.IsNotFound(err) {
+					t.Fatalf("want not found error, got %v", err)
+				}
+			case tt.invalid:
+				if !errdefs.IsInvalidInput(err) {
+					t.Fatalf("want invalid input error, got %v", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", gotArgs, tt.wantArgs)
+			}
+			//被拒绝的调用也要审计
+			data, err := os.ReadFile(auditFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			record := &auditRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Caller != "admin" || record.Action != tt.action || (record.Error == "") != (err == nil && !tt.notFound && !tt.invalid) {
+				t.Errorf("audit record = %+v", record)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	m, _ := newTestManager(t, `{"actions": [{"name": "sleep", "command": ["sleep", "10"], "timeout": "10ms", "user": "root"}]}`)
+	m.run = func(ctx context.Context, a *Action, args []string) (*Result, error) {
+		<-ctx.Done()
+		return &Result{}, ctx.Err()
+	}
+	start := time.Now()
+	if _, err := m.Run(context.Background(), "admin", "sleep", nil); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("want timeout error, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("action is not stopped at the timeout")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			config: testConfig,
+		},
+		{
+			name:    "user is required",
+			config:  `{"actions": [{"name": "ls", "command": ["ls"]}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "undeclared param",
+			config:  `{"actions": [{"name": "ls", "command": ["ls", "${dir}"], "user": "nobody"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "duplicate action",
+			config:  `{"actions": [{"name": "ls", "command": ["ls"], "user": "nobody"}, {"name": "ls", "command": ["ls"], "user": "nobody"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "default does not match pattern",
+			config:  `{"actions": [{"name": "ls", "command": ["ls", "${dir}"], "params": [{"name": "dir", "pattern": "/tmp", "default": "/"}], "user": "nobody"}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t, tt.config)
+			_, err := m.List()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("List() err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	a := &Action{Name: "sh", User: u.Username}
+	result, err := runCommand(context.Background(), a, []string{"sh", "-c", "echo out; echo err >&2; exit 3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Result{Stdout: "out\n", Stderr: "err\n", ExitCode: 3}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+}
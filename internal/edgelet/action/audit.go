@@ -0,0 +1,57 @@
+package action
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//auditRecord 审计日志中的一行
+type auditRecord struct {
+	Time     time.Time         `json:"time"`
+	Caller   string            `json:"caller"`
+	Action   string            `json:"action"`
+	Params   map[string]string `json:"params,omitempty"`
+	User     string            `json:"user,omitempty"`
+	Command  []string          `json:"command,omitempty"`
+	ExitCode int               `json:"exitCode"`
+	Duration string            `json:"duration"`
+	Error    string            `json:"error,omitempty"`
+}
+
+//auditLog 每次调用追加一行json, path为空时只记录到日志
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (l *auditLog) write(r *auditRecord) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		logrus.Error("marshal action audit record failed, err=", err)
+		return
+	}
+	logrus.Infof("action audit: %s", data)
+	if l.path == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		logrus.Errorf("write action audit log %s failed, err=%v", l.path, err)
+		return
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logrus.Errorf("write action audit log %s failed, err=%v", l.path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logrus.Errorf("write action audit log %s failed, err=%v", l.path, err)
+	}
+}
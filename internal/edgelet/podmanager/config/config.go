@@ -3,11 +3,24 @@ package config
 import (
 	"edge/internal/constant"
 	"path/filepath"
+	"time"
 )
 
 const (
 	defaultProject     = "edge"
 	defaultProjectPath = constant.EdgeletDurablePath
+
+	defaultContainerdAddress = "/run/containerd/containerd.sock"
+	defaultSnapshotter       = "overlayfs"
+)
+
+//DefaultCompletedPodTTL 运行结束的pod默认保留容器的时间
+const DefaultCompletedPodTTL = time.Hour
+
+//容器运行时
+const (
+	RuntimeDocker     = "docker"
+	RuntimeContainerd = "containerd"
 )
 
 type Config struct {
@@ -15,6 +28,16 @@ type Config struct {
 	ProjectPath string
 	VolumePath  string
 	IPAddress   string
+	Runtime     string
+	Containerd  ContainerdConfig
+	//运行结束的pod保留容器的时间, 0表示不删除
+	CompletedPodTTL time.Duration
+}
+
+type ContainerdConfig struct {
+	Address     string
+	Namespace   string //为空时使用Project
+	Snapshotter string
 }
 
 type Option interface {
@@ -26,9 +49,23 @@ func DefaultConfig() Config {
 		Project:     defaultProject,
 		ProjectPath: defaultProjectPath + defaultProject,
 		VolumePath:  defaultProjectPath + defaultProject + "/vol",
+		Runtime:     RuntimeDocker,
+		Containerd: ContainerdConfig{
+			Address:     defaultContainerdAddress,
+			Snapshotter: defaultSnapshotter,
+		},
+		CompletedPodTTL: DefaultCompletedPodTTL,
 	}
 }
 
+func (c *Config) PodStoreRoot() string {
+	return filepath.Join(c.ProjectPath, "pods")
+}
+
+func (c *Config) LogRoot() string {
+	return filepath.Join(c.ProjectPath, "logs")
+}
+
 func (c *Config) EmptyDirRoot() string {
 	return filepath.Join(c.VolumePath, "emptydir")
 }
@@ -68,3 +105,33 @@ func WithIPAddress(address string) Option {
 		c.IPAddress = address
 	})
 }
+
+func WithRuntime(runtime string) Option {
+	return newFuncConfigOption(func(c *Config) {
+		if runtime != "" {
+			c.Runtime = runtime
+		}
+	})
+}
+
+func WithContainerd(cc ContainerdConfig) Option {
+	return newFuncConfigOption(func(c *Config) {
+		if cc.Address != "" {
+			c.Containerd.Address = cc.Address
+		}
+		if cc.Namespace != "" {
+			c.Containerd.Namespace = cc.Namespace
+		}
+		if cc.Snapshotter != "" {
+			c.Containerd.Snapshotter = cc.Snapshotter
+		}
+	})
+}
+
+func WithCompletedPodTTL(ttl time.Duration) Option {
+	return newFuncConfigOption(func(c *Config) {
+		if ttl >= 0 {
+			c.CompletedPodTTL = ttl
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package containerd
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const cleanupInterval = time.Minute
+
+//cleanupCompletedPods 定期删除运行结束超过CompletedPodTTL的pod的容器
+//pod的最终状态保存在completed中直到DeletePod, 日志文件不删除, 删除容器后仍然可以查看
+func (c *ctrPodManager) cleanupCompletedPods() {
+	if c.CompletedPodTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := c.context(context.Background())
+		pods, err := c.GetPods(ctx)
+		if err != nil {
+			logrus.Error("cleanupCompletedPods GetPods failed,err=", err)
+			continue
+		}
+		for _, pod := range pods {
+			if !podutil.IsTerminated(pod) {
+				continue
+			}
+			finishedAt, ok := podutil.FinishedAt(pod)
+			if !ok || time.Since(finishedAt) < c.CompletedPodTTL {
+				continue
+			}
+			if err := c.removeCompletedContainers(ctx, pod.Namespace, pod.Name); err != nil {
+				logrus.Errorf("remove containers of completed pod %s/%s failed,err=%v", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+}
+
+//removeCompletedContainers 持有podMutex后重新获取状态, 期间被更新或删除的pod不处理
+func (c *ctrPodManager) removeCompletedContainers(ctx context.Context, namespace, podName string) error {
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	containers, err := c.podContainers(ctx, namespace, podName)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	pod, err := c.containersToK8sPod(ctx, containers)
+	if err != nil {
+		return err
+	}
+	if !podutil.IsTerminated(pod) {
+		return nil
+	}
+	if err := c.completed.Put(pod); err != nil {
+		return err
+	}
+	if err := c.completed.UpdateStatus(pod.Namespace, pod.Name, pod.Status); err != nil {
+		return err
+	}
+	logrus.Infof("pod %s/%s completed more than %s ago, remove its containers", pod.Namespace, pod.Name, c.CompletedPodTTL)
+	for _, container := range containers {
+		if err := c.removeContainer(ctx, container, podGracePeriod(pod)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//completedPod 容器已经被删除的pod, 与下发的是同一个pod时不再重新运行
+func (c *ctrPodManager) completedPod(pod *v1.Pod) (*v1.Pod, bool) {
+	stored, err := c.completed.Get(pod.Namespace, pod.Name)
+	if err != nil {
+		return nil, false
+	}
+	if stored.UID != pod.UID {
+		return nil, false
+	}
+	return stored, true
+}
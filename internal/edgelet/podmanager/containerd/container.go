@@ -0,0 +1,369 @@
+package containerd
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	ctrderrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/containerd/typeurl"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	k8sNamespaceLabel     = "k8s-namespace"
+	k8sPodNameLabel       = "k8s-podname"
+	k8sContainerNameLabel = "k8s-containername"
+	k8sContainerHashLabel = "k8s-containerhash"
+	k8sInitContainer      = "k8s-initContainer"
+	k8sRestartCountLabel  = "k8s-restartcount"
+	k8sStartedAtLabel     = "k8s-startedat"
+
+	//完整的pod保存在容器的extension中, label的长度有限制
+	podExtension = "edge.pod"
+)
+
+//v1.Pod实现了gogo的proto接口, typeurl对其编解码不一致, 所以包装一层按json保存
+type podInfo struct {
+	Pod *v1.Pod `json:"pod"`
+}
+
+func init() {
+	typeurl.Register(&podInfo{}, "edge", "podInfo")
+}
+
+//containerd的容器id只能包含[A-Za-z0-9_.-], namespace和pod名称中不会出现下划线
+func containerID(pod *v1.Pod, containerName string) string {
+	return fmt.Sprintf("%s_%s_%s", pod.Namespace, pod.Name, containerName)
+}
+
+func containerHash(spec v1.Container) string {
+	data, _ := json.Marshal(spec)
+	h := fnv.New32a()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum32())
+}
+
+func sameSpec(ctx context.Context, container containerd.Container, spec v1.Container) (bool, error) {
+	labels, err := container.Labels(ctx)
+	if err != nil {
+		return false, err
+	}
+	return labels[k8sContainerHashLabel] == containerHash(spec), nil
+}
+
+func exitedCode(ctx context.Context, container containerd.Container) (uint32, bool) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return 0, false
+	}
+	status, err := task.Status(ctx)
+	if err != nil || status.Status != containerd.Stopped {
+		return 0, false
+	}
+	return status.ExitStatus, true
+}
+
+func (c *ctrPodManager) logPath(pod *v1.Pod, containerName string) string {
+	return podutil.LogPath(c.Config, pod.Namespace, pod.Name, containerName)
+}
+
+//task的stdout/stderr由shim直接写入日志文件, edgelet重启不影响日志
+func (c *ctrPodManager) logCreator(pod *v1.Pod, containerName string) cio.Creator {
+	return cio.LogFile(c.logPath(pod, containerName))
+}
+
+func (c *ctrPodManager) ensureImage(ctx context.Context, spec v1.Container) (containerd.Image, error) {
+	if spec.ImagePullPolicy != v1.PullAlways {
+		image, err := c.client.GetImage(ctx, spec.Image)
+		if err == nil {
+			return image, nil
+		}
+		if !ctrderrdefs.IsNotFound(err) {
+			return nil, err
+		}
+		if spec.ImagePullPolicy == v1.PullNever {
+			return nil, fmt.Errorf("container image %q is not present with pull policy of Never", spec.Image)
+		}
+	}
+	return c.client.Pull(ctx, spec.Image,
+		containerd.WithPullUnpack,
+		containerd.WithPullSnapshotter(c.Containerd.Snapshotter),
+	)
+}
+
+func (c *ctrPodManager) createContainer(ctx context.Context, pod *v1.Pod, spec v1.Container, isInit bool) (containerd.Container, error) {
+	image, err := c.ensureImage(ctx, spec)
+	if err != nil {
+		return nil, err
+	}
+	specOpts, err := c.specOpts(pod, spec, image)
+	if err != nil {
+		return nil, fmt.Errorf("container %s: %v", spec.Name, err)
+	}
+
+	labels := map[string]string{
+		k8sNamespaceLabel:     pod.Namespace,
+		k8sPodNameLabel:       pod.Name,
+		k8sContainerNameLabel: spec.Name,
+		k8sContainerHashLabel: containerHash(spec),
+	}
+	if isInit {
+		labels[k8sInitContainer] = "true"
+	}
+	id := containerID(pod, spec.Name)
+	opts := []containerd.NewContainerOpts{
+		containerd.WithImage(image),
+		containerd.WithSnapshotter(c.Containerd.Snapshotter),
+		containerd.WithNewSnapshot(id, image),
+		containerd.WithNewSpec(specOpts...),
+		containerd.WithContainerLabels(labels),
+		containerd.WithContainerExtension(podExtension, &podInfo{Pod: pod}),
+	}
+	return c.client.NewContainer(ctx, id, opts...)
+}
+
+func (c *ctrPodManager) specOpts(pod *v1.Pod, spec v1.Container, image containerd.Image) ([]oci.SpecOpts, error) {
+	opts := []oci.SpecOpts{}
+	switch {
+	case len(spec.Command) > 0:
+		opts = append(opts, oci.WithImageConfig(image), oci.WithProcessArgs(append(spec.Command, spec.Args...)...))
+	case len(spec.Args) > 0:
+		opts = append(opts, oci.WithImageConfigArgs(image, spec.Args))
+	default:
+		opts = append(opts, oci.WithImageConfig(image))
+	}
+	if spec.WorkingDir != "" {
+		opts = append(opts, oci.WithProcessCwd(spec.WorkingDir))
+	}
+	if spec.TTY {
+		opts = append(opts, oci.WithTTY)
+	}
+
+	env, err := podutil.MakeEnvironmentVariables(c.Config, pod, spec)
+	if err != nil {
+		return nil, err
+	}
+	envList := make([]string, 0, len(env))
+	for k, v := range env {
+		envList = append(envList, k+"="+v)
+	}
+	sort.Strings(envList)
+	opts = append(opts, oci.WithEnv(envList))
+
+	//pod内的容器共享宿主机网络
+	opts = append(opts,
+		oci.WithHostNamespace(specs.NetworkNamespace),
+		oci.WithHostHostsFile,
+		oci.WithHostResolvconf,
+	)
+
+	mounts := []specs.Mount{}
+	for _, vm := range spec.VolumeMounts {
+		source := podutil.VolumeSource(c.Config, pod, vm)
+		if source == "" {
+			continue
+		}
+		options := []string{"rbind", "rw"}
+		if vm.ReadOnly {
+			options = []string{"rbind", "ro"}
+		}
+		mounts = append(mounts, specs.Mount{
+			Type:        "bind",
+			Source:      source,
+			Destination: vm.MountPath,
+			Options:     options,
+		})
+	}
+	opts = append(opts, oci.WithMounts(mounts))
+
+	if sc := spec.SecurityContext; sc != nil {
+		if sc.Privileged != nil && *sc.Privileged {
+			opts = append(opts, oci.WithPrivileged, oci.WithAllDevicesAllowed, oci.WithHostDevices)
+		}
+		if sc.RunAsUser != nil {
+			opts = append(opts, oci.WithUserID(uint32(*sc.RunAsUser)))
+		}
+	}
+
+	if cpu, ok := spec.Resources.Limits[v1.ResourceCPU]; ok && !cpu.IsZero() {
+		opts = append(opts, oci.WithCPUCFS(podutil.MilliCPUToQuota(cpu.MilliValue(), podutil.QuotaPeriod), podutil.QuotaPeriod))
+	}
+	if memory, ok := spec.Resources.Limits[v1.ResourceMemory]; ok && !memory.IsZero() {
+		opts = append(opts, oci.WithMemoryLimit(uint64(memory.Value())))
+	}
+	cpuRequest, ok := spec.Resources.Requests[v1.ResourceCPU]
+	if !ok {
+		cpuRequest = spec.Resources.Limits[v1.ResourceCPU]
+	}
+	if !cpuRequest.IsZero() {
+		opts = append(opts, oci.WithCPUShares(uint64(podutil.MilliCPUToShares(cpuRequest.MilliValue()))))
+	}
+	return opts, nil
+}
+
+func (c *ctrPodManager) containersToK8sPod(ctx context.Context, list []containerd.Container) (*v1.Pod, error) {
+	var pod *v1.Pod
+	infos := make([]containers.Container, 0, len(list))
+	for _, container := range list {
+		info, err := container.Info(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if pod == nil {
+			if pod, err = podFromInfo(info); err != nil {
+				return nil, err
+			}
+		}
+		infos = append(infos, info)
+	}
+	if pod == nil {
+		return nil, fmt.Errorf("not k8s container")
+	}
+	statuses := make(map[string]v1.ContainerStatus)
+	for i, container := range list {
+		statuses[infos[i].Labels[k8sContainerNameLabel]] = c.containerStatus(ctx, container, infos[i], pod.Spec.RestartPolicy)
+	}
+	return c.setPodStatus(pod, statuses), nil
+}
+
+//podFromInfo 从容器的extension中读取创建时的pod
+func podFromInfo(info containers.Container) (*v1.Pod, error) {
+	ext, ok := info.Extensions[podExtension]
+	if !ok {
+		return nil, fmt.Errorf("not k8s container")
+	}
+	v, err := typeurl.UnmarshalAny(&ext)
+	if err != nil {
+		return nil, err
+	}
+	pi, ok := v.(*podInfo)
+	if !ok || pi.Pod == nil {
+		return nil, fmt.Errorf("not k8s container")
+	}
+	return pi.Pod, nil
+}
+
+//setPodStatus 根据容器状态计算pod的状态, 还没有创建的容器为等待状态
+func (c *ctrPodManager) setPodStatus(pod *v1.Pod, statuses map[string]v1.ContainerStatus) *v1.Pod {
+	pod.Status.Reason = ""
+	pod.Status.PodIP = c.IPAddress
+	pod.Status.HostIP = c.IPAddress
+	pod.Status.Conditions = []v1.PodCondition{
+		{
+			Type:   v1.PodInitialized,
+			Status: v1.ConditionTrue,
+		},
+		{
+			Type:   v1.PodReady,
+			Status: v1.ConditionTrue,
+		},
+		{
+			Type:   v1.PodScheduled,
+			Status: v1.ConditionTrue,
+		},
+	}
+	initialized := true
+	var initStatus, runStatus []v1.ContainerStatus
+	for _, ic := range pod.Spec.InitContainers {
+		status, ok := statuses[ic.Name]
+		if !ok {
+			status = waitingStatus(ic, podutil.PodInitializing)
+		}
+		if !status.Ready {
+			initialized = false
+			pod.Status.Conditions[0].Status = v1.ConditionFalse
+			pod.Status.Conditions[1].Status = v1.ConditionFalse
+		}
+		initStatus = append(initStatus, status)
+	}
+	for _, rc := range pod.Spec.Containers {
+		status, ok := statuses[rc.Name]
+		if !ok {
+			reason := podutil.ContainerCreating
+			if !initialized {
+				reason = podutil.PodInitializing
+			}
+			status = waitingStatus(rc, reason)
+		}
+		if !status.Ready {
+			pod.Status.Conditions[1].Status = v1.ConditionFalse
+		}
+		runStatus = append(runStatus, status)
+	}
+	pod.Status.InitContainerStatuses = initStatus
+	pod.Status.ContainerStatuses = runStatus
+	pod.Status.Phase = podutil.GetPhase(&pod.Spec, initStatus, runStatus)
+	return pod
+}
+
+func waitingStatus(spec v1.Container, reason string) v1.ContainerStatus {
+	return v1.ContainerStatus{
+		Name:  spec.Name,
+		Image: spec.Image,
+		State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}},
+	}
+}
+
+//containerStatus 等待重启的容器为CrashLoopBackOff, 上一次退出的状态放在LastTerminationState
+func (c *ctrPodManager) containerStatus(ctx context.Context, container containerd.Container, info containers.Container, policy v1.RestartPolicy) v1.ContainerStatus {
+	_, isInit := info.Labels[k8sInitContainer]
+	name := info.Labels[k8sContainerNameLabel]
+	started := metav1.NewTime(startedAt(info))
+	ret := v1.ContainerStatus{
+		Name:         name,
+		Image:        info.Image,
+		ImageID:      info.Image,
+		ContainerID:  "containerd://" + container.ID(),
+		RestartCount: int32(restartCount(info)),
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		ret.State.Waiting = &v1.ContainerStateWaiting{Reason: podutil.ContainerCreating}
+		return ret
+	}
+	status, err := task.Status(ctx)
+	if err != nil {
+		ret.State.Waiting = &v1.ContainerStateWaiting{Reason: "Unknown", Message: err.Error()}
+		return ret
+	}
+	switch status.Status {
+	case containerd.Running:
+		ret.Ready = !isInit
+		ok := true
+		ret.Started = &ok
+		ret.State.Running = &v1.ContainerStateRunning{StartedAt: started}
+	case containerd.Stopped:
+		terminated := &v1.ContainerStateTerminated{
+			ExitCode:   int32(status.ExitStatus),
+			StartedAt:  started,
+			FinishedAt: metav1.NewTime(status.ExitTime),
+			Reason:     "Error",
+		}
+		if status.ExitStatus == 0 {
+			terminated.Reason = "Completed"
+			ret.Ready = isInit
+		}
+		if !isInit && shouldRestart(policy, status.ExitStatus) {
+			ret.State.Waiting = &v1.ContainerStateWaiting{
+				Reason:  podutil.CrashLoopBackOff,
+				Message: fmt.Sprintf("back-off %s restarting failed container=%s", restartDelay(restartCount(info), started.Time, status.ExitTime), name),
+			}
+			ret.LastTerminationState.Terminated = terminated
+			return ret
+		}
+		ret.State.Terminated = terminated
+	default:
+		ret.State.Waiting = &v1.ContainerStateWaiting{Reason: string(status.Status)}
+	}
+	return ret
+}
@@ -0,0 +1,609 @@
+/*
+	Note: containerd没有pod的概念, pod内的容器都使用宿主机的网络命名空间(与hostNetwork一致)
+	容器退出后由edgelet按restartPolicy重启, 等待时间与kubelet一致, OnFailure只重启退出码不为0的容器
+	有init-container的pod由后台的worker按顺序运行init-container, 之后再启动所有容器, 期间pod为PodInitializing
+*/
+
+package containerd
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	pmconf "edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/podmanager/podstore"
+	"edge/internal/edgelet/podmanager/podutil"
+	"edge/pkg/errdefs"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd"
+	apievents "github.com/containerd/containerd/api/events"
+	ctrderrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/runtime/restart"
+	"github.com/containerd/typeurl"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultGracePeriod = 30 * time.Second
+	eventRetryInterval = 5 * time.Second
+	//容器和init-container失败后重试的间隔, 与kubelet一致
+	initialBackOff = 10 * time.Second
+	maxBackOff     = 300 * time.Second
+	//运行超过这个时间后退出的容器重新从initialBackOff开始等待
+	backOffResetRun = 10 * time.Minute
+)
+
+type ctrPodManager struct {
+	pmconf.Config
+	client         *containerd.Client
+	namespace      string
+	podEvents      map[string]podKey
+	eventMutex     sync.RWMutex
+	podMutex       sync.Mutex
+	runtimeVersion string
+	versionMutex   sync.Mutex
+	cpuUsage       map[string]cpuSample
+	statsMutex     sync.Mutex
+
+	//正在初始化的pod, key为namespace/name, 由podMutex保护
+	initWorkers map[string]*initWorker
+	//等待重启的容器, key为容器id, 由podMutex保护
+	restarts map[string]*time.Timer
+	//运行结束且容器已经被删除的pod
+	completed *podstore.Store
+}
+
+//initWorker 运行一个pod的init-container, pod更新或删除时取消
+type initWorker struct {
+	cancel context.CancelFunc
+}
+
+type podKey struct {
+	namespace string
+	name      string
+}
+
+func NewPodManager(opts ...pmconf.Option) *ctrPodManager {
+	conf := pmconf.DefaultConfig()
+	for _, o := range opts {
+		o.Apply(&conf)
+	}
+	namespace := conf.Containerd.Namespace
+	if namespace == "" {
+		namespace = conf.Project
+	}
+	if namespace == "" {
+		panic("missing namespace: containerd init must specify a namespace")
+	}
+	client, err := containerd.New(conf.Containerd.Address, containerd.WithDefaultNamespace(namespace))
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(conf.LogRoot(), 0755); err != nil {
+		panic(err)
+	}
+	completed, err := podstore.New(conf.PodStoreRoot())
+	if err != nil {
+		panic(err)
+	}
+	ctr := &ctrPodManager{
+		Config:      conf,
+		client:      client,
+		namespace:   namespace,
+		podEvents:   map[string]podKey{},
+		initWorkers: map[string]*initWorker{},
+		restarts:    map[string]*time.Timer{},
+		cpuUsage:    map[string]cpuSample{},
+		completed:   completed,
+	}
+	go ctr.handleEvent()
+	go ctr.cleanupCompletedPods()
+	return ctr
+}
+
+func (c *ctrPodManager) context(ctx context.Context) context.Context {
+	return namespaces.WithNamespace(ctx, c.namespace)
+}
+
+func (c *ctrPodManager) CreateVolume(ctx context.Context, req *pb.CreateVolumeRequest) error {
+	return podutil.CreateVolume(c.Config, req)
+}
+
+func (c *ctrPodManager) CreatePod(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	return c.createOrUpdate(ctx, pod)
+}
+
+func (c *ctrPodManager) UpdatePod(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	return c.createOrUpdate(ctx, pod)
+}
+
+func (c *ctrPodManager) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	ctx = c.context(ctx)
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	c.stopInitWorker(pod.Namespace, pod.Name)
+	containers, err := c.podContainers(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	gracePeriod := podGracePeriod(pod)
+	for _, container := range containers {
+		if err := c.removeContainer(ctx, container, gracePeriod); err != nil {
+			return err
+		}
+	}
+	podutil.RemoveLogs(c.Config, pod.Namespace, pod.Name)
+	return c.completed.Delete(pod.Namespace, pod.Name)
+}
+
+func (c *ctrPodManager) GetPod(ctx context.Context, namespace, podName string) (*v1.Pod, error) {
+	ctx = c.context(ctx)
+	containers, err := c.podContainers(ctx, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		pod, err := c.completed.Find(namespace, podName)
+		if err != nil {
+			return nil, errdefs.NotFoundf("%s/%s not found", namespace, podName)
+		}
+		return pod, nil
+	}
+	return c.containersToK8sPod(ctx, containers)
+}
+
+func (c *ctrPodManager) GetPods(ctx context.Context) ([]*v1.Pod, error) {
+	ctx = c.context(ctx)
+	containers, err := c.client.Containers(ctx, fmt.Sprintf("labels.%q", k8sPodNameLabel))
+	if err != nil {
+		return nil, err
+	}
+	//将一个pod下的container分组
+	podContainers := make(map[podKey][]containerd.Container)
+	for _, container := range containers {
+		labels, err := container.Labels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key := podKey{namespace: labels[k8sNamespaceLabel], name: labels[k8sPodNameLabel]}
+		podContainers[key] = append(podContainers[key], container)
+	}
+	pods := make([]*v1.Pod, 0, len(podContainers))
+	for _, cs := range podContainers {
+		pod, err := c.containersToK8sPod(ctx, cs)
+		if err != nil {
+			logrus.Error("containersToK8sPod failed,err=", err)
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	//容器已经被删除的pod
+	for _, pod := range c.completed.List() {
+		if _, ok := podContainers[podKey{namespace: pod.Namespace, name: pod.Name}]; ok {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (c *ctrPodManager) DescribePodsStatus(ctx context.Context) ([]*v1.Pod, error) {
+	var keys []podKey
+	var pods []*v1.Pod
+	c.eventMutex.Lock()
+	for id, key := range c.podEvents {
+		keys = append(keys, key)
+		delete(c.podEvents, id)
+	}
+	c.eventMutex.Unlock()
+
+	for _, key := range keys {
+		pod, err := c.GetPod(ctx, key.namespace, key.name)
+		if err != nil {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+func (c *ctrPodManager) ContainerRuntimeVersion(ctx context.Context) string {
+	c.versionMutex.Lock()
+	defer c.versionMutex.Unlock()
+	if c.runtimeVersion != "" {
+		return c.runtimeVersion
+	}
+	ver, err := c.client.Version(ctx)
+	if err != nil {
+		logrus.Warn("get container runtime version failed,err=", err)
+		return ""
+	}
+	c.runtimeVersion = "containerd://" + ver.Version
+	return c.runtimeVersion
+}
+
+//init-container按顺序运行且必须成功退出, 之后再启动所有容器
+//spec没有变化的容器保持不变, 变化了的容器会被重建
+func (c *ctrPodManager) createOrUpdate(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	ctx = c.context(ctx)
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	//之前的worker使用的是旧的spec
+	c.stopInitWorker(pod.Namespace, pod.Name)
+	if stored, ok := c.completedPod(pod); ok {
+		return stored, nil
+	}
+	//同名的新pod
+	if err := c.completed.Delete(pod.Namespace, pod.Name); err != nil {
+		return pod, err
+	}
+	existing, err := c.podContainers(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return pod, err
+	}
+	current := make(map[string]containerd.Container)
+	for _, container := range existing {
+		current[container.ID()] = container
+	}
+
+	gracePeriod := podGracePeriod(pod)
+	wanted := make(map[string]struct{})
+	for _, ic := range pod.Spec.InitContainers {
+		wanted[containerID(pod, ic.Name)] = struct{}{}
+	}
+	for _, rc := range pod.Spec.Containers {
+		wanted[containerID(pod, rc.Name)] = struct{}{}
+	}
+	for id, container := range current {
+		if _, ok := wanted[id]; ok {
+			continue
+		}
+		if err := c.removeContainer(ctx, container, gracePeriod); err != nil {
+			return pod, err
+		}
+		delete(current, id)
+	}
+
+	//init-container可能运行很久, 在后台运行, 不阻塞调用者和其他pod
+	if len(pod.Spec.InitContainers) > 0 {
+		c.startInitWorker(pod, gracePeriod)
+		status, err := c.GetPod(ctx, pod.Namespace, pod.Name)
+		if errdefs.IsNotFound(err) {
+			return c.setPodStatus(pod.DeepCopy(), nil), nil
+		}
+		return status, err
+	}
+	for _, rc := range pod.Spec.Containers {
+		if err := c.runContainer(ctx, pod, rc, current[containerID(pod, rc.Name)], gracePeriod); err != nil {
+			return pod, err
+		}
+	}
+	return c.GetPod(ctx, pod.Namespace, pod.Name)
+}
+
+func podGracePeriod(pod *v1.Pod) time.Duration {
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+	return defaultGracePeriod
+}
+
+//startInitWorker 调用者需要持有podMutex
+func (c *ctrPodManager) startInitWorker(pod *v1.Pod, gracePeriod time.Duration) {
+	ctx, cancel := context.WithCancel(c.context(context.Background()))
+	w := &initWorker{cancel: cancel}
+	c.initWorkers[pod.Namespace+"/"+pod.Name] = w
+	go c.initPod(ctx, w, pod.DeepCopy(), gracePeriod)
+}
+
+//stopInitWorker 调用者需要持有podMutex, worker在下一次获取podMutex时退出
+func (c *ctrPodManager) stopInitWorker(namespace, podName string) {
+	key := namespace + "/" + podName
+	if w, ok := c.initWorkers[key]; ok {
+		w.cancel()
+		delete(c.initWorkers, key)
+	}
+}
+
+//initPod 运行init-container直到全部成功, 失败时按backoff重试, restartPolicy为Never时pod失败
+func (c *ctrPodManager) initPod(ctx context.Context, w *initWorker, pod *v1.Pod, gracePeriod time.Duration) {
+	key := pod.Namespace + "/" + pod.Name
+	defer func() {
+		c.podMutex.Lock()
+		if c.initWorkers[key] == w {
+			delete(c.initWorkers, key)
+		}
+		c.podMutex.Unlock()
+		w.cancel()
+	}()
+	for attempt := 0; ; attempt++ {
+		err := c.runInitContainers(ctx, pod, gracePeriod)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.Warnf("init pod %s failed, err=%v", key, err)
+		if pod.Spec.RestartPolicy == v1.RestartPolicyNever {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backOff(attempt)):
+		}
+	}
+	if err := c.startContainers(ctx, pod, gracePeriod); err != nil && ctx.Err() == nil {
+		logrus.Errorf("start containers of pod %s failed, err=%v", key, err)
+	}
+}
+
+//backOff 第attempt次失败后的等待时间, 从initialBackOff开始翻倍, 不超过maxBackOff
+func backOff(attempt int) time.Duration {
+	d := initialBackOff
+	for i := 0; i < attempt && d < maxBackOff; i++ {
+		d *= 2
+	}
+	if d > maxBackOff {
+		d = maxBackOff
+	}
+	return d
+}
+
+//runInitContainers 按顺序运行init-container, 等待退出时不持有podMutex
+func (c *ctrPodManager) runInitContainers(ctx context.Context, pod *v1.Pod, gracePeriod time.Duration) error {
+	for _, ic := range pod.Spec.InitContainers {
+		statusC, err := c.startInitContainer(ctx, pod, ic, gracePeriod)
+		if err != nil {
+			return err
+		}
+		if statusC == nil {
+			continue
+		}
+		select {
+		case status := <-statusC:
+			code, _, err := status.Result()
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return fmt.Errorf("init container %s exited with code %d", ic.Name, code)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+//startInitContainer 已经成功退出的init-container返回nil, 正在运行的继续等待, 其他情况重新创建
+func (c *ctrPodManager) startInitContainer(ctx context.Context, pod *v1.Pod, spec v1.Container, gracePeriod time.Duration) (<-chan containerd.ExitStatus, error) {
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	existing, err := c.client.LoadContainer(ctx, containerID(pod, spec.Name))
+	if err != nil && !ctrderrdefs.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		same, err := sameSpec(ctx, existing, spec)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			if code, ok := exitedCode(ctx, existing); ok && code == 0 {
+				return nil, nil
+			}
+			//pod更新时不打断正在运行的init-container
+			if task, err := existing.Task(ctx, nil); err == nil {
+				if status, err := task.Status(ctx); err == nil && status.Status == containerd.Running {
+					return task.Wait(ctx)
+				}
+			}
+		}
+		if err := c.removeContainer(ctx, existing, gracePeriod); err != nil {
+			return nil, err
+		}
+	}
+	container, err := c.createContainer(ctx, pod, spec, true)
+	if err != nil {
+		return nil, err
+	}
+	task, err := container.NewTask(ctx, c.logCreator(pod, spec.Name))
+	if err != nil {
+		return nil, err
+	}
+	statusC, err := task.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := task.Start(ctx); err != nil {
+		return nil, err
+	}
+	return statusC, nil
+}
+
+//startContainers init-container全部成功后启动容器, pod已经更新或删除时不再启动
+func (c *ctrPodManager) startContainers(ctx context.Context, pod *v1.Pod, gracePeriod time.Duration) error {
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	existing, err := c.podContainers(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]containerd.Container)
+	for _, container := range existing {
+		current[container.ID()] = container
+	}
+	for _, rc := range pod.Spec.Containers {
+		if err := c.runContainer(ctx, pod, rc, current[containerID(pod, rc.Name)], gracePeriod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ctrPodManager) runContainer(ctx context.Context, pod *v1.Pod, spec v1.Container, existing containerd.Container, gracePeriod time.Duration) error {
+	if existing != nil {
+		same, err := sameSpec(ctx, existing, spec)
+		if err != nil {
+			return err
+		}
+		if same {
+			return nil
+		}
+		if err := c.removeContainer(ctx, existing, gracePeriod); err != nil {
+			return err
+		}
+	}
+	container, err := c.createContainer(ctx, pod, spec, false)
+	if err != nil {
+		return err
+	}
+	task, err := container.NewTask(ctx, c.logCreator(pod, spec.Name))
+	if err != nil {
+		return err
+	}
+	return task.Start(ctx)
+}
+
+//先取消等待中的重启, 再发送SIGTERM, 超过gracePeriod后SIGKILL
+//旧版本创建的容器由containerd的restart monitor重启, 需要先关闭
+func (c *ctrPodManager) removeContainer(ctx context.Context, container containerd.Container, gracePeriod time.Duration) error {
+	c.cancelRestart(container.ID())
+	if err := container.Update(ctx, restart.WithNoRestarts); err != nil && !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	task, err := container.Task(ctx, nil)
+	if err == nil {
+		if err := stopTask(ctx, task, gracePeriod); err != nil {
+			return err
+		}
+		if _, err := task.Delete(ctx, containerd.WithProcessKill); err != nil && !ctrderrdefs.IsNotFound(err) {
+			return err
+		}
+	} else if !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	err = container.Delete(ctx, containerd.WithSnapshotCleanup)
+	if err != nil && !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	c.statsMutex.Lock()
+	delete(c.cpuUsage, container.ID())
+	c.statsMutex.Unlock()
+	return nil
+}
+
+func stopTask(ctx context.Context, task containerd.Task, gracePeriod time.Duration) error {
+	status, err := task.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Status == containerd.Stopped {
+		return nil
+	}
+	statusC, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := task.Kill(ctx, syscall.SIGTERM); err != nil && !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	select {
+	case <-statusC:
+		return nil
+	case <-time.After(gracePeriod):
+	}
+	if err := task.Kill(ctx, syscall.SIGKILL, containerd.WithKillAll); err != nil && !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	<-statusC
+	return nil
+}
+
+func (c *ctrPodManager) podContainers(ctx context.Context, namespace, podName string) ([]containerd.Container, error) {
+	filter := fmt.Sprintf("labels.%q==%q", k8sPodNameLabel, podName)
+	if namespace != "" {
+		filter += fmt.Sprintf(",labels.%q==%q", k8sNamespaceLabel, namespace)
+	}
+	return c.client.Containers(ctx, filter)
+}
+
+//订阅task的事件, 记录状态发生变化的pod
+func (c *ctrPodManager) handleEvent() {
+	for {
+		ctx, cancel := context.WithCancel(c.context(context.Background()))
+		eventCh, errCh := c.client.Subscribe(ctx, fmt.Sprintf("namespace==%q,topic~=%q", c.namespace, "^/tasks/"))
+		go c.resyncRestarts(ctx)
+		func() {
+			defer cancel()
+			for {
+				select {
+				case envelope := <-eventCh:
+					event, err := typeurl.UnmarshalAny(envelope.Event)
+					if err != nil {
+						logrus.Error("handleEvent unmarshal event failed ,err=", err)
+						continue
+					}
+					c.consumeEvent(ctx, event)
+				case err := <-errCh:
+					logrus.Error("handleEvent receive err ,err=", err)
+					return
+				}
+			}
+		}()
+		time.Sleep(eventRetryInterval)
+	}
+}
+
+func (c *ctrPodManager) consumeEvent(ctx context.Context, event interface{}) {
+	var id string
+	var exit *apievents.TaskExit
+	switch e := event.(type) {
+	case *apievents.TaskCreate:
+		id = e.ContainerID
+	case *apievents.TaskStart:
+		id = e.ContainerID
+	case *apievents.TaskExit:
+		id = e.ContainerID
+		//exec的进程退出时ID为进程id
+		if e.ID == e.ContainerID {
+			exit = e
+		}
+	case *apievents.TaskOOM:
+		id = e.ContainerID
+	case *apievents.TaskDelete:
+		id = e.ContainerID
+	default:
+		return
+	}
+	container, err := c.client.LoadContainer(ctx, id)
+	if err != nil {
+		return
+	}
+	labels, err := container.Labels(ctx)
+	if err != nil || labels[k8sPodNameLabel] == "" {
+		return
+	}
+	key := podKey{namespace: labels[k8sNamespaceLabel], name: labels[k8sPodNameLabel]}
+	c.eventMutex.Lock()
+	c.podEvents[key.namespace+"/"+key.name] = key
+	c.eventMutex.Unlock()
+	//onTaskExit需要获取podMutex, 不阻塞事件的接收
+	if exit != nil {
+		go c.onTaskExit(ctx, container, exit.ExitStatus, exit.ExitedAt)
+	}
+}
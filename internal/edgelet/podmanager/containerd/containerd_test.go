@@ -0,0 +1,258 @@
+package containerd
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podstore"
+	"edge/internal/edgelet/podmanager/podutil"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/containers"
+	ctrderrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/containerd/typeurl"
+	"github.com/gogo/protobuf/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//fakeTask 只实现状态查询, 其他方法调用时panic
+type fakeTask struct {
+	containerd.Task
+	status containerd.Status
+}
+
+func (t *fakeTask) Status(ctx context.Context) (containerd.Status, error) {
+	return t.status, nil
+}
+
+//fakeContainer task为空时表示容器还没有启动
+type fakeContainer struct {
+	containerd.Container
+	info containers.Container
+	task *fakeTask
+}
+
+func (c *fakeContainer) ID() string {
+	return c.info.ID
+}
+
+func (c *fakeContainer) Info(ctx context.Context, opts ...containerd.InfoOpts) (containers.Container, error) {
+	return c.info, nil
+}
+
+func (c *fakeContainer) Labels(ctx context.Context) (map[string]string, error) {
+	return c.info.Labels, nil
+}
+
+func (c *fakeContainer) Task(ctx context.Context, attach cio.Attach) (containerd.Task, error) {
+	if c.task == nil {
+		return nil, ctrderrdefs.ErrNotFound
+	}
+	return c.task, nil
+}
+
+func newFakeContainer(t *testing.T, pod *v1.Pod, spec v1.Container, isInit bool, task *fakeTask) *fakeContainer {
+	ext, err := typeurl.MarshalAny(&podInfo{Pod: pod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{
+		k8sNamespaceLabel:     pod.Namespace,
+		k8sPodNameLabel:       pod.Name,
+		k8sContainerNameLabel: spec.Name,
+		k8sContainerHashLabel: containerHash(spec),
+	}
+	if isInit {
+		labels[k8sInitContainer] = "true"
+	}
+	return &fakeContainer{
+		info: containers.Container{
+			ID:         containerID(pod, spec.Name),
+			Labels:     labels,
+			Image:      spec.Image,
+			CreatedAt:  time.Now(),
+			Extensions: map[string]types.Any{podExtension: *ext},
+		},
+		task: task,
+	}
+}
+
+func newTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: v1.PodSpec{
+			RestartPolicy:  v1.RestartPolicyAlways,
+			InitContainers: []v1.Container{{Name: "migrate", Image: "migrate:v1"}},
+			Containers:     []v1.Container{{Name: "web", Image: "web:v1"}},
+		},
+	}
+}
+
+func Test_containersToK8sPod(t *testing.T) {
+	pod := newTestPod()
+	initSpec, webSpec := pod.Spec.InitContainers[0], pod.Spec.Containers[0]
+	tests := []struct {
+		name            string
+		containers      []containerd.Container
+		wantPhase       v1.PodPhase
+		wantInitialized v1.ConditionStatus
+		wantInitState   string
+		wantWebState    string
+	}{
+		{
+			name:            "init container running",
+			containers:      []containerd.Container{newFakeContainer(t, pod, initSpec, true, &fakeTask{status: containerd.Status{Status: containerd.Running}})},
+			wantPhase:       v1.PodPending,
+			wantInitialized: v1.ConditionFalse,
+			wantInitState:   "Running",
+			wantWebState:    podutil.PodInitializing,
+		},
+		{
+			name:            "init container failed",
+			containers:      []containerd.Container{newFakeContainer(t, pod, initSpec, true, &fakeTask{status: containerd.Status{Status: containerd.Stopped, ExitStatus: 1}})},
+			wantPhase:       v1.PodPending,
+			wantInitialized: v1.ConditionFalse,
+			wantInitState:   "Error",
+			wantWebState:    podutil.PodInitializing,
+		},
+		{
+			name: "initialized and running",
+			containers: []containerd.Container{
+				newFakeContainer(t, pod, initSpec, true, &fakeTask{status: containerd.Status{Status: containerd.Stopped}}),
+				newFakeContainer(t, pod, webSpec, false, &fakeTask{status: containerd.Status{Status: containerd.Running}}),
+			},
+			wantPhase:       v1.PodRunning,
+			wantInitialized: v1.ConditionTrue,
+			wantInitState:   "Completed",
+			wantWebState:    "Running",
+		},
+		{
+			name: "initialized and creating",
+			containers: []containerd.Container{
+				newFakeContainer(t, pod, initSpec, true, &fakeTask{status: containerd.Status{Status: containerd.Stopped}}),
+			},
+			wantPhase:       v1.PodPending,
+			wantInitialized: v1.ConditionTrue,
+			wantInitState:   "Completed",
+			wantWebState:    podutil.ContainerCreating,
+		},
+	}
+	c := &ctrPodManager{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.containersToK8sPod(context.Background(), tt.containers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %s, want %s", got.Status.Phase, tt.wantPhase)
+			}
+			if got.Status.Conditions[0].Type != v1.PodInitialized || got.Status.Conditions[0].Status != tt.wantInitialized {
+				t.Errorf("conditions = %+v, want initialized %s", got.Status.Conditions, tt.wantInitialized)
+			}
+			if len(got.Status.InitContainerStatuses) != 1 || len(got.Status.ContainerStatuses) != 1 {
+				t.Fatalf("statuses = %+v %+v", got.Status.InitContainerStatuses, got.Status.ContainerStatuses)
+			}
+			if state := stateReason(got.Status.InitContainerStatuses[0].State); state != tt.wantInitState {
+				t.Errorf("init container state = %s, want %s", state, tt.wantInitState)
+			}
+			if state := stateReason(got.Status.ContainerStatuses[0].State); state != tt.wantWebState {
+				t.Errorf("container state = %s, want %s", state, tt.wantWebState)
+			}
+		})
+	}
+}
+
+func stateReason(state v1.ContainerState) string {
+	switch {
+	case state.Running != nil:
+		return "Running"
+	case state.Terminated != nil:
+		return state.Terminated.Reason
+	case state.Waiting != nil:
+		return state.Waiting.Reason
+	}
+	return ""
+}
+
+//CreatePod返回时还没有创建任何容器
+func Test_setPodStatus(t *testing.T) {
+	c := &ctrPodManager{}
+	got := c.setPodStatus(newTestPod(), nil)
+	if got.Status.Phase != v1.PodPending {
+		t.Errorf("phase = %s, want Pending", got.Status.Phase)
+	}
+	for _, s := range append(got.Status.InitContainerStatuses, got.Status.ContainerStatuses...) {
+		if s.State.Waiting == nil || s.State.Waiting.Reason != podutil.PodInitializing {
+			t.Errorf("container %s state = %+v, want waiting %s", s.Name, s.State, podutil.PodInitializing)
+		}
+	}
+}
+
+func Test_backOff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 20 * time.Second},
+		{4, 160 * time.Second},
+		{5, 300 * time.Second},
+		{100, 300 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := backOff(tt.attempt); got != tt.want {
+			t.Errorf("backOff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+//更新或删除pod时取消正在运行的worker
+func Test_stopInitWorker(t *testing.T) {
+	c := &ctrPodManager{initWorkers: map[string]*initWorker{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.initWorkers["default/web"] = &initWorker{cancel: cancel}
+	c.stopInitWorker("default", "other")
+	if ctx.Err() != nil {
+		t.Fatal("worker of another pod is canceled")
+	}
+	c.stopInitWorker("default", "web")
+	if ctx.Err() == nil {
+		t.Error("worker is not canceled")
+	}
+	if len(c.initWorkers) != 0 {
+		t.Errorf("workers = %v", c.initWorkers)
+	}
+}
+
+//容器已经被删除的pod, 同一个pod不再重新运行, 同名的新pod正常创建
+func Test_completedPod(t *testing.T) {
+	store, err := podstore.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ctrPodManager{completed: store}
+	pod := newTestPod()
+	pod.UID = "uid-1"
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+	if _, ok := c.completedPod(pod); ok {
+		t.Fatal("completedPod() of unknown pod = true")
+	}
+	if err := store.Put(pod); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateStatus(pod.Namespace, pod.Name, v1.PodStatus{Phase: v1.PodSucceeded}); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := c.completedPod(pod)
+	if !ok || got.Status.Phase != v1.PodSucceeded {
+		t.Errorf("completedPod() = %v, %v, want Succeeded", got, ok)
+	}
+	recreated := pod.DeepCopy()
+	recreated.UID = "uid-2"
+	if _, ok := c.completedPod(recreated); ok {
+		t.Error("completedPod() of recreated pod = true")
+	}
+}
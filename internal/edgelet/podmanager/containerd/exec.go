@@ -0,0 +1,87 @@
+package containerd
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/stream"
+	"edge/pkg/errdefs"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/cio"
+	ctrderrdefs "github.com/containerd/containerd/errdefs"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func (c *ctrPodManager) RunInContainer(ctx context.Context, namespace, podname, containerName string, cmd []string, attach stream.AttachIO) error {
+	ctx = c.context(ctx)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: podname}}
+	container, err := c.client.LoadContainer(ctx, containerID(pod, containerName))
+	if err != nil {
+		if ctrderrdefs.IsNotFound(err) {
+			return errdefs.NotFoundf("%s/%s-%s not found", namespace, podname, containerName)
+		}
+		return err
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return err
+	}
+	spec, err := container.Spec(ctx)
+	if err != nil {
+		return err
+	}
+	pspec := *spec.Process
+	pspec.Args = cmd
+	pspec.Terminal = attach.TTY()
+
+	ioOpts := []cio.Opt{cio.WithStreams(attach.Stdin(), attach.Stdout(), attach.Stderr())}
+	if attach.TTY() {
+		ioOpts = append(ioOpts, cio.WithTerminal)
+	}
+	execID := fmt.Sprintf("exec-%d", time.Now().UnixNano())
+	process, err := task.Exec(ctx, execID, &pspec, cio.NewCreator(ioOpts...))
+	if err != nil {
+		return err
+	}
+	defer process.Delete(context.Background())
+
+	statusC, err := process.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if err := process.Start(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if attach.TTY() {
+		go func() {
+			for {
+				select {
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+					if err := process.Resize(ctx, uint32(size.Width), uint32(size.Height)); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	status := <-statusC
+	//等待输出全部拷贝完成
+	process.IO().Wait()
+	code, _, err := status.Result()
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("command terminated with exit code %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package containerd
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/internal/edgelet/podmanager/podutil"
+	"edge/pkg/errdefs"
+	"io"
+	"os"
+)
+
+//日志文件中没有时间戳, Timestamps/SinceTime/SinceSeconds不生效
+func (c *ctrPodManager) GetContainerLogs(ctx context.Context, namespace, podname, containerName string, opts *pb.ContainerLogOptions) (io.ReadCloser, error) {
+	r, err := podutil.OpenLogFile(ctx, podutil.LogPath(c.Config, namespace, podname, containerName), opts)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errdefs.NotFoundf("%s/%s-%s log not found", namespace, podname, containerName)
+		}
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,191 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	ctrderrdefs "github.com/containerd/containerd/errdefs"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+//shouldRestart 容器退出后是否按restartPolicy重启, OnFailure只重启失败的容器
+func shouldRestart(policy v1.RestartPolicy, exitCode uint32) bool {
+	switch policy {
+	case v1.RestartPolicyNever:
+		return false
+	case v1.RestartPolicyOnFailure:
+		return exitCode != 0
+	}
+	return true
+}
+
+//restartDelay 容器退出后到重启的等待时间, 与kubelet一致: 从initialBackOff开始翻倍, 不超过maxBackOff
+//容器运行超过backOffResetRun后从initialBackOff重新开始
+func restartDelay(restartCount int, startedAt, exitedAt time.Time) time.Duration {
+	if exitedAt.Sub(startedAt) >= backOffResetRun {
+		return initialBackOff
+	}
+	return backOff(restartCount)
+}
+
+func restartCount(info containers.Container) int {
+	count, _ := strconv.Atoi(info.Labels[k8sRestartCountLabel])
+	return count
+}
+
+//startedAt 容器最近一次启动的时间, 没有重启过时为创建时间
+func startedAt(info containers.Container) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, info.Labels[k8sStartedAtLabel]); err == nil {
+		return t
+	}
+	return info.CreatedAt
+}
+
+//onTaskExit 容器退出后按restartPolicy安排重启, init-container由initWorker负责
+//删除容器时也会收到退出事件, 持有podMutex后再检查容器是否还存在
+func (c *ctrPodManager) onTaskExit(ctx context.Context, container containerd.Container, exitCode uint32, exitedAt time.Time) {
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	info, err := container.Info(ctx)
+	if err != nil {
+		return
+	}
+	//删除后重新创建的同名容器会收到旧容器的退出事件
+	if _, isInit := info.Labels[k8sInitContainer]; isInit || exitedAt.Before(info.CreatedAt) {
+		return
+	}
+	pod, err := podFromInfo(info)
+	if err != nil {
+		logrus.Errorf("get pod of container %s failed, err=%v", container.ID(), err)
+		return
+	}
+	if !shouldRestart(pod.Spec.RestartPolicy, exitCode) {
+		return
+	}
+	c.scheduleRestart(container.ID(), restartDelay(restartCount(info), startedAt(info), exitedAt))
+}
+
+//scheduleRestart 调用者需要持有podMutex, 已经在等待重启的容器保持原来的等待时间
+func (c *ctrPodManager) scheduleRestart(id string, delay time.Duration) {
+	if _, ok := c.restarts[id]; ok {
+		return
+	}
+	logrus.Infof("back-off %s restarting container %s", delay, id)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		c.restartContainer(id, timer)
+	})
+	c.restarts[id] = timer
+}
+
+//cancelRestart 调用者需要持有podMutex
+func (c *ctrPodManager) cancelRestart(id string) {
+	if timer, ok := c.restarts[id]; ok {
+		timer.Stop()
+		delete(c.restarts, id)
+	}
+}
+
+//restartContainer 重新创建task, 容器已经删除, 重建或者已经在运行时什么都不做
+func (c *ctrPodManager) restartContainer(id string, timer *time.Timer) {
+	ctx := c.context(context.Background())
+	c.podMutex.Lock()
+	defer c.podMutex.Unlock()
+	if c.restarts[id] != timer {
+		return
+	}
+	delete(c.restarts, id)
+	container, err := c.client.LoadContainer(ctx, id)
+	if err != nil {
+		return
+	}
+	info, err := container.Info(ctx)
+	if err != nil {
+		logrus.Errorf("restart container %s failed, err=%v", id, err)
+		return
+	}
+	pod, err := podFromInfo(info)
+	if err != nil {
+		logrus.Errorf("restart container %s failed, err=%v", id, err)
+		return
+	}
+	count := restartCount(info) + 1
+	if err := c.startTask(ctx, container, pod, info.Labels[k8sContainerNameLabel], count); err != nil {
+		logrus.Errorf("restart container %s failed, err=%v", id, err)
+		c.scheduleRestart(id, backOff(count))
+	}
+}
+
+//startTask 删除已经退出的task后重新启动, 并记录重启次数和启动时间
+func (c *ctrPodManager) startTask(ctx context.Context, container containerd.Container, pod *v1.Pod, name string, count int) error {
+	task, err := container.Task(ctx, nil)
+	if err == nil {
+		status, err := task.Status(ctx)
+		if err != nil {
+			return err
+		}
+		if status.Status != containerd.Stopped {
+			return nil
+		}
+		if _, err := task.Delete(ctx); err != nil && !ctrderrdefs.IsNotFound(err) {
+			return err
+		}
+	} else if !ctrderrdefs.IsNotFound(err) {
+		return err
+	}
+	_, err = container.SetLabels(ctx, map[string]string{
+		k8sRestartCountLabel: strconv.Itoa(count),
+		k8sStartedAtLabel:    time.Now().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	task, err = container.NewTask(ctx, c.logCreator(pod, name))
+	if err != nil {
+		return err
+	}
+	return task.Start(ctx)
+}
+
+//resyncRestarts edgelet重启或者事件订阅中断期间退出的容器不会收到TaskExit, 重新检查所有容器
+//没有task的容器(例如宿主机重启后)不知道退出码, 只在restartPolicy为Always时重启
+func (c *ctrPodManager) resyncRestarts(ctx context.Context) {
+	list, err := c.client.Containers(ctx, fmt.Sprintf("labels.%q", k8sPodNameLabel))
+	if err != nil {
+		logrus.Error("resync restarts failed, err=", err)
+		return
+	}
+	for _, container := range list {
+		task, err := container.Task(ctx, nil)
+		if err == nil {
+			status, err := task.Status(ctx)
+			if err != nil || status.Status != containerd.Stopped {
+				continue
+			}
+			c.onTaskExit(ctx, container, status.ExitStatus, status.ExitTime)
+			continue
+		}
+		if !ctrderrdefs.IsNotFound(err) {
+			continue
+		}
+		info, err := container.Info(ctx)
+		if err != nil {
+			continue
+		}
+		if _, isInit := info.Labels[k8sInitContainer]; isInit {
+			continue
+		}
+		pod, err := podFromInfo(info)
+		if err != nil || pod.Spec.RestartPolicy == v1.RestartPolicyNever || pod.Spec.RestartPolicy == v1.RestartPolicyOnFailure {
+			continue
+		}
+		c.podMutex.Lock()
+		c.scheduleRestart(container.ID(), 0)
+		c.podMutex.Unlock()
+	}
+}
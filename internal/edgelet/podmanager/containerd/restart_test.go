@@ -0,0 +1,123 @@
+package containerd
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd"
+	v1 "k8s.io/api/core/v1"
+)
+
+func Test_shouldRestart(t *testing.T) {
+	tests := []struct {
+		policy   v1.RestartPolicy
+		exitCode uint32
+		want     bool
+	}{
+		{v1.RestartPolicyAlways, 0, true},
+		{v1.RestartPolicyAlways, 1, true},
+		{"", 0, true},
+		{v1.RestartPolicyOnFailure, 0, false},
+		{v1.RestartPolicyOnFailure, 137, true},
+		{v1.RestartPolicyNever, 1, false},
+	}
+	for _, tt := range tests {
+		if got := shouldRestart(tt.policy, tt.exitCode); got != tt.want {
+			t.Errorf("shouldRestart(%s, %d) = %v, want %v", tt.policy, tt.exitCode, got, tt.want)
+		}
+	}
+}
+
+func Test_restartDelay(t *testing.T) {
+	start := time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		restartCount int
+		ran          time.Duration
+		want         time.Duration
+	}{
+		{name: "first crash", restartCount: 0, ran: time.Second, want: 10 * time.Second},
+		{name: "crash looping", restartCount: 3, ran: time.Second, want: 80 * time.Second},
+		{name: "capped", restartCount: 10, ran: time.Second, want: 300 * time.Second},
+		{name: "reset after long run", restartCount: 10, ran: 10 * time.Minute, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restartDelay(tt.restartCount, start, start.Add(tt.ran)); got != tt.want {
+				t.Errorf("restartDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_containerStatusRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      v1.RestartPolicy
+		exitCode    uint32
+		wantState   string
+		wantRestart bool
+	}{
+		{name: "always", policy: v1.RestartPolicyAlways, exitCode: 0, wantState: podutil.CrashLoopBackOff, wantRestart: true},
+		{name: "on failure failed", policy: v1.RestartPolicyOnFailure, exitCode: 1, wantState: podutil.CrashLoopBackOff, wantRestart: true},
+		{name: "on failure succeeded", policy: v1.RestartPolicyOnFailure, exitCode: 0, wantState: "Completed"},
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 1, wantState: "Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.RestartPolicy = tt.policy
+			container := newFakeContainer(t, pod, pod.Spec.Containers[0], false, &fakeTask{status: containerd.Status{Status: containerd.Stopped, ExitStatus: tt.exitCode, ExitTime: time.Now()}})
+			container.info.Labels[k8sRestartCountLabel] = "2"
+			c := &ctrPodManager{}
+			got := c.containerStatus(context.Background(), container, container.info, tt.policy)
+			if state := stateReason(got.State); state != tt.wantState {
+				t.Errorf("state = %s, want %s", state, tt.wantState)
+			}
+			if got.RestartCount != 2 {
+				t.Errorf("restartCount = %d, want 2", got.RestartCount)
+			}
+			if tt.wantRestart && got.LastTerminationState.Terminated == nil {
+				t.Error("missing last termination state")
+			}
+		})
+	}
+}
+
+func Test_onTaskExit(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   v1.RestartPolicy
+		isInit   bool
+		exitCode uint32
+		stale    bool
+		want     bool
+	}{
+		{name: "always", policy: v1.RestartPolicyAlways, want: true},
+		{name: "on failure failed", policy: v1.RestartPolicyOnFailure, exitCode: 2, want: true},
+		{name: "on failure succeeded", policy: v1.RestartPolicyOnFailure, want: false},
+		{name: "never", policy: v1.RestartPolicyNever, exitCode: 1, want: false},
+		{name: "init container", policy: v1.RestartPolicyAlways, isInit: true, exitCode: 1, want: false},
+		{name: "exit of removed container", policy: v1.RestartPolicyAlways, stale: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := newTestPod()
+			pod.Spec.RestartPolicy = tt.policy
+			container := newFakeContainer(t, pod, pod.Spec.Containers[0], tt.isInit, nil)
+			exitedAt := container.info.CreatedAt.Add(time.Second)
+			if tt.stale {
+				exitedAt = container.info.CreatedAt.Add(-time.Second)
+			}
+			c := &ctrPodManager{restarts: map[string]*time.Timer{}}
+			c.onTaskExit(context.Background(), container, tt.exitCode, exitedAt)
+			_, got := c.restarts[container.ID()]
+			c.cancelRestart(container.ID())
+			if got != tt.want {
+				t.Errorf("restart scheduled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
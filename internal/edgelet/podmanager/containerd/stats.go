@@ -0,0 +1,211 @@
+package containerd
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1stats "github.com/containerd/cgroups/stats/v1"
+	v2stats "github.com/containerd/cgroups/v2/stats"
+	"github.com/containerd/containerd"
+	"github.com/containerd/typeurl"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+//cgroup里的cpu使用量是累计值, 需要和上一次采样做差计算使用率
+type cpuSample struct {
+	time           time.Time
+	usageNanoCores uint64
+	usageNanoSecs  uint64
+}
+
+type containerMetrics struct {
+	usageNanoSecs uint64
+	memUsage      uint64
+	memWorkingSet uint64
+	memRSS        uint64
+	pageFaults    uint64
+	majorFaults   uint64
+	memLimit      uint64
+}
+
+//GetPodStats 获取所有k8s下发的运行中容器的stats，并按pod分组
+func (c *ctrPodManager) GetPodStats(ctx context.Context) ([]stats.PodStats, error) {
+	ctx = c.context(ctx)
+	pods, err := c.GetPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+
+	podStats := make([]stats.PodStats, 0, len(pods))
+	for _, pod := range pods {
+		ps := stats.PodStats{
+			PodRef: stats.PodReference{Name: pod.Name, Namespace: pod.Namespace, UID: string(pod.UID)},
+		}
+		var cpuNanoCores, cpuNanoSeconds, memUsage, memWorkingSet, memRSS, faults, rootfsUsed uint64
+		now := metav1.Now()
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Running == nil {
+				continue
+			}
+			container, err := c.client.LoadContainer(ctx, containerID(pod, cs.Name))
+			if err != nil {
+				continue
+			}
+			containerStats, err := c.containerStats(ctx, container, cs.Name, cs.State.Running.StartedAt)
+			if err != nil {
+				logrus.Warnf("get container %s stats failed,err=%v", container.ID(), err)
+				continue
+			}
+			if ps.StartTime.IsZero() || containerStats.StartTime.Before(&ps.StartTime) {
+				ps.StartTime = containerStats.StartTime
+			}
+			cpuNanoCores += *containerStats.CPU.UsageNanoCores
+			cpuNanoSeconds += *containerStats.CPU.UsageCoreNanoSeconds
+			memUsage += *containerStats.Memory.UsageBytes
+			memWorkingSet += *containerStats.Memory.WorkingSetBytes
+			memRSS += *containerStats.Memory.RSSBytes
+			faults += *containerStats.Memory.PageFaults
+			rootfsUsed += *containerStats.Rootfs.UsedBytes
+			ps.Containers = append(ps.Containers, containerStats)
+		}
+		if len(ps.Containers) == 0 {
+			continue
+		}
+		ps.CPU = &stats.CPUStats{
+			Time:                 now,
+			UsageNanoCores:       &cpuNanoCores,
+			UsageCoreNanoSeconds: &cpuNanoSeconds,
+		}
+		ps.Memory = &stats.MemoryStats{
+			Time:            now,
+			UsageBytes:      &memUsage,
+			WorkingSetBytes: &memWorkingSet,
+			RSSBytes:        &memRSS,
+			PageFaults:      &faults,
+		}
+		ps.EphemeralStorage = &stats.FsStats{
+			Time:      now,
+			UsedBytes: &rootfsUsed,
+		}
+		podStats = append(podStats, ps)
+	}
+	return podStats, nil
+}
+
+func (c *ctrPodManager) containerStats(ctx context.Context, container containerd.Container, name string, startTime metav1.Time) (stats.ContainerStats, error) {
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return stats.ContainerStats{}, err
+	}
+	metric, err := task.Metrics(ctx)
+	if err != nil {
+		return stats.ContainerStats{}, err
+	}
+	data, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return stats.ContainerStats{}, err
+	}
+	m := toContainerMetrics(data)
+	now := metav1.NewTime(metric.Timestamp)
+	cpuNanoCores := c.cpuNanoCores(container.ID(), metric.Timestamp, m.usageNanoSecs)
+
+	cs := stats.ContainerStats{
+		Name:      name,
+		StartTime: startTime,
+		CPU: &stats.CPUStats{
+			Time:                 now,
+			UsageNanoCores:       &cpuNanoCores,
+			UsageCoreNanoSeconds: &m.usageNanoSecs,
+		},
+		Memory: &stats.MemoryStats{
+			Time:            now,
+			UsageBytes:      &m.memUsage,
+			WorkingSetBytes: &m.memWorkingSet,
+			RSSBytes:        &m.memRSS,
+			PageFaults:      &m.pageFaults,
+			MajorPageFaults: &m.majorFaults,
+		},
+	}
+	if m.memLimit > m.memWorkingSet {
+		available := m.memLimit - m.memWorkingSet
+		cs.Memory.AvailableBytes = &available
+	}
+
+	var rootfsUsed uint64
+	info, err := container.Info(ctx)
+	if err == nil {
+		usage, err := c.client.SnapshotService(info.Snapshotter).Usage(ctx, info.SnapshotKey)
+		if err == nil {
+			rootfsUsed = uint64(usage.Size)
+		}
+	}
+	cs.Rootfs = &stats.FsStats{
+		Time:      now,
+		UsedBytes: &rootfsUsed,
+	}
+	return cs, nil
+}
+
+func (c *ctrPodManager) cpuNanoCores(id string, now time.Time, usageNanoSecs uint64) uint64 {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	last, ok := c.cpuUsage[id]
+	sample := cpuSample{time: now, usageNanoSecs: usageNanoSecs}
+	if ok {
+		sample.usageNanoCores = last.usageNanoCores
+		if interval := now.Sub(last.time); interval > 0 && usageNanoSecs >= last.usageNanoSecs {
+			sample.usageNanoCores = uint64(float64(usageNanoSecs-last.usageNanoSecs) / interval.Seconds())
+		}
+	}
+	c.cpuUsage[id] = sample
+	return sample.usageNanoCores
+}
+
+//cgroup v1与v2的指标格式不同
+func toContainerMetrics(data interface{}) containerMetrics {
+	m := containerMetrics{}
+	switch metrics := data.(type) {
+	case *v1stats.Metrics:
+		if metrics.CPU != nil && metrics.CPU.Usage != nil {
+			m.usageNanoSecs = metrics.CPU.Usage.Total
+		}
+		if mem := metrics.Memory; mem != nil {
+			if mem.Usage != nil {
+				m.memUsage = mem.Usage.Usage
+				m.memLimit = mem.Usage.Limit
+			}
+			m.memWorkingSet = m.memUsage
+			if mem.TotalInactiveFile < m.memWorkingSet {
+				m.memWorkingSet -= mem.TotalInactiveFile
+			}
+			m.memRSS = mem.TotalRSS
+			m.pageFaults = mem.TotalPgFault
+			m.majorFaults = mem.TotalPgMajFault
+		}
+	case *v2stats.Metrics:
+		if metrics.CPU != nil {
+			m.usageNanoSecs = metrics.CPU.UsageUsec * 1000
+		}
+		if mem := metrics.Memory; mem != nil {
+			m.memUsage = mem.Usage
+			m.memLimit = mem.UsageLimit
+			m.memWorkingSet = m.memUsage
+			if mem.InactiveFile < m.memWorkingSet {
+				m.memWorkingSet -= mem.InactiveFile
+			}
+			m.memRSS = mem.Anon
+			m.pageFaults = mem.Pgfault
+			m.majorFaults = mem.Pgmajfault
+		}
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+package dockercompose
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"io"
+	"os"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	"github.com/docker/compose/v2/pkg/api"
+	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const cleanupInterval = time.Minute
+
+//cleanupCompletedPods 定期删除运行结束超过CompletedPodTTL的pod的容器
+//pod仍然保留在store中直到DeletePod, 云端的Job controller可以一直看到pod的最终状态, 日志在删除容器前保存到文件
+func (d *dcpPodManager) cleanupCompletedPods() {
+	if d.CompletedPodTTL <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		pods, err := d.GetPods(ctx)
+		if err != nil {
+			logrus.Error("cleanupCompletedPods GetPods failed,err=", err)
+			continue
+		}
+		for _, pod := range pods {
+			if !podutil.IsTerminated(pod) {
+				continue
+			}
+			finishedAt, ok := podutil.FinishedAt(pod)
+			if !ok || time.Since(finishedAt) < d.CompletedPodTTL {
+				continue
+			}
+			if err := d.removeCompletedContainers(ctx, pod); err != nil {
+				logrus.Errorf("remove containers of completed pod %s/%s failed,err=%v", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+}
+
+func (d *dcpPodManager) removeCompletedContainers(ctx context.Context, pod *v1.Pod) error {
+	d.podMutex.Lock()
+	defer d.podMutex.Unlock()
+	f := getDefaultFilters(d.Project)
+	f = append(f, namespaceFilter(pod.Namespace), podnameFilter(pod.Name))
+	containers, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+		Filters: filters.NewArgs(f...),
+		All:     true,
+	})
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	services := types.Services{}
+	for _, c := range containers {
+		if err := d.archiveLogs(ctx, c); err != nil {
+			return err
+		}
+		services = append(services, types.ServiceConfig{Name: c.Labels[api.ServiceLabel]})
+	}
+	d.prober.removePod(pod)
+	logrus.Infof("pod %s/%s completed more than %s ago, remove its containers", pod.Namespace, pod.Name, d.CompletedPodTTL)
+	return d.composeApi.Down(ctx, d.Project, api.DownOptions{
+		Project: &types.Project{
+			Name:     d.Project,
+			Services: services,
+		},
+	})
+}
+
+//archiveLogs 把容器的日志保存到文件, 容器删除后GetContainerLogs从文件读取
+func (d *dcpPodManager) archiveLogs(ctx context.Context, c moby.Container) error {
+	namespace, podName, containerName := containerIdentity(c.Labels)
+	inspect, err := d.dockerCli.Client().ContainerInspect(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	rc, err := d.dockerCli.Client().ContainerLogs(ctx, c.ID, moby.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(d.LogRoot(), 0755); err != nil {
+		return err
+	}
+	path := podutil.LogPath(d.Config, namespace, podName, containerName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	//tty模式下docker不会区分stdout和stderr
+	if inspect.Config != nil && inspect.Config.Tty {
+		_, err = io.Copy(f, rc)
+	} else {
+		_, err = stdcopy.StdCopy(f, f, rc)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
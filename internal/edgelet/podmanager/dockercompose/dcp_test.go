@@ -347,7 +347,7 @@ func Test_log(t *testing.T) {
 	podname := "iperf-exporter-8vc4m"
 	containerName := "exporter"
 	f := getDefaultFilters(dcp.Project)
-	f = append(f, serviceFilter(makeContainerServiceName(podname, containerName)))
+	f = append(f, serviceFilter(makeContainerServiceName("default", podname, containerName)))
 	mcs, err := dcp.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
 		Filters: filters.NewArgs(f...),
 		All:     true,
@@ -446,3 +446,54 @@ func Test_slice(t *testing.T) {
 	path := filepath.Join("/etc", "host")
 	t.Log(path)
 }
+
+func Test_containerServiceName(t *testing.T) {
+	tests := []struct {
+		name          string
+		namespace     string
+		podName       string
+		containerName string
+		wantOK        bool
+	}{
+		{name: "simple", namespace: "default", podName: "web", containerName: "app", wantOK: true},
+		{name: "dashes", namespace: "kube-system", podName: "web-5d8f7-x2x9q", containerName: "log-agent", wantOK: true},
+		{name: "dots", namespace: "default", podName: "web.v1", containerName: "app", wantOK: true},
+		//k8s的名称中不会出现下划线, 出现时无法还原, 不能解析成错误的pod
+		{name: "underscore in pod", namespace: "default", podName: "web_1", containerName: "app"},
+		{name: "underscore in container", namespace: "default", podName: "web", containerName: "app_1"},
+		{name: "underscore in namespace", namespace: "my_ns", podName: "web", containerName: "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serviceName := makeContainerServiceName(tt.namespace, tt.podName, tt.containerName)
+			namespace, podName, containerName := parseContainerServiceName(serviceName)
+			if !tt.wantOK {
+				if namespace != "" || podName != "" || containerName != "" {
+					t.Errorf("parseContainerServiceName(%q) = %q %q %q, want empty", serviceName, namespace, podName, containerName)
+				}
+				return
+			}
+			if namespace != tt.namespace || podName != tt.podName || containerName != tt.containerName {
+				t.Errorf("parseContainerServiceName(%q) = %q %q %q", serviceName, namespace, podName, containerName)
+			}
+			//没有k8s label的容器从service名称获取
+			namespace, podName, containerName = containerIdentity(map[string]string{api.ServiceLabel: serviceName})
+			if namespace != tt.namespace || podName != tt.podName || containerName != tt.containerName {
+				t.Errorf("containerIdentity(%q) = %q %q %q", serviceName, namespace, podName, containerName)
+			}
+		})
+	}
+}
+
+//旧版本的容器有pod的label, 但没有容器名称的label
+func Test_containerIdentity_legacy(t *testing.T) {
+	labels := map[string]string{
+		k8sNamespaceLabel: "default",
+		k8sPodNameLabel:   "web-1",
+		api.ServiceLabel:  legacyContainerServiceName("web-1", "log-agent"),
+	}
+	namespace, podName, containerName := containerIdentity(labels)
+	if namespace != "default" || podName != "web-1" || containerName != "log-agent" {
+		t.Errorf("containerIdentity() = %q %q %q", namespace, podName, containerName)
+	}
+}
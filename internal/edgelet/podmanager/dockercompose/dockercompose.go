@@ -8,14 +8,17 @@ import (
 	"context"
 	"edge/api/edge-proto/pb"
 	pmconf "edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/podmanager/podstore"
+	"edge/internal/edgelet/podmanager/podutil"
+	"edge/internal/edgelet/podmanager/stream"
 	"edge/pkg/errdefs"
-	"edge/pkg/util"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"os"
+	"io/ioutil"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -27,22 +30,34 @@ import (
 	"github.com/docker/compose/v2/pkg/api"
 	"github.com/docker/compose/v2/pkg/compose"
 	moby "github.com/docker/docker/api/types"
+	mobycontainer "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
-	k8sNamespaceLabel = "k8s-namespace"
-	k8sPodInfoLabel   = "k8s-podinfo"
-	k8sPodNameLabel   = "k8s-podname"
-	k8sFromLabel      = "k8s-from"
-	k8sInitContainer  = "k8s-initContainer"
-	always            = "always"
-
-	restartTimes = 5
+	k8sNamespaceLabel     = "k8s-namespace"
+	k8sPodInfoLabel       = "k8s-podinfo" //旧版本把整个pod保存在label中, 只用于迁移
+	k8sPodUIDLabel        = "k8s-poduid"
+	k8sPodNameLabel       = "k8s-podname"
+	k8sContainerNameLabel = "k8s-containername"
+	k8sFromLabel          = "k8s-from"
+	k8sInitContainer      = "k8s-initContainer"
+	always                = "always"
+
+	//pod status中上报docker实际生效的资源限制, message为每个容器的limits和requests
+	resourcesAppliedCondition v1.PodConditionType = "ResourcesApplied"
+
+	//重启由docker的restart manager负责, 等待时间从100ms开始翻倍, 最长1分钟, 运行超过10s后重新从100ms开始
+	//探测失败后停止的容器由edgelet按相同的等待时间启动
+	initialBackOff  = 100 * time.Millisecond
+	maxBackOff      = time.Minute
+	backOffResetRun = 10 * time.Second
 )
 
 type containerReason string
@@ -54,6 +69,7 @@ const (
 	errorReason            containerReason = "Error"
 	recreateReason         containerReason = "ReCreated"
 	pendingReason          containerReason = "Pending"
+	containerMissingReason containerReason = "ContainerMissing"
 )
 
 var (
@@ -71,10 +87,19 @@ type dcpPodManager struct {
 	pmconf.Config
 	composeApi     api.Service
 	dockerCli      command.Cli
-	podEvents      map[string]struct{}
+	podEvents      map[string]podKey
 	eventMutex     sync.RWMutex
 	podMutex       sync.Mutex
 	runtimeVersion string
+	prober         *prober
+	store          *podstore.Store
+	waiting        map[podKey]map[string]v1.ContainerStateWaiting
+	waitingMutex   sync.RWMutex
+}
+
+type podKey struct {
+	namespace string
+	name      string
 }
 
 //Docker Compose版本必须要在V2.0 以上
@@ -94,16 +119,29 @@ func NewPodManager(opts ...pmconf.Option) *dcpPodManager {
 	options.ConfigDir = filepath.Dir(config.Dir())
 	dockerCli.Initialize(options)
 	composeAPI := compose.NewComposeService(dockerCli)
+	store, err := podstore.New(conf.PodStoreRoot())
+	if err != nil {
+		panic(err)
+	}
 	dcp := &dcpPodManager{
 		dockerCli:  dockerCli,
 		composeApi: composeAPI,
 		Config:     conf,
-		podEvents:  map[string]struct{}{},
-	}
+		podEvents:  map[string]podKey{},
+		store:      store,
+		waiting:    map[podKey]map[string]v1.ContainerStateWaiting{},
+	}
+	dcp.prober = newProber(dcp)
+	go dcp.startProbers()
+	go dcp.cleanupCompletedPods()
+	go dcp.recreateMissingPods()
 	go dcp.handleEvent(func(event api.Event) error {
+		namespace, podName, _ := containerIdentity(event.Attributes)
+		if podName == "" {
+			return nil
+		}
 		dcp.eventMutex.Lock()
-		podName, _ := parseContainerServiceName(event.Service)
-		dcp.podEvents[podName] = struct{}{}
+		dcp.podEvents[namespace+"/"+podName] = podKey{namespace: namespace, name: podName}
 		dcp.eventMutex.Unlock()
 		return nil
 	})
@@ -111,80 +149,7 @@ func NewPodManager(opts ...pmconf.Option) *dcpPodManager {
 }
 
 func (d *dcpPodManager) CreateVolume(ctx context.Context, req *pb.CreateVolumeRequest) error {
-	for _, v := range req.Vols {
-		logrus.Info("CreateVolume ", v.Name)
-		switch vol := v.Volumn.(type) {
-		case *pb.EdgeVolume_EmptyDir:
-			path := filepath.Join(d.EmptyDirRoot(), v.Name)
-			if util.IsFileExist(path) {
-				continue
-			}
-			err := os.MkdirAll(path, 0755)
-			if err != nil {
-				return fmt.Errorf("mkdir emptydir failed,err=%v", err)
-			}
-		case *pb.EdgeVolume_HostPath:
-			path := vol.HostPath.Path
-			if util.IsFileExist(path) {
-				logrus.Info("path is exist:", path)
-				continue
-			}
-			hostType := v1.HostPathType(vol.HostPath.HostType)
-			if hostType == v1.HostPathDirectory || hostType == v1.HostPathDirectoryOrCreate || hostType == v1.HostPathUnset {
-				err := os.MkdirAll(path, 0755)
-				if err != nil {
-					return fmt.Errorf("mkdir hostPath failed,err=%v", err)
-				}
-			} else if hostType == v1.HostPathFile || hostType == v1.HostPathFileOrCreate {
-				f, err := os.Create(path)
-				if err != nil {
-					return fmt.Errorf("mkdir hostPath failed,err=%v", err)
-				}
-				f.Close()
-			}
-		case *pb.EdgeVolume_ConfigMap:
-			dirpath := filepath.Join(d.ConfigMapRoot(), vol.ConfigMap.Namespace, v.Name)
-			if err := os.MkdirAll(dirpath, 0755); err != nil {
-				return fmt.Errorf("mkdir configPath %s failed, err=%v", dirpath, err)
-			}
-			for name, data := range vol.ConfigMap.Items {
-				path := filepath.Join(dirpath, name)
-				if util.IsFileExist(path) {
-					continue
-				}
-				f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
-				if err != nil {
-					return fmt.Errorf("create configmap failed,err=%v", err)
-				}
-				_, err = f.WriteString(data)
-				if err != nil {
-					return fmt.Errorf("write configmap %s failed,err=%v", name, err)
-				}
-				f.Close()
-			}
-		case *pb.EdgeVolume_Secret:
-			dirpath := filepath.Join(d.SecretRoot(), vol.Secret.Namespace, v.Name)
-			if err := os.MkdirAll(dirpath, 0755); err != nil {
-				return fmt.Errorf("mkdir secretPath %s failed, err=%v", dirpath, err)
-			}
-			for name, data := range vol.Secret.Items {
-				path := filepath.Join(dirpath, name)
-				if util.IsFileExist(path) {
-					continue
-				}
-				f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
-				if err != nil {
-					return fmt.Errorf("create secret failed,err=%v", err)
-				}
-				_, err = f.Write(data)
-				if err != nil {
-					return fmt.Errorf("write secret %s failed,err=%v", name, err)
-				}
-				f.Close()
-			}
-		}
-	}
-	return nil
+	return podutil.CreateVolume(d.Config, req)
 }
 
 //将k8s的pod转换为docker compose中的
@@ -197,14 +162,23 @@ func (d *dcpPodManager) UpdatePod(ctx context.Context, pod *v1.Pod) (*v1.Pod, er
 }
 
 func (d *dcpPodManager) DeletePod(ctx context.Context, pod *v1.Pod) error {
+	d.podMutex.Lock()
+	defer d.podMutex.Unlock()
+	d.prober.removePod(pod)
 	pp := NewPodProject(d.Config, pod)
 	services := pp.ServiceNames()
-	return d.composeApi.Down(ctx, d.Project, api.DownOptions{
+	err := d.composeApi.Down(ctx, d.Project, api.DownOptions{
 		Project: &types.Project{
 			Name:     d.Project,
 			Services: services,
 		},
 	})
+	if err != nil {
+		return err
+	}
+	d.clearWaiting(pod)
+	podutil.RemoveLogs(d.Config, pod.Namespace, pod.Name)
+	return d.store.Delete(pod.Namespace, pod.Name)
 }
 
 func (d *dcpPodManager) GetPod(ctx context.Context, namespace, podName string) (*v1.Pod, error) {
@@ -221,7 +195,11 @@ func (d *dcpPodManager) GetPod(ctx context.Context, namespace, podName string) (
 		return nil, err
 	}
 	if len(containers) == 0 {
-		return nil, errdefs.NotFoundf("%s/%s not found", namespace, podName)
+		pod, err := d.store.Find(namespace, podName)
+		if err != nil {
+			return nil, errdefs.NotFoundf("%s/%s not found", namespace, podName)
+		}
+		return d.noContainersPod(pod), nil
 	}
 
 	inspects := make([]moby.ContainerJSON, len(containers))
@@ -249,9 +227,8 @@ func (d *dcpPodManager) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 	}
 	//将一个pod下的container分组
 	for _, c := range containers {
-		serivceName := c.Labels[api.ServiceLabel]
-		podName, _ := parseContainerServiceName(serivceName)
-		//解析为空则说明不是k8s下发的
+		namespace, podName, _ := containerIdentity(c.Labels)
+		//为空则说明不是k8s下发的
 		if podName == "" {
 			continue
 		}
@@ -259,7 +236,8 @@ func (d *dcpPodManager) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 		if err != nil {
 			return nil, err
 		}
-		podContainers[podName] = append(podContainers[podName], inspect)
+		key := namespace + "/" + podName
+		podContainers[key] = append(podContainers[key], inspect)
 	}
 	pods := make([]*v1.Pod, 0)
 	for _, cs := range podContainers {
@@ -270,22 +248,28 @@ func (d *dcpPodManager) GetPods(ctx context.Context) ([]*v1.Pod, error) {
 		}
 		pods = append(pods, pod)
 	}
+	//store中有记录但是容器已经不存在的pod
+	for _, pod := range d.store.List() {
+		if _, ok := podContainers[pod.Namespace+"/"+pod.Name]; ok {
+			continue
+		}
+		pods = append(pods, d.noContainersPod(pod))
+	}
 	return pods, nil
 }
 
 func (d *dcpPodManager) GetContainerLogs(ctx context.Context, namespace, podname, containerName string, opts *pb.ContainerLogOptions) (io.ReadCloser, error) {
-	f := getDefaultFilters(d.Project)
-	f = append(f, serviceFilter(makeContainerServiceName(podname, containerName)))
-	mcs, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
-		Filters: filters.NewArgs(f...),
-		All:     true,
-	})
+	containerID, err := d.getContainerID(ctx, namespace, podname, containerName)
+	if errdefs.IsNotFound(err) {
+		//运行结束的pod的容器被删除后, 日志保存在文件中
+		r, ferr := podutil.OpenLogFile(ctx, podutil.LogPath(d.Config, namespace, podname, containerName), opts)
+		if ferr == nil {
+			return r, nil
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	if len(mcs) == 0 {
-		return nil, errdefs.NotFoundf("%s/%s-%s not found", namespace, podname, containerName)
-	}
 
 	mopts := moby.ContainerLogsOptions{
 		ShowStdout: true,
@@ -298,21 +282,115 @@ func (d *dcpPodManager) GetContainerLogs(ctx context.Context, namespace, podname
 	if opts.Tail > 0 {
 		mopts.Tail = fmt.Sprint(opts.Tail)
 	}
-	return d.dockerCli.Client().ContainerLogs(ctx, mcs[0].ID, mopts)
+	return d.dockerCli.Client().ContainerLogs(ctx, containerID, mopts)
+}
+
+func (d *dcpPodManager) RunInContainer(ctx context.Context, namespace, podname, containerName string, cmd []string, attach stream.AttachIO) error {
+	containerID, err := d.getContainerID(ctx, namespace, podname, containerName)
+	if err != nil {
+		return err
+	}
+
+	stdin, stdout, stderr := attach.Stdin(), attach.Stdout(), attach.Stderr()
+	execConfig := moby.ExecConfig{
+		Cmd:          cmd,
+		Tty:          attach.TTY(),
+		AttachStdin:  stdin != nil,
+		AttachStdout: stdout != nil,
+		AttachStderr: stderr != nil,
+	}
+	execResp, err := d.dockerCli.Client().ContainerExecCreate(ctx, containerID, execConfig)
+	if err != nil {
+		return fmt.Errorf("create exec in %s/%s-%s failed,err=%v", namespace, podname, containerName, err)
+	}
+	hijack, err := d.dockerCli.Client().ContainerExecAttach(ctx, execResp.ID, moby.ExecStartCheck{Tty: execConfig.Tty})
+	if err != nil {
+		return fmt.Errorf("attach exec %s failed,err=%v", execResp.ID, err)
+	}
+	defer hijack.Close()
+
+	go func() {
+		resize := attach.Resize()
+		if resize == nil {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case size, ok := <-resize:
+				if !ok {
+					return
+				}
+				err := d.dockerCli.Client().ContainerExecResize(ctx, execResp.ID, moby.ResizeOptions{
+					Height: uint(size.Height),
+					Width:  uint(size.Width),
+				})
+				if err != nil {
+					logrus.Warn("resize exec tty failed,err=", err)
+				}
+			}
+		}
+	}()
+
+	if stdin != nil {
+		go func() {
+			if _, err := io.Copy(hijack.Conn, stdin); err != nil {
+				logrus.Warn("copy exec stdin failed,err=", err)
+			}
+			hijack.CloseWrite()
+		}()
+	}
+
+	outputDone := make(chan error, 1)
+	go func() {
+		var err error
+		if stdout == nil {
+			stdout = nopWriteCloser{ioutil.Discard}
+		}
+		if stderr == nil {
+			stderr = nopWriteCloser{ioutil.Discard}
+		}
+		//tty模式下docker不会区分stdout和stderr
+		if execConfig.Tty {
+			_, err = io.Copy(stdout, hijack.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(stdout, stderr, hijack.Reader)
+		}
+		outputDone <- err
+	}()
+
+	select {
+	case err := <-outputDone:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	inspect, err := d.dockerCli.Client().ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("command terminated with exit code %d", inspect.ExitCode)
+	}
+	return nil
 }
 
 func (d *dcpPodManager) DescribePodsStatus(ctx context.Context) ([]*v1.Pod, error) {
-	var podNames []string
+	var keys []podKey
 	var pods []*v1.Pod
 	d.eventMutex.Lock()
-	for podName := range d.podEvents {
-		podNames = append(podNames, podName)
-		delete(d.podEvents, podName)
+	for id, key := range d.podEvents {
+		keys = append(keys, key)
+		delete(d.podEvents, id)
 	}
 	d.eventMutex.Unlock()
 
-	for _, podName := range podNames {
-		pod, err := d.GetPod(ctx, "", podName)
+	for _, key := range keys {
+		pod, err := d.GetPod(ctx, key.namespace, key.name)
 		if err != nil {
 			continue
 		}
@@ -376,19 +454,45 @@ func (d *dcpPodManager) createOrUpdate(ctx context.Context, pod *v1.Pod) (*v1.Po
 	logrus.Info("podIp:", pod.Status.PodIP, pod.Status.PodIPs)
 	d.podMutex.Lock()
 	defer d.podMutex.Unlock()
-	project := NewPodProject(d.Config, pod).Project()
-	err := d.composeApi.Up(ctx, &project, api.UpOptions{
+	if err := d.store.Put(pod); err != nil {
+		return pod, err
+	}
+	return d.upPod(ctx, pod)
+}
+
+//upPod 创建或者更新pod的容器, 调用者需要持有podMutex
+func (d *dcpPodManager) upPod(ctx context.Context, pod *v1.Pod) (*v1.Pod, error) {
+	if err := d.removeLegacyServices(ctx, pod); err != nil {
+		return pod, err
+	}
+	if err := d.pullImages(ctx, pod); err != nil {
+		return pod, err
+	}
+	project, err := NewPodProject(d.Config, pod).Project()
+	if err != nil {
+		d.setCreateError(pod, podutil.CreateConfigError, err)
+		return pod, err
+	}
+	finished, err := d.finishedServices(ctx, pod)
+	if err != nil {
+		return pod, err
+	}
+	startProject := withoutServices(project, finished)
+	err = d.composeApi.Up(ctx, &project, api.UpOptions{
 		Create: api.CreateOptions{
 			Inherit:              true,
 			Recreate:             api.RecreateNever,
 			RecreateDependencies: api.RecreateNever,
 			IgnoreOrphans:        true,
 		},
-		Start: api.StartOptions{Project: &project},
+		Start: api.StartOptions{Project: &startProject},
 	})
 	if err != nil {
+		d.setCreateError(pod, podutil.CreateContainerError, err)
 		return pod, err
 	}
+	d.clearWaiting(pod)
+	d.prober.addPod(pod)
 	pod, err = d.GetPod(ctx, pod.Namespace, pod.Name)
 	if err != nil {
 		return pod, err
@@ -396,21 +500,131 @@ func (d *dcpPodManager) createOrUpdate(ctx context.Context, pod *v1.Pod) (*v1.Po
 	return pod, nil
 }
 
-func makeContainerServiceName(podName, containerName string) string {
+func (d *dcpPodManager) getContainerID(ctx context.Context, namespace, podname, containerName string) (string, error) {
+	//旧版本的service名称不包含namespace, 需要额外按namespace过滤
+	for _, f := range [][]filters.KeyValuePair{
+		getDefaultFilters(d.Project, makeContainerServiceName(namespace, podname, containerName)),
+		append(getDefaultFilters(d.Project, legacyContainerServiceName(podname, containerName)), namespaceFilter(namespace)),
+	} {
+		mcs, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+			Filters: filters.NewArgs(f...),
+			All:     true,
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(mcs) > 0 {
+			return mcs[0].ID, nil
+		}
+	}
+	return "", errdefs.NotFoundf("%s/%s-%s not found", namespace, podname, containerName)
+}
+
+//removeLegacyServices 删除旧版本命名的容器, 之后由createOrUpdate按新的service名称重新创建
+//升级后第一次更新pod时, 正在运行的旧容器会被停止并重建
+func (d *dcpPodManager) removeLegacyServices(ctx context.Context, pod *v1.Pod) error {
+	f := getDefaultFilters(d.Project)
+	f = append(f, namespaceFilter(pod.Namespace), podnameFilter(pod.Name))
+	containers, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+		Filters: filters.NewArgs(f...),
+		All:     true,
+	})
+	if err != nil {
+		return err
+	}
+	services := types.Services{}
+	for _, c := range containers {
+		if _, ok := c.Labels[k8sContainerNameLabel]; ok {
+			continue
+		}
+		services = append(services, types.ServiceConfig{Name: c.Labels[api.ServiceLabel]})
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	logrus.Warnf("migrate pod %s/%s to namespaced service names, its containers will be recreated", pod.Namespace, pod.Name)
+	return d.composeApi.Down(ctx, d.Project, api.DownOptions{
+		Project: &types.Project{
+			Name:     d.Project,
+			Services: services,
+		},
+	})
+}
+
+func (d *dcpPodManager) inspectContainer(ctx context.Context, namespace, podname, containerName string) (moby.ContainerJSON, error) {
+	containerID, err := d.getContainerID(ctx, namespace, podname, containerName)
+	if err != nil {
+		return moby.ContainerJSON{}, err
+	}
+	return d.dockerCli.Client().ContainerInspect(ctx, containerID)
+}
+
+//podIP pod内的容器都共享第一个容器的网络, hostNetwork时为节点的ip
+func (d *dcpPodManager) podIP(ctx context.Context, pod *v1.Pod) (string, error) {
+	if pod.Spec.HostNetwork || len(pod.Spec.Containers) == 0 {
+		return d.IPAddress, nil
+	}
+	inspect, err := d.inspectContainer(ctx, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name)
+	if err != nil {
+		return "", err
+	}
+	if inspect.NetworkSettings == nil {
+		return "", fmt.Errorf("container %s has no network settings", inspect.Name)
+	}
+	_, networkName := makeNetworkName(d.Project)
+	if ep, ok := inspect.NetworkSettings.Networks[networkName]; ok && ep.IPAddress != "" {
+		return ep.IPAddress, nil
+	}
+	return "", fmt.Errorf("container %s has no ip in network %s", inspect.Name, networkName)
+}
+
+//startProbers edgelet重启后为已经存在的pod恢复探测
+func (d *dcpPodManager) startProbers() {
+	pods, err := d.GetPods(context.Background())
+	if err != nil {
+		logrus.Error("startProbers GetPods failed,err=", err)
+		return
+	}
+	for _, pod := range pods {
+		d.prober.addPod(pod)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+//compose的service名称只能包含[a-zA-Z0-9._-], k8s的namespace、pod名称和容器名称中都不会出现下划线,
+//所以用下划线拼接可以无歧义地还原, 不同namespace下的同名pod也不会冲突
+func makeContainerServiceName(namespace, podName, containerName string) string {
+	return namespace + "_" + podName + "_" + containerName
+}
+
+func parseContainerServiceName(serviceName string) (namespace, podName, containerName string) {
+	slice := strings.Split(serviceName, "_")
+	if len(slice) != 3 {
+		return "", "", ""
+	}
+	return slice[0], slice[1], slice[2]
+}
+
+//旧版本的service名称为<pod>.<container>, 只用于迁移
+func legacyContainerServiceName(podName, containerName string) string {
 	return podName + "." + containerName
 }
 
-func parseContainerServiceName(serviceName string) (podName, containerName string) {
-	slice := strings.Split(serviceName, ".")
-	if len(slice) < 2 {
-		return "", ""
+//containerIdentity 从容器的label获取所属的pod和容器名称
+//旧版本的容器没有容器名称的label, 从service名称中去掉pod名称得到
+func containerIdentity(labels map[string]string) (namespace, podName, containerName string) {
+	namespace, podName, containerName = labels[k8sNamespaceLabel], labels[k8sPodNameLabel], labels[k8sContainerNameLabel]
+	if podName == "" {
+		return parseContainerServiceName(labels[api.ServiceLabel])
 	}
-	i := 1
-	podName = slice[0]
-	for ; i < len(slice)-1; i++ {
-		podName += "." + slice[i]
+	if containerName == "" {
+		containerName = strings.TrimPrefix(labels[api.ServiceLabel], podName+".")
 	}
-	containerName = slice[i]
 	return
 }
 
@@ -447,12 +661,16 @@ func getDefaultFilters(projectName string, selectedServices ...string) []filters
 	return f
 }
 
-//重点
-func (d *dcpPodManager) mobyContainersToK8sPod(containers ...moby.ContainerJSON) (*v1.Pod, error) {
-	if len(containers) == 0 {
-		return nil, errdefs.NotFound("container is empty")
+//从pod store中获取期望的pod, 旧版本的容器把pod保存在label中, 读取后导入到store
+func (d *dcpPodManager) storedPod(containers ...moby.ContainerJSON) (*v1.Pod, error) {
+	labels := containers[0].Config.Labels
+	pod, err := d.store.Get(labels[k8sNamespaceLabel], labels[k8sPodNameLabel])
+	if err == nil {
+		return pod, nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return nil, err
 	}
-	pod := v1.Pod{}
 	podinfo := ""
 	for _, c := range containers {
 		info := c.Config.Labels[k8sPodInfoLabel]
@@ -464,70 +682,137 @@ func (d *dcpPodManager) mobyContainersToK8sPod(containers ...moby.ContainerJSON)
 	if len(podinfo) == 0 {
 		return nil, errdefs.InvalidInput("not k8s container")
 	}
-	err := json.Unmarshal([]byte(podinfo), &pod)
-	if err != nil {
+	pod = &v1.Pod{}
+	if err := json.Unmarshal([]byte(podinfo), pod); err != nil {
 		logrus.Error("json unmarshal container pod label failed,err=", err)
 		return nil, errdefs.InvalidInput("k8s container label invalid")
 	}
-	pod.Status.Phase = v1.PodRunning
-	pod.Status.Reason = ""
-	pod.Status.PodIP = d.IPAddress
-	pod.Status.HostIP = d.IPAddress
-	pod.Status.Conditions = []v1.PodCondition{
-		{
-			Type:   v1.PodInitialized,
-			Status: v1.ConditionTrue,
-		},
-		{
-			Type:   v1.PodReady,
-			Status: v1.ConditionTrue,
-		},
-		{
-			Type:   v1.PodScheduled,
-			Status: v1.ConditionTrue,
-		},
+	logrus.Infof("migrate pod %s/%s from container label to pod store", pod.Namespace, pod.Name)
+	if err := d.store.Put(pod); err != nil {
+		return nil, err
 	}
+	return pod, nil
+}
 
-	initContainers := make(map[string]moby.ContainerJSON)
-	runContainers := make(map[string]moby.ContainerJSON)
-	for _, c := range containers {
-		serviceName := c.Config.Labels[api.ServiceLabel]
-		_, podContainerName := parseContainerServiceName(serviceName)
-		_, isInit := c.Config.Labels[k8sInitContainer]
-		if isInit {
-			initContainers[podContainerName] = c
-		} else {
-			runContainers[podContainerName] = c
-		}
+//pod的容器不存在了(例如被手动删除), 返回最后一次记录的状态并标记容器丢失, 已经结束的pod保持原来的phase
+func missingContainersPod(pod *v1.Pod) *v1.Pod {
+	if !podutil.IsTerminated(pod) {
+		pod.Status.Phase = v1.PodUnknown
 	}
-
-	//spec container
-	var initStatus, statuses []v1.ContainerStatus
-	for _, ic := range pod.Spec.InitContainers {
-		mobyContainer, ok := initContainers[ic.Name]
-		if ok {
-			containerStatus := mobyContainerToK8sContainerState(ic.Name, mobyContainer, true)
-			if !containerStatus.Ready {
-				pod.Status.Conditions[0].Status = v1.ConditionFalse
-				pod.Status.Conditions[1].Status = v1.ConditionFalse
+	markMissing := func(statuses []v1.ContainerStatus) {
+		for i := range statuses {
+			if statuses[i].State.Waiting == nil {
+				statuses[i].LastTerminationState = statuses[i].State
+			}
+			statuses[i].State = v1.ContainerState{
+				Waiting: &v1.ContainerStateWaiting{
+					Reason:  string(containerMissingReason),
+					Message: "container not found in the container runtime",
+				},
 			}
-			initStatus = append(initStatus, containerStatus)
+			statuses[i].Ready = false
+			statuses[i].Started = nil
+		}
+	}
+	markMissing(pod.Status.InitContainerStatuses)
+	markMissing(pod.Status.ContainerStatuses)
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == v1.PodReady {
+			pod.Status.Conditions[i].Status = v1.ConditionFalse
+			pod.Status.Conditions[i].Reason = string(containerMissingReason)
 		}
 	}
-	pod.Status.InitContainerStatuses = initStatus
+	return pod
+}
 
-	for _, c := range pod.Spec.Containers {
-		mobyContainer, ok := runContainers[c.Name]
-		if ok {
-			containerStatus := mobyContainerToK8sContainerState(c.Name, mobyContainer, false)
-			if !containerStatus.Ready {
-				pod.Status.Conditions[1].Status = v1.ConditionFalse
-			}
-			statuses = append(statuses, containerStatus)
+//重点
+func (d *dcpPodManager) mobyContainersToK8sPod(containers ...moby.ContainerJSON) (*v1.Pod, error) {
+	if len(containers) == 0 {
+		return nil, errdefs.NotFound("container is empty")
+	}
+	pod, err := d.storedPod(containers...)
+	if err != nil {
+		return nil, err
+	}
+	pod = d.podStatus(pod, containers)
+	if err := d.store.UpdateStatus(pod.Namespace, pod.Name, pod.Status); err != nil {
+		logrus.Warn("save pod status failed,err=", err)
+	}
+	return pod, nil
+}
+
+//把容器HostConfig中实际生效的cpu/memory限制以condition的形式写回pod status
+//例如 app: limits cpu=500m memory=256Mi, requests cpu=250m
+func setAppliedResources(pod *v1.Pod, containers []moby.ContainerJSON) {
+	var applied []string
+	for _, c := range containers {
+		if c.HostConfig == nil {
+			continue
+		}
+		_, _, podContainerName := containerIdentity(c.Config.Labels)
+		resources := mobyResourcesToK8s(c.HostConfig.Resources)
+		var parts []string
+		if len(resources.Limits) > 0 {
+			parts = append(parts, "limits "+formatResourceList(resources.Limits))
+		}
+		if len(resources.Requests) > 0 {
+			parts = append(parts, "requests "+formatResourceList(resources.Requests))
+		}
+		if len(parts) == 0 {
+			continue
 		}
+		applied = append(applied, podContainerName+": "+strings.Join(parts, ", "))
 	}
-	pod.Status.ContainerStatuses = statuses
-	return &pod, nil
+	if len(applied) == 0 {
+		return
+	}
+	sort.Strings(applied)
+	pod.Status.Conditions = append(pod.Status.Conditions, v1.PodCondition{
+		Type:    resourcesAppliedCondition,
+		Status:  v1.ConditionTrue,
+		Message: strings.Join(applied, "; "),
+	})
+}
+
+//formatResourceList 按资源名称排序, 例如 cpu=500m memory=256Mi
+func formatResourceList(list v1.ResourceList) string {
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		quantity := list[v1.ResourceName(name)]
+		parts = append(parts, name+"="+quantity.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func mobyResourcesToK8s(r mobycontainer.Resources) v1.ResourceRequirements {
+	ret := v1.ResourceRequirements{}
+	limits := v1.ResourceList{}
+	if r.NanoCPUs > 0 {
+		limits[v1.ResourceCPU] = *resource.NewMilliQuantity(r.NanoCPUs/1e6, resource.DecimalSI)
+	}
+	if r.Memory > 0 {
+		limits[v1.ResourceMemory] = *resource.NewQuantity(r.Memory, resource.BinarySI)
+	}
+	if len(limits) > 0 {
+		ret.Limits = limits
+	}
+	requests := v1.ResourceList{}
+	//cpu_shares为0或者默认的1024时表示没有设置
+	if r.CPUShares > 0 && r.CPUShares != podutil.SharesPerCPU {
+		requests[v1.ResourceCPU] = *resource.NewMilliQuantity(r.CPUShares*podutil.MilliCPUToCPU/podutil.SharesPerCPU, resource.DecimalSI)
+	}
+	if r.MemoryReservation > 0 {
+		requests[v1.ResourceMemory] = *resource.NewQuantity(r.MemoryReservation, resource.BinarySI)
+	}
+	if len(requests) > 0 {
+		ret.Requests = requests
+	}
+	return ret
 }
 
 func mobyContainerToK8sContainerState(podContainerName string, container moby.ContainerJSON, isInit bool) v1.ContainerStatus {
@@ -538,8 +823,15 @@ func mobyContainerToK8sContainerState(podContainerName string, container moby.Co
 	ret.Ready = false
 	createTime, _ := time.Parse(time.RFC3339Nano, container.Created)
 	createAt := metav1.NewTime(createTime)
+	//已经创建但还没有启动过, 例如在等待init-container完成
+	if container.State.Status == "created" {
+		ret.State.Waiting = &v1.ContainerStateWaiting{
+			Reason: podutil.ContainerCreating,
+		}
+		return ret
+	}
 	if container.State.Running && !container.State.Restarting {
-		ret.Ready = true
+		ret.Ready = !isInit
 		ret.State.Running = &v1.ContainerStateRunning{
 			StartedAt: createAt,
 		}
@@ -548,22 +840,6 @@ func mobyContainerToK8sContainerState(podContainerName string, container moby.Co
 
 	startTime, _ := time.Parse(time.RFC3339Nano, container.State.StartedAt)
 	endtime, _ := time.Parse(time.RFC3339Nano, container.State.FinishedAt)
-	if isInit {
-		ret.State.Terminated = &v1.ContainerStateTerminated{
-			ExitCode:   int32(container.State.ExitCode),
-			StartedAt:  metav1.NewTime(startTime),
-			FinishedAt: metav1.NewTime(endtime),
-		}
-		if container.State.ExitCode == 0 {
-			ret.Ready = true
-			ret.State.Terminated.Reason = string(completedReason)
-		} else {
-			ret.State.Terminated.Reason = string(initErrorReason)
-			ret.State.Terminated.Message = container.State.Error
-		}
-		return ret
-	}
-
 	terminate := &v1.ContainerStateTerminated{
 		ExitCode:   int32(container.State.ExitCode),
 		Reason:     string(errorReason),
@@ -572,15 +848,118 @@ func mobyContainerToK8sContainerState(podContainerName string, container moby.Co
 	}
 	if container.State.ExitCode == 0 {
 		terminate.Reason = string(completedReason)
+		ret.Ready = isInit
+	} else if isInit {
+		terminate.Reason = string(initErrorReason)
+		terminate.Message = container.State.Error
 	}
 
-	if ret.RestartCount >= 3 {
+	//已经重启过并且docker还会再次重启, 处于重启前的等待
+	if ret.RestartCount > 0 && willRestart(container) {
+		ret.Ready = false
 		ret.State.Waiting = &v1.ContainerStateWaiting{
-			Reason: string(crashLoopBackOffReason),
+			Reason:  string(crashLoopBackOffReason),
+			Message: fmt.Sprintf("back-off %s restarting failed container=%s", crashLoopBackOff(container), podContainerName),
 		}
 		ret.LastTerminationState.Terminated = terminate
-	} else {
-		ret.State.Terminated = terminate
+		return ret
 	}
+	ret.State.Terminated = terminate
 	return ret
 }
+
+//willRestart 已退出的容器是否会被docker的重启策略再次启动
+func willRestart(container moby.ContainerJSON) bool {
+	if container.State.Restarting {
+		return true
+	}
+	if container.HostConfig == nil {
+		return false
+	}
+	policy := container.HostConfig.RestartPolicy
+	switch {
+	case policy.IsAlways(), policy.IsUnlessStopped():
+		return true
+	case policy.IsOnFailure():
+		return container.State.ExitCode != 0 && (policy.MaximumRetryCount == 0 || container.RestartCount < policy.MaximumRetryCount)
+	}
+	return false
+}
+
+//crashLoopBackOff docker下一次重启容器前的等待时间, docker在等待之前已经把RestartCount加1
+//docker不会在上一次运行超过10s后清零RestartCount, 所以更早的长时间运行之后的等待时间会偏大
+func crashLoopBackOff(container moby.ContainerJSON) time.Duration {
+	startTime, _ := time.Parse(time.RFC3339Nano, container.State.StartedAt)
+	endTime, _ := time.Parse(time.RFC3339Nano, container.State.FinishedAt)
+	if endTime.Sub(startTime) >= backOffResetRun {
+		return initialBackOff
+	}
+	return backOff(container.RestartCount)
+}
+
+//backOff 第restarts次重启前的等待时间, 与docker的restart manager一致: 从initialBackOff开始翻倍, 不超过maxBackOff
+func backOff(restarts int) time.Duration {
+	backoff := initialBackOff
+	for i := 1; i < restarts && backoff < maxBackOff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackOff {
+		backoff = maxBackOff
+	}
+	return backoff
+}
+
+//finishedServices 已经运行结束并且不应该再启动的service: 成功完成的init-container, 以及restartPolicy不允许重启的已退出容器
+//compose up会启动所有没有运行的容器, 这些service需要从启动的project中去掉
+func (d *dcpPodManager) finishedServices(ctx context.Context, pod *v1.Pod) (map[string]bool, error) {
+	f := getDefaultFilters(d.Project)
+	f = append(f, namespaceFilter(pod.Namespace), podnameFilter(pod.Name), filters.Arg("status", "exited"))
+	containers, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+		Filters: filters.NewArgs(f...),
+		All:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	finished := map[string]bool{}
+	for _, c := range containers {
+		inspect, err := d.dockerCli.Client().ContainerInspect(ctx, c.ID)
+		if err != nil {
+			return nil, err
+		}
+		_, isInit := c.Labels[k8sInitContainer]
+		exitCode := inspect.State.ExitCode
+		switch {
+		case pod.Spec.RestartPolicy == v1.RestartPolicyNever:
+		case isInit && exitCode == 0:
+		case !isInit && pod.Spec.RestartPolicy == v1.RestartPolicyOnFailure && exitCode == 0:
+		default:
+			continue
+		}
+		finished[c.Labels[api.ServiceLabel]] = true
+	}
+	return finished, nil
+}
+
+//withoutServices 去掉指定的service以及对它们的依赖
+func withoutServices(project types.Project, excluded map[string]bool) types.Project {
+	if len(excluded) == 0 {
+		return project
+	}
+	services := types.Services{}
+	for _, s := range project.Services {
+		if excluded[s.Name] {
+			continue
+		}
+		dependsOn := types.DependsOnConfig{}
+		for name, dep := range s.DependsOn {
+			if !excluded[name] {
+				dependsOn[name] = dep
+			}
+		}
+		s.DependsOn = dependsOn
+		services = append(services, s)
+	}
+	project.Services = services
+	return project
+}
@@ -0,0 +1,77 @@
+package dockercompose
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/distribution/reference"
+	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	v1 "k8s.io/api/core/v1"
+)
+
+var errImageNeverPull = errors.New("image is not present with pull policy of Never")
+
+//pullImages 在compose up之前逐个拉取容器的镜像, 这样拉取失败时能知道是哪个容器, 并记录容器等待的原因
+func (d *dcpPodManager) pullImages(ctx context.Context, pod *v1.Pod) error {
+	waiting := map[string]v1.ContainerStateWaiting{}
+	var errs []string
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		err := d.ensureImage(ctx, c)
+		if err == nil {
+			continue
+		}
+		state := v1.ContainerStateWaiting{Reason: podutil.ErrImagePull, Message: err.Error()}
+		if errors.Is(err, errImageNeverPull) {
+			state.Reason = podutil.ErrImageNeverPull
+		} else if last, ok := d.waitingState(pod, c.Name); ok && (last.Reason == podutil.ErrImagePull || last.Reason == podutil.ImagePullBackOff) {
+			//连续拉取失败
+			state = v1.ContainerStateWaiting{Reason: podutil.ImagePullBackOff, Message: fmt.Sprintf("Back-off pulling image %q", c.Image)}
+		}
+		waiting[c.Name] = state
+		errs = append(errs, fmt.Sprintf("container %s: %v", c.Name, err))
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	d.setWaiting(pod, waiting)
+	return fmt.Errorf("pull images of pod %s/%s failed: %s", pod.Namespace, pod.Name, strings.Join(errs, "; "))
+}
+
+func (d *dcpPodManager) ensureImage(ctx context.Context, c v1.Container) error {
+	named, err := reference.ParseNormalizedNamed(c.Image)
+	if err != nil {
+		return fmt.Errorf("invalid image %q: %v", c.Image, err)
+	}
+	//没有tag时docker会拉取所有tag
+	image := reference.FamiliarString(reference.TagNameOnly(named))
+	if c.ImagePullPolicy != v1.PullAlways {
+		_, _, err := d.dockerCli.Client().ImageInspectWithRaw(ctx, image)
+		if err == nil {
+			return nil
+		}
+		if !client.IsErrNotFound(err) {
+			return err
+		}
+		if c.ImagePullPolicy == v1.PullNever {
+			return fmt.Errorf("container image %q: %w", c.Image, errImageNeverPull)
+		}
+	}
+	auth, err := command.RetrieveAuthTokenFromImage(ctx, d.dockerCli, image)
+	if err != nil {
+		return err
+	}
+	reader, err := d.dockerCli.Client().ImagePull(ctx, image, moby.ImagePullOptions{RegistryAuth: auth})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	//拉取过程中的错误在返回的消息流中
+	return jsonmessage.DisplayJSONMessagesStream(reader, ioutil.Discard, 0, false, nil)
+}
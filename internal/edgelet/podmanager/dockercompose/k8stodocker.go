@@ -2,10 +2,10 @@ package dockercompose
 
 import (
 	"edge/internal/edgelet/podmanager/config"
-	"encoding/json"
+	"edge/internal/edgelet/podmanager/podutil"
 	"fmt"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/compose-spec/compose-go/types"
 	"github.com/docker/compose/v2/pkg/api"
@@ -29,7 +29,7 @@ type dockerComposeProject struct {
 }
 
 type DockerComposeProject interface {
-	Project() types.Project
+	Project() (types.Project, error)
 	ServiceNames() []types.ServiceConfig
 }
 
@@ -40,17 +40,15 @@ func NewPodProject(conf config.Config, pod *v1.Pod) DockerComposeProject {
 	}
 }
 
-func (dcpp *dockerComposeProject) newDockerComposeLabels(service string, isInit bool) types.Labels {
+func (dcpp *dockerComposeProject) newDockerComposeLabels(service, containerName string, isInit bool) types.Labels {
 	labels := types.Labels{}
 	labels.Add(api.ProjectLabel, dcpp.config.Project)
 	labels.Add(api.ServiceLabel, service)
 	labels.Add(api.OneoffLabel, "False")
 	labels.Add(k8sNamespaceLabel, dcpp.pod.ObjectMeta.Namespace)
 	labels.Add(k8sPodNameLabel, dcpp.pod.ObjectMeta.Name)
-	jbyte, _ := json.Marshal(dcpp.pod)
-	if len(jbyte) > 0 {
-		labels.Add(k8sPodInfoLabel, string(jbyte))
-	}
+	labels.Add(k8sPodUIDLabel, string(dcpp.pod.ObjectMeta.UID))
+	labels.Add(k8sContainerNameLabel, containerName)
 	if isInit {
 		labels.Add(k8sInitContainer, "true")
 	}
@@ -60,47 +58,44 @@ func (dcpp *dockerComposeProject) newDockerComposeLabels(service string, isInit
 
 //volume 的sourcePath转换处理,configMap/secrets
 func (dcpp *dockerComposeProject) genSourcePath(mount v1.VolumeMount) string {
-	var index int = 0
-	for i, vo := range dcpp.pod.Spec.Volumes {
-		if vo.Name == mount.Name {
-			index = i
-			break
-		}
-	}
-	vo := dcpp.pod.Spec.Volumes[index]
-	path := ""
-	if vo.HostPath != nil {
-		path = vo.HostPath.Path
-	}
-	if vo.ConfigMap != nil {
-		path = filepath.Join(dcpp.config.ConfigMapRoot(), dcpp.pod.Namespace, vo.Name)
-	}
-	if vo.Secret != nil {
-		path = filepath.Join(dcpp.config.SecretRoot(), dcpp.pod.Namespace, vo.Name)
-	}
-	if vo.EmptyDir != nil {
-		path = filepath.Join(dcpp.config.EmptyDirRoot(), vo.Name)
-	}
-	if mount.SubPath != "" {
-		path = filepath.Join(path, mount.SubPath)
-	}
-	return path
+	return podutil.VolumeSource(dcpp.config, dcpp.pod, mount)
 }
 
-//TODO:健康检测的转换处理
+//健康检测的转换处理: 只有exec类型的probe能转换为docker的healthcheck,按readiness/liveness/startup的优先级选取一个
+//httpGet和tcpSocket类型的probe由prober负责
 func (dcpp *dockerComposeProject) toHealthCheck(container v1.Container) *types.HealthCheckConfig {
-
-	return nil
+	probe := healthCheckProbe(container)
+	if probe == nil {
+		return nil
+	}
+	probe = withProbeDefaults(probe)
+	test := types.HealthCheckTest{"CMD"}
+	test = append(test, probe.Exec.Command...)
+	timeout := types.Duration(time.Duration(probe.TimeoutSeconds) * time.Second)
+	interval := types.Duration(time.Duration(probe.PeriodSeconds) * time.Second)
+	startPeriod := types.Duration(time.Duration(probe.InitialDelaySeconds) * time.Second)
+	retries := uint64(probe.FailureThreshold)
+	return &types.HealthCheckConfig{
+		Test:        test,
+		Timeout:     &timeout,
+		Interval:    &interval,
+		StartPeriod: &startPeriod,
+		Retries:     &retries,
+	}
 }
 
 //env的处理
-func (dcpp *dockerComposeProject) toEnv(container v1.Container) types.MappingWithEquals {
+func (dcpp *dockerComposeProject) toEnv(container v1.Container) (types.MappingWithEquals, error) {
+	env, err := podutil.MakeEnvironmentVariables(dcpp.config, dcpp.pod, container)
+	if err != nil {
+		return nil, err
+	}
 	envs := types.MappingWithEquals{}
-	for _, e := range container.Env {
-		env := e
-		envs[env.Name] = &env.Value
+	for k, v := range env {
+		value := v
+		envs[k] = &value
 	}
-	return envs
+	return envs, nil
 }
 
 func (dcpp *dockerComposeProject) toVolumes(container v1.Container) []types.ServiceVolumeConfig {
@@ -143,7 +138,7 @@ func (dcpp *dockerComposeProject) toNetworkMode(container v1.Container) string {
 	//多容器Pod网络处理，都依赖于第一个容器的网络
 	if len(dcpp.pod.Spec.Containers) > 0 {
 		if dcpp.pod.Spec.Containers[0].Name != container.Name {
-			return networkModeServiceRely + makeContainerServiceName(dcpp.pod.Name, dcpp.pod.Spec.Containers[0].Name)
+			return networkModeServiceRely + makeContainerServiceName(dcpp.pod.Namespace, dcpp.pod.Name, dcpp.pod.Spec.Containers[0].Name)
 		}
 	}
 	return ""
@@ -182,6 +177,65 @@ func (dcpp *dockerComposeProject) toPrivileged(container v1.Container) bool {
 	return false
 }
 
+//重启策略的转换处理: 与kubelet一致, init-container成功完成后不再重启
+func (dcpp *dockerComposeProject) toRestartPolicy(isInit bool) string {
+	switch dcpp.pod.Spec.RestartPolicy {
+	case v1.RestartPolicyNever:
+		return types.RestartPolicyNo
+	case v1.RestartPolicyOnFailure:
+		return types.RestartPolicyOnFailure
+	}
+	if isInit {
+		return types.RestartPolicyOnFailure
+	}
+	return types.RestartPolicyAlways
+}
+
+//资源的转换处理: limits对应deploy.resources.limits
+//requests对应deploy.resources.reservations, 因为docker-compose不会应用reservations里的cpu/memory, 所以同时设置cpu_shares/mem_reservation
+func (dcpp *dockerComposeProject) toResources(container v1.Container, svrconf *types.ServiceConfig) {
+	limits := &types.Resource{}
+	if cpu, ok := container.Resources.Limits[v1.ResourceCPU]; ok && !cpu.IsZero() {
+		limits.NanoCPUs = fmt.Sprint(podutil.MilliCPUToNanoCPUs(cpu.MilliValue()))
+	}
+	if memory, ok := container.Resources.Limits[v1.ResourceMemory]; ok && !memory.IsZero() {
+		limits.MemoryBytes = types.UnitBytes(memory.Value())
+	}
+
+	//与apiserver一致，没有设置requests时使用limits
+	requests := v1.ResourceList{}
+	for name, quantity := range container.Resources.Limits {
+		requests[name] = quantity
+	}
+	for name, quantity := range container.Resources.Requests {
+		requests[name] = quantity
+	}
+	reservations := &types.Resource{}
+	if cpu, ok := requests[v1.ResourceCPU]; ok && !cpu.IsZero() {
+		reservations.NanoCPUs = fmt.Sprint(podutil.MilliCPUToNanoCPUs(cpu.MilliValue()))
+		svrconf.CPUShares = podutil.MilliCPUToShares(cpu.MilliValue())
+	}
+	if memory, ok := requests[v1.ResourceMemory]; ok && !memory.IsZero() {
+		reservations.MemoryBytes = types.UnitBytes(memory.Value())
+		svrconf.MemReservation = types.UnitBytes(memory.Value())
+	}
+
+	if isEmptyResource(limits) && isEmptyResource(reservations) {
+		return
+	}
+	svrconf.Deploy = &types.DeployConfig{}
+	if !isEmptyResource(limits) {
+		svrconf.Deploy.Resources.Limits = limits
+	}
+	if !isEmptyResource(reservations) {
+		svrconf.Deploy.Resources.Reservations = reservations
+	}
+}
+
+func isEmptyResource(r *types.Resource) bool {
+	return r.NanoCPUs == "" && r.MemoryBytes == 0
+}
+
 func (dcpp *dockerComposeProject) toExtraHosts() types.HostsList {
 	hosts := types.HostsList{}
 	for _, ha := range dcpp.pod.Spec.HostAliases {
@@ -193,39 +247,46 @@ func (dcpp *dockerComposeProject) toExtraHosts() types.HostsList {
 }
 
 //pod里面的容器转换成docker-compose的service
-func (dcpp *dockerComposeProject) toService(container v1.Container, isInit bool) types.ServiceConfig {
+func (dcpp *dockerComposeProject) toService(container v1.Container, isInit bool) (types.ServiceConfig, error) {
 	svrconf := types.ServiceConfig{}
-	podName := dcpp.pod.Name
-	svrconf.Name = makeContainerServiceName(podName, container.Name)
+	svrconf.Name = makeContainerServiceName(dcpp.pod.Namespace, dcpp.pod.Name, container.Name)
 	svrconf.Command = append(container.Command, container.Args...)
 	svrconf.Image = container.Image
-	svrconf.Labels = dcpp.newDockerComposeLabels(svrconf.Name, isInit)
+	svrconf.Labels = dcpp.newDockerComposeLabels(svrconf.Name, container.Name, isInit)
 	svrconf.CustomLabels = types.Labels{}
-	svrconf.Environment = dcpp.toEnv(container)
+	env, err := dcpp.toEnv(container)
+	if err != nil {
+		return svrconf, fmt.Errorf("container %s: %v", container.Name, err)
+	}
+	svrconf.Environment = env
 	svrconf.HealthCheck = dcpp.toHealthCheck(container)
 	svrconf.PullPolicy = types.PullPolicyIfNotPresent
-	svrconf.Restart = types.RestartPolicyAlways //types.RestartPolicyOnFailure+ ":" + fmt.Sprint(restartTimes) //github.com/docker/compose/@v2.6.0/pkg/compose/create.go/getRestartPolicy
+	svrconf.Restart = dcpp.toRestartPolicy(isInit)
 	svrconf.Scale = 1
 	svrconf.Ports = dcpp.toPort(container)
 	svrconf.Networks = dcpp.toServiceNetworks(isInit)
 	svrconf.NetworkMode = dcpp.toNetworkMode(container)
 	svrconf.Volumes = dcpp.toVolumes(container)
 	svrconf.Privileged = dcpp.toPrivileged(container)
+	dcpp.toResources(container, &svrconf)
 	svrconf.Tty = true
 	if !strings.HasPrefix(svrconf.NetworkMode, networkModeServiceRely) {
 		svrconf.ExtraHosts = dcpp.toExtraHosts() //这个会跟network_mode冲突
 	}
-	return svrconf
+	return svrconf, nil
 }
 
 //init-container依赖于上一个init-container的启动
 //容器依赖于所有init-container的启动
-func (dcpp *dockerComposeProject) services() types.Services {
+func (dcpp *dockerComposeProject) services() (types.Services, error) {
 	services := types.Services{}
 	lastServiceName := ""
 	initServiceNames := make([]string, len(dcpp.pod.Spec.InitContainers))
 	for i, ic := range dcpp.pod.Spec.InitContainers {
-		svrconf := dcpp.toService(ic, true)
+		svrconf, err := dcpp.toService(ic, true)
+		if err != nil {
+			return nil, err
+		}
 		if i != 0 {
 			svrconf.DependsOn = types.DependsOnConfig{
 				lastServiceName: serviceCompeleteDependency,
@@ -236,33 +297,40 @@ func (dcpp *dockerComposeProject) services() types.Services {
 		initServiceNames[i] = svrconf.Name
 	}
 	for _, c := range dcpp.pod.Spec.Containers {
-		svrconf := dcpp.toService(c, false)
+		svrconf, err := dcpp.toService(c, false)
+		if err != nil {
+			return nil, err
+		}
 		svrconf.DependsOn = types.DependsOnConfig{}
 		for _, isn := range initServiceNames {
 			svrconf.DependsOn[isn] = serviceCompeleteDependency
 		}
 		services = append(services, svrconf)
 	}
-	return services
+	return services, nil
 }
 
-func (dcpp *dockerComposeProject) Project() types.Project {
+func (dcpp *dockerComposeProject) Project() (types.Project, error) {
 	project := types.Project{Name: dcpp.config.Project}
-	project.Services = dcpp.services()
+	services, err := dcpp.services()
+	if err != nil {
+		return project, err
+	}
+	project.Services = services
 	networkField, networkName := makeNetworkName(project.Name)
 	project.Networks = types.Networks{networkField: types.NetworkConfig{Name: networkName}}
-	return project
+	return project, nil
 }
 
-//获取pod下的所有容器的service
+//获取pod下的所有容器的service, 包括旧版本命名的service, 保证删除pod时旧容器也会被删除
 func (dcpp *dockerComposeProject) ServiceNames() []types.ServiceConfig {
 	var rets []types.ServiceConfig
-	podName := dcpp.pod.Name
-	for _, ic := range dcpp.pod.Spec.InitContainers {
-		rets = append(rets, types.ServiceConfig{Name: makeContainerServiceName(podName, ic.Name)})
-	}
-	for _, c := range dcpp.pod.Spec.Containers {
-		rets = append(rets, types.ServiceConfig{Name: makeContainerServiceName(podName, c.Name)})
+	pod := dcpp.pod
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		rets = append(rets,
+			types.ServiceConfig{Name: makeContainerServiceName(pod.Namespace, pod.Name, c.Name)},
+			types.ServiceConfig{Name: legacyContainerServiceName(pod.Name, c.Name)},
+		)
 	}
 	return rets
 }
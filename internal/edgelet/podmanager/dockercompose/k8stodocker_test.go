@@ -0,0 +1,264 @@
+package dockercompose
+
+import (
+	"edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/podmanager/podutil"
+	"strconv"
+	"testing"
+
+	"github.com/compose-spec/compose-go/types"
+	moby "github.com/docker/docker/api/types"
+	mobycontainer "github.com/docker/docker/api/types/container"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_toResources(t *testing.T) {
+	tests := []struct {
+		name               string
+		resources          v1.ResourceRequirements
+		wantLimits         *types.Resource
+		wantReservations   *types.Resource
+		wantCPUShares      int64
+		wantMemReservation types.UnitBytes
+	}{
+		{
+			name: "no resources",
+		},
+		{
+			name: "whole cpu and binary memory limits",
+			resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("2"),
+					v1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			//没有requests时使用limits
+			wantLimits:         &types.Resource{NanoCPUs: "2000000000", MemoryBytes: 1 << 30},
+			wantReservations:   &types.Resource{NanoCPUs: "2000000000", MemoryBytes: 1 << 30},
+			wantCPUShares:      2048,
+			wantMemReservation: 1 << 30,
+		},
+		{
+			name: "millicore limits and requests",
+			resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("500m"),
+					v1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("250m"),
+					v1.ResourceMemory: resource.MustParse("128Mi"),
+				},
+			},
+			wantLimits:         &types.Resource{NanoCPUs: "500000000", MemoryBytes: 512 << 20},
+			wantReservations:   &types.Resource{NanoCPUs: "250000000", MemoryBytes: 128 << 20},
+			wantCPUShares:      256,
+			wantMemReservation: 128 << 20,
+		},
+		{
+			name: "requests only",
+			resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("1m"),
+					v1.ResourceMemory: resource.MustParse("64M"),
+				},
+			},
+			wantReservations:   &types.Resource{NanoCPUs: "1000000", MemoryBytes: 64000000},
+			wantCPUShares:      podutil.MinShares,
+			wantMemReservation: 64000000,
+		},
+		{
+			name: "fractional cpu and kibibytes",
+			resources: v1.ResourceRequirements{
+				Limits: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("1.5"),
+					v1.ResourceMemory: resource.MustParse("256Ki"),
+				},
+				Requests: v1.ResourceList{
+					v1.ResourceCPU: resource.MustParse("0.1"),
+				},
+			},
+			wantLimits:         &types.Resource{NanoCPUs: "1500000000", MemoryBytes: 256 << 10},
+			wantReservations:   &types.Resource{NanoCPUs: "100000000", MemoryBytes: 256 << 10},
+			wantCPUShares:      102,
+			wantMemReservation: 256 << 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Name: "app", Image: "nginx", Resources: tt.resources},
+					},
+				},
+			}
+			project, err := NewPodProject(config.DefaultConfig(), pod).Project()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(project.Services) != 1 {
+				t.Fatalf("got %d services, want 1", len(project.Services))
+			}
+			svc := project.Services[0]
+
+			var gotLimits, gotReservations *types.Resource
+			if svc.Deploy != nil {
+				gotLimits = svc.Deploy.Resources.Limits
+				gotReservations = svc.Deploy.Resources.Reservations
+			}
+			assertResource(t, "limits", gotLimits, tt.wantLimits)
+			assertResource(t, "reservations", gotReservations, tt.wantReservations)
+			if svc.CPUShares != tt.wantCPUShares {
+				t.Errorf("cpu_shares = %d, want %d", svc.CPUShares, tt.wantCPUShares)
+			}
+			if svc.MemReservation != tt.wantMemReservation {
+				t.Errorf("mem_reservation = %d, want %d", svc.MemReservation, tt.wantMemReservation)
+			}
+		})
+	}
+}
+
+func assertResource(t *testing.T, name string, got, want *types.Resource) {
+	t.Helper()
+	if want == nil {
+		if got != nil {
+			t.Errorf("%s = %+v, want nil", name, got)
+		}
+		return
+	}
+	if got == nil {
+		t.Fatalf("%s = nil, want %+v", name, want)
+	}
+	if got.NanoCPUs != want.NanoCPUs || got.MemoryBytes != want.MemoryBytes {
+		t.Errorf("%s = {cpus:%s memory:%d}, want {cpus:%s memory:%d}", name, got.NanoCPUs, got.MemoryBytes, want.NanoCPUs, want.MemoryBytes)
+	}
+}
+
+func Test_mobyResourcesToK8s(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name:  "app",
+				Image: "nginx",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse("500m"),
+						v1.ResourceMemory: resource.MustParse("512Mi"),
+					},
+					Requests: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse("250m"),
+						v1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+				},
+			}},
+		},
+	}
+	//模拟docker compose把service的资源配置写入HostConfig
+	project, err := NewPodProject(config.DefaultConfig(), pod).Project()
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := project.Services[0]
+	nanoCPUs, err := strconv.ParseInt(svc.Deploy.Resources.Limits.NanoCPUs, 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := mobycontainer.Resources{
+		NanoCPUs:          nanoCPUs,
+		Memory:            int64(svc.Deploy.Resources.Limits.MemoryBytes),
+		CPUShares:         svc.CPUShares,
+		MemoryReservation: int64(svc.MemReservation),
+	}
+	got := mobyResourcesToK8s(r)
+	for name, want := range pod.Spec.Containers[0].Resources.Limits {
+		if q := got.Limits[name]; q.Cmp(want) != 0 {
+			t.Errorf("limits[%s] = %s, want %s", name, q.String(), want.String())
+		}
+	}
+	for name, want := range pod.Spec.Containers[0].Resources.Requests {
+		if q := got.Requests[name]; q.Cmp(want) != 0 {
+			t.Errorf("requests[%s] = %s, want %s", name, q.String(), want.String())
+		}
+	}
+}
+
+func Test_setAppliedResources(t *testing.T) {
+	mobyContainer := func(name string, r mobycontainer.Resources) moby.ContainerJSON {
+		return moby.ContainerJSON{
+			ContainerJSONBase: &moby.ContainerJSONBase{HostConfig: &mobycontainer.HostConfig{Resources: r}},
+			Config: &mobycontainer.Config{Labels: map[string]string{
+				k8sNamespaceLabel:     "default",
+				k8sPodNameLabel:       "test",
+				k8sContainerNameLabel: name,
+			}},
+		}
+	}
+	tests := []struct {
+		name        string
+		containers  []moby.ContainerJSON
+		wantMessage string
+	}{
+		{
+			name:       "no limits",
+			containers: []moby.ContainerJSON{mobyContainer("app", mobycontainer.Resources{})},
+		},
+		{
+			name: "limits and requests",
+			containers: []moby.ContainerJSON{
+				mobyContainer("sidecar", mobycontainer.Resources{Memory: 64 << 20}),
+				mobyContainer("app", mobycontainer.Resources{NanoCPUs: 5e8, Memory: 256 << 20, CPUShares: 256}),
+			},
+			wantMessage: "app: limits cpu=500m memory=256Mi, requests cpu=250m; sidecar: limits memory=64Mi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{Status: v1.PodStatus{Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}}}
+			setAppliedResources(pod, tt.containers)
+			var got *v1.PodCondition
+			for i := range pod.Status.Conditions {
+				if pod.Status.Conditions[i].Type == resourcesAppliedCondition {
+					got = &pod.Status.Conditions[i]
+				}
+			}
+			if tt.wantMessage == "" {
+				if got != nil {
+					t.Errorf("condition = %+v, want none", got)
+				}
+				return
+			}
+			if got == nil || got.Status != v1.ConditionTrue || got.Message != tt.wantMessage {
+				t.Errorf("condition = %+v, want message %q", got, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_toRestartPolicy(t *testing.T) {
+	tests := []struct {
+		restartPolicy v1.RestartPolicy
+		isInit        bool
+		want          string
+	}{
+		{restartPolicy: v1.RestartPolicyAlways, want: types.RestartPolicyAlways},
+		{restartPolicy: v1.RestartPolicyOnFailure, want: types.RestartPolicyOnFailure},
+		{restartPolicy: v1.RestartPolicyNever, want: types.RestartPolicyNo},
+		{restartPolicy: "", want: types.RestartPolicyAlways},
+		//init-container成功完成后不再重启
+		{restartPolicy: v1.RestartPolicyAlways, isInit: true, want: types.RestartPolicyOnFailure},
+		{restartPolicy: v1.RestartPolicyOnFailure, isInit: true, want: types.RestartPolicyOnFailure},
+		{restartPolicy: v1.RestartPolicyNever, isInit: true, want: types.RestartPolicyNo},
+	}
+	for _, tt := range tests {
+		pod := &v1.Pod{Spec: v1.PodSpec{RestartPolicy: tt.restartPolicy}}
+		dcpp := &dockerComposeProject{pod: pod, config: config.DefaultConfig()}
+		if got := dcpp.toRestartPolicy(tt.isInit); got != tt.want {
+			t.Errorf("toRestartPolicy(%q, init=%v) = %q, want %q", tt.restartPolicy, tt.isInit, got, tt.want)
+		}
+	}
+}
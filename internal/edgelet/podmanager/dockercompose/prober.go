@@ -0,0 +1,527 @@
+package dockercompose
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type probeType string
+
+const (
+	liveness  probeType = "liveness"
+	readiness probeType = "readiness"
+	startup   probeType = "startup"
+)
+
+const (
+	defaultProbePeriodSeconds    = 10
+	defaultProbeTimeoutSeconds   = 1
+	defaultProbeSuccessThreshold = 1
+	defaultProbeFailureThreshold = 3
+	probeStopTimeout             = 10 * time.Second
+)
+
+//healthCheckProbe 选取可以转换为docker healthcheck的exec probe
+func healthCheckProbe(container v1.Container) *v1.Probe {
+	for _, probe := range []*v1.Probe{container.ReadinessProbe, container.LivenessProbe, container.StartupProbe} {
+		if probe != nil && probe.Exec != nil && len(probe.Exec.Command) > 0 {
+			return probe
+		}
+	}
+	return nil
+}
+
+//withProbeDefaults 与apiserver的默认值保持一致
+func withProbeDefaults(probe *v1.Probe) *v1.Probe {
+	p := probe.DeepCopy()
+	if p.PeriodSeconds <= 0 {
+		p.PeriodSeconds = defaultProbePeriodSeconds
+	}
+	if p.TimeoutSeconds <= 0 {
+		p.TimeoutSeconds = defaultProbeTimeoutSeconds
+	}
+	if p.SuccessThreshold <= 0 {
+		p.SuccessThreshold = defaultProbeSuccessThreshold
+	}
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = defaultProbeFailureThreshold
+	}
+	return p
+}
+
+type probeKey struct {
+	serviceName string
+	probeType   probeType
+}
+
+//prober 在edgelet内对容器执行liveness/readiness/startup探测
+type prober struct {
+	d       *dcpPodManager
+	mutex   sync.RWMutex
+	workers map[probeKey]*probeWorker
+}
+
+func newProber(d *dcpPodManager) *prober {
+	return &prober{
+		d:       d,
+		workers: map[probeKey]*probeWorker{},
+	}
+}
+
+//addPod 为pod内每个定义了probe的容器启动探测协程, spec未变化的探测保持不变
+func (p *prober) addPod(pod *v1.Pod) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	expected := map[probeKey]struct{}{}
+	for _, c := range pod.Spec.Containers {
+		serviceName := makeContainerServiceName(pod.Namespace, pod.Name, c.Name)
+		for pt, probe := range map[probeType]*v1.Probe{liveness: c.LivenessProbe, readiness: c.ReadinessProbe, startup: c.StartupProbe} {
+			if probe == nil {
+				continue
+			}
+			key := probeKey{serviceName: serviceName, probeType: pt}
+			expected[key] = struct{}{}
+			if w, ok := p.workers[key]; ok {
+				if reflect.DeepEqual(w.spec, withProbeDefaults(probe)) {
+					continue
+				}
+				w.stop()
+			}
+			w := newProbeWorker(p, pod, c, pt, probe)
+			p.workers[key] = w
+			go w.run()
+		}
+	}
+
+	for key, w := range p.workers {
+		if _, ok := expected[key]; ok {
+			continue
+		}
+		if w.pod.Namespace == pod.Namespace && w.pod.Name == pod.Name {
+			w.stop()
+			delete(p.workers, key)
+		}
+	}
+}
+
+func (p *prober) removePod(pod *v1.Pod) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, c := range pod.Spec.Containers {
+		serviceName := makeContainerServiceName(pod.Namespace, pod.Name, c.Name)
+		for _, pt := range []probeType{liveness, readiness, startup} {
+			key := probeKey{serviceName: serviceName, probeType: pt}
+			if w, ok := p.workers[key]; ok {
+				w.stop()
+				delete(p.workers, key)
+			}
+		}
+	}
+}
+
+//result 返回探测结果, 没有对应的探测协程时ok为false
+func (p *prober) result(serviceName string, pt probeType) (success bool, ok bool) {
+	p.mutex.RLock()
+	w, ok := p.workers[probeKey{serviceName: serviceName, probeType: pt}]
+	p.mutex.RUnlock()
+	if !ok {
+		return false, false
+	}
+	return w.getResult(), true
+}
+
+//updateContainerStatus 根据startup和readiness的探测结果设置容器的Started和Ready
+func (p *prober) updateContainerStatus(pod *v1.Pod, container v1.Container, status *v1.ContainerStatus) {
+	running := status.State.Running != nil
+	started := running
+	if running && container.StartupProbe != nil {
+		started, _ = p.result(makeContainerServiceName(pod.Namespace, pod.Name, container.Name), startup)
+	}
+	status.Started = &started
+
+	ready := started
+	if ready && container.ReadinessProbe != nil {
+		ready, _ = p.result(makeContainerServiceName(pod.Namespace, pod.Name, container.Name), readiness)
+	}
+	status.Ready = ready
+}
+
+type probeWorker struct {
+	prober      *prober
+	pod         *v1.Pod
+	container   v1.Container
+	serviceName string
+	probeType   probeType
+	spec        *v1.Probe
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+
+	mutex       sync.RWMutex
+	result      bool
+	containerID string
+	startedAt   string
+	successRun  int32
+	failureRun  int32
+	//startup探测成功后不再执行
+	finished bool
+	//已经记录的docker healthcheck结果的时间
+	lastHealthCheck time.Time
+	//探测失败后连续重启的次数, 用于计算back-off
+	restarts int
+}
+
+func newProbeWorker(p *prober, pod *v1.Pod, container v1.Container, pt probeType, probe *v1.Probe) *probeWorker {
+	w := &probeWorker{
+		prober:      p,
+		pod:         pod,
+		container:   container,
+		serviceName: makeContainerServiceName(pod.Namespace, pod.Name, container.Name),
+		probeType:   pt,
+		spec:        withProbeDefaults(probe),
+		stopCh:      make(chan struct{}),
+	}
+	w.resetResult()
+	return w
+}
+
+func (w *probeWorker) run() {
+	ticker := time.NewTicker(time.Duration(w.spec.PeriodSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		w.doProbe()
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *probeWorker) stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+func (w *probeWorker) getResult() bool {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	return w.result
+}
+
+//resetResult 容器(重新)启动后的初始结果与kubelet一致: readiness失败，liveness成功
+func (w *probeWorker) resetResult() {
+	w.result = w.probeType != readiness
+	if w.probeType == startup {
+		w.result = false
+	}
+	w.successRun = 0
+	w.failureRun = 0
+	w.finished = false
+	w.lastHealthCheck = time.Time{}
+}
+
+func (w *probeWorker) doProbe() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(w.spec.PeriodSeconds)*time.Second)
+	defer cancel()
+
+	inspect, err := w.prober.d.inspectContainer(ctx, w.pod.Namespace, w.pod.Name, w.container.Name)
+	if err != nil || inspect.State == nil || !inspect.State.Running {
+		w.mutex.Lock()
+		w.resetResult()
+		w.mutex.Unlock()
+		return
+	}
+
+	w.mutex.Lock()
+	if inspect.ID != w.containerID || inspect.State.StartedAt != w.startedAt {
+		w.containerID = inspect.ID
+		w.startedAt = inspect.State.StartedAt
+		w.resetResult()
+	}
+	finished := w.finished
+	w.mutex.Unlock()
+	if finished {
+		return
+	}
+
+	startedAt, _ := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+	if time.Since(startedAt) < time.Duration(w.spec.InitialDelaySeconds)*time.Second {
+		return
+	}
+	//startup探测成功之前不执行liveness和readiness探测
+	if w.probeType != startup && w.container.StartupProbe != nil {
+		if started, ok := w.prober.result(w.serviceName, startup); ok && !started {
+			return
+		}
+	}
+
+	var results []bool
+	if w.usesHealthCheck(inspect) {
+		results = w.healthCheckResults(inspect, startedAt.Add(time.Duration(w.spec.InitialDelaySeconds)*time.Second))
+	} else {
+		success, known := w.probe(ctx, inspect)
+		if !known {
+			return
+		}
+		results = []bool{success}
+	}
+
+	//liveness或startup探测失败需要停止容器
+	for _, success := range results {
+		if w.recordResult(success) {
+			w.restartContainer(inspect.ID, startedAt)
+			return
+		}
+	}
+}
+
+//usesHealthCheck 与healthcheck对应的exec probe使用docker的探测结果, 不在edgelet中再执行一次
+func (w *probeWorker) usesHealthCheck(inspect moby.ContainerJSON) bool {
+	if w.spec.Exec == nil || inspect.State.Health == nil {
+		return false
+	}
+	hc := healthCheckProbe(w.container)
+	return hc != nil && reflect.DeepEqual(withProbeDefaults(hc), w.spec)
+}
+
+//healthCheckResults 上一次之后docker healthcheck每一次执行的结果, 早于since的结果被忽略
+//docker按retries计算的健康状态不使用, 阈值只由recordResult计算一次
+func (w *probeWorker) healthCheckResults(inspect moby.ContainerJSON, since time.Time) []bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var results []bool
+	for _, r := range inspect.State.Health.Log {
+		if r == nil || r.Start.Before(since) || !r.Start.After(w.lastHealthCheck) {
+			continue
+		}
+		w.lastHealthCheck = r.Start
+		results = append(results, r.ExitCode == 0)
+	}
+	return results
+}
+
+//restartContainer 与kubelet一致: restartPolicy为Never时只停止容器, 其他情况按back-off重新启动
+//通过API停止的容器不会被docker的restart manager重启, 由edgelet启动, 等待时间与docker一致
+func (w *probeWorker) restartContainer(containerID string, startedAt time.Time) {
+	policy := w.pod.Spec.RestartPolicy
+	logrus.Warnf("%s probe of %s/%s failed %d times, stop it, restartPolicy=%s", w.probeType, w.pod.Namespace, w.serviceName, w.spec.FailureThreshold, policy)
+	cli := w.prober.d.dockerCli.Client()
+	timeout := probeStopTimeout
+	if err := cli.ContainerStop(context.Background(), containerID, &timeout); err != nil {
+		logrus.Errorf("stop container %s failed,err=%v", w.serviceName, err)
+		return
+	}
+	w.mutex.Lock()
+	w.resetResult()
+	restarts := w.nextRestart(time.Since(startedAt))
+	w.mutex.Unlock()
+	if policy == v1.RestartPolicyNever {
+		return
+	}
+	delay := backOff(restarts)
+	logrus.Infof("back-off %s restarting container %s", delay, w.serviceName)
+	select {
+	case <-w.stopCh:
+		return
+	case <-time.After(delay):
+	}
+	if err := cli.ContainerStart(context.Background(), containerID, moby.ContainerStartOptions{}); err != nil {
+		logrus.Errorf("start container %s failed,err=%v", w.serviceName, err)
+	}
+}
+
+//nextRestart 调用者需要持有mutex, 容器运行超过backOffResetRun后重新从initialBackOff开始
+func (w *probeWorker) nextRestart(run time.Duration) int {
+	if run >= backOffResetRun {
+		w.restarts = 0
+	}
+	w.restarts++
+	return w.restarts
+}
+
+//recordResult 记录一次探测结果, 连续成功或失败达到阈值后才改变结果, 返回true表示需要重启容器
+func (w *probeWorker) recordResult(success bool) (restart bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if success {
+		w.failureRun = 0
+		w.successRun++
+		if w.successRun >= w.spec.SuccessThreshold {
+			w.result = true
+			if w.probeType == startup {
+				w.finished = true
+			}
+		}
+		return false
+	}
+	w.successRun = 0
+	w.failureRun++
+	if w.failureRun < w.spec.FailureThreshold {
+		return false
+	}
+	w.result = false
+	return w.probeType == liveness || w.probeType == startup
+}
+
+//probe 执行一次探测, known为false表示结果未知(如无法获取pod的IP)
+func (w *probeWorker) probe(ctx context.Context, inspect moby.ContainerJSON) (success bool, known bool) {
+	timeout := time.Duration(w.spec.TimeoutSeconds) * time.Second
+	switch {
+	case w.spec.Exec != nil:
+		return w.execProbe(ctx, inspect.ID, timeout), true
+	case w.spec.HTTPGet != nil:
+		host, err := w.prober.d.podIP(ctx, w.pod)
+		if err != nil {
+			logrus.Warnf("get pod %s ip failed,err=%v", w.pod.Name, err)
+			return false, false
+		}
+		return w.httpProbe(host, timeout), true
+	case w.spec.TCPSocket != nil:
+		host, err := w.prober.d.podIP(ctx, w.pod)
+		if err != nil {
+			logrus.Warnf("get pod %s ip failed,err=%v", w.pod.Name, err)
+			return false, false
+		}
+		return w.tcpProbe(host, timeout), true
+	}
+	logrus.Warnf("%s probe of %s has no handler", w.probeType, w.serviceName)
+	return false, false
+}
+
+func (w *probeWorker) execProbe(ctx context.Context, containerID string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cli := w.prober.d.dockerCli.Client()
+	execResp, err := cli.ContainerExecCreate(ctx, containerID, moby.ExecConfig{Cmd: w.spec.Exec.Command})
+	if err != nil {
+		logrus.Warnf("%s probe of %s create exec failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	if err := cli.ContainerExecStart(ctx, execResp.ID, moby.ExecStartCheck{}); err != nil {
+		logrus.Warnf("%s probe of %s start exec failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	for {
+		inspect, err := cli.ContainerExecInspect(ctx, execResp.ID)
+		if err != nil {
+			return false
+		}
+		if !inspect.Running {
+			return inspect.ExitCode == 0
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (w *probeWorker) httpProbe(podIP string, timeout time.Duration) bool {
+	action := w.spec.HTTPGet
+	host := action.Host
+	if host == "" {
+		host = podIP
+	}
+	port, err := resolveProbePort(action.Port, w.container)
+	if err != nil {
+		logrus.Warnf("%s probe of %s failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	scheme := strings.ToLower(string(action.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+	u := &url.URL{
+		Scheme: scheme,
+		Host:   net.JoinHostPort(host, strconv.Itoa(port)),
+	}
+	if ref, err := url.Parse(action.Path); err == nil {
+		u = u.ResolveReference(ref)
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+	for _, h := range action.HTTPHeaders {
+		if strings.EqualFold(h.Name, "host") {
+			req.Host = h.Value
+			continue
+		}
+		req.Header.Add(h.Name, h.Value)
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "edgelet/probe")
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Debugf("%s probe of %s failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusBadRequest
+}
+
+func (w *probeWorker) tcpProbe(podIP string, timeout time.Duration) bool {
+	host := w.spec.TCPSocket.Host
+	if host == "" {
+		host = podIP
+	}
+	port, err := resolveProbePort(w.spec.TCPSocket.Port, w.container)
+	if err != nil {
+		logrus.Warnf("%s probe of %s failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), timeout)
+	if err != nil {
+		logrus.Debugf("%s probe of %s failed,err=%v", w.probeType, w.serviceName, err)
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+//resolveProbePort 解析数字端口或者容器中命名的端口
+func resolveProbePort(port intstr.IntOrString, container v1.Container) (int, error) {
+	if port.Type == intstr.Int {
+		if port.IntValue() <= 0 || port.IntValue() > 65535 {
+			return 0, fmt.Errorf("invalid port number: %d", port.IntValue())
+		}
+		return port.IntValue(), nil
+	}
+	for _, p := range container.Ports {
+		if p.Name == port.StrVal {
+			return int(p.ContainerPort), nil
+		}
+	}
+	if n, err := strconv.Atoi(port.StrVal); err == nil {
+		if n <= 0 || n > 65535 {
+			return 0, fmt.Errorf("invalid port number: %d", n)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("couldn't find port %q in container %s", port.StrVal, container.Name)
+}
@@ -0,0 +1,444 @@
+package dockercompose
+
+import (
+	"edge/internal/edgelet/podmanager/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/compose-spec/compose-go/types"
+	moby "github.com/docker/docker/api/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func execProbe(command ...string) *v1.Probe {
+	return &v1.Probe{Handler: v1.Handler{Exec: &v1.ExecAction{Command: command}}}
+}
+
+func Test_withProbeDefaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe *v1.Probe
+		want  *v1.Probe
+	}{
+		{
+			name:  "defaults",
+			probe: execProbe("true"),
+			want: &v1.Probe{
+				Handler:          v1.Handler{Exec: &v1.ExecAction{Command: []string{"true"}}},
+				PeriodSeconds:    10,
+				TimeoutSeconds:   1,
+				SuccessThreshold: 1,
+				FailureThreshold: 3,
+			},
+		},
+		{
+			name: "keep values",
+			probe: &v1.Probe{
+				Handler:             v1.Handler{Exec: &v1.ExecAction{Command: []string{"true"}}},
+				InitialDelaySeconds: 5,
+				PeriodSeconds:       2,
+				TimeoutSeconds:      3,
+				SuccessThreshold:    2,
+				FailureThreshold:    5,
+			},
+			want: &v1.Probe{
+				Handler:             v1.Handler{Exec: &v1.ExecAction{Command: []string{"true"}}},
+				InitialDelaySeconds: 5,
+				PeriodSeconds:       2,
+				TimeoutSeconds:      3,
+				SuccessThreshold:    2,
+				FailureThreshold:    5,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := tt.probe.DeepCopy()
+			if got := withProbeDefaults(tt.probe); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withProbeDefaults() = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.probe, original) {
+				t.Error("withProbeDefaults() modified the probe")
+			}
+		})
+	}
+}
+
+func Test_toHealthCheck(t *testing.T) {
+	duration := func(d time.Duration) *types.Duration {
+		v := types.Duration(d)
+		return &v
+	}
+	retries := func(n uint64) *uint64 {
+		return &n
+	}
+	httpProbe := &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(80)}}}
+	tests := []struct {
+		name      string
+		container v1.Container
+		want      *types.HealthCheckConfig
+	}{
+		{name: "no probe", container: v1.Container{}},
+		{name: "http probe only", container: v1.Container{LivenessProbe: httpProbe}},
+		{
+			name: "exec liveness with defaults",
+			container: v1.Container{
+				LivenessProbe: execProbe("cat", "/tmp/healthy"),
+			},
+			want: &types.HealthCheckConfig{
+				Test:        types.HealthCheckTest{"CMD", "cat", "/tmp/healthy"},
+				Timeout:     duration(time.Second),
+				Interval:    duration(10 * time.Second),
+				StartPeriod: duration(0),
+				Retries:     retries(3),
+			},
+		},
+		{
+			name: "readiness preferred",
+			container: v1.Container{
+				LivenessProbe: execProbe("live"),
+				ReadinessProbe: &v1.Probe{
+					Handler:             v1.Handler{Exec: &v1.ExecAction{Command: []string{"ready"}}},
+					InitialDelaySeconds: 15,
+					PeriodSeconds:       5,
+					TimeoutSeconds:      2,
+					FailureThreshold:    6,
+				},
+			},
+			want: &types.HealthCheckConfig{
+				Test:        types.HealthCheckTest{"CMD", "ready"},
+				Timeout:     duration(2 * time.Second),
+				Interval:    duration(5 * time.Second),
+				StartPeriod: duration(15 * time.Second),
+				Retries:     retries(6),
+			},
+		},
+		{
+			name: "http readiness and exec startup",
+			container: v1.Container{
+				ReadinessProbe: httpProbe,
+				StartupProbe:   execProbe("started"),
+			},
+			want: &types.HealthCheckConfig{
+				Test:        types.HealthCheckTest{"CMD", "started"},
+				Timeout:     duration(time.Second),
+				Interval:    duration(10 * time.Second),
+				StartPeriod: duration(0),
+				Retries:     retries(3),
+			},
+		},
+	}
+	dcpp := &dockerComposeProject{pod: &v1.Pod{}, config: config.DefaultConfig()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dcpp.toHealthCheck(tt.container); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toHealthCheck() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveProbePort(t *testing.T) {
+	container := v1.Container{
+		Name:  "app",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+	}
+	tests := []struct {
+		name    string
+		port    intstr.IntOrString
+		want    int
+		wantErr bool
+	}{
+		{name: "number", port: intstr.FromInt(80), want: 80},
+		{name: "named", port: intstr.FromString("http"), want: 8080},
+		{name: "number string", port: intstr.FromString("9090"), want: 9090},
+		{name: "zero", port: intstr.FromInt(0), wantErr: true},
+		{name: "too large", port: intstr.FromInt(70000), wantErr: true},
+		{name: "too large string", port: intstr.FromString("70000"), wantErr: true},
+		{name: "unknown name", port: intstr.FromString("grpc"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveProbePort(tt.port, container)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveProbePort() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveProbePort() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestProbeWorker(pt probeType, probe *v1.Probe) *probeWorker {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	return newProbeWorker(newProber(nil), pod, v1.Container{Name: "app"}, pt, probe)
+}
+
+//每一步是一次探测的结果, 以及之后的探测结果和是否需要重启
+func Test_probeWorker_recordResult(t *testing.T) {
+	type step struct {
+		success     bool
+		wantResult  bool
+		wantRestart bool
+	}
+	tests := []struct {
+		name      string
+		probeType probeType
+		probe     *v1.Probe
+		initial   bool
+		steps     []step
+	}{
+		{
+			name:      "readiness success threshold",
+			probeType: readiness,
+			probe:     &v1.Probe{SuccessThreshold: 2, FailureThreshold: 2},
+			initial:   false,
+			steps: []step{
+				{success: true, wantResult: false},
+				{success: false, wantResult: false},
+				{success: true, wantResult: false},
+				{success: true, wantResult: true},
+				{success: false, wantResult: true},
+				{success: false, wantResult: false},
+			},
+		},
+		{
+			name:      "liveness restarts after failure threshold",
+			probeType: liveness,
+			probe:     &v1.Probe{FailureThreshold: 3},
+			initial:   true,
+			steps: []step{
+				{success: false, wantResult: true},
+				{success: false, wantResult: true},
+				{success: true, wantResult: true},
+				{success: false, wantResult: true},
+				{success: false, wantResult: true},
+				{success: false, wantResult: false, wantRestart: true},
+			},
+		},
+		{
+			name:      "readiness never restarts",
+			probeType: readiness,
+			probe:     &v1.Probe{FailureThreshold: 1},
+			initial:   false,
+			steps: []step{
+				{success: true, wantResult: true},
+				{success: false, wantResult: false},
+				{success: false, wantResult: false},
+			},
+		},
+		{
+			name:      "startup finishes after success",
+			probeType: startup,
+			probe:     &v1.Probe{FailureThreshold: 2},
+			initial:   false,
+			steps: []step{
+				{success: false, wantResult: false},
+				{success: true, wantResult: true},
+			},
+		},
+		{
+			name:      "startup restarts after failure threshold",
+			probeType: startup,
+			probe:     &v1.Probe{FailureThreshold: 2},
+			initial:   false,
+			steps: []step{
+				{success: false, wantResult: false},
+				{success: false, wantResult: false, wantRestart: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestProbeWorker(tt.probeType, tt.probe)
+			if w.getResult() != tt.initial {
+				t.Fatalf("initial result = %v, want %v", w.getResult(), tt.initial)
+			}
+			for i, s := range tt.steps {
+				restart := w.recordResult(s.success)
+				if restart != s.wantRestart {
+					t.Errorf("step %d restart = %v, want %v", i, restart, s.wantRestart)
+				}
+				if got := w.getResult(); got != s.wantResult {
+					t.Errorf("step %d result = %v, want %v", i, got, s.wantResult)
+				}
+			}
+			if tt.probeType == startup && w.getResult() && !w.finished {
+				t.Error("startup probe is not finished after success")
+			}
+		})
+	}
+}
+
+//与healthcheck对应的exec probe使用docker每一次的执行结果, 失败次数只按FailureThreshold计算一次
+func Test_probeWorker_healthCheckResults(t *testing.T) {
+	probe := execProbe("cat", "/tmp/healthy")
+	probe.FailureThreshold = 3
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	container := v1.Container{Name: "app", LivenessProbe: probe}
+	w := newProbeWorker(newProber(nil), pod, container, liveness, probe)
+
+	startedAt := time.Now().Add(-time.Minute)
+	result := func(offset time.Duration, exitCode int) *moby.HealthcheckResult {
+		return &moby.HealthcheckResult{Start: startedAt.Add(offset), ExitCode: exitCode}
+	}
+	health := &moby.Health{Status: moby.Unhealthy}
+	inspect := moby.ContainerJSON{ContainerJSONBase: &moby.ContainerJSONBase{State: &moby.ContainerState{Running: true, Health: health}}}
+	if !w.usesHealthCheck(inspect) {
+		t.Fatal("usesHealthCheck() = false")
+	}
+
+	//启动之前的结果被忽略
+	health.Log = []*moby.HealthcheckResult{result(-time.Second, 1), result(10*time.Second, 1), result(20*time.Second, 1)}
+	if got := w.healthCheckResults(inspect, startedAt); !reflect.DeepEqual(got, []bool{false, false}) {
+		t.Fatalf("healthCheckResults() = %v, want [false false]", got)
+	}
+	//docker已经是unhealthy, 但是只失败了两次
+	for _, success := range []bool{false, false} {
+		if w.recordResult(success) {
+			t.Fatal("restart before failure threshold")
+		}
+	}
+	if got := w.healthCheckResults(inspect, startedAt); len(got) != 0 {
+		t.Fatalf("healthCheckResults() again = %v, want none", got)
+	}
+	health.Log = append(health.Log, result(30*time.Second, 1))
+	got := w.healthCheckResults(inspect, startedAt)
+	if !reflect.DeepEqual(got, []bool{false}) {
+		t.Fatalf("healthCheckResults() after new result = %v, want [false]", got)
+	}
+	if !w.recordResult(got[0]) {
+		t.Error("no restart after failure threshold")
+	}
+
+	//不是healthcheck对应的probe自己执行
+	other := newProbeWorker(newProber(nil), pod, container, readiness, execProbe("ready"))
+	if other.usesHealthCheck(inspect) {
+		t.Error("usesHealthCheck() of another probe = true")
+	}
+}
+
+func Test_probeWorker_nextRestart(t *testing.T) {
+	w := newTestProbeWorker(liveness, execProbe("true"))
+	steps := []struct {
+		run  time.Duration
+		want time.Duration
+	}{
+		{time.Second, initialBackOff},
+		{time.Second, 2 * initialBackOff},
+		{time.Second, 4 * initialBackOff},
+		//运行足够长的时间后重新开始
+		{backOffResetRun, initialBackOff},
+		{time.Second, 2 * initialBackOff},
+	}
+	for i, s := range steps {
+		if got := backOff(w.nextRestart(s.run)); got != s.want {
+			t.Errorf("step %d back-off = %s, want %s", i, got, s.want)
+		}
+	}
+	if got := backOff(100); got != maxBackOff {
+		t.Errorf("backOff(100) = %s, want %s", got, maxBackOff)
+	}
+}
+
+func Test_prober_updateContainerStatus(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	container := v1.Container{Name: "app", ReadinessProbe: execProbe("ready"), StartupProbe: execProbe("started")}
+	serviceName := makeContainerServiceName(pod.Namespace, pod.Name, container.Name)
+	running := v1.ContainerStatus{State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}}
+	tests := []struct {
+		name        string
+		status      v1.ContainerStatus
+		started     bool
+		ready       bool
+		wantStarted bool
+		wantReady   bool
+	}{
+		{name: "not running", status: v1.ContainerStatus{State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{}}}, started: true, ready: true},
+		{name: "not started", status: running, started: false, ready: true},
+		{name: "started not ready", status: running, started: true, ready: false, wantStarted: true},
+		{name: "ready", status: running, started: true, ready: true, wantStarted: true, wantReady: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProber(nil)
+			p.workers[probeKey{serviceName: serviceName, probeType: startup}] = &probeWorker{result: tt.started}
+			p.workers[probeKey{serviceName: serviceName, probeType: readiness}] = &probeWorker{result: tt.ready}
+			status := tt.status
+			p.updateContainerStatus(pod, container, &status)
+			if *status.Started != tt.wantStarted || status.Ready != tt.wantReady {
+				t.Errorf("started = %v ready = %v, want %v %v", *status.Started, status.Ready, tt.wantStarted, tt.wantReady)
+			}
+		})
+	}
+}
+
+func Test_probeWorker_httpProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Check") != "edge" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case "/redirect":
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	host, portStr, _ := net.SplitHostPort(u.Host)
+	port, _ := strconv.Atoi(portStr)
+
+	tests := []struct {
+		name    string
+		path    string
+		headers []v1.HTTPHeader
+		want    bool
+	}{
+		{name: "ok", path: "/healthz", headers: []v1.HTTPHeader{{Name: "X-Check", Value: "edge"}}, want: true},
+		{name: "3xx", path: "/redirect", headers: []v1.HTTPHeader{{Name: "X-Check", Value: "edge"}}, want: true},
+		{name: "5xx", path: "/broken", headers: []v1.HTTPHeader{{Name: "X-Check", Value: "edge"}}, want: false},
+		{name: "4xx", path: "/healthz", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newTestProbeWorker(readiness, &v1.Probe{Handler: v1.Handler{HTTPGet: &v1.HTTPGetAction{
+				Path:        tt.path,
+				Port:        intstr.FromInt(port),
+				HTTPHeaders: tt.headers,
+			}}})
+			if got := w.httpProbe(host, time.Second); got != tt.want {
+				t.Errorf("httpProbe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_probeWorker_tcpProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	w := newTestProbeWorker(liveness, &v1.Probe{Handler: v1.Handler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(port)}}})
+	if !w.tcpProbe("127.0.0.1", time.Second) {
+		t.Error("tcpProbe() of listening port = false")
+	}
+	l.Close()
+	if w.tcpProbe("127.0.0.1", time.Second) {
+		t.Error("tcpProbe() of closed port = true")
+	}
+}
@@ -0,0 +1,78 @@
+package dockercompose
+
+import (
+	"context"
+	"edge/internal/edgelet/podmanager/podutil"
+	"edge/pkg/errdefs"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const recreateInterval = time.Minute
+
+//recreateMissingPods 定期检查store中的pod, 容器被删除(例如手动docker rm或者docker system prune)后按保存的spec重新创建
+func (d *dcpPodManager) recreateMissingPods() {
+	ticker := time.NewTicker(recreateInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		for _, pod := range d.store.List() {
+			if err := d.recreateMissing(ctx, pod.Namespace, pod.Name); err != nil {
+				logrus.Errorf("recreate missing containers of pod %s/%s failed,err=%v", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+}
+
+//recreateMissing 持有podMutex后重新读取store, 避免重建刚被删除的pod
+func (d *dcpPodManager) recreateMissing(ctx context.Context, namespace, name string) error {
+	d.podMutex.Lock()
+	defer d.podMutex.Unlock()
+	pod, err := d.store.Get(namespace, name)
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	//运行结束的pod的容器可能是被cleanupCompletedPods删除的, 没有成功创建过的pod等待下一次更新
+	if podutil.IsTerminated(pod) || d.hasWaiting(pod) || !everStarted(pod) {
+		return nil
+	}
+	f := getDefaultFilters(d.Project)
+	f = append(f, namespaceFilter(pod.Namespace), podnameFilter(pod.Name))
+	containers, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+		Filters: filters.NewArgs(f...),
+		All:     true,
+	})
+	if err != nil {
+		return err
+	}
+	missing := missingContainers(pod, containers)
+	if len(missing) == 0 {
+		return nil
+	}
+	logrus.Warnf("containers %v of pod %s/%s are missing, recreate them", missing, pod.Namespace, pod.Name)
+	_, err = d.upPod(ctx, pod)
+	return err
+}
+
+//missingContainers spec中有但是docker中没有容器的容器名称
+func missingContainers(pod *v1.Pod, containers []moby.Container) []string {
+	existing := make(map[string]struct{})
+	for _, c := range containers {
+		_, _, containerName := containerIdentity(c.Labels)
+		existing[containerName] = struct{}{}
+	}
+	var missing []string
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if _, ok := existing[c.Name]; !ok {
+			missing = append(missing, c.Name)
+		}
+	}
+	return missing
+}
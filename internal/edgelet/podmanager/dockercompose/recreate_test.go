@@ -0,0 +1,52 @@
+package dockercompose
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/compose/v2/pkg/api"
+	moby "github.com/docker/docker/api/types"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_missingContainers(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "app"}, {Name: "sidecar"}},
+		},
+	}
+	container := func(name string) moby.Container {
+		return moby.Container{Labels: map[string]string{
+			k8sNamespaceLabel:     "default",
+			k8sPodNameLabel:       "web",
+			k8sContainerNameLabel: name,
+		}}
+	}
+	tests := []struct {
+		name       string
+		containers []moby.Container
+		want       []string
+	}{
+		{name: "all present", containers: []moby.Container{container("init"), container("app"), container("sidecar")}},
+		{name: "all missing", want: []string{"init", "app", "sidecar"}},
+		{name: "one missing", containers: []moby.Container{container("init"), container("app")}, want: []string{"sidecar"}},
+		{
+			name: "legacy service name",
+			containers: []moby.Container{
+				{Labels: map[string]string{k8sNamespaceLabel: "default", k8sPodNameLabel: "web", api.ServiceLabel: legacyContainerServiceName("web", "init")}},
+				container("app"),
+				container("sidecar"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingContainers(pod, tt.containers); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("missingContainers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
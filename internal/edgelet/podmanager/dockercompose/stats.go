@@ -0,0 +1,249 @@
+package dockercompose
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+//docker stats采集到的单个容器的数据
+type containerStatsInfo struct {
+	container moby.Container
+	inspect   moby.ContainerJSON
+	stats     moby.StatsJSON
+}
+
+//GetPodStats 获取所有k8s下发的运行中容器的stats，并按pod分组
+func (d *dcpPodManager) GetPodStats(ctx context.Context) ([]stats.PodStats, error) {
+	containers, err := d.dockerCli.Client().ContainerList(ctx, moby.ContainerListOptions{
+		Filters: filters.NewArgs(getDefaultFilters(d.Project)...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//stream=false时docker会采样两次用于计算cpu使用率，大约耗时1s，所以并发采集
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		results []containerStatsInfo
+	)
+	for _, c := range containers {
+		wg.Add(1)
+		go func(c moby.Container) {
+			defer wg.Done()
+			info, err := d.containerStats(ctx, c)
+			if err != nil {
+				logrus.Warnf("get container %s stats failed,err=%v", c.ID, err)
+				return
+			}
+			mutex.Lock()
+			results = append(results, info)
+			mutex.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	return groupPodStats(results), nil
+}
+
+func (d *dcpPodManager) containerStats(ctx context.Context, c moby.Container) (containerStatsInfo, error) {
+	info := containerStatsInfo{container: c}
+	resp, err := d.dockerCli.Client().ContainerStats(ctx, c.ID, false)
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&info.stats); err != nil {
+		return info, err
+	}
+	inspect, _, err := d.dockerCli.Client().ContainerInspectWithRaw(ctx, c.ID, true)
+	if err != nil {
+		return info, err
+	}
+	info.inspect = inspect
+	return info, nil
+}
+
+func groupPodStats(infos []containerStatsInfo) []stats.PodStats {
+	podInfos := make(map[string][]containerStatsInfo)
+	for _, info := range infos {
+		namespace, podName, _ := containerIdentity(info.container.Labels)
+		if podName == "" {
+			continue
+		}
+		key := namespace + "/" + podName
+		podInfos[key] = append(podInfos[key], info)
+	}
+
+	keys := make([]string, 0, len(podInfos))
+	for key := range podInfos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	podStats := make([]stats.PodStats, 0, len(keys))
+	for _, key := range keys {
+		podStats = append(podStats, toPodStats(podInfos[key]))
+	}
+	return podStats
+}
+
+func toPodStats(infos []containerStatsInfo) stats.PodStats {
+	ps := stats.PodStats{}
+	var (
+		cpuNanoCores, cpuNanoSeconds            uint64
+		memUsage, memWorkingSet, memRSS, faults uint64
+		rootfsUsed                              uint64
+	)
+	now := metav1.Now()
+	for _, info := range infos {
+		if ps.PodRef.Name == "" {
+			ps.PodRef = podReference(info.container.Labels)
+		}
+		cs := toContainerStats(info)
+		if ps.StartTime.IsZero() || cs.StartTime.Before(&ps.StartTime) {
+			ps.StartTime = cs.StartTime
+		}
+		cpuNanoCores += *cs.CPU.UsageNanoCores
+		cpuNanoSeconds += *cs.CPU.UsageCoreNanoSeconds
+		memUsage += *cs.Memory.UsageBytes
+		memWorkingSet += *cs.Memory.WorkingSetBytes
+		memRSS += *cs.Memory.RSSBytes
+		faults += *cs.Memory.PageFaults
+		rootfsUsed += *cs.Rootfs.UsedBytes
+		ps.Containers = append(ps.Containers, cs)
+
+		//pod内容器共享第一个容器的网络，只有它的网络数据是有效的
+		if ps.Network == nil && len(info.stats.Networks) > 0 {
+			ps.Network = toNetworkStats(now, info.stats.Networks)
+		}
+	}
+	sort.Slice(ps.Containers, func(i, j int) bool { return ps.Containers[i].Name < ps.Containers[j].Name })
+	ps.CPU = &stats.CPUStats{
+		Time:                 now,
+		UsageNanoCores:       &cpuNanoCores,
+		UsageCoreNanoSeconds: &cpuNanoSeconds,
+	}
+	ps.Memory = &stats.MemoryStats{
+		Time:            now,
+		UsageBytes:      &memUsage,
+		WorkingSetBytes: &memWorkingSet,
+		RSSBytes:        &memRSS,
+		PageFaults:      &faults,
+	}
+	ps.EphemeralStorage = &stats.FsStats{
+		Time:      now,
+		UsedBytes: &rootfsUsed,
+	}
+	return ps
+}
+
+func podReference(labels map[string]string) stats.PodReference {
+	ref := stats.PodReference{
+		Name:      labels[k8sPodNameLabel],
+		Namespace: labels[k8sNamespaceLabel],
+	}
+	ref.UID = labels[k8sPodUIDLabel]
+	if ref.UID == "" {
+		pod := v1.Pod{}
+		if err := json.Unmarshal([]byte(labels[k8sPodInfoLabel]), &pod); err == nil {
+			ref.UID = string(pod.UID)
+		}
+	}
+	return ref
+}
+
+func toContainerStats(info containerStatsInfo) stats.ContainerStats {
+	s := info.stats
+	_, _, containerName := containerIdentity(info.container.Labels)
+	startTime, _ := time.Parse(time.RFC3339Nano, info.inspect.State.StartedAt)
+	now := metav1.NewTime(s.Read)
+
+	cpuNanoSeconds := s.CPUStats.CPUUsage.TotalUsage
+	var cpuNanoCores uint64
+	if interval := s.Read.Sub(s.PreRead); interval > 0 && cpuNanoSeconds > s.PreCPUStats.CPUUsage.TotalUsage {
+		cpuNanoCores = uint64(float64(cpuNanoSeconds-s.PreCPUStats.CPUUsage.TotalUsage) / interval.Seconds())
+	}
+
+	memUsage := s.MemoryStats.Usage
+	memWorkingSet := memUsage
+	//cgroup v1 为total_inactive_file, cgroup v2 为inactive_file
+	if inactive, ok := s.MemoryStats.Stats["total_inactive_file"]; ok && inactive < memWorkingSet {
+		memWorkingSet -= inactive
+	} else if inactive, ok := s.MemoryStats.Stats["inactive_file"]; ok && inactive < memWorkingSet {
+		memWorkingSet -= inactive
+	}
+	memRSS := s.MemoryStats.Stats["rss"]
+	if memRSS == 0 {
+		memRSS = s.MemoryStats.Stats["anon"]
+	}
+	pageFaults := s.MemoryStats.Stats["pgfault"]
+	majorPageFaults := s.MemoryStats.Stats["pgmajfault"]
+
+	cs := stats.ContainerStats{
+		Name:      containerName,
+		StartTime: metav1.NewTime(startTime),
+		CPU: &stats.CPUStats{
+			Time:                 now,
+			UsageNanoCores:       &cpuNanoCores,
+			UsageCoreNanoSeconds: &cpuNanoSeconds,
+		},
+		Memory: &stats.MemoryStats{
+			Time:            now,
+			UsageBytes:      &memUsage,
+			WorkingSetBytes: &memWorkingSet,
+			RSSBytes:        &memRSS,
+			PageFaults:      &pageFaults,
+			MajorPageFaults: &majorPageFaults,
+		},
+	}
+	if s.MemoryStats.Limit > memWorkingSet {
+		available := s.MemoryStats.Limit - memWorkingSet
+		cs.Memory.AvailableBytes = &available
+	}
+
+	var rootfsUsed uint64
+	if info.inspect.SizeRw != nil {
+		rootfsUsed = uint64(*info.inspect.SizeRw)
+	}
+	cs.Rootfs = &stats.FsStats{
+		Time:      now,
+		UsedBytes: &rootfsUsed,
+	}
+	return cs
+}
+
+func toNetworkStats(now metav1.Time, networks map[string]moby.NetworkStats) *stats.NetworkStats {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ns := &stats.NetworkStats{Time: now}
+	for _, name := range names {
+		n := networks[name]
+		rxBytes, rxErrors, txBytes, txErrors := n.RxBytes, n.RxErrors, n.TxBytes, n.TxErrors
+		ns.Interfaces = append(ns.Interfaces, stats.InterfaceStats{
+			Name:     name,
+			RxBytes:  &rxBytes,
+			RxErrors: &rxErrors,
+			TxBytes:  &txBytes,
+			TxErrors: &txErrors,
+		})
+	}
+	//kubelet用默认网卡作为pod的网络数据
+	if len(ns.Interfaces) > 0 {
+		ns.InterfaceStats = ns.Interfaces[0]
+	}
+	return ns
+}
@@ -0,0 +1,228 @@
+package dockercompose
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/compose/v2/pkg/api"
+	moby "github.com/docker/docker/api/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+var statsRead = time.Date(2022, 6, 1, 10, 0, 2, 0, time.UTC)
+
+//statsInfo 两次采样间隔2s, cpu使用了cpuDelta纳秒
+func statsInfo(namespace, podName, containerName string, cpuTotal, cpuDelta uint64, memStats map[string]uint64) containerStatsInfo {
+	info := containerStatsInfo{
+		container: moby.Container{Labels: map[string]string{
+			k8sNamespaceLabel:     namespace,
+			k8sPodNameLabel:       podName,
+			k8sContainerNameLabel: containerName,
+			k8sPodUIDLabel:        podName + "-uid",
+		}},
+		inspect: moby.ContainerJSON{ContainerJSONBase: &moby.ContainerJSONBase{State: &moby.ContainerState{StartedAt: testTime}}},
+	}
+	info.stats.Read = statsRead
+	info.stats.PreRead = statsRead.Add(-2 * time.Second)
+	info.stats.CPUStats.CPUUsage.TotalUsage = cpuTotal
+	info.stats.PreCPUStats.CPUUsage.TotalUsage = cpuTotal - cpuDelta
+	info.stats.MemoryStats.Usage = 100 << 20
+	info.stats.MemoryStats.Limit = 200 << 20
+	info.stats.MemoryStats.Stats = memStats
+	return info
+}
+
+func uint64Ptr(v uint64) *uint64 {
+	return &v
+}
+
+func Test_toContainerStats(t *testing.T) {
+	started, _ := time.Parse(time.RFC3339Nano, testTime)
+	sizeRw := int64(4096)
+	tests := []struct {
+		name   string
+		info   containerStatsInfo
+		modify func(info *containerStatsInfo)
+		want   stats.ContainerStats
+	}{
+		{
+			name: "cgroup v1",
+			info: statsInfo("default", "web", "nginx", 5e9, 1e9, map[string]uint64{"total_inactive_file": 20 << 20, "rss": 50 << 20, "pgfault": 10, "pgmajfault": 1}),
+			modify: func(info *containerStatsInfo) {
+				info.inspect.SizeRw = &sizeRw
+			},
+			want: stats.ContainerStats{
+				Name:      "nginx",
+				StartTime: metav1.NewTime(started),
+				CPU:       &stats.CPUStats{Time: metav1.NewTime(statsRead), UsageNanoCores: uint64Ptr(5e8), UsageCoreNanoSeconds: uint64Ptr(5e9)},
+				Memory: &stats.MemoryStats{
+					Time:            metav1.NewTime(statsRead),
+					AvailableBytes:  uint64Ptr(120 << 20),
+					UsageBytes:      uint64Ptr(100 << 20),
+					WorkingSetBytes: uint64Ptr(80 << 20),
+					RSSBytes:        uint64Ptr(50 << 20),
+					PageFaults:      uint64Ptr(10),
+					MajorPageFaults: uint64Ptr(1),
+				},
+				Rootfs: &stats.FsStats{Time: metav1.NewTime(statsRead), UsedBytes: uint64Ptr(4096)},
+			},
+		},
+		{
+			name: "cgroup v2",
+			info: statsInfo("default", "web", "nginx", 5e9, 4e9, map[string]uint64{"inactive_file": 10 << 20, "anon": 30 << 20}),
+			want: stats.ContainerStats{
+				Name:      "nginx",
+				StartTime: metav1.NewTime(started),
+				CPU:       &stats.CPUStats{Time: metav1.NewTime(statsRead), UsageNanoCores: uint64Ptr(2e9), UsageCoreNanoSeconds: uint64Ptr(5e9)},
+				Memory: &stats.MemoryStats{
+					Time:            metav1.NewTime(statsRead),
+					AvailableBytes:  uint64Ptr(110 << 20),
+					UsageBytes:      uint64Ptr(100 << 20),
+					WorkingSetBytes: uint64Ptr(90 << 20),
+					RSSBytes:        uint64Ptr(30 << 20),
+					PageFaults:      uint64Ptr(0),
+					MajorPageFaults: uint64Ptr(0),
+				},
+				Rootfs: &stats.FsStats{Time: metav1.NewTime(statsRead), UsedBytes: uint64Ptr(0)},
+			},
+		},
+		{
+			name: "without previous sample and limit",
+			info: statsInfo("default", "web", "nginx", 5e9, 0, nil),
+			modify: func(info *containerStatsInfo) {
+				info.stats.PreRead = time.Time{}
+				info.stats.MemoryStats.Limit = 0
+			},
+			want: stats.ContainerStats{
+				Name:      "nginx",
+				StartTime: metav1.NewTime(started),
+				CPU:       &stats.CPUStats{Time: metav1.NewTime(statsRead), UsageNanoCores: uint64Ptr(0), UsageCoreNanoSeconds: uint64Ptr(5e9)},
+				Memory: &stats.MemoryStats{
+					Time:            metav1.NewTime(statsRead),
+					UsageBytes:      uint64Ptr(100 << 20),
+					WorkingSetBytes: uint64Ptr(100 << 20),
+					RSSBytes:        uint64Ptr(0),
+					PageFaults:      uint64Ptr(0),
+					MajorPageFaults: uint64Ptr(0),
+				},
+				Rootfs: &stats.FsStats{Time: metav1.NewTime(statsRead), UsedBytes: uint64Ptr(0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.modify != nil {
+				tt.modify(&tt.info)
+			}
+			if got := toContainerStats(tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toContainerStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_toNetworkStats(t *testing.T) {
+	now := metav1.NewTime(statsRead)
+	eth0 := stats.InterfaceStats{Name: "eth0", RxBytes: uint64Ptr(100), RxErrors: uint64Ptr(1), TxBytes: uint64Ptr(200), TxErrors: uint64Ptr(2)}
+	eth1 := stats.InterfaceStats{Name: "eth1", RxBytes: uint64Ptr(300), RxErrors: uint64Ptr(0), TxBytes: uint64Ptr(400), TxErrors: uint64Ptr(0)}
+	tests := []struct {
+		name     string
+		networks map[string]moby.NetworkStats
+		want     *stats.NetworkStats
+	}{
+		{
+			name: "sorted by name",
+			networks: map[string]moby.NetworkStats{
+				"eth1": {RxBytes: 300, TxBytes: 400},
+				"eth0": {RxBytes: 100, RxErrors: 1, TxBytes: 200, TxErrors: 2},
+			},
+			want: &stats.NetworkStats{Time: now, InterfaceStats: eth0, Interfaces: []stats.InterfaceStats{eth0, eth1}},
+		},
+		{
+			name: "no interface",
+			want: &stats.NetworkStats{Time: now},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toNetworkStats(now, tt.networks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toNetworkStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_groupPodStats(t *testing.T) {
+	started, _ := time.Parse(time.RFC3339Nano, testTime)
+	memStats := map[string]uint64{"total_inactive_file": 20 << 20, "rss": 50 << 20, "pgfault": 10}
+
+	web := statsInfo("default", "web", "nginx", 5e9, 1e9, memStats)
+	web.stats.Networks = map[string]moby.NetworkStats{"eth0": {RxBytes: 100, TxBytes: 200}}
+	//加入第一个容器网络的容器没有网络数据
+	sidecar := statsInfo("default", "web", "log-agent", 3e9, 2e9, memStats)
+	sidecar.inspect.State.StartedAt = started.Add(time.Second).Format(time.RFC3339Nano)
+	db := statsInfo("db", "mysql", "mysql", 1e9, 1e9, memStats)
+	other := containerStatsInfo{container: moby.Container{Labels: map[string]string{api.ServiceLabel: "other"}}}
+
+	got := groupPodStats([]containerStatsInfo{sidecar, other, web, db})
+	if len(got) != 2 {
+		t.Fatalf("groupPodStats() returned %d pods, want 2", len(got))
+	}
+	tests := []struct {
+		ref            stats.PodReference
+		containers     []string
+		cpuNanoCores   uint64
+		cpuNanoSeconds uint64
+		memWorkingSet  uint64
+		rxBytes        uint64
+	}{
+		{
+			ref:            stats.PodReference{Name: "mysql", Namespace: "db", UID: "mysql-uid"},
+			containers:     []string{"mysql"},
+			cpuNanoCores:   5e8,
+			cpuNanoSeconds: 1e9,
+			memWorkingSet:  80 << 20,
+		},
+		{
+			ref:            stats.PodReference{Name: "web", Namespace: "default", UID: "web-uid"},
+			containers:     []string{"log-agent", "nginx"},
+			cpuNanoCores:   15e8,
+			cpuNanoSeconds: 8e9,
+			memWorkingSet:  160 << 20,
+			rxBytes:        100,
+		},
+	}
+	for i, tt := range tests {
+		ps := got[i]
+		if ps.PodRef != tt.ref {
+			t.Errorf("pod %d ref = %+v, want %+v", i, ps.PodRef, tt.ref)
+		}
+		var names []string
+		for _, c := range ps.Containers {
+			names = append(names, c.Name)
+		}
+		if !reflect.DeepEqual(names, tt.containers) {
+			t.Errorf("pod %s containers = %v, want %v", tt.ref.Name, names, tt.containers)
+		}
+		if !ps.StartTime.Time.Equal(started) {
+			t.Errorf("pod %s start time = %v, want %v", tt.ref.Name, ps.StartTime, started)
+		}
+		if *ps.CPU.UsageNanoCores != tt.cpuNanoCores || *ps.CPU.UsageCoreNanoSeconds != tt.cpuNanoSeconds {
+			t.Errorf("pod %s cpu = %d %d, want %d %d", tt.ref.Name, *ps.CPU.UsageNanoCores, *ps.CPU.UsageCoreNanoSeconds, tt.cpuNanoCores, tt.cpuNanoSeconds)
+		}
+		if *ps.Memory.WorkingSetBytes != tt.memWorkingSet {
+			t.Errorf("pod %s working set = %d, want %d", tt.ref.Name, *ps.Memory.WorkingSetBytes, tt.memWorkingSet)
+		}
+		if tt.rxBytes == 0 {
+			if ps.Network != nil {
+				t.Errorf("pod %s network = %+v, want nil", tt.ref.Name, ps.Network)
+			}
+			continue
+		}
+		if ps.Network == nil || *ps.Network.RxBytes != tt.rxBytes {
+			t.Errorf("pod %s network = %+v, want rx %d", tt.ref.Name, ps.Network, tt.rxBytes)
+		}
+	}
+}
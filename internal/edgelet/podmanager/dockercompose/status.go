@@ -0,0 +1,159 @@
+package dockercompose
+
+import (
+	"edge/internal/edgelet/podmanager/podutil"
+
+	moby "github.com/docker/docker/api/types"
+	v1 "k8s.io/api/core/v1"
+)
+
+//与kubelet一致, 运行结束的pod的Ready condition的原因
+const podCompletedReason = "PodCompleted"
+
+//setWaiting 记录创建pod失败时容器等待的原因, 直到下一次创建成功
+func (d *dcpPodManager) setWaiting(pod *v1.Pod, waiting map[string]v1.ContainerStateWaiting) {
+	d.waitingMutex.Lock()
+	defer d.waitingMutex.Unlock()
+	d.waiting[podKey{namespace: pod.Namespace, name: pod.Name}] = waiting
+}
+
+//setCreateError 创建失败的pod中所有容器都记录相同的原因
+func (d *dcpPodManager) setCreateError(pod *v1.Pod, reason string, err error) {
+	waiting := map[string]v1.ContainerStateWaiting{}
+	for _, c := range append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		waiting[c.Name] = v1.ContainerStateWaiting{Reason: reason, Message: err.Error()}
+	}
+	d.setWaiting(pod, waiting)
+}
+
+func (d *dcpPodManager) clearWaiting(pod *v1.Pod) {
+	d.waitingMutex.Lock()
+	defer d.waitingMutex.Unlock()
+	delete(d.waiting, podKey{namespace: pod.Namespace, name: pod.Name})
+}
+
+func (d *dcpPodManager) waitingState(pod *v1.Pod, containerName string) (v1.ContainerStateWaiting, bool) {
+	d.waitingMutex.RLock()
+	defer d.waitingMutex.RUnlock()
+	state, ok := d.waiting[podKey{namespace: pod.Namespace, name: pod.Name}][containerName]
+	return state, ok
+}
+
+func (d *dcpPodManager) hasWaiting(pod *v1.Pod) bool {
+	d.waitingMutex.RLock()
+	defer d.waitingMutex.RUnlock()
+	_, ok := d.waiting[podKey{namespace: pod.Namespace, name: pod.Name}]
+	return ok
+}
+
+//podStatus 根据docker容器的状态计算pod的状态, spec中没有对应docker容器的容器处于等待状态
+func (d *dcpPodManager) podStatus(pod *v1.Pod, containers []moby.ContainerJSON) *v1.Pod {
+	pod.Status.Reason = ""
+	pod.Status.Message = ""
+	pod.Status.PodIP = d.IPAddress
+	pod.Status.HostIP = d.IPAddress
+	pod.Status.Conditions = []v1.PodCondition{
+		{
+			Type:   v1.PodInitialized,
+			Status: v1.ConditionTrue,
+		},
+		{
+			Type:   v1.PodReady,
+			Status: v1.ConditionTrue,
+		},
+		{
+			Type:   v1.PodScheduled,
+			Status: v1.ConditionTrue,
+		},
+	}
+
+	initContainers := make(map[string]moby.ContainerJSON)
+	runContainers := make(map[string]moby.ContainerJSON)
+	for _, c := range containers {
+		_, _, podContainerName := containerIdentity(c.Config.Labels)
+		_, isInit := c.Config.Labels[k8sInitContainer]
+		if isInit {
+			initContainers[podContainerName] = c
+		} else {
+			runContainers[podContainerName] = c
+		}
+	}
+
+	var initStatus, statuses []v1.ContainerStatus
+	for _, ic := range pod.Spec.InitContainers {
+		containerStatus := d.waitingContainerStatus(pod, ic, podutil.ContainerCreating)
+		if mobyContainer, ok := initContainers[ic.Name]; ok {
+			containerStatus = mobyContainerToK8sContainerState(ic.Name, mobyContainer, true)
+		}
+		if !containerStatus.Ready {
+			pod.Status.Conditions[0].Status = v1.ConditionFalse
+			pod.Status.Conditions[1].Status = v1.ConditionFalse
+		}
+		initStatus = append(initStatus, containerStatus)
+	}
+	pod.Status.InitContainerStatuses = initStatus
+
+	initialized := pod.Status.Conditions[0].Status == v1.ConditionTrue
+	for _, c := range pod.Spec.Containers {
+		//init-container没有完成时, 容器已经创建但不会启动
+		defaultReason := podutil.ContainerCreating
+		if !initialized {
+			defaultReason = podutil.PodInitializing
+		}
+		containerStatus := d.waitingContainerStatus(pod, c, defaultReason)
+		if mobyContainer, ok := runContainers[c.Name]; ok {
+			containerStatus = mobyContainerToK8sContainerState(c.Name, mobyContainer, false)
+			if w := containerStatus.State.Waiting; w != nil && w.Reason == podutil.ContainerCreating {
+				w.Reason = defaultReason
+			}
+			d.prober.updateContainerStatus(pod, c, &containerStatus)
+		}
+		if !containerStatus.Ready {
+			pod.Status.Conditions[1].Status = v1.ConditionFalse
+		}
+		statuses = append(statuses, containerStatus)
+	}
+	pod.Status.ContainerStatuses = statuses
+	pod.Status.Phase = podutil.GetPhase(&pod.Spec, initStatus, statuses)
+	if podutil.IsTerminated(pod) {
+		pod.Status.Conditions[1].Reason = podCompletedReason
+	}
+	setAppliedResources(pod, containers)
+	return pod
+}
+
+//waitingContainerStatus 没有docker容器时的状态, 创建失败时使用记录的原因
+func (d *dcpPodManager) waitingContainerStatus(pod *v1.Pod, container v1.Container, defaultReason string) v1.ContainerStatus {
+	state, ok := d.waitingState(pod, container.Name)
+	if !ok {
+		state = v1.ContainerStateWaiting{Reason: defaultReason}
+	}
+	return v1.ContainerStatus{
+		Name:  container.Name,
+		Image: container.Image,
+		State: v1.ContainerState{Waiting: &state},
+	}
+}
+
+//noContainersPod pod在docker中没有容器: 运行结束的pod的容器已经被清理, 返回最后记录的状态
+//还没有创建成功时处于Pending, 容器丢失时标记容器丢失
+func (d *dcpPodManager) noContainersPod(pod *v1.Pod) *v1.Pod {
+	if podutil.IsTerminated(pod) {
+		return pod
+	}
+	if d.hasWaiting(pod) || !everStarted(pod) {
+		return d.podStatus(pod, nil)
+	}
+	return missingContainersPod(pod)
+}
+
+func everStarted(pod *v1.Pod) bool {
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if s.State.Waiting == nil || s.LastTerminationState.Terminated != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
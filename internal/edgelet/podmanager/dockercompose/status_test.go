@@ -0,0 +1,315 @@
+package dockercompose
+
+import (
+	"edge/internal/edgelet/podmanager/podutil"
+	"reflect"
+	"testing"
+	"time"
+
+	moby "github.com/docker/docker/api/types"
+	mobycontainer "github.com/docker/docker/api/types/container"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testTime = "2022-06-01T10:00:00Z"
+
+func running(name string, isInit bool) moby.ContainerJSON {
+	return mobyContainer(name, isInit, moby.ContainerState{Status: "running", Running: true, StartedAt: testTime})
+}
+
+func exited(name string, isInit bool, code int) moby.ContainerJSON {
+	return mobyContainer(name, isInit, moby.ContainerState{Status: "exited", ExitCode: code, StartedAt: testTime, FinishedAt: testTime})
+}
+
+func created(name string, isInit bool) moby.ContainerJSON {
+	return mobyContainer(name, isInit, moby.ContainerState{Status: "created"})
+}
+
+//docker按always策略重启中的容器
+func crashLooping(name string, restarts int) moby.ContainerJSON {
+	c := mobyContainer(name, false, moby.ContainerState{Status: "restarting", Running: true, Restarting: true, ExitCode: 1, StartedAt: testTime, FinishedAt: testTime})
+	c.RestartCount = restarts
+	c.HostConfig = &mobycontainer.HostConfig{RestartPolicy: mobycontainer.RestartPolicy{Name: "always"}}
+	return c
+}
+
+func mobyContainer(name string, isInit bool, state moby.ContainerState) moby.ContainerJSON {
+	labels := map[string]string{
+		k8sNamespaceLabel:     "default",
+		k8sPodNameLabel:       "test",
+		k8sContainerNameLabel: name,
+	}
+	if isInit {
+		labels[k8sInitContainer] = "true"
+	}
+	return moby.ContainerJSON{
+		ContainerJSONBase: &moby.ContainerJSONBase{
+			Created: testTime,
+			State:   &state,
+		},
+		Config: &mobycontainer.Config{Labels: labels},
+	}
+}
+
+func Test_podStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		restartPolicy v1.RestartPolicy
+		initNames     []string
+		containers    []moby.ContainerJSON
+		waiting       map[string]v1.ContainerStateWaiting
+		wantPhase     v1.PodPhase
+		//容器名称到等待原因, 只检查处于等待状态的容器
+		wantWaiting map[string]string
+	}{
+		{
+			name:          "no containers yet",
+			restartPolicy: v1.RestartPolicyAlways,
+			wantPhase:     v1.PodPending,
+			wantWaiting:   map[string]string{"app": podutil.ContainerCreating, "sidecar": podutil.ContainerCreating},
+		},
+		{
+			name:          "all running",
+			restartPolicy: v1.RestartPolicyAlways,
+			containers:    []moby.ContainerJSON{running("app", false), running("sidecar", false)},
+			wantPhase:     v1.PodRunning,
+		},
+		{
+			name:          "one container missing",
+			restartPolicy: v1.RestartPolicyAlways,
+			containers:    []moby.ContainerJSON{running("app", false)},
+			wantPhase:     v1.PodPending,
+			wantWaiting:   map[string]string{"sidecar": podutil.ContainerCreating},
+		},
+		{
+			name:          "init container running",
+			restartPolicy: v1.RestartPolicyAlways,
+			initNames:     []string{"init"},
+			containers:    []moby.ContainerJSON{running("init", true), created("app", false), created("sidecar", false)},
+			wantPhase:     v1.PodPending,
+			wantWaiting:   map[string]string{"app": podutil.PodInitializing, "sidecar": podutil.PodInitializing},
+		},
+		{
+			name:          "init container completed",
+			restartPolicy: v1.RestartPolicyAlways,
+			initNames:     []string{"init"},
+			containers:    []moby.ContainerJSON{exited("init", true, 0), running("app", false), running("sidecar", false)},
+			wantPhase:     v1.PodRunning,
+		},
+		{
+			name:          "init container failed with restart never",
+			restartPolicy: v1.RestartPolicyNever,
+			initNames:     []string{"init"},
+			containers:    []moby.ContainerJSON{exited("init", true, 1), created("app", false), created("sidecar", false)},
+			wantPhase:     v1.PodFailed,
+		},
+		{
+			name:          "init container failed with restart always",
+			restartPolicy: v1.RestartPolicyAlways,
+			initNames:     []string{"init"},
+			containers:    []moby.ContainerJSON{exited("init", true, 1), created("app", false), created("sidecar", false)},
+			wantPhase:     v1.PodPending,
+		},
+		{
+			name:          "all succeeded with restart never",
+			restartPolicy: v1.RestartPolicyNever,
+			containers:    []moby.ContainerJSON{exited("app", false, 0), exited("sidecar", false, 0)},
+			wantPhase:     v1.PodSucceeded,
+		},
+		{
+			name:          "all succeeded with restart on failure",
+			restartPolicy: v1.RestartPolicyOnFailure,
+			containers:    []moby.ContainerJSON{exited("app", false, 0), exited("sidecar", false, 0)},
+			wantPhase:     v1.PodSucceeded,
+		},
+		{
+			name:          "all exited with restart always",
+			restartPolicy: v1.RestartPolicyAlways,
+			containers:    []moby.ContainerJSON{exited("app", false, 0), exited("sidecar", false, 1)},
+			wantPhase:     v1.PodRunning,
+		},
+		{
+			name:          "one failed with restart never",
+			restartPolicy: v1.RestartPolicyNever,
+			containers:    []moby.ContainerJSON{exited("app", false, 0), exited("sidecar", false, 2)},
+			wantPhase:     v1.PodFailed,
+		},
+		{
+			name:          "one failed with restart on failure",
+			restartPolicy: v1.RestartPolicyOnFailure,
+			containers:    []moby.ContainerJSON{exited("app", false, 0), exited("sidecar", false, 2)},
+			wantPhase:     v1.PodRunning,
+		},
+		{
+			name:          "one running one succeeded",
+			restartPolicy: v1.RestartPolicyNever,
+			containers:    []moby.ContainerJSON{running("app", false), exited("sidecar", false, 0)},
+			wantPhase:     v1.PodRunning,
+		},
+		{
+			name:          "crash looping with restart always",
+			restartPolicy: v1.RestartPolicyAlways,
+			containers:    []moby.ContainerJSON{crashLooping("app", 4), running("sidecar", false)},
+			wantPhase:     v1.PodRunning,
+			wantWaiting:   map[string]string{"app": string(crashLoopBackOffReason)},
+		},
+		{
+			name:          "image pull failed",
+			restartPolicy: v1.RestartPolicyAlways,
+			waiting: map[string]v1.ContainerStateWaiting{
+				"app":     {Reason: podutil.ErrImagePull, Message: "manifest unknown"},
+				"sidecar": {Reason: podutil.ImagePullBackOff, Message: `Back-off pulling image "sidecar"`},
+			},
+			wantPhase:   v1.PodPending,
+			wantWaiting: map[string]string{"app": podutil.ErrImagePull, "sidecar": podutil.ImagePullBackOff},
+		},
+		{
+			name:          "create container failed",
+			restartPolicy: v1.RestartPolicyAlways,
+			waiting: map[string]v1.ContainerStateWaiting{
+				"app":     {Reason: podutil.CreateContainerError, Message: "port is already allocated"},
+				"sidecar": {Reason: podutil.CreateContainerError, Message: "port is already allocated"},
+			},
+			wantPhase:   v1.PodPending,
+			wantWaiting: map[string]string{"app": podutil.CreateContainerError, "sidecar": podutil.CreateContainerError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dcpPodManager{waiting: map[podKey]map[string]v1.ContainerStateWaiting{}}
+			d.prober = newProber(d)
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+				Spec: v1.PodSpec{
+					RestartPolicy: tt.restartPolicy,
+					Containers:    []v1.Container{{Name: "app", Image: "app"}, {Name: "sidecar", Image: "sidecar"}},
+				},
+			}
+			for _, name := range tt.initNames {
+				pod.Spec.InitContainers = append(pod.Spec.InitContainers, v1.Container{Name: name, Image: name})
+			}
+			if tt.waiting != nil {
+				d.setWaiting(pod, tt.waiting)
+			}
+
+			got := d.podStatus(pod, tt.containers)
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %v, want %v", got.Status.Phase, tt.wantPhase)
+			}
+			for _, s := range got.Status.ContainerStatuses {
+				want, ok := tt.wantWaiting[s.Name]
+				if !ok {
+					continue
+				}
+				if s.State.Waiting == nil {
+					t.Errorf("container %s state = %+v, want waiting %s", s.Name, s.State, want)
+					continue
+				}
+				if s.State.Waiting.Reason != want {
+					t.Errorf("container %s waiting reason = %s, want %s", s.Name, s.State.Waiting.Reason, want)
+				}
+				if w, ok := tt.waiting[s.Name]; ok && s.State.Waiting.Message != w.Message {
+					t.Errorf("container %s waiting message = %q, want %q", s.Name, s.State.Waiting.Message, w.Message)
+				}
+			}
+		})
+	}
+}
+
+func Test_crashLoopBackOff(t *testing.T) {
+	longRun := crashLooping("app", 5)
+	longRun.State.FinishedAt = "2022-06-01T10:00:10Z"
+	tests := []struct {
+		name      string
+		container moby.ContainerJSON
+		want      time.Duration
+	}{
+		{name: "first restart", container: crashLooping("app", 1), want: 100 * time.Millisecond},
+		{name: "second restart", container: crashLooping("app", 2), want: 200 * time.Millisecond},
+		{name: "fourth restart", container: crashLooping("app", 4), want: 800 * time.Millisecond},
+		{name: "capped", container: crashLooping("app", 11), want: time.Minute},
+		{name: "many restarts", container: crashLooping("app", 100), want: time.Minute},
+		{name: "reset after long run", container: longRun, want: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := crashLoopBackOff(tt.container); got != tt.want {
+				t.Errorf("crashLoopBackOff() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_willRestart(t *testing.T) {
+	withPolicy := func(c moby.ContainerJSON, name string, max int) moby.ContainerJSON {
+		c.HostConfig = &mobycontainer.HostConfig{RestartPolicy: mobycontainer.RestartPolicy{Name: name, MaximumRetryCount: max}}
+		return c
+	}
+	tests := []struct {
+		name      string
+		container moby.ContainerJSON
+		want      bool
+	}{
+		{name: "restarting", container: crashLooping("app", 1), want: true},
+		{name: "always", container: withPolicy(exited("app", false, 0), "always", 0), want: true},
+		{name: "no", container: withPolicy(exited("app", false, 1), "no", 0), want: false},
+		{name: "on-failure succeeded", container: withPolicy(exited("app", false, 0), "on-failure", 0), want: false},
+		{name: "on-failure failed", container: withPolicy(exited("app", false, 1), "on-failure", 0), want: true},
+		{name: "no host config", container: exited("app", false, 1), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := willRestart(tt.container); got != tt.want {
+				t.Errorf("willRestart() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_noContainersPod(t *testing.T) {
+	terminated := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 0, Reason: string(completedReason)}}
+	running := v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+	tests := []struct {
+		name      string
+		status    v1.PodStatus
+		wantPhase v1.PodPhase
+		wantState v1.ContainerState
+	}{
+		{
+			name:      "completed pod keeps its final status after cleanup",
+			status:    v1.PodStatus{Phase: v1.PodSucceeded, ContainerStatuses: []v1.ContainerStatus{{Name: "app", State: terminated}}},
+			wantPhase: v1.PodSucceeded,
+			wantState: terminated,
+		},
+		{
+			name:      "never created",
+			wantPhase: v1.PodPending,
+			wantState: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: podutil.ContainerCreating}},
+		},
+		{
+			name:      "containers lost",
+			status:    v1.PodStatus{Phase: v1.PodRunning, ContainerStatuses: []v1.ContainerStatus{{Name: "app", State: running}}},
+			wantPhase: v1.PodUnknown,
+			wantState: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: string(containerMissingReason), Message: "container not found in the container runtime"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dcpPodManager{waiting: map[podKey]map[string]v1.ContainerStateWaiting{}}
+			d.prober = newProber(d)
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+				Spec:       v1.PodSpec{RestartPolicy: v1.RestartPolicyNever, Containers: []v1.Container{{Name: "app", Image: "app"}}},
+				Status:     tt.status,
+			}
+			got := d.noContainersPod(pod)
+			if got.Status.Phase != tt.wantPhase {
+				t.Errorf("phase = %v, want %v", got.Status.Phase, tt.wantPhase)
+			}
+			if len(got.Status.ContainerStatuses) != 1 || !reflect.DeepEqual(got.Status.ContainerStatuses[0].State, tt.wantState) {
+				t.Errorf("container statuses = %+v, want state %+v", got.Status.ContainerStatuses, tt.wantState)
+			}
+		})
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"edge/api/edge-proto/pb"
 	"edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/podmanager/containerd"
 	"edge/internal/edgelet/podmanager/dockercompose"
+	"edge/internal/edgelet/podmanager/stream"
 	"io"
 
 	v1 "k8s.io/api/core/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
 
 type PodManager interface {
@@ -17,11 +20,23 @@ type PodManager interface {
 	GetPod(ctx context.Context, namespace, name string) (*v1.Pod, error)
 	GetPods(ctx context.Context) ([]*v1.Pod, error)
 	GetContainerLogs(ctx context.Context, namespace, podname, containerName string, opts *pb.ContainerLogOptions) (io.ReadCloser, error)
+	RunInContainer(ctx context.Context, namespace, podname, containerName string, cmd []string, attach stream.AttachIO) error
 	DescribePodsStatus(ctx context.Context) ([]*v1.Pod, error)
+	GetPodStats(ctx context.Context) ([]stats.PodStats, error)
 	CreateVolume(ctx context.Context, volume *pb.CreateVolumeRequest) error
 	ContainerRuntimeVersion(ctx context.Context) string
 }
 
+//根据配置的容器运行时选择PodManager的实现
 func New(opts ...config.Option) PodManager {
-	return dockercompose.NewPodManager(opts...)
+	conf := config.DefaultConfig()
+	for _, o := range opts {
+		o.Apply(&conf)
+	}
+	switch conf.Runtime {
+	case config.RuntimeContainerd:
+		return containerd.NewPodManager(opts...)
+	default:
+		return dockercompose.NewPodManager(opts...)
+	}
 }
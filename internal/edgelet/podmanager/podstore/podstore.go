@@ -0,0 +1,195 @@
+package podstore
+
+import (
+	"edge/pkg/errdefs"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+const fileSuffix = ".json"
+
+//Record 本地保存的pod: 期望的spec以及最后一次上报的状态
+type Record struct {
+	Pod             *v1.Pod      `json:"pod"`
+	ResourceVersion string       `json:"resourceVersion"`
+	Status          v1.PodStatus `json:"status"`
+	UpdateTime      time.Time    `json:"updateTime"`
+}
+
+//Store 每个pod保存为<root>/<namespace>_<name>.json, 内存中缓存一份
+type Store struct {
+	root    string
+	mutex   sync.RWMutex
+	records map[string]*Record
+}
+
+func New(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		root:    root,
+		records: map[string]*Record{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func key(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+//namespace和pod名称中不会出现下划线
+func (s *Store) path(namespace, name string) string {
+	return filepath.Join(s.root, namespace+"_"+name+fileSuffix)
+}
+
+func (s *Store) load() error {
+	files, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.root, f.Name()))
+		if err != nil {
+			return err
+		}
+		record := &Record{}
+		if err := json.Unmarshal(data, record); err != nil || record.Pod == nil {
+			logrus.Warnf("skip invalid pod record %s, err=%v", f.Name(), err)
+			continue
+		}
+		s.records[key(record.Pod.Namespace, record.Pod.Name)] = record
+	}
+	return nil
+}
+
+//写临时文件再rename, 保证文件不会只写一半
+func (s *Store) save(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	path := s.path(record.Pod.Namespace, record.Pod.Name)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//Put 保存期望的pod spec, 保留已有的状态
+func (s *Store) Put(pod *v1.Pod) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record := &Record{}
+	if old, ok := s.records[key(pod.Namespace, pod.Name)]; ok {
+		*record = *old
+	}
+	record.Pod = pod.DeepCopy()
+	record.Pod.Status = v1.PodStatus{}
+	record.ResourceVersion = pod.ResourceVersion
+	record.UpdateTime = time.Now()
+	if err := s.save(record); err != nil {
+		return fmt.Errorf("save pod %s/%s failed, err=%v", pod.Namespace, pod.Name, err)
+	}
+	s.records[key(pod.Namespace, pod.Name)] = record
+	return nil
+}
+
+//UpdateStatus 记录最后一次的状态, 只有状态变化时才写文件
+func (s *Store) UpdateStatus(namespace, name string, status v1.PodStatus) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, ok := s.records[key(namespace, name)]
+	if !ok {
+		return errdefs.NotFoundf("pod %s/%s not found in store", namespace, name)
+	}
+	oldData, _ := json.Marshal(old.Status)
+	newData, _ := json.Marshal(status)
+	if string(oldData) == string(newData) {
+		return nil
+	}
+	record := *old
+	record.Status = *status.DeepCopy()
+	record.UpdateTime = time.Now()
+	if err := s.save(&record); err != nil {
+		return fmt.Errorf("save pod %s/%s status failed, err=%v", namespace, name, err)
+	}
+	s.records[key(namespace, name)] = &record
+	return nil
+}
+
+//Get 返回pod的副本, 状态为最后一次记录的状态
+func (s *Store) Get(namespace, name string) (*v1.Pod, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	record, ok := s.records[key(namespace, name)]
+	if !ok {
+		return nil, errdefs.NotFoundf("pod %s/%s not found in store", namespace, name)
+	}
+	return record.toPod(), nil
+}
+
+//Find 根据名称查找pod, namespace为空时匹配任意namespace
+func (s *Store) Find(namespace, name string) (*v1.Pod, error) {
+	if namespace != "" {
+		return s.Get(namespace, name)
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, record := range s.records {
+		if record.Pod.Name == name {
+			return record.toPod(), nil
+		}
+	}
+	return nil, errdefs.NotFoundf("pod %s not found in store", name)
+}
+
+//List 按namespace/name排序返回所有pod
+func (s *Store) List() []*v1.Pod {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, 0, len(s.records))
+	for k := range s.records {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pods := make([]*v1.Pod, 0, len(keys))
+	for _, k := range keys {
+		pods = append(pods, s.records[k].toPod())
+	}
+	return pods
+}
+
+func (s *Store) Delete(namespace, name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, key(namespace, name))
+	err := os.Remove(s.path(namespace, name))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r *Record) toPod() *v1.Pod {
+	pod := r.Pod.DeepCopy()
+	pod.Status = *r.Status.DeepCopy()
+	return pod
+}
@@ -0,0 +1,207 @@
+package podstore
+
+import (
+	"edge/pkg/errdefs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestPod(namespace, name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app", Image: "nginx"}}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func runningStatus() v1.PodStatus {
+	return v1.PodStatus{
+		Phase:             v1.PodRunning,
+		ContainerStatuses: []v1.ContainerStatus{{Name: "app", Ready: true}},
+	}
+}
+
+func TestStore_PutGet(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := newTestPod("default", "web")
+	if err := s.Put(pod); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get("default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//只保存spec, 调用者传入的状态不会保存
+	want := pod.DeepCopy()
+	want.Status = v1.PodStatus{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+	//返回的是副本
+	got.Spec.Containers[0].Image = "changed"
+	if again, _ := s.Get("default", "web"); again.Spec.Containers[0].Image != "nginx" {
+		t.Error("Get() returned the cached pod")
+	}
+	if _, err := s.Get("default", "missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("Get() missing pod error = %v, want NotFound", err)
+	}
+}
+
+//写入通过rename完成, 不会留下临时文件
+func TestStore_atomicSave(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(newTestPod("default", "web")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("default", "web", runningStatus()); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"default_web.json"}) {
+		t.Errorf("files = %v, want [default_web.json]", names)
+	}
+}
+
+func TestStore_UpdateStatus(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("default", "web", runningStatus()); !errdefs.IsNotFound(err) {
+		t.Errorf("UpdateStatus() of unknown pod error = %v, want NotFound", err)
+	}
+	if err := s.Put(newTestPod("default", "web")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("default", "web", runningStatus()); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Get("default", "web")
+	if !reflect.DeepEqual(got.Status, runningStatus()) {
+		t.Errorf("status = %+v, want %+v", got.Status, runningStatus())
+	}
+
+	//状态没有变化时不写文件
+	path := filepath.Join(root, "default_web.json")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("default", "web", runningStatus()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("unchanged status was written, err=%v", err)
+	}
+
+	//更新spec时保留已有的状态
+	pod := newTestPod("default", "web")
+	pod.ResourceVersion = "2"
+	if err := s.Put(pod); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = s.Get("default", "web")
+	if got.ResourceVersion != "2" || !reflect.DeepEqual(got.Status, runningStatus()) {
+		t.Errorf("after Put resourceVersion = %s status = %+v", got.ResourceVersion, got.Status)
+	}
+}
+
+//edgelet重启后从文件加载, 不合法的文件和临时文件被跳过
+func TestStore_loadAfterRestart(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"web", "db"} {
+		if err := s.Put(newTestPod("default", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(newTestPod("kube-system", "proxy")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateStatus("default", "web", runningStatus()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("default", "db"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"broken.json":           "{",
+		"empty.json":            "{}",
+		"default_web.json.tmp":  "{",
+		"default_other.ignored": "{}",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarted, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, pod := range restarted.List() {
+		names = append(names, pod.Namespace+"/"+pod.Name)
+	}
+	if want := []string{"default/web", "kube-system/proxy"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+	got, err := restarted.Get("default", "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Status, runningStatus()) {
+		t.Errorf("status = %+v, want %+v", got.Status, runningStatus())
+	}
+	got, err = restarted.Find("", "proxy")
+	if err != nil || got.Namespace != "kube-system" {
+		t.Errorf("Find() = %v, %v", got, err)
+	}
+}
+
+func TestStore_Delete(t *testing.T) {
+	root := t.TempDir()
+	s, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(newTestPod("default", "web")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("default", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("default", "web"); !errdefs.IsNotFound(err) {
+		t.Errorf("Get() after Delete error = %v, want NotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "default_web.json")); !os.IsNotExist(err) {
+		t.Errorf("file not removed, err=%v", err)
+	}
+	//删除不存在的pod不报错
+	if err := s.Delete("default", "web"); err != nil {
+		t.Errorf("Delete() twice error = %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package podutil
+
+import (
+	"edge/internal/edgelet/podmanager/config"
+	"edge/pkg/errdefs"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/mem"
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/kubernetes/pkg/fieldpath"
+	"k8s.io/kubernetes/third_party/forked/golang/expansion"
+)
+
+type envResolver struct {
+	conf config.Config
+	pod  *v1.Pod
+}
+
+//MakeEnvironmentVariables 生成容器的环境变量，与kubelet的makeEnvironmentVariables一致:
+//先处理envFrom，再处理env，env中的$(VAR)引用会被展开
+func MakeEnvironmentVariables(conf config.Config, pod *v1.Pod, container v1.Container) (map[string]string, error) {
+	r := &envResolver{conf: conf, pod: pod}
+	tmpEnv := make(map[string]string)
+	for _, envFrom := range container.EnvFrom {
+		switch {
+		case envFrom.ConfigMapRef != nil:
+			cm := envFrom.ConfigMapRef
+			optional := cm.Optional != nil && *cm.Optional
+			data, err := r.readConfigMap(cm.Name)
+			if err != nil {
+				if errdefs.IsNotFound(err) && optional {
+					continue
+				}
+				return nil, err
+			}
+			r.addEnvFrom(tmpEnv, envFrom.Prefix, data, "ConfigMap", cm.Name)
+		case envFrom.SecretRef != nil:
+			s := envFrom.SecretRef
+			optional := s.Optional != nil && *s.Optional
+			data, err := r.readSecret(s.Name)
+			if err != nil {
+				if errdefs.IsNotFound(err) && optional {
+					continue
+				}
+				return nil, err
+			}
+			r.addEnvFrom(tmpEnv, envFrom.Prefix, data, "Secret", s.Name)
+		}
+	}
+
+	mappingFunc := expansion.MappingFuncFor(tmpEnv)
+	for _, envVar := range container.Env {
+		runtimeVal := envVar.Value
+		if runtimeVal != "" {
+			runtimeVal = expansion.Expand(runtimeVal, mappingFunc)
+		} else if envVar.ValueFrom != nil {
+			var err error
+			var skip bool
+			runtimeVal, skip, err = r.envValueFrom(container, envVar.ValueFrom)
+			if err != nil {
+				return nil, err
+			}
+			if skip {
+				continue
+			}
+		}
+		tmpEnv[envVar.Name] = runtimeVal
+	}
+	return tmpEnv, nil
+}
+
+//envFrom中key不是合法的环境变量名时跳过, 与kubelet一致
+func (r *envResolver) addEnvFrom(tmpEnv map[string]string, prefix string, data map[string]string, kind, name string) {
+	var invalidKeys []string
+	for k, v := range data {
+		if len(prefix) > 0 {
+			k = prefix + k
+		}
+		if errMsgs := validation.IsEnvVarName(k); len(errMsgs) != 0 {
+			invalidKeys = append(invalidKeys, k)
+			continue
+		}
+		tmpEnv[k] = v
+	}
+	if len(invalidKeys) > 0 {
+		logrus.Warnf("pod %s/%s: keys [%s] from the EnvFrom %s %s/%s were skipped since they are considered invalid environment variable names",
+			r.pod.Namespace, r.pod.Name, strings.Join(invalidKeys, ", "), kind, r.pod.Namespace, name)
+	}
+}
+
+//返回值skip为true时表示optional的引用不存在，不设置该环境变量
+func (r *envResolver) envValueFrom(container v1.Container, from *v1.EnvVarSource) (value string, skip bool, err error) {
+	switch {
+	case from.FieldRef != nil:
+		value, err = r.podFieldSelectorRuntimeValue(from.FieldRef)
+		return value, false, err
+	case from.ResourceFieldRef != nil:
+		value, err = containerResourceRuntimeValue(from.ResourceFieldRef, container)
+		return value, false, err
+	case from.ConfigMapKeyRef != nil:
+		cm := from.ConfigMapKeyRef
+		optional := cm.Optional != nil && *cm.Optional
+		data, err := r.readConfigMap(cm.Name)
+		if err != nil {
+			if errdefs.IsNotFound(err) && optional {
+				return "", true, nil
+			}
+			return "", false, err
+		}
+		value, ok := data[cm.Key]
+		if !ok {
+			if optional {
+				return "", true, nil
+			}
+			return "", false, fmt.Errorf("couldn't find key %v in ConfigMap %v/%v", cm.Key, r.pod.Namespace, cm.Name)
+		}
+		return value, false, nil
+	case from.SecretKeyRef != nil:
+		s := from.SecretKeyRef
+		optional := s.Optional != nil && *s.Optional
+		data, err := r.readSecret(s.Name)
+		if err != nil {
+			if errdefs.IsNotFound(err) && optional {
+				return "", true, nil
+			}
+			return "", false, err
+		}
+		value, ok := data[s.Key]
+		if !ok {
+			if optional {
+				return "", true, nil
+			}
+			return "", false, fmt.Errorf("couldn't find key %v in Secret %v/%v", s.Key, r.pod.Namespace, s.Name)
+		}
+		return value, false, nil
+	}
+	return "", false, nil
+}
+
+//downward api, pod的ip就是上报的pod ip
+func (r *envResolver) podFieldSelectorRuntimeValue(fs *v1.ObjectFieldSelector) (string, error) {
+	switch fs.FieldPath {
+	case "spec.nodeName":
+		return r.pod.Spec.NodeName, nil
+	case "spec.serviceAccountName":
+		return r.pod.Spec.ServiceAccountName, nil
+	case "status.hostIP", "status.podIP", "status.podIPs":
+		return r.conf.IPAddress, nil
+	}
+	return fieldpath.ExtractFieldPathAsString(r.pod, fs.FieldPath)
+}
+
+//没有设置limits时使用节点的资源, 结果按divisor向上取整, 与kubelet一致
+func containerResourceRuntimeValue(fs *v1.ResourceFieldSelector, container v1.Container) (string, error) {
+	divisor := fs.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+	limits := v1.ResourceList{}
+	for name, q := range container.Resources.Limits {
+		limits[name] = q
+	}
+	for name, q := range hostAllocatable() {
+		if limit, ok := limits[name]; !ok || limit.IsZero() {
+			limits[name] = q
+		}
+	}
+
+	var q resource.Quantity
+	switch fs.Resource {
+	case "limits.cpu":
+		q = limits[v1.ResourceCPU]
+	case "limits.memory":
+		q = limits[v1.ResourceMemory]
+	case "limits.ephemeral-storage":
+		q = limits[v1.ResourceEphemeralStorage]
+	case "requests.cpu":
+		q = container.Resources.Requests[v1.ResourceCPU]
+	case "requests.memory":
+		q = container.Resources.Requests[v1.ResourceMemory]
+	case "requests.ephemeral-storage":
+		q = container.Resources.Requests[v1.ResourceEphemeralStorage]
+	default:
+		return "", fmt.Errorf("unsupported container resource : %v", fs.Resource)
+	}
+	if strings.HasSuffix(fs.Resource, ".cpu") {
+		return strconv.FormatInt(int64(math.Ceil(float64(q.MilliValue())/float64(divisor.MilliValue()))), 10), nil
+	}
+	return strconv.FormatInt(int64(math.Ceil(float64(q.Value())/float64(divisor.Value()))), 10), nil
+}
+
+func hostAllocatable() v1.ResourceList {
+	allocatable := v1.ResourceList{
+		v1.ResourceCPU: *resource.NewQuantity(int64(runtime.NumCPU()), resource.DecimalSI),
+	}
+	if ms, err := mem.VirtualMemory(); err == nil {
+		allocatable[v1.ResourceMemory] = *resource.NewQuantity(int64(ms.Total), resource.BinarySI)
+	}
+	return allocatable
+}
+
+func (r *envResolver) readConfigMap(name string) (map[string]string, error) {
+	data, err := r.readVolumeData(r.conf.ConfigMapRoot(), name, func(v v1.Volume) ([]v1.KeyToPath, bool) {
+		if v.ConfigMap != nil && v.ConfigMap.Name == name {
+			return v.ConfigMap.Items, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errdefs.NotFoundf("configmap %q not found", name)
+	}
+	return data, nil
+}
+
+func (r *envResolver) readSecret(name string) (map[string]string, error) {
+	data, err := r.readVolumeData(r.conf.SecretRoot(), name, func(v v1.Volume) ([]v1.KeyToPath, bool) {
+		if v.Secret != nil && v.Secret.SecretName == name {
+			return v.Secret.Items, true
+		}
+		return nil, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errdefs.NotFoundf("secret %q not found", name)
+	}
+	return data, nil
+}
+
+//CreateVolume把configmap/secret的每个key写成<root>/<namespace>/<volume name>/<path>
+//被pod volume引用的使用volume的名字, 设置了items时文件名是item的path, 需要换回key, 没有投影的key读不到
+//只被env引用的configmap/secret以自身的名字作为volume名下发, 文件名就是key
+//同一个namespace中其他pod的volume可能与configmap/secret同名, 只在pod没有引用它的volume时读取这个目录
+func (r *envResolver) readVolumeData(root, name string, match func(v1.Volume) ([]v1.KeyToPath, bool)) (map[string]string, error) {
+	var data map[string]string
+	matched := false
+	for _, v := range r.pod.Spec.Volumes {
+		items, ok := match(v)
+		if !ok {
+			continue
+		}
+		matched = true
+		dir := filepath.Join(root, r.pod.Namespace, v.Name)
+		if len(items) == 0 {
+			files, err := readDataDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			if files == nil {
+				continue
+			}
+			if data == nil {
+				data = make(map[string]string)
+			}
+			for k, content := range files {
+				data[k] = content
+			}
+			continue
+		}
+		for _, item := range items {
+			content, err := ioutil.ReadFile(filepath.Join(dir, item.Path))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			if data == nil {
+				data = make(map[string]string)
+			}
+			data[item.Key] = string(content)
+		}
+	}
+	if !matched {
+		return readDataDir(filepath.Join(root, r.pod.Namespace, name))
+	}
+	return data, nil
+}
+
+//readDataDir 目录不存在时返回nil
+func readDataDir(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data := make(map[string]string)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		data[f.Name()] = string(content)
+	}
+	return data, nil
+}
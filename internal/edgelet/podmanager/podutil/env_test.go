@@ -0,0 +1,240 @@
+package podutil
+
+import (
+	"edge/internal/edgelet/podmanager/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func writeVolumeFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_MakeEnvironmentVariables(t *testing.T) {
+	optional := true
+	tests := []struct {
+		name      string
+		files     map[string]string
+		volumes   []v1.Volume
+		container v1.Container
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "expansion",
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "A", Value: "foo"},
+				{Name: "B", Value: "$(A)-bar"},
+				{Name: "C", Value: "$$(A)"},
+				{Name: "D", Value: "$(MISSING)"},
+				{Name: "E", Value: "$(C)"},
+			}},
+			want: map[string]string{"A": "foo", "B": "foo-bar", "C": "$(A)", "D": "$(MISSING)", "E": "$(A)"},
+		},
+		{
+			name:  "expansion from envFrom",
+			files: map[string]string{"configmap/default/app/HOST": "db"},
+			container: v1.Container{
+				EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}},
+				Env:     []v1.EnvVar{{Name: "URL", Value: "http://$(HOST):80"}},
+			},
+			want: map[string]string{"HOST": "db", "URL": "http://db:80"},
+		},
+		{
+			name: "envFrom prefix and invalid keys",
+			files: map[string]string{
+				"configmap/default/app/port":       "80",
+				"configmap/default/app/1st":        "skipped without prefix",
+				"secret/default/creds/with space":  "skipped",
+				"secret/default/creds/password":    "secret",
+				"configmap/default/other/ignored":  "not referenced",
+				"configmap/default/app/sub/nested": "directories are skipped",
+			},
+			container: v1.Container{EnvFrom: []v1.EnvFromSource{
+				{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}},
+				{Prefix: "DB_", SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "creds"}}},
+			}},
+			want: map[string]string{"port": "80", "DB_password": "secret"},
+		},
+		{
+			name: "env overrides envFrom",
+			files: map[string]string{
+				"configmap/default/app/MODE": "dev",
+			},
+			container: v1.Container{
+				EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}},
+				Env:     []v1.EnvVar{{Name: "MODE", Value: "prod"}},
+			},
+			want: map[string]string{"MODE": "prod"},
+		},
+		{
+			name: "optional envFrom missing",
+			container: v1.Container{EnvFrom: []v1.EnvFromSource{
+				{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Optional: &optional}},
+				{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "creds"}, Optional: &optional}},
+			}},
+			want: map[string]string{},
+		},
+		{
+			name: "required envFrom missing",
+			container: v1.Container{EnvFrom: []v1.EnvFromSource{
+				{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "creds"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:  "key refs",
+			files: map[string]string{"configmap/default/app/level": "debug", "secret/default/creds/token": "abc"},
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "LEVEL", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Key: "level"}}},
+				{Name: "TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "creds"}, Key: "token"}}},
+				{Name: "MISSING_KEY", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Key: "missing", Optional: &optional}}},
+				{Name: "MISSING_SECRET", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "other"}, Key: "token", Optional: &optional}}},
+			}},
+			want: map[string]string{"LEVEL": "debug", "TOKEN": "abc"},
+		},
+		{
+			name:  "required key missing",
+			files: map[string]string{"configmap/default/app/level": "debug"},
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "LEVEL", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Key: "missing"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name:  "required configmap missing",
+			files: map[string]string{"configmap/default/app/level": "debug"},
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "LEVEL", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "other"}, Key: "level"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "read from pod volume with items",
+			files: map[string]string{
+				"secret/default/db-creds/pw":  "secret",
+				"configmap/default/conf/mode": "prod",
+			},
+			volumes: []v1.Volume{
+				{Name: "db-creds", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "creds", Items: []v1.KeyToPath{{Key: "password", Path: "pw"}}}}},
+				{Name: "conf", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}},
+			},
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "PASSWORD", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "creds"}, Key: "password"}}},
+				{Name: "MODE", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "app"}, Key: "mode"}}},
+			}},
+			want: map[string]string{"PASSWORD": "secret", "MODE": "prod"},
+		},
+		{
+			name: "pod volume wins over volume named after configmap",
+			files: map[string]string{
+				"configmap/default/app/MODE":  "from other",
+				"configmap/default/conf/MODE": "prod",
+			},
+			volumes: []v1.Volume{
+				{Name: "app", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "other"}}}},
+				{Name: "conf", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}},
+			},
+			container: v1.Container{EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "app"}}}}},
+			want:      map[string]string{"MODE": "prod"},
+		},
+		{
+			name: "field refs",
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "POD_NAME", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+				{Name: "POD_NAMESPACE", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+				{Name: "APP", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "metadata.labels['app']"}}},
+				{Name: "NODE_NAME", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+				{Name: "POD_IP", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+				{Name: "HOST_IP", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "status.hostIP"}}},
+			}},
+			want: map[string]string{
+				"POD_NAME":      "web",
+				"POD_NAMESPACE": "default",
+				"APP":           "nginx",
+				"NODE_NAME":     "edge-1",
+				"POD_IP":        "192.168.1.10",
+				"HOST_IP":       "192.168.1.10",
+			},
+		},
+		{
+			name: "unsupported field ref",
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "X", ValueFrom: &v1.EnvVarSource{FieldRef: &v1.ObjectFieldSelector{FieldPath: "spec.unknown"}}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "resource field refs",
+			container: v1.Container{
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse("500m"),
+						v1.ResourceMemory: resource.MustParse("128Mi"),
+					},
+					Requests: v1.ResourceList{
+						v1.ResourceCPU: resource.MustParse("250m"),
+					},
+				},
+				Env: []v1.EnvVar{
+					{Name: "CPU_LIMIT", ValueFrom: &v1.EnvVarSource{ResourceFieldRef: &v1.ResourceFieldSelector{Resource: "limits.cpu"}}},
+					{Name: "CPU_REQUEST", ValueFrom: &v1.EnvVarSource{ResourceFieldRef: &v1.ResourceFieldSelector{Resource: "requests.cpu", Divisor: resource.MustParse("1m")}}},
+					{Name: "MEMORY_LIMIT", ValueFrom: &v1.EnvVarSource{ResourceFieldRef: &v1.ResourceFieldSelector{Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}}},
+					{Name: "MEMORY_REQUEST", ValueFrom: &v1.EnvVarSource{ResourceFieldRef: &v1.ResourceFieldSelector{Resource: "requests.memory"}}},
+				},
+			},
+			want: map[string]string{"CPU_LIMIT": "1", "CPU_REQUEST": "250", "MEMORY_LIMIT": "128", "MEMORY_REQUEST": "0"},
+		},
+		{
+			name: "unsupported resource field ref",
+			container: v1.Container{Env: []v1.EnvVar{
+				{Name: "X", ValueFrom: &v1.EnvVarSource{ResourceFieldRef: &v1.ResourceFieldSelector{Resource: "limits.gpu"}}},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := config.Config{VolumePath: t.TempDir(), IPAddress: "192.168.1.10"}
+			writeVolumeFiles(t, conf.VolumePath, tt.files)
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: map[string]string{"app": "nginx"}},
+				Spec:       v1.PodSpec{NodeName: "edge-1", Volumes: tt.volumes},
+			}
+			got, err := MakeEnvironmentVariables(conf, pod, tt.container)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MakeEnvironmentVariables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MakeEnvironmentVariables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+//没有设置limits时使用节点的资源
+func Test_containerResourceRuntimeValue_hostAllocatable(t *testing.T) {
+	got, err := containerResourceRuntimeValue(&v1.ResourceFieldSelector{Resource: "limits.cpu"}, v1.Container{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hostAllocatable()[v1.ResourceCPU]
+	if got != want.String() {
+		t.Errorf("limits.cpu = %s, want %s", got, want.String())
+	}
+}
@@ -0,0 +1,118 @@
+package podutil
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/internal/edgelet/podmanager/config"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	followInterval = 500 * time.Millisecond
+	tailBlockSize  = 4096
+)
+
+//LogPath 容器的日志文件, 同一个pod的日志文件有相同的前缀
+func LogPath(conf config.Config, namespace, podName, containerName string) string {
+	return filepath.Join(conf.LogRoot(), namespace+"_"+podName+"_"+containerName+".log")
+}
+
+//OpenLogFile 按ContainerLogOptions读取日志文件, 文件中没有时间戳, Timestamps/SinceTime/SinceSeconds不生效
+func OpenLogFile(ctx context.Context, path string, opts *pb.ContainerLogOptions) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Tail > 0 {
+		offset, err := tailOffset(f, int(opts.Tail))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	var r io.ReadCloser = f
+	if opts.Follow {
+		r = &followReader{ctx: ctx, f: f}
+	}
+	if opts.LimitBytes > 0 {
+		r = &limitReadCloser{Reader: io.LimitReader(r, int64(opts.LimitBytes)), Closer: r}
+	}
+	return r, nil
+}
+
+//RemoveLogs pod删除后清理日志文件
+func RemoveLogs(conf config.Config, namespace, podName string) {
+	files, _ := filepath.Glob(LogPath(conf, namespace, podName, "*"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+}
+
+//从文件末尾向前找到倒数第n行的起始位置
+func tailOffset(f *os.File, n int) (int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := stat.Size()
+	end := size
+	lines := 0
+	buf := make([]byte, tailBlockSize)
+	for end > 0 {
+		start := end - tailBlockSize
+		if start < 0 {
+			start = 0
+		}
+		block := buf[:end-start]
+		if _, err := f.ReadAt(block, start); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			if block[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			lines++
+			if lines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+//读到文件末尾时等待新的内容, 直到ctx结束
+type followReader struct {
+	ctx context.Context
+	f   *os.File
+}
+
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.f.Read(p)
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
+		}
+		select {
+		case <-fr.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
+
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,148 @@
+package podutil
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLogFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "default_web_app.log")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_tailOffset(t *testing.T) {
+	//跨越多个block的文件
+	var long []string
+	for i := 0; i < 2000; i++ {
+		long = append(long, strings.Repeat("x", 10))
+	}
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    string
+	}{
+		{name: "empty file", content: "", n: 1, want: ""},
+		{name: "last line", content: "a\nb\nc\n", n: 1, want: "c\n"},
+		{name: "last two lines", content: "a\nb\nc\n", n: 2, want: "b\nc\n"},
+		{name: "without trailing newline", content: "a\nb\nc", n: 2, want: "b\nc"},
+		{name: "more than lines", content: "a\nb\n", n: 10, want: "a\nb\n"},
+		{name: "empty lines", content: "a\n\n\n", n: 2, want: "\n\n"},
+		{name: "multiple blocks", content: strings.Join(long, "\n") + "\n", n: 1000, want: strings.Join(long[1000:], "\n") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(writeLogFile(t, tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			offset, err := tailOffset(f, tt.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.content[offset:]; got != tt.want {
+				t.Errorf("tailOffset() = %d, content %q, want %q", offset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenLogFile(t *testing.T) {
+	path := writeLogFile(t, "line1\nline2\nline3\n")
+	tests := []struct {
+		name string
+		opts *pb.ContainerLogOptions
+		want string
+	}{
+		{name: "all", opts: &pb.ContainerLogOptions{}, want: "line1\nline2\nline3\n"},
+		{name: "tail", opts: &pb.ContainerLogOptions{Tail: 2}, want: "line2\nline3\n"},
+		{name: "limit bytes", opts: &pb.ContainerLogOptions{LimitBytes: 8}, want: "line1\nli"},
+		{name: "tail and limit bytes", opts: &pb.ContainerLogOptions{Tail: 1, LimitBytes: 4}, want: "line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := OpenLogFile(context.Background(), path, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := OpenLogFile(context.Background(), path+".missing", &pb.ContainerLogOptions{}); !os.IsNotExist(err) {
+		t.Errorf("OpenLogFile() missing file error = %v, want not exist", err)
+	}
+}
+
+//follow时读到新写入的内容, ctx结束后返回EOF
+func Test_followReader(t *testing.T) {
+	path := writeLogFile(t, "line1\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, err := OpenLogFile(ctx, path, &pb.ContainerLogOptions{Follow: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 64)
+	n, err := r.Read(buf)
+	if err != nil || string(buf[:n]) != "line1\n" {
+		t.Fatalf("Read() = %q, %v", buf[:n], err)
+	}
+	go func() {
+		time.Sleep(followInterval / 2)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return
+		}
+		f.WriteString("line2\n")
+		f.Close()
+	}()
+	n, err = r.Read(buf)
+	if err != nil || string(buf[:n]) != "line2\n" {
+		t.Fatalf("Read() after append = %q, %v", buf[:n], err)
+	}
+
+	cancel()
+	if n, err := r.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read() after cancel = %d, %v, want EOF", n, err)
+	}
+}
+
+//limitReadCloser关闭时关闭底层的文件
+func Test_limitReadCloser(t *testing.T) {
+	path := writeLogFile(t, "line1\n")
+	r, err := OpenLogFile(context.Background(), path, &pb.ContainerLogOptions{Follow: true, LimitBytes: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != "lin" {
+		t.Fatalf("ReadAll() = %q, %v", got, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fr := r.(*limitReadCloser).Closer.(*followReader)
+	if _, err := fr.f.Read(make([]byte, 1)); err == nil {
+		t.Error("file is not closed")
+	}
+}
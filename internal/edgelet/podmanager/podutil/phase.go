@@ -0,0 +1,137 @@
+package podutil
+
+import (
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+//容器等待的原因, 与kubelet一致
+const (
+	ContainerCreating    = "ContainerCreating"
+	PodInitializing      = "PodInitializing"
+	ErrImagePull         = "ErrImagePull"
+	ImagePullBackOff     = "ImagePullBackOff"
+	CrashLoopBackOff     = "CrashLoopBackOff"
+	ErrImageNeverPull    = "ErrImageNeverPull"
+	CreateContainerError = "CreateContainerError"
+	CreateConfigError    = "CreateContainerConfigError"
+)
+
+func containerStatus(statuses []v1.ContainerStatus, name string) (v1.ContainerStatus, bool) {
+	for _, s := range statuses {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return v1.ContainerStatus{}, false
+}
+
+//GetPhase 根据容器状态和restartPolicy计算pod的phase, 与kubelet的getPhase一致
+func GetPhase(spec *v1.PodSpec, initStatuses, statuses []v1.ContainerStatus) v1.PodPhase {
+	pendingInitialization := 0
+	failedInitialization := 0
+	for _, container := range spec.InitContainers {
+		status, ok := containerStatus(initStatuses, container.Name)
+		if !ok {
+			pendingInitialization++
+			continue
+		}
+		switch {
+		case status.State.Running != nil:
+			pendingInitialization++
+		case status.State.Terminated != nil:
+			if status.State.Terminated.ExitCode != 0 {
+				failedInitialization++
+			}
+		case status.State.Waiting != nil:
+			if status.LastTerminationState.Terminated != nil {
+				if status.LastTerminationState.Terminated.ExitCode != 0 {
+					failedInitialization++
+				}
+			} else {
+				pendingInitialization++
+			}
+		default:
+			pendingInitialization++
+		}
+	}
+
+	unknown := 0
+	running := 0
+	waiting := 0
+	stopped := 0
+	succeeded := 0
+	for _, container := range spec.Containers {
+		status, ok := containerStatus(statuses, container.Name)
+		if !ok {
+			unknown++
+			continue
+		}
+		switch {
+		case status.State.Running != nil:
+			running++
+		case status.State.Terminated != nil:
+			stopped++
+			if status.State.Terminated.ExitCode == 0 {
+				succeeded++
+			}
+		case status.State.Waiting != nil:
+			if status.LastTerminationState.Terminated != nil {
+				stopped++
+			} else {
+				waiting++
+			}
+		default:
+			unknown++
+		}
+	}
+
+	if failedInitialization > 0 && spec.RestartPolicy == v1.RestartPolicyNever {
+		return v1.PodFailed
+	}
+
+	switch {
+	case pendingInitialization > 0:
+		fallthrough
+	case waiting > 0:
+		//还有容器没有启动
+		return v1.PodPending
+	case running > 0 && unknown == 0:
+		//所有容器都已启动, 至少有一个在运行
+		return v1.PodRunning
+	case running == 0 && stopped > 0 && unknown == 0:
+		//所有容器都已退出
+		if spec.RestartPolicy == v1.RestartPolicyAlways {
+			return v1.PodRunning
+		}
+		if stopped == succeeded {
+			return v1.PodSucceeded
+		}
+		if spec.RestartPolicy == v1.RestartPolicyNever {
+			return v1.PodFailed
+		}
+		//OnFailure时失败的容器会被重启
+		return v1.PodRunning
+	default:
+		return v1.PodPending
+	}
+}
+
+//IsTerminated pod已经运行结束, 不会再启动任何容器
+func IsTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+//FinishedAt pod中最后一个容器的退出时间
+func FinishedAt(pod *v1.Pod) (time.Time, bool) {
+	var finishedAt time.Time
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, s := range statuses {
+			if s.State.Terminated != nil && s.State.Terminated.FinishedAt.Time.After(finishedAt) {
+				finishedAt = s.State.Terminated.FinishedAt.Time
+			}
+		}
+	}
+	return finishedAt, !finishedAt.IsZero()
+}
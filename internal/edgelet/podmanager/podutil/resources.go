@@ -0,0 +1,41 @@
+package podutil
+
+const (
+	MinShares     = 2
+	SharesPerCPU  = 1024
+	MilliCPUToCPU = 1000
+)
+
+//MilliCPUToShares 与kubelet的MilliCPUToShares一致
+func MilliCPUToShares(milliCPU int64) int64 {
+	if milliCPU == 0 {
+		return MinShares
+	}
+	shares := milliCPU * SharesPerCPU / MilliCPUToCPU
+	if shares < MinShares {
+		return MinShares
+	}
+	return shares
+}
+
+func MilliCPUToNanoCPUs(milliCPU int64) int64 {
+	return milliCPU * 1e6
+}
+
+const (
+	//cfs period, 与kubelet的默认值一致
+	QuotaPeriod    = 100000
+	minQuotaPeriod = 1000
+)
+
+//MilliCPUToQuota 与kubelet的MilliCPUToQuota一致
+func MilliCPUToQuota(milliCPU int64, period int64) int64 {
+	if milliCPU == 0 {
+		return 0
+	}
+	quota := milliCPU * period / MilliCPUToCPU
+	if quota < minQuotaPeriod {
+		quota = minQuotaPeriod
+	}
+	return quota
+}
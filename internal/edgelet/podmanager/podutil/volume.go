@@ -0,0 +1,120 @@
+package podutil
+
+import (
+	"edge/api/edge-proto/pb"
+	"edge/internal/edgelet/podmanager/config"
+	"edge/pkg/util"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+//CreateVolume 把下发的volume落地到本地目录, configmap/secret的每个key写成一个文件
+func CreateVolume(conf config.Config, req *pb.CreateVolumeRequest) error {
+	for _, v := range req.Vols {
+		logrus.Info("CreateVolume ", v.Name)
+		switch vol := v.Volumn.(type) {
+		case *pb.EdgeVolume_EmptyDir:
+			path := filepath.Join(conf.EmptyDirRoot(), v.Name)
+			if util.IsFileExist(path) {
+				continue
+			}
+			err := os.MkdirAll(path, 0755)
+			if err != nil {
+				return fmt.Errorf("mkdir emptydir failed,err=%v", err)
+			}
+		case *pb.EdgeVolume_HostPath:
+			path := vol.HostPath.Path
+			if util.IsFileExist(path) {
+				logrus.Info("path is exist:", path)
+				continue
+			}
+			hostType := v1.HostPathType(vol.HostPath.HostType)
+			if hostType == v1.HostPathDirectory || hostType == v1.HostPathDirectoryOrCreate || hostType == v1.HostPathUnset {
+				err := os.MkdirAll(path, 0755)
+				if err != nil {
+					return fmt.Errorf("mkdir hostPath failed,err=%v", err)
+				}
+			} else if hostType == v1.HostPathFile || hostType == v1.HostPathFileOrCreate {
+				f, err := os.Create(path)
+				if err != nil {
+					return fmt.Errorf("mkdir hostPath failed,err=%v", err)
+				}
+				f.Close()
+			}
+		case *pb.EdgeVolume_ConfigMap:
+			dirpath := filepath.Join(conf.ConfigMapRoot(), vol.ConfigMap.Namespace, v.Name)
+			if err := os.MkdirAll(dirpath, 0755); err != nil {
+				return fmt.Errorf("mkdir configPath %s failed, err=%v", dirpath, err)
+			}
+			for name, data := range vol.ConfigMap.Items {
+				path := filepath.Join(dirpath, name)
+				if util.IsFileExist(path) {
+					continue
+				}
+				f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
+				if err != nil {
+					return fmt.Errorf("create configmap failed,err=%v", err)
+				}
+				_, err = f.WriteString(data)
+				if err != nil {
+					return fmt.Errorf("write configmap %s failed,err=%v", name, err)
+				}
+				f.Close()
+			}
+		case *pb.EdgeVolume_Secret:
+			dirpath := filepath.Join(conf.SecretRoot(), vol.Secret.Namespace, v.Name)
+			if err := os.MkdirAll(dirpath, 0755); err != nil {
+				return fmt.Errorf("mkdir secretPath %s failed, err=%v", dirpath, err)
+			}
+			for name, data := range vol.Secret.Items {
+				path := filepath.Join(dirpath, name)
+				if util.IsFileExist(path) {
+					continue
+				}
+				f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0755)
+				if err != nil {
+					return fmt.Errorf("create secret failed,err=%v", err)
+				}
+				_, err = f.Write(data)
+				if err != nil {
+					return fmt.Errorf("write secret %s failed,err=%v", name, err)
+				}
+				f.Close()
+			}
+		}
+	}
+	return nil
+}
+
+//VolumeSource 返回volumeMount在宿主机上对应的路径,configMap/secrets
+func VolumeSource(conf config.Config, pod *v1.Pod, mount v1.VolumeMount) string {
+	var index int = 0
+	for i, vo := range pod.Spec.Volumes {
+		if vo.Name == mount.Name {
+			index = i
+			break
+		}
+	}
+	vo := pod.Spec.Volumes[index]
+	path := ""
+	if vo.HostPath != nil {
+		path = vo.HostPath.Path
+	}
+	if vo.ConfigMap != nil {
+		path = filepath.Join(conf.ConfigMapRoot(), pod.Namespace, vo.Name)
+	}
+	if vo.Secret != nil {
+		path = filepath.Join(conf.SecretRoot(), pod.Namespace, vo.Name)
+	}
+	if vo.EmptyDir != nil {
+		path = filepath.Join(conf.EmptyDirRoot(), vo.Name)
+	}
+	if mount.SubPath != "" {
+		path = filepath.Join(path, mount.SubPath)
+	}
+	return path
+}
@@ -0,0 +1,18 @@
+package stream
+
+import "io"
+
+// TermSize is the size of the tty window
+type TermSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// AttachIO is used to pass the standard streams of an exec session between edgelet and the container runtime
+type AttachIO interface {
+	Stdin() io.Reader
+	Stdout() io.WriteCloser
+	Stderr() io.WriteCloser
+	TTY() bool
+	Resize() <-chan TermSize
+}
@@ -1,9 +1,13 @@
 package edgelet
 
 import (
+	"crypto/tls"
 	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
 	"edge/internal/edgelet/service"
 	"edge/pkg/common"
+	"edge/pkg/grpcauth"
+	"edge/pkg/tunnel"
 	"net"
 	"os"
 	"os/signal"
@@ -19,15 +23,23 @@ func Run(runAddress, version string) {
 	common.InitLogger()
 	logrus.Info("edgelet version:", version)
 
-	grpcServer := grpc.NewServer()
 	edgelet := service.NewEdgelet(version)
-	//健康检测
-	health := health.NewServer()
-	health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
-	healthpb.RegisterHealthServer(grpcServer, health)
-	pb.RegisterEdgeletServer(grpcServer, edgelet)
-	pb.RegisterEdgeadmServer(grpcServer, edgelet)
-
+	auth := edgelet.AuthConfig()
+	//节点证书由同一个CA签发, 一个节点不能访问其他节点的edgelet, TCP和反向通道使用同一个server
+	opts, err := grpcauth.ServerOptions(auth, grpcauth.DenyNodes())
+	if err != nil {
+		logrus.Fatal("failed to load grpc credentials: ", err)
+	}
+	//没有配置客户端证书和token时任何人都可以调用exec/upgrade等接口, 只监听本机地址
+	if !auth.Authenticated() {
+		if runAddress, err = loopbackAddress(runAddress); err != nil {
+			logrus.Fatal("invalid listen address: ", err)
+		}
+		logrus.Warn("neither client certificate nor token is configured, edgelet only listens on ", runAddress)
+	} else if !auth.TLSEnabled() {
+		logrus.Warn("tls is not configured, edgelet listens on ", runAddress, " in plaintext")
+	}
+	grpcServer := newGrpcServer(edgelet, opts...)
 	listen, err := net.Listen("tcp4", runAddress)
 	if err != nil {
 		logrus.Fatal("failed to listen: ", err)
@@ -39,12 +51,77 @@ func Run(runAddress, version string) {
 		}
 	}()
 
+	//本机的edgectl通过unix socket访问, 不需要证书
+	localServer := newGrpcServer(edgelet)
+	localListen, err := listenUnix(constant.EdgeletSocket)
+	if err != nil {
+		logrus.Fatal("failed to listen: ", err)
+	}
+	go func() {
+		if err := localServer.Serve(localListen); err != nil {
+			logrus.Fatal("failed to serve:", err)
+		}
+	}()
+
+	//节点在NAT后面时云端无法直接访问, 通过反向通道提供相同的grpc服务
+	var tunnelTLS *tls.Config
+	if auth.TLSEnabled() {
+		if tunnelTLS, err = auth.ClientTLSConfig(); err != nil {
+			logrus.Fatal("failed to load tunnel credentials: ", err)
+		}
+	}
+	stopCh := make(chan struct{})
+	go tunnel.NewAgent(edgelet.TunnelTarget, grpcServer, tunnelTLS, auth.Token).Run(stopCh)
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logrus.Info("Shutting down server...")
+	close(stopCh)
 	edgelet.Stop()
 	grpcServer.Stop()
+	localServer.Stop()
 	logrus.Info("Server exiting")
 }
+
+type edgeletServer interface {
+	pb.EdgeletServer
+	pb.EdgeadmServer
+}
+
+func newGrpcServer(edgelet edgeletServer, opts ...grpc.ServerOption) *grpc.Server {
+	grpcServer := grpc.NewServer(opts...)
+	//健康检测
+	health := health.NewServer()
+	health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(grpcServer, health)
+	pb.RegisterEdgeletServer(grpcServer, edgelet)
+	pb.RegisterEdgeadmServer(grpcServer, edgelet)
+	return grpcServer
+}
+
+//loopbackAddress 保留端口, 只监听127.0.0.1
+func loopbackAddress(address string) (string, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort("127.0.0.1", port), nil
+}
+
+//listenUnix 删除上次运行残留的socket文件, 只允许root访问
+func listenUnix(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listen, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listen.Close()
+		return nil, err
+	}
+	return listen, nil
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"edge/api/edge-proto/pb"
+	"edge/internal/edgelet/podmanager/stream"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//execAttach 把grpc的双向流适配成podmanager需要的AttachIO
+type execAttach struct {
+	stream    pb.Edgelet_RunInContainerServer
+	sendMutex sync.Mutex
+	tty       bool
+	stdinR    *io.PipeReader
+	stdinW    *io.PipeWriter
+	resize    chan stream.TermSize
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newExecAttach(s pb.Edgelet_RunInContainerServer, req *pb.RunInContainerRequest) *execAttach {
+	ea := &execAttach{
+		stream: s,
+		tty:    req.Tty,
+		done:   make(chan struct{}),
+	}
+	if req.AttachStdin {
+		ea.stdinR, ea.stdinW = io.Pipe()
+	}
+	if req.Tty {
+		ea.resize = make(chan stream.TermSize, 1)
+		if req.Resize != nil {
+			ea.resize <- stream.TermSize{Width: uint16(req.Resize.Width), Height: uint16(req.Resize.Height)}
+		}
+	}
+	return ea
+}
+
+//receive 持续读取客户端发来的stdin和resize，直到流结束
+func (ea *execAttach) receive() {
+	defer func() {
+		if ea.stdinW != nil {
+			ea.stdinW.Close()
+		}
+	}()
+	for {
+		req, err := ea.stream.Recv()
+		if err != nil {
+			if err != io.EOF {
+				log.Info("RunInContainer receive exit for err=", err)
+			}
+			return
+		}
+		if len(req.Stdin) > 0 && ea.stdinW != nil {
+			if _, err := ea.stdinW.Write(req.Stdin); err != nil {
+				log.Warn("RunInContainer write stdin failed,err=", err)
+				return
+			}
+		}
+		if req.StdinClosed && ea.stdinW != nil {
+			ea.stdinW.Close()
+		}
+		if req.Resize != nil && ea.resize != nil {
+			select {
+			case ea.resize <- stream.TermSize{Width: uint16(req.Resize.Width), Height: uint16(req.Resize.Height)}:
+			case <-ea.done:
+				return
+			}
+		}
+	}
+}
+
+func (ea *execAttach) send(resp *pb.RunInContainerResponse) error {
+	ea.sendMutex.Lock()
+	defer ea.sendMutex.Unlock()
+	return ea.stream.Send(resp)
+}
+
+func (ea *execAttach) close() {
+	ea.closeOnce.Do(func() {
+		close(ea.done)
+		if ea.stdinR != nil {
+			ea.stdinR.Close()
+		}
+	})
+}
+
+func (ea *execAttach) Stdin() io.Reader {
+	if ea.stdinR == nil {
+		return nil
+	}
+	return ea.stdinR
+}
+
+func (ea *execAttach) Stdout() io.WriteCloser {
+	return &execWriter{attach: ea, stderr: false}
+}
+
+func (ea *execAttach) Stderr() io.WriteCloser {
+	return &execWriter{attach: ea, stderr: true}
+}
+
+func (ea *execAttach) TTY() bool {
+	return ea.tty
+}
+
+func (ea *execAttach) Resize() <-chan stream.TermSize {
+	return ea.resize
+}
+
+type execWriter struct {
+	attach *execAttach
+	stderr bool
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	resp := &pb.RunInContainerResponse{Stdout: data}
+	if w.stderr {
+		resp = &pb.RunInContainerResponse{Stderr: data}
+	}
+	if err := w.attach.send(resp); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *execWriter) Close() error {
+	return nil
+}
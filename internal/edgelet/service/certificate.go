@@ -0,0 +1,118 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edge/internal/constant"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+var (
+	pkiPath      = filepath.Join(constant.EdgeletCfgPath, "pki")
+	certFileName = "edgelet.crt"
+	keyFileName  = "edgelet.key"
+	caFileName   = "ca.crt"
+	//等待审批时申请证书使用的私钥
+	pendingKeyFileName = "edgelet-pending.key"
+)
+
+//newCertificateRequest 生成节点的CSR, CN为节点名称, 证书同时用于edgelet的服务端.
+//keyPEM为空时生成新的私钥, 等待审批的节点再次join时使用第一次join时的私钥, edge-registry据此确认是同一个节点
+func newCertificateRequest(nodeName, ipAddress string, keyPEM []byte) (csrPEM, newKeyPEM []byte, err error) {
+	var key *ecdsa.PrivateKey
+	if len(keyPEM) > 0 {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, nil, fmt.Errorf("invalid pending private key")
+		}
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, nil, err
+		}
+	}
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: nodeName},
+		DNSNames: []string{nodeName},
+	}
+	if ip := net.ParseIP(ipAddress); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+//loadPendingKey 读取等待审批时保存的私钥, 不存在时返回nil
+func loadPendingKey() ([]byte, error) {
+	key, err := os.ReadFile(filepath.Join(pkiPath, pendingKeyFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return key, err
+}
+
+//savePendingKey 等待审批的节点没有证书, 保存私钥用于审批通过后再次join
+func savePendingKey(key []byte) error {
+	if err := os.MkdirAll(pkiPath, 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(pkiPath, pendingKeyFileName), key, 0600)
+}
+
+func removePendingKey() error {
+	err := os.Remove(filepath.Join(pkiPath, pendingKeyFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//saveCertificate 保存edge-registry签发的证书, 更新config中的证书路径, 调用者需要持有configMutex
+func (ec *EdgeletConfig) saveCertificate(cert, key, ca []byte) error {
+	if err := os.MkdirAll(pkiPath, 0700); err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		filepath.Join(pkiPath, certFileName): cert,
+		filepath.Join(pkiPath, keyFileName):  key,
+	}
+	if len(ca) > 0 {
+		files[filepath.Join(pkiPath, caFileName)] = ca
+	}
+	for path, data := range files {
+		if err := writeFileAtomic(path, data, 0600); err != nil {
+			return err
+		}
+	}
+	ec.Auth.CertFile = filepath.Join(pkiPath, certFileName)
+	ec.Auth.KeyFile = filepath.Join(pkiPath, keyFileName)
+	if ec.Auth.CAFile == "" && len(ca) > 0 {
+		ec.Auth.CAFile = filepath.Join(pkiPath, caFileName)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -2,19 +2,55 @@ package service
 
 import (
 	"edge/internal/constant"
+	pmconf "edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/upgrade"
+	"edge/pkg/grpcauth"
 	"edge/pkg/util"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 )
 
 type EdgeletConfig struct {
 	RegistryAddress string `json:"registryAddress"`
 	DiskPath        string `json:"diskPath"`
 	NodeName        string `json:"nodeName"`
+	//节点最多运行的pod数量
+	MaxPods int `json:"maxPods"`
+	//为系统守护进程预留的资源, 如 {"cpu": "200m", "memory": "256Mi"}
+	SystemReserved map[string]string `json:"systemReserved"`
+	//为edgelet/docker等组件预留的资源, 格式同SystemReserved
+	KubeReserved map[string]string `json:"kubeReserved"`
+	//容器运行时: docker(默认) 或 containerd
+	Runtime    string           `json:"runtime"`
+	Containerd ContainerdConfig `json:"containerd"`
+	//运行结束的pod(如Job)保留容器的时间, 超时后删除容器, 状态和日志仍然保留, 如 "1h", "0"表示不删除
+	CompletedPodTTL string `json:"completedPodTTL"`
+	//云端tunnel server的地址, 如 "center.edge.com:10351", 节点在NAT后面时由edgelet主动连接云端, 为空时不启用
+	TunnelAddress string `json:"tunnelAddress"`
+	//grpc的证书和token, 同时用于edgelet的服务端和访问edge-registry的客户端, 证书为空时使用明文
+	Auth grpcauth.Config `json:"auth"`
+	//校验升级包签名的ed25519公钥(PEM), 配置后升级必须提供签名
+	UpgradePublicKeyFile string `json:"upgradePublicKeyFile"`
+	//升级后edgelet通过健康检测的时间, 超时后回滚, 如 "2m"
+	UpgradeHealthTimeout string `json:"upgradeHealthTimeout"`
+	//允许远程执行的action的配置文件, 为空时使用/data/edgelet/.conf/actions.json
+	ActionsFile string `json:"actionsFile"`
+	//action的审计日志, 为空时使用/data/edgelet/audit/actions.log
+	ActionAuditLog string `json:"actionAuditLog"`
+}
+
+type ContainerdConfig struct {
+	Address     string `json:"address"`
+	Namespace   string `json:"namespace"`
+	Snapshotter string `json:"snapshotter"`
 }
 
 const (
@@ -26,16 +62,27 @@ var (
 	defaultConfig = EdgeletConfig{
 		RegistryAddress: constant.CenterDomain,
 		DiskPath:        "/",
+		MaxPods:         defaultMaxPods,
+		SystemReserved:  map[string]string{},
+		KubeReserved:    map[string]string{},
+		Runtime:         pmconf.RuntimeDocker,
+		CompletedPodTTL: pmconf.DefaultCompletedPodTTL.String(),
 	}
 )
 
+const (
+	defaultMaxPods = 110
+	//配置中包含bearer token, 只允许root读写
+	configFileMode = 0600
+)
+
 func configReady(file string) error {
 	if !util.IsFileExist(file) {
 		err := os.MkdirAll(configPath, 0755)
 		if err != nil {
 			return err
 		}
-		f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR|os.O_TRUNC, configFileMode)
 		if err != nil {
 			return err
 		}
@@ -46,7 +93,8 @@ func configReady(file string) error {
 		}
 		f.Write(data)
 	}
-	return nil
+	//旧版本创建的配置文件为0755
+	return os.Chmod(file, configFileMode)
 }
 
 func initConfig() (*EdgeletConfig, error) {
@@ -72,8 +120,84 @@ func initConfig() (*EdgeletConfig, error) {
 	return ec, nil
 }
 
+//AuthConfig edgelet启动时读取一次, 修改证书需要重启edgelet
+func (e *edgelet) AuthConfig() grpcauth.Config {
+	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	return e.config.Auth
+}
+
+func (ec *EdgeletConfig) maxPods() int {
+	if ec.MaxPods <= 0 {
+		return defaultMaxPods
+	}
+	return ec.MaxPods
+}
+
+func (ec *EdgeletConfig) completedPodTTL() (time.Duration, error) {
+	if ec.CompletedPodTTL == "" {
+		return pmconf.DefaultCompletedPodTTL, nil
+	}
+	ttl, err := time.ParseDuration(ec.CompletedPodTTL)
+	if err != nil {
+		return pmconf.DefaultCompletedPodTTL, fmt.Errorf("invalid completedPodTTL %s, err=%v", ec.CompletedPodTTL, err)
+	}
+	if ttl < 0 {
+		return pmconf.DefaultCompletedPodTTL, fmt.Errorf("completedPodTTL %s must not be negative", ec.CompletedPodTTL)
+	}
+	return ttl, nil
+}
+
+func (ec *EdgeletConfig) upgradeHealthTimeout() (time.Duration, error) {
+	if ec.UpgradeHealthTimeout == "" {
+		return upgrade.DefaultHealthTimeout, nil
+	}
+	timeout, err := time.ParseDuration(ec.UpgradeHealthTimeout)
+	if err != nil {
+		return upgrade.DefaultHealthTimeout, fmt.Errorf("invalid upgradeHealthTimeout %s, err=%v", ec.UpgradeHealthTimeout, err)
+	}
+	if timeout <= 0 {
+		return upgrade.DefaultHealthTimeout, fmt.Errorf("upgradeHealthTimeout %s must be positive", ec.UpgradeHealthTimeout)
+	}
+	return timeout, nil
+}
+
+func (ec *EdgeletConfig) actionsFile() string {
+	if ec.ActionsFile == "" {
+		return constant.EdgeletActionsFile
+	}
+	return ec.ActionsFile
+}
+
+func (ec *EdgeletConfig) actionAuditLog() string {
+	if ec.ActionAuditLog == "" {
+		return constant.EdgeletActionAuditLog
+	}
+	return ec.ActionAuditLog
+}
+
+//systemReserved与kubeReserved之和
+func (ec *EdgeletConfig) reserved() (v1.ResourceList, error) {
+	reserved := v1.ResourceList{}
+	for _, rl := range []map[string]string{ec.SystemReserved, ec.KubeReserved} {
+		for name, value := range rl {
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid reserved %s=%s, err=%v", name, value, err)
+			}
+			if q.Sign() < 0 {
+				return nil, fmt.Errorf("reserved %s=%s must not be negative", name, value)
+			}
+			total := reserved[v1.ResourceName(name)]
+			total.Add(q)
+			reserved[v1.ResourceName(name)] = total
+		}
+	}
+	return reserved, nil
+}
+
 func (ec *EdgeletConfig) Save() error {
-	f, err := os.OpenFile(filepath.Join(configPath, configName), os.O_WRONLY|os.O_TRUNC, 0755)
+	f, err := os.OpenFile(filepath.Join(configPath, configName), os.O_WRONLY|os.O_TRUNC, configFileMode)
 	if err != nil {
 		return err
 	}
@@ -3,13 +3,16 @@ package service
 import (
 	"context"
 	"edge/api/edge-proto/pb"
-	"edge/internal/constant"
+	"edge/internal/edgelet/upgrade"
+	"edge/pkg/errdefs"
+	"edge/pkg/grpcauth"
 	"edge/pkg/protoerr"
-	"edge/pkg/util"
 	"fmt"
+	"runtime"
+	"time"
 
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
 )
 
 func (e *edgelet) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinResponse, error) {
@@ -30,11 +33,12 @@ func (e *edgelet) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinRespon
 		e.config.RegistryAddress = req.CloudAddress
 	}
 	e.configMutex.Unlock()
-	conn, err := grpc.Dial(e.config.RegistryAddress, grpc.WithInsecure()) //grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpcauth.Dial(e.config.RegistryAddress, e.AuthConfig())
 	if err != nil {
 		logrus.Errorf("grpc.Dial %s failed, err=%v", e.config.RegistryAddress, err)
 		return nil, err
 	}
+	defer conn.Close()
 	client := pb.NewEdgeRegistryServiceClient(conn)
 	if e.config.NodeName != "" && e.config.NodeName != req.NodeName {
 		getrsp, err := client.GetNode(ctx, &pb.GetNodeRequest{NodeName: e.config.NodeName})
@@ -55,17 +59,65 @@ func (e *edgelet) Join(ctx context.Context, req *pb.JoinRequest) (*pb.JoinRespon
 		}
 	}
 
-	cnresp, err := client.CreateNode(ctx, &pb.CreateNodeRequest{NodeName: req.NodeName})
+	cnreq := &pb.CreateNodeRequest{
+		NodeName:       req.NodeName,
+		Token:          req.Token,
+		Ip:             e.localIPAddress,
+		Os:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		EdgeletVersion: e.buildVersion,
+		Labels:         req.Labels,
+		Taints:         req.Taints,
+	}
+	//使用bootstrap token时向edge-registry申请证书, 之后访问云端使用证书认证.
+	//等待审批的节点再次join时使用相同的私钥, 审批通过后才会签发证书
+	pendingKey, err := loadPendingKey()
+	if err != nil {
+		logrus.Error("load pending private key failed,err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	var keyPEM []byte
+	if req.Token != "" || pendingKey != nil {
+		cnreq.Csr, keyPEM, err = newCertificateRequest(req.NodeName, e.localIPAddress, pendingKey)
+		if err != nil {
+			logrus.Error("create certificate request failed,err=", err)
+			resp.Error = protoerr.InternalErr(err)
+			return resp, nil
+		}
+	}
+	cnresp, err := client.CreateNode(ctx, cnreq)
 	if err != nil {
 		logrus.Error("CreateNode in Join failed,err=", err)
 		return nil, err
 	}
 	resp.Error = cnresp.Error
 	resp.Exist = cnresp.Exist
+	resp.Pending = cnresp.Pending
+	if resp.Error != nil {
+		return resp, nil
+	}
 	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	if len(cnresp.Certificate) > 0 {
+		if err := e.config.saveCertificate(cnresp.Certificate, keyPEM, cnresp.CaCertificate); err != nil {
+			logrus.Error("save certificate failed,err=", err)
+			resp.Error = protoerr.InternalErr(err)
+			return resp, nil
+		}
+		if err := removePendingKey(); err != nil {
+			logrus.Warn("remove pending private key failed,err=", err)
+		}
+		logrus.Infof("certificate for node %s saved to %s, restart edgelet to serve with it", req.NodeName, e.config.Auth.CertFile)
+	} else if cnresp.Pending && len(keyPEM) > 0 {
+		if err := savePendingKey(keyPEM); err != nil {
+			logrus.Error("save pending private key failed,err=", err)
+			resp.Error = protoerr.InternalErr(err)
+			return resp, nil
+		}
+	}
 	e.config.NodeName = req.NodeName
 	e.config.Save()
-	e.configMutex.Unlock()
 	return resp, nil
 }
 
@@ -77,11 +129,12 @@ func (e *edgelet) Reset(ctx context.Context, req *pb.ResetRequest) (*pb.ResetRes
 		resp.Error = protoerr.ParamErr("should to join before reset")
 	}
 
-	conn, err := grpc.Dial(e.config.RegistryAddress, grpc.WithInsecure()) //grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpcauth.Dial(e.config.RegistryAddress, e.AuthConfig())
 	if err != nil {
 		logrus.Error("connect failed,cloudAddress:", e.config.RegistryAddress, " err:", err)
 		return nil, err
 	}
+	defer conn.Close()
 	client := pb.NewEdgeRegistryServiceClient(conn)
 	delresp, err := client.DeleteNode(ctx, &pb.DeleteNodeRequest{NodeName: e.config.NodeName})
 	if err != nil {
@@ -103,26 +156,17 @@ func (e *edgelet) Upgrade(ctx context.Context, req *pb.UpgradeRequest) (*pb.Upgr
 	}
 
 	if req.Component == pb.EdgeComponent_EDGELET {
-		pberr := e.upgradeEdgelet(ctx, req.Image, req.ShellCmds)
-		if pberr != nil {
-			resp.Error = pberr
-		}
+		resp.JobId, resp.Error = e.upgradeEdgelet(ctx, req)
 		return resp, nil
 	}
 
 	if req.Component == pb.EdgeComponent_EDGECTL {
-		pberr := e.upgradeEdgectl(ctx, req.Image, req.ShellCmds)
-		if pberr != nil {
-			resp.Error = pberr
-		}
+		resp.JobId, resp.Error = e.upgradeEdgectl(ctx, req)
 		return resp, nil
 	}
 
 	if req.Component == pb.EdgeComponent_CUSTOMIZE {
-		err := util.RunLinuxCommands(false, req.ShellCmds...)
-		if err != nil {
-			resp.Error = protoerr.InternalErr(err)
-		}
+		resp.Error = protoerr.ParamErr("customized commands are not supported, run an action configured on the node instead")
 		return resp, nil
 	}
 
@@ -136,40 +180,129 @@ func (e *edgelet) ListVersion(ctx context.Context, req *pb.ListVersionRequest) (
 	return resp, nil
 }
 
-func (e *edgelet) upgradeEdgelet(ctx context.Context, image string, shellcmds []string) *pb.Error {
-	if len(shellcmds) == 0 {
-		if len(image) == 0 {
-			return protoerr.ParamErr("image is empty")
-		}
-		dockerRunCmd := fmt.Sprintf(constant.DockerCopyEdgeletCmd, image)
-		err := util.RunLinuxCommands(false, dockerRunCmd, constant.UpdageEdgeletCmd)
-		if err != nil {
-			return protoerr.InternalErr(err)
-		}
-	} else {
-		err := util.RunLinuxCommands(false, shellcmds...)
-		if err != nil {
-			return protoerr.InternalErr(err)
+//GetUpgradeStatus 查询升级任务, JobId为空时返回最近的任务
+func (e *edgelet) GetUpgradeStatus(ctx context.Context, req *pb.GetUpgradeStatusRequest) (*pb.GetUpgradeStatusResponse, error) {
+	resp := &pb.GetUpgradeStatusResponse{}
+	job, err := e.upgrader.GetJob(req.JobId)
+	if err != nil {
+		resp.Error = upgradeErr(err)
+		return resp, nil
+	}
+	resp.Job = upgradeJobToPB(job)
+	return resp, nil
+}
+
+//WatchUpgrade 任务的阶段变化时发送, 任务结束后返回. edgelet升级自身时连接会在重启时断开, 客户端需要重新watch
+func (e *edgelet) WatchUpgrade(req *pb.WatchUpgradeRequest, stream pb.Edgeadm_WatchUpgradeServer) error {
+	logrus.Info("WatchUpgrade request:", req)
+	err := e.upgrader.WatchJob(stream.Context(), req.JobId, func(job *upgrade.Job) error {
+		return stream.Send(&pb.WatchUpgradeResponse{Job: upgradeJobToPB(job)})
+	})
+	if err != nil && stream.Context().Err() == nil {
+		if errdefs.IsNotFound(err) || errdefs.IsInvalidInput(err) {
+			return stream.Send(&pb.WatchUpgradeResponse{Error: upgradeErr(err)})
 		}
+		logrus.Error("WatchUpgrade failed, err=", err)
+		return err
 	}
 	return nil
 }
 
-func (e *edgelet) upgradeEdgectl(ctx context.Context, image string, shellcmds []string) *pb.Error {
-	if len(shellcmds) == 0 {
-		if len(image) == 0 {
-			return protoerr.ParamErr("image is empty")
-		}
-		dockerRunCmd := fmt.Sprintf(constant.DockerCopyEdgectlCmd, image)
-		err := util.RunLinuxCommands(false, dockerRunCmd, constant.UpdateEdgectlCmd)
-		if err != nil {
-			return protoerr.InternalErr(err)
-		}
-	} else {
-		err := util.RunLinuxCommands(false, shellcmds...)
-		if err != nil {
-			return protoerr.InternalErr(err)
+func (e *edgelet) upgradeEdgelet(ctx context.Context, req *pb.UpgradeRequest) (string, *pb.Error) {
+	return e.upgradeComponent(ctx, upgrade.Edgelet, req)
+}
+
+func (e *edgelet) upgradeEdgectl(ctx context.Context, req *pb.UpgradeRequest) (string, *pb.Error) {
+	return e.upgradeComponent(ctx, upgrade.Edgectl, req)
+}
+
+//upgradeComponent 创建升级任务并返回任务的id
+func (e *edgelet) upgradeComponent(ctx context.Context, c upgrade.Component, req *pb.UpgradeRequest) (string, *pb.Error) {
+	if len(req.ShellCmds) > 0 {
+		return "", protoerr.ParamErr("customized commands are not supported, run an action configured on the node instead")
+	}
+	job, err := e.upgrader.Start(c, &upgrade.Request{
+		Image:     req.Image,
+		Sha256:    req.Sha256,
+		Signature: req.Signature,
+	})
+	if err != nil {
+		logrus.Errorf("upgrade %s failed, err=%v", c.Name, err)
+		return "", upgradeErr(err)
+	}
+	return job.ID, nil
+}
+
+//ListActions 节点上配置的action
+func (e *edgelet) ListActions(ctx context.Context, req *pb.ListActionsRequest) (*pb.ListActionsResponse, error) {
+	resp := &pb.ListActionsResponse{}
+	actions, err := e.actions.List()
+	if err != nil {
+		logrus.Error("ListActions failed, err=", err)
+		resp.Error = protoerr.InternalErr(err)
+		return resp, nil
+	}
+	for _, a := range actions {
+		pa := &pb.Action{Name: a.Name, Description: a.Description, Timeout: a.Timeout, User: a.User}
+		for _, p := range a.Params {
+			pa.Params = append(pa.Params, &pb.ActionParam{
+				Name:        p.Name,
+				Description: p.Description,
+				Pattern:     p.Pattern,
+				Required:    p.Required,
+				Default:     p.Default,
+			})
 		}
+		resp.Actions = append(resp.Actions, pa)
+	}
+	return resp, nil
+}
+
+//RunAction 执行配置的action, 命令返回非0时通过ExitCode返回, 不是错误
+func (e *edgelet) RunAction(ctx context.Context, req *pb.RunActionRequest) (*pb.RunActionResponse, error) {
+	logrus.Info("RunAction request:", req)
+	resp := &pb.RunActionResponse{}
+	result, err := e.actions.Run(ctx, actionCaller(ctx), req.Name, req.Params)
+	if result != nil {
+		resp.Stdout, resp.Stderr, resp.ExitCode = result.Stdout, result.Stderr, int32(result.ExitCode)
+	}
+	if err != nil {
+		logrus.Errorf("run action %s failed, err=%v", req.Name, err)
+		resp.Error = upgradeErr(err)
+	}
+	return resp, nil
+}
+
+//actionCaller 审计日志中的调用者, 客户端证书的CN或者对端地址
+func actionCaller(ctx context.Context) string {
+	if cert := grpcauth.PeerCertificate(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil && p.Addr.String() != "" {
+		return p.Addr.String()
+	}
+	return "local"
+}
+
+func upgradeErr(err error) *pb.Error {
+	if errdefs.IsInvalidInput(err) {
+		return protoerr.ParamErr(err.Error())
+	}
+	if errdefs.IsNotFound(err) {
+		return protoerr.NotFoundErr(err.Error())
+	}
+	return protoerr.InternalErr(err)
+}
+
+func upgradeJobToPB(job *upgrade.Job) *pb.UpgradeJob {
+	return &pb.UpgradeJob{
+		Id:         job.ID,
+		Component:  job.Component,
+		Image:      job.Image,
+		Phase:      job.Phase,
+		Message:    job.Message,
+		CreateTime: job.CreatedAt.Format(time.RFC3339),
+		UpdateTime: job.UpdatedAt.Format(time.RFC3339),
+		Finished:   job.Finished(),
 	}
-	return nil
 }
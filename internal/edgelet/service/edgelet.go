@@ -3,16 +3,21 @@ package service
 import (
 	"context"
 	"edge/api/edge-proto/pb"
+	"edge/internal/constant"
+	"edge/internal/edgelet/action"
 	"edge/internal/edgelet/podmanager"
 	"edge/internal/edgelet/podmanager/config"
+	"edge/internal/edgelet/upgrade"
 	"edge/pkg/errdefs"
 	"edge/pkg/protoerr"
 	"edge/pkg/util"
+	"encoding/json"
 	"fmt"
 	"io"
 	"runtime"
 	"sync"
 
+	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/mem"
@@ -20,6 +25,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
 )
 
 type edgelet struct {
@@ -32,6 +38,8 @@ type edgelet struct {
 	kernalVersion      string
 	OSIImage           string
 	buildVersion       string
+	upgrader           *upgrade.Manager
+	actions            *action.Manager
 }
 
 const (
@@ -50,13 +58,44 @@ func NewEdgelet(version string) *edgelet {
 		log.Panicf("init config %s, err=%v", configPath, err)
 	}
 	log.Info("config load success:", conf)
+	if _, err := conf.reserved(); err != nil {
+		log.Warn("reserved resources in config are ignored, err=", err)
+	}
+	ttl, err := conf.completedPodTTL()
+	if err != nil {
+		log.Warn("completedPodTTL in config is ignored, err=", err)
+	}
+	healthTimeout, err := conf.upgradeHealthTimeout()
+	if err != nil {
+		log.Warn("upgradeHealthTimeout in config is ignored, err=", err)
+	}
+	upgrader, err := upgrade.NewManager(constant.EdgeletUpgradePath, conf.UpgradePublicKeyFile, healthTimeout)
+	if err != nil {
+		log.Panicf("init upgrade manager failed, err=%v", err)
+	}
+	upgrader.Recover()
+	if jobs, _ := upgrader.ListJobs(upgrade.Edgelet.Name); len(jobs) > 0 && (jobs[0].Phase == upgrade.PhaseRolledBack || jobs[0].Phase == upgrade.PhaseRollbackFailed) {
+		log.Warnf("last upgrade job %s of edgelet %s: %s", jobs[0].ID, jobs[0].Phase, jobs[0].Message)
+	}
+	actions := action.NewManager(conf.actionsFile(), conf.actionAuditLog())
+	if _, err := actions.List(); err != nil {
+		log.Warn("actions are not available, err=", err)
+	}
+	pm := podmanager.New(
+		config.WithIPAddress(localaddress),
+		config.WithRuntime(conf.Runtime),
+		config.WithContainerd(config.ContainerdConfig(conf.Containerd)),
+		config.WithCompletedPodTTL(ttl),
+	)
 	return &edgelet{
 		kernalVersion:  kernalversion,
 		OSIImage:       platform,
 		localIPAddress: localaddress,
-		pm:             podmanager.New(config.WithIPAddress(localaddress)),
+		pm:             pm,
 		config:         conf,
 		buildVersion:   version,
+		upgrader:       upgrader,
+		actions:        actions,
 	}
 }
 
@@ -78,6 +117,11 @@ func (e *edgelet) CreateVolume(ctx context.Context, req *pb.CreateVolumeRequest)
 func (e *edgelet) CreatePod(ctx context.Context, req *pb.CreatePodRequest) (*pb.CreatePodResponse, error) {
 	log := log.WithField("pod", req.Pod.Name)
 	resp := &pb.CreatePodResponse{}
+	if err := e.admitPod(ctx, req.Pod); err != nil {
+		log.Error("CreatePod admit failed, err=", err)
+		resp.Error = &pb.Error{Code: pb.ErrorCode_INTERNAL_ERROR, Msg: err.Error()}
+		return resp, nil
+	}
 	pod, err := e.pm.CreatePod(ctx, req.Pod)
 	if err != nil {
 		log.Error("CreatePod failed, err=", err)
@@ -185,12 +229,57 @@ func (e *edgelet) GetContainerLogStream(req *pb.GetContainerLogsRequest, stream
 	return nil
 }
 
-func (e *edgelet) RunInContainer(ctx context.Context, req *pb.RunInContainerRequest) (*pb.RunInContainerResponse, error) {
-	return &pb.RunInContainerResponse{}, nil
+//第一个请求携带要执行的命令，之后的请求只携带stdin数据和tty的resize事件
+func (e *edgelet) RunInContainer(stream pb.Edgelet_RunInContainerServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		log.Error("RunInContainer receive first request failed, err=", err)
+		return err
+	}
+	log.Info("RunInContainer :", req)
+	if len(req.Cmd) == 0 {
+		return stream.Send(&pb.RunInContainerResponse{Error: protoerr.ParamErr("cmd is empty")})
+	}
+
+	ctx := stream.Context()
+	attach := newExecAttach(stream, req)
+	go attach.receive()
+
+	err = e.pm.RunInContainer(ctx, req.Namespace, req.Name, req.ContainerName, req.Cmd, attach)
+	attach.close()
+	if err != nil {
+		log.Error("RunInContainer failed, err=", err)
+		return attach.send(&pb.RunInContainerResponse{Error: protoerr.InternalErr(err)})
+	}
+	err = attach.send(&pb.RunInContainerResponse{Error: protoerr.StreamFinishErr("EOF")})
+	if err != nil {
+		log.Warn("RunInContainer send EOF failed err=", err)
+	}
+	log.Info("RunInContainer is exit")
+	return nil
 }
 
+//返回kubelet stats/v1alpha1格式的Summary，virtual-kubelet可以直接使用
 func (e *edgelet) GetStatsSummary(ctx context.Context, req *pb.GetStatsSummaryRequest) (*pb.GetStatsSummaryResponse, error) {
-	return &pb.GetStatsSummaryResponse{}, nil
+	log.Info("GetStatsSummary")
+	resp := &pb.GetStatsSummaryResponse{}
+	podStats, err := e.pm.GetPodStats(ctx)
+	if err != nil {
+		log.Error("GetPodStats failed, err=", err)
+		resp.Error = &pb.Error{Code: pb.ErrorCode_INTERNAL_ERROR, Msg: err.Error()}
+		return resp, nil
+	}
+	summary := stats.Summary{
+		Node: e.nodeStats(),
+		Pods: podStats,
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		resp.Error = &pb.Error{Code: pb.ErrorCode_INTERNAL_ERROR, Msg: err.Error()}
+		return resp, nil
+	}
+	resp.Summary = data
+	return resp, nil
 }
 
 func (e *edgelet) DescribeNodeStatus(ctx context.Context, req *pb.DescribeNodeStatusRequest) (*pb.DescribeNodeStatusResponse, error) {
@@ -209,12 +298,12 @@ func (e *edgelet) DescribeNodeStatus(ctx context.Context, req *pb.DescribeNodeSt
 }
 
 func (e *edgelet) configNode() *v1.Node {
-	ms, _ := mem.VirtualMemory()
+	capacity := e.capacity()
 	node := &v1.Node{
 		Status: v1.NodeStatus{
 			Phase:       v1.NodeRunning,
-			Capacity:    e.capacity(ms),
-			Allocatable: e.allocatable(ms),
+			Capacity:    capacity,
+			Allocatable: e.allocatable(capacity),
 			Conditions:  e.nodeConditions(),
 			Addresses:   e.nodeAddresses(),
 			NodeInfo: v1.NodeSystemInfo{
@@ -230,28 +319,69 @@ func (e *edgelet) configNode() *v1.Node {
 }
 
 // Capacity returns a resource list containing the capacity limits.
-func (e *edgelet) capacity(minfo *mem.VirtualMemoryStat) v1.ResourceList {
-	var total uint64 = 100
-	if minfo != nil {
-		total = minfo.Total / MiB
+func (e *edgelet) capacity() v1.ResourceList {
+	cores, err := cpu.Counts(true)
+	if err != nil || cores <= 0 {
+		cores = runtime.NumCPU()
 	}
-	return v1.ResourceList{
-		"cpu":    resource.MustParse("100"),
-		"memory": resource.MustParse(fmt.Sprintf("%dMi", total)),
-		"pods":   resource.MustParse("110"),
+	capacity := v1.ResourceList{
+		v1.ResourceCPU:  *resource.NewQuantity(int64(cores), resource.DecimalSI),
+		v1.ResourcePods: *resource.NewQuantity(int64(e.config.maxPods()), resource.DecimalSI),
 	}
+	if ms, err := mem.VirtualMemory(); err != nil {
+		log.Error("fetch mermory failed,err=", err)
+	} else {
+		capacity[v1.ResourceMemory] = *resource.NewQuantity(int64(ms.Total), resource.BinarySI)
+	}
+	if dsk, err := disk.Usage(e.config.DiskPath); err != nil {
+		log.Error("fetch dsk failed ,err=", err)
+	} else {
+		capacity[v1.ResourceEphemeralStorage] = *resource.NewQuantity(int64(dsk.Total), resource.BinarySI)
+	}
+	return capacity
 }
 
-func (e *edgelet) allocatable(minfo *mem.VirtualMemoryStat) v1.ResourceList {
-	var usage uint64 = 0
-	if minfo != nil {
-		usage = minfo.Free / MiB
+//allocatable = capacity - systemReserved - kubeReserved, 与kubelet一致
+func (e *edgelet) allocatable(capacity v1.ResourceList) v1.ResourceList {
+	reserved, err := e.config.reserved()
+	if err != nil {
+		log.Error("parse reserved resources failed,err=", err)
+		reserved = v1.ResourceList{}
 	}
-	return v1.ResourceList{
-		"cpu":    resource.MustParse("100"),
-		"memory": resource.MustParse(fmt.Sprintf("%dMi", usage)),
-		"pods":   resource.MustParse("110"),
+	allocatable := v1.ResourceList{}
+	for name, value := range capacity {
+		q := value.DeepCopy()
+		if r, ok := reserved[name]; ok {
+			q.Sub(r)
+			if q.Sign() < 0 {
+				q.Set(0)
+			}
+		}
+		allocatable[name] = q
 	}
+	return allocatable
+}
+
+//pod数量达到maxPods时拒绝创建新的pod
+func (e *edgelet) admitPod(ctx context.Context, pod *v1.Pod) error {
+	pods, err := e.pm.GetPods(ctx)
+	if err != nil {
+		return err
+	}
+	//与kubelet一致, 已经运行结束的pod不占用pod数量
+	active := 0
+	for _, p := range pods {
+		if p.Namespace == pod.Namespace && p.Name == pod.Name {
+			return nil
+		}
+		if p.Status.Phase != v1.PodSucceeded && p.Status.Phase != v1.PodFailed {
+			active++
+		}
+	}
+	if maxPods := e.config.maxPods(); active >= maxPods {
+		return fmt.Errorf("OutOfpods: node has %d pods, max pods is %d", active, maxPods)
+	}
+	return nil
 }
 
 // NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/host"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+//nodeStats 用gopsutil采集节点的cpu/memory/network/fs数据
+func (e *edgelet) nodeStats() stats.NodeStats {
+	now := metav1.Now()
+	ns := stats.NodeStats{
+		NodeName: e.config.NodeName,
+	}
+	if bootTime, err := host.BootTime(); err == nil {
+		ns.StartTime = metav1.NewTime(time.Unix(int64(bootTime), 0))
+	}
+
+	if times, err := cpu.Times(false); err != nil {
+		log.Error("fetch cpu times failed,err=", err)
+	} else if len(times) > 0 {
+		//interval为0时与上一次调用做比较
+		percents, _ := cpu.Percent(0, false)
+		ns.CPU = nodeCPUStats(now, times[0], percents, runtime.NumCPU())
+	}
+
+	if ms, err := mem.VirtualMemory(); err != nil {
+		log.Error("fetch mermory failed,err=", err)
+	} else {
+		ns.Memory = nodeMemoryStats(now, ms)
+	}
+
+	if counters, err := net.IOCounters(true); err != nil {
+		log.Error("fetch network counters failed,err=", err)
+	} else {
+		ns.Network = nodeNetworkStats(now, counters)
+	}
+
+	if dsk, err := disk.Usage(e.config.DiskPath); err != nil {
+		log.Error("fetch dsk failed ,err=", err)
+	} else {
+		ns.Fs = nodeFsStats(now, dsk)
+	}
+	return ns
+}
+
+//nodeCPUStats iowait不计入使用时间, percents为空时不上报UsageNanoCores
+func nodeCPUStats(now metav1.Time, times cpu.TimesStat, percents []float64, numCPU int) *stats.CPUStats {
+	busy := times.Total() - times.Idle - times.Iowait
+	usageCoreNanoSeconds := uint64(busy * float64(time.Second))
+	cs := &stats.CPUStats{
+		Time:                 now,
+		UsageCoreNanoSeconds: &usageCoreNanoSeconds,
+	}
+	if len(percents) > 0 {
+		usageNanoCores := uint64(percents[0] / 100 * float64(numCPU) * float64(time.Second))
+		cs.UsageNanoCores = &usageNanoCores
+	}
+	return cs
+}
+
+func nodeMemoryStats(now metav1.Time, ms *mem.VirtualMemoryStat) *stats.MemoryStats {
+	usage := ms.Total - ms.Free
+	workingSet := ms.Used
+	available := ms.Available
+	return &stats.MemoryStats{
+		Time:            now,
+		AvailableBytes:  &available,
+		UsageBytes:      &usage,
+		WorkingSetBytes: &workingSet,
+	}
+}
+
+//nodeNetworkStats 忽略lo, 第一个网卡作为节点的默认网卡
+func nodeNetworkStats(now metav1.Time, counters []net.IOCountersStat) *stats.NetworkStats {
+	network := &stats.NetworkStats{Time: now}
+	for _, c := range counters {
+		if c.Name == "lo" {
+			continue
+		}
+		rxBytes, rxErrors, txBytes, txErrors := c.BytesRecv, c.Errin, c.BytesSent, c.Errout
+		network.Interfaces = append(network.Interfaces, stats.InterfaceStats{
+			Name:     c.Name,
+			RxBytes:  &rxBytes,
+			RxErrors: &rxErrors,
+			TxBytes:  &txBytes,
+			TxErrors: &txErrors,
+		})
+	}
+	if len(network.Interfaces) > 0 {
+		network.InterfaceStats = network.Interfaces[0]
+	}
+	return network
+}
+
+func nodeFsStats(now metav1.Time, dsk *disk.UsageStat) *stats.FsStats {
+	available, capacity, used := dsk.Free, dsk.Total, dsk.Used
+	inodes, inodesFree, inodesUsed := dsk.InodesTotal, dsk.InodesFree, dsk.InodesUsed
+	return &stats.FsStats{
+		Time:           now,
+		AvailableBytes: &available,
+		CapacityBytes:  &capacity,
+		UsedBytes:      &used,
+		Inodes:         &inodes,
+		InodesFree:     &inodesFree,
+		InodesUsed:     &inodesUsed,
+	}
+}
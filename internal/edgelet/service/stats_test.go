@@ -0,0 +1,118 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/net"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	stats "k8s.io/kubernetes/pkg/kubelet/apis/stats/v1alpha1"
+)
+
+func uint64Ptr(v uint64) *uint64 {
+	return &v
+}
+
+func Test_nodeCPUStats(t *testing.T) {
+	now := metav1.NewTime(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC))
+	times := cpu.TimesStat{User: 10, System: 4, Nice: 1, Iowait: 3, Irq: 0.5, Softirq: 0.5, Idle: 100}
+	tests := []struct {
+		name     string
+		percents []float64
+		numCPU   int
+		want     *stats.CPUStats
+	}{
+		{
+			name:     "quarter of four cores",
+			percents: []float64{25},
+			numCPU:   4,
+			want:     &stats.CPUStats{Time: now, UsageNanoCores: uint64Ptr(1e9), UsageCoreNanoSeconds: uint64Ptr(16e9)},
+		},
+		{
+			name:     "idle",
+			percents: []float64{0},
+			numCPU:   2,
+			want:     &stats.CPUStats{Time: now, UsageNanoCores: uint64Ptr(0), UsageCoreNanoSeconds: uint64Ptr(16e9)},
+		},
+		{
+			name:   "percent unavailable",
+			numCPU: 4,
+			want:   &stats.CPUStats{Time: now, UsageCoreNanoSeconds: uint64Ptr(16e9)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeCPUStats(now, times, tt.percents, tt.numCPU); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeCPUStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nodeMemoryStats(t *testing.T) {
+	now := metav1.NewTime(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC))
+	ms := &mem.VirtualMemoryStat{Total: 8 << 30, Free: 2 << 30, Used: 4 << 30, Available: 3 << 30}
+	want := &stats.MemoryStats{
+		Time:            now,
+		AvailableBytes:  uint64Ptr(3 << 30),
+		UsageBytes:      uint64Ptr(6 << 30),
+		WorkingSetBytes: uint64Ptr(4 << 30),
+	}
+	if got := nodeMemoryStats(now, ms); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeMemoryStats() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_nodeNetworkStats(t *testing.T) {
+	now := metav1.NewTime(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC))
+	eth0 := stats.InterfaceStats{Name: "eth0", RxBytes: uint64Ptr(100), RxErrors: uint64Ptr(1), TxBytes: uint64Ptr(200), TxErrors: uint64Ptr(2)}
+	wlan0 := stats.InterfaceStats{Name: "wlan0", RxBytes: uint64Ptr(300), RxErrors: uint64Ptr(0), TxBytes: uint64Ptr(400), TxErrors: uint64Ptr(0)}
+	tests := []struct {
+		name     string
+		counters []net.IOCountersStat
+		want     *stats.NetworkStats
+	}{
+		{
+			name: "skip loopback",
+			counters: []net.IOCountersStat{
+				{Name: "lo", BytesRecv: 1000, BytesSent: 1000},
+				{Name: "eth0", BytesRecv: 100, Errin: 1, BytesSent: 200, Errout: 2},
+				{Name: "wlan0", BytesRecv: 300, BytesSent: 400},
+			},
+			want: &stats.NetworkStats{Time: now, InterfaceStats: eth0, Interfaces: []stats.InterfaceStats{eth0, wlan0}},
+		},
+		{
+			name:     "loopback only",
+			counters: []net.IOCountersStat{{Name: "lo", BytesRecv: 1000, BytesSent: 1000}},
+			want:     &stats.NetworkStats{Time: now},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeNetworkStats(now, tt.counters); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeNetworkStats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nodeFsStats(t *testing.T) {
+	now := metav1.NewTime(time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC))
+	dsk := &disk.UsageStat{Total: 100 << 30, Free: 60 << 30, Used: 40 << 30, InodesTotal: 1000, InodesFree: 900, InodesUsed: 100}
+	want := &stats.FsStats{
+		Time:           now,
+		AvailableBytes: uint64Ptr(60 << 30),
+		CapacityBytes:  uint64Ptr(100 << 30),
+		UsedBytes:      uint64Ptr(40 << 30),
+		Inodes:         uint64Ptr(1000),
+		InodesFree:     uint64Ptr(900),
+		InodesUsed:     uint64Ptr(100),
+	}
+	if got := nodeFsStats(now, dsk); !reflect.DeepEqual(got, want) {
+		t.Errorf("nodeFsStats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,8 @@
+package service
+
+//TunnelTarget 反向通道的地址和节点名称, 没有配置TunnelAddress或者还没有join时为空
+func (e *edgelet) TunnelTarget() (string, string) {
+	e.configMutex.Lock()
+	defer e.configMutex.Unlock()
+	return e.config.TunnelAddress, e.config.NodeName
+}
@@ -0,0 +1,214 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/rand"
+	"edge/pkg/errdefs"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//升级任务的阶段, 按顺序经过Pulling, Staging, Swapping, 需要重启的组件之后是Restarting
+const (
+	PhasePulling    = "Pulling"
+	PhaseStaging    = "Staging"
+	PhaseSwapping   = "Swapping"
+	PhaseRestarting = "Restarting"
+	//以下是结束的阶段
+	PhaseVerified       = "Verified"
+	PhaseRolledBack     = "RolledBack"
+	PhaseRollbackFailed = "RollbackFailed"
+	//替换二进制之前失败, 当前版本没有变化
+	PhaseFailed = "Failed"
+)
+
+const (
+	jobsDir = "jobs"
+	//保留的任务数量, 超过时删除最早结束的任务
+	maxJobs = 20
+)
+
+//Job 一次升级任务, 保存在暂存目录中, edgelet重启后仍然可以查询, 重启阶段由watcher进程更新
+type Job struct {
+	ID        string    `json:"id"`
+	Component string    `json:"component"`
+	Image     string    `json:"image"`
+	Phase     string    `json:"phase"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	//重启后通过健康检测的截止时间
+	Deadline time.Time `json:"deadline,omitempty"`
+	//回滚后旧版本的健康检测也使用这个时间
+	HealthTimeout time.Duration `json:"healthTimeout,omitempty"`
+}
+
+//Finished 任务是否已经结束
+func (j *Job) Finished() bool {
+	switch j.Phase {
+	case PhaseVerified, PhaseRolledBack, PhaseRollbackFailed, PhaseFailed:
+		return true
+	}
+	return false
+}
+
+func newJobID(c Component) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%s-%s", c.Name, time.Now().Format("20060102150405"), hex.EncodeToString(b))
+}
+
+func (m *Manager) jobPath(id string) string {
+	return filepath.Join(m.stagingDir, jobsDir, id+".json")
+}
+
+func saveJob(path string, job *Job) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	job.UpdatedAt = time.Now()
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	tmp := path + newFileSuffix
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func loadJob(path string) (*Job, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+//updateJob 更新任务的阶段, 保存失败时只记录日志, 不影响升级
+func (m *Manager) updateJob(job *Job, phase, message string) {
+	job.Phase = phase
+	job.Message = message
+	if err := saveJob(m.jobPath(job.ID), job); err != nil {
+		logrus.Errorf("save upgrade job %s failed, err=%v", job.ID, err)
+	}
+	logrus.Infof("upgrade job %s %s %s", job.ID, phase, message)
+}
+
+//GetJob 查询任务, id为空时返回最近的任务
+func (m *Manager) GetJob(id string) (*Job, error) {
+	if id == "" {
+		jobs, err := m.ListJobs("")
+		if err != nil {
+			return nil, err
+		}
+		if len(jobs) == 0 {
+			return nil, errdefs.NotFound("no upgrade job")
+		}
+		return jobs[0], nil
+	}
+	if strings.ContainsAny(id, `/\`) {
+		return nil, errdefs.InvalidInputf("invalid upgrade job id %s", id)
+	}
+	job, err := loadJob(m.jobPath(id))
+	if os.IsNotExist(err) {
+		return nil, errdefs.NotFoundf("upgrade job %s not found", id)
+	}
+	return job, err
+}
+
+//ListJobs 组件的任务, 按创建时间从新到旧排列, component为空时返回所有组件的任务
+func (m *Manager) ListJobs(component string) ([]*Job, error) {
+	files, err := filepath.Glob(filepath.Join(m.stagingDir, jobsDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, f := range files {
+		job, err := loadJob(f)
+		if err != nil {
+			logrus.Warnf("load upgrade job %s failed, err=%v", f, err)
+			continue
+		}
+		if component == "" || job.Component == component {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs, nil
+}
+
+//pruneJobs 删除超过maxJobs的已经结束的任务
+func (m *Manager) pruneJobs() {
+	jobs, err := m.ListJobs("")
+	if err != nil || len(jobs) <= maxJobs {
+		return
+	}
+	for _, job := range jobs[maxJobs:] {
+		if job.Finished() {
+			os.Remove(m.jobPath(job.ID))
+		}
+	}
+}
+
+//Recover edgelet启动时调用, 替换二进制之前被中断的任务标记为失败,
+//watcher没有记录结果的任务(例如节点重启)在截止时间之后也标记为失败
+func (m *Manager) Recover() {
+	jobs, err := m.ListJobs("")
+	if err != nil {
+		logrus.Error("list upgrade jobs failed, err=", err)
+		return
+	}
+	for _, job := range jobs {
+		switch job.Phase {
+		case PhasePulling, PhaseStaging, PhaseSwapping:
+			m.updateJob(job, PhaseFailed, fmt.Sprintf("interrupted in %s, edgelet restarted while upgrading", job.Phase))
+		case PhaseRestarting:
+			if time.Now().After(job.Deadline.Add(job.HealthTimeout + m.restartDelay)) {
+				m.updateJob(job, PhaseFailed, "upgrade watcher exited without reporting the result")
+			}
+		}
+	}
+}
+
+//WatchJob 任务变化时调用fn, 直到任务结束或ctx取消
+func (m *Manager) WatchJob(ctx context.Context, id string, fn func(job *Job) error) error {
+	var last time.Time
+	ticker := time.NewTicker(m.watchInterval)
+	defer ticker.Stop()
+	for {
+		job, err := m.GetJob(id)
+		if err != nil {
+			return err
+		}
+		//id为空时固定为第一次查询到的任务
+		id = job.ID
+		if !job.UpdatedAt.Equal(last) {
+			last = job.UpdatedAt
+			if err := fn(job); err != nil {
+				return err
+			}
+		}
+		if job.Finished() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"edge/internal/constant"
+	"edge/pkg/errdefs"
+	"edge/pkg/grpcauth"
+	"edge/pkg/util"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+	升级流程:
+	1. 从镜像中复制新版本的二进制到暂存目录, 校验SHA-256和签名
+	2. 备份当前版本为<binary>.bak, 使用rename原子替换
+	3. 需要重启的组件(edgelet)由独立的watcher进程重启, 在截止时间内没有通过grpc健康检测时自动回滚
+	每次升级是一个Job, 各个阶段保存在暂存目录中, 可以在edgelet重启后查询
+*/
+
+//Component 可以升级的组件
+type Component struct {
+	Name       string
+	BinaryPath string
+	//为空时只替换二进制, 例如edgectl
+	RestartCmd string
+	//重启后健康检测的grpc地址
+	HealthAddress string
+}
+
+var (
+	Edgelet = Component{
+		Name:          "edgelet",
+		BinaryPath:    constant.EdgeletBinaryPath,
+		RestartCmd:    constant.RestartEdgeletCmd,
+		HealthAddress: grpcauth.UnixPrefix + constant.EdgeletSocket,
+	}
+	Edgectl = Component{
+		Name:       "edgectl",
+		BinaryPath: constant.EdgectlBinaryPath,
+	}
+	components = map[string]Component{Edgelet.Name: Edgelet, Edgectl.Name: Edgectl}
+)
+
+const (
+	backupSuffix  = ".bak"
+	newFileSuffix = ".new"
+
+	DefaultHealthTimeout = 2 * time.Minute
+	//从镜像中复制二进制的超时时间, 包括拉取镜像
+	pullTimeout = 10 * time.Minute
+	//执行重启命令的超时时间
+	restartTimeout = time.Minute
+)
+
+//Request 一次升级的参数
+type Request struct {
+	Image string
+	//新版本二进制的SHA-256, 十六进制
+	Sha256 string
+	//对SHA-256摘要的ed25519签名, 配置了公钥时必须提供
+	Signature []byte
+}
+
+type Manager struct {
+	stagingDir string
+	publicKey  ed25519.PublicKey
+	//新版本通过健康检测的截止时间
+	healthTimeout time.Duration
+
+	//测试时替换
+	components    map[string]Component
+	copyBinary    func(ctx context.Context, dir, image, name string) error
+	runCommand    func(ctx context.Context, cmd string) error
+	healthCheck   func(ctx context.Context, address string) error
+	startWatcher  func(c Component, statePath string) error
+	restartDelay  time.Duration
+	checkInterval time.Duration
+	watchInterval time.Duration
+
+	mu sync.Mutex
+}
+
+//NewManager publicKeyFile为空时不校验签名, healthTimeout为0时使用默认值
+func NewManager(stagingDir, publicKeyFile string, healthTimeout time.Duration) (*Manager, error) {
+	m := &Manager{
+		stagingDir:    stagingDir,
+		healthTimeout: healthTimeout,
+		components:    components,
+		copyBinary:    dockerCopyBinary,
+		runCommand:    runShell,
+		healthCheck:   checkHealth,
+		startWatcher:  startWatcher,
+		restartDelay:  2 * time.Second,
+		checkInterval: 2 * time.Second,
+		watchInterval: time.Second,
+	}
+	if m.healthTimeout <= 0 {
+		m.healthTimeout = DefaultHealthTimeout
+	}
+	if publicKeyFile != "" {
+		key, err := loadPublicKey(publicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		m.publicKey = key
+	}
+	return m, nil
+}
+
+//loadPublicKey PEM格式的ed25519公钥, 如 openssl pkey -pubout 的输出
+func loadPublicKey(file string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", file)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an ed25519 public key", file)
+	}
+	return pub, nil
+}
+
+//Start 校验参数后创建升级任务并在后台执行, 同一个组件同时只能有一个未结束的任务
+func (m *Manager) Start(c Component, req *Request) (*Job, error) {
+	if req.Image == "" {
+		return nil, errdefs.InvalidInput("image is empty")
+	}
+	if _, err := reference.ParseNormalizedNamed(req.Image); err != nil {
+		return nil, errdefs.InvalidInputf("invalid image %q, err=%v", req.Image, err)
+	}
+	if req.Sha256 == "" {
+		return nil, errdefs.InvalidInput("sha256 of the new binary is required")
+	}
+	if m.publicKey != nil && len(req.Signature) == 0 {
+		return nil, errdefs.InvalidInput("signature of the new binary is required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	jobs, err := m.ListJobs(c.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) > 0 && !jobs[0].Finished() {
+		return nil, errdefs.InvalidInputf("upgrade job %s of %s is still %s", jobs[0].ID, c.Name, jobs[0].Phase)
+	}
+	job := &Job{
+		ID:        newJobID(c),
+		Component: c.Name,
+		Image:     req.Image,
+		Phase:     PhasePulling,
+		CreatedAt: time.Now(),
+	}
+	if err := saveJob(m.jobPath(job.ID), job); err != nil {
+		return nil, err
+	}
+	m.pruneJobs()
+	started := *job
+	go m.run(job, c, req)
+	return &started, nil
+}
+
+//run 执行升级任务, 需要重启的组件在Restarting阶段交给watcher
+func (m *Manager) run(job *Job, c Component, req *Request) {
+	staged, err := m.pull(c, req)
+	if err != nil {
+		m.updateJob(job, PhaseFailed, err.Error())
+		return
+	}
+	m.updateJob(job, PhaseStaging, "")
+	if err := m.verify(staged, req.Sha256, req.Signature); err != nil {
+		os.RemoveAll(filepath.Dir(staged))
+		m.updateJob(job, PhaseFailed, err.Error())
+		return
+	}
+	m.updateJob(job, PhaseSwapping, "")
+	if err := m.Install(c, staged); err != nil {
+		m.updateJob(job, PhaseFailed, err.Error())
+		return
+	}
+	if c.RestartCmd == "" {
+		m.updateJob(job, PhaseVerified, "previous version is kept at "+c.BinaryPath+backupSuffix)
+		return
+	}
+	job.Deadline = time.Now().Add(m.restartDelay + m.healthTimeout)
+	job.HealthTimeout = m.healthTimeout
+	m.updateJob(job, PhaseRestarting, "rolled back if not healthy before "+job.Deadline.Format(time.RFC3339))
+	if err := m.startWatcher(c, m.jobPath(job.ID)); err != nil {
+		logrus.Errorf("start upgrade watcher failed, rollback %s, err=%v", c.Name, err)
+		if rerr := m.Rollback(c); rerr != nil {
+			m.updateJob(job, PhaseRollbackFailed, fmt.Sprintf("start upgrade watcher failed: %v; rollback failed: %v", err, rerr))
+			return
+		}
+		m.updateJob(job, PhaseRolledBack, fmt.Sprintf("start upgrade watcher failed: %v", err))
+	}
+}
+
+//pull 从镜像中复制二进制到暂存目录, 返回暂存文件的路径
+func (m *Manager) pull(c Component, req *Request) (string, error) {
+	dir := filepath.Join(m.stagingDir, c.Name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pullTimeout)
+	defer cancel()
+	if err := m.copyBinary(ctx, dir, req.Image, c.Name); err != nil {
+		return "", fmt.Errorf("copy %s from image %s failed, err=%v", c.Name, req.Image, err)
+	}
+	return filepath.Join(dir, c.Name), nil
+}
+
+//dockerCopyBinary 使用镜像中的/usr/local/bin/<name>, 不经过shell, 镜像名称不会被当作命令或参数
+func dockerCopyBinary(ctx context.Context, dir, image, name string) error {
+	cmd := exec.Command("docker", "run", "--rm", "-v", dir+":/myapp", image, "cp", "/usr/local/bin/"+name, "/myapp/")
+	log := logrus.WithField("image", image)
+	result, err := util.RunCommand(ctx, cmd, func(stream, line string) {
+		log.Infof("%s: %s", stream, line)
+	})
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("docker run exited with %d: %s", result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+func runShell(ctx context.Context, cmd string) error {
+	_, err := util.RunShell(ctx, cmd)
+	return err
+}
+
+func (m *Manager) verify(file, sha256Hex string, signature []byte) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	digest := h.Sum(nil)
+	if got := hex.EncodeToString(digest); !strings.EqualFold(got, sha256Hex) {
+		return errdefs.InvalidInputf("sha256 mismatch, got %s, want %s", got, sha256Hex)
+	}
+	if m.publicKey != nil && !ed25519.Verify(m.publicKey, digest, signature) {
+		return errdefs.InvalidInput("invalid signature of the new binary")
+	}
+	return nil
+}
+
+//Install 备份当前版本后原子替换, 新文件先复制到目标目录再rename, 避免跨文件系统
+func (m *Manager) Install(c Component, staged string) error {
+	if _, err := os.Stat(c.BinaryPath); err == nil {
+		if err := copyFileAtomic(c.BinaryPath, c.BinaryPath+backupSuffix); err != nil {
+			return fmt.Errorf("backup %s failed, err=%v", c.BinaryPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := copyFileAtomic(staged, c.BinaryPath); err != nil {
+		return fmt.Errorf("install %s failed, err=%v", c.BinaryPath, err)
+	}
+	os.RemoveAll(filepath.Dir(staged))
+	return nil
+}
+
+//Rollback 恢复备份的版本, 备份仍然保留
+func (m *Manager) Rollback(c Component) error {
+	backup := c.BinaryPath + backupSuffix
+	if _, err := os.Stat(backup); err != nil {
+		return fmt.Errorf("no backup of %s to roll back, err=%v", c.Name, err)
+	}
+	return copyFileAtomic(backup, c.BinaryPath)
+}
+
+func copyFileAtomic(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + newFileSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,304 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"edge/pkg/errdefs"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const newBinary = "new binary"
+
+func newTestManager(t *testing.T, publicKey ed25519.PublicKey) (*Manager, Component) {
+	dir := t.TempDir()
+	c := Component{
+		Name:          "edgelet",
+		BinaryPath:    filepath.Join(dir, "bin", "edgelet"),
+		RestartCmd:    "restart",
+		HealthAddress: "unix:///tmp/edgelet.sock",
+	}
+	if err := os.MkdirAll(filepath.Dir(c.BinaryPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.BinaryPath, []byte("old binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{
+		stagingDir:    filepath.Join(dir, "staging"),
+		publicKey:     publicKey,
+		healthTimeout: 50 * time.Millisecond,
+		components:    map[string]Component{c.Name: c},
+		//模拟docker cp, 写入暂存目录
+		copyBinary: func(ctx context.Context, stagingDir, image, name string) error {
+			return os.WriteFile(filepath.Join(stagingDir, name), []byte(newBinary), 0755)
+		},
+		runCommand:    func(ctx context.Context, cmd string) error { return nil },
+		healthCheck:   func(ctx context.Context, address string) error { return nil },
+		startWatcher:  func(c Component, jobPath string) error { return nil },
+		checkInterval: 10 * time.Millisecond,
+		watchInterval: 10 * time.Millisecond,
+	}
+	return m, c
+}
+
+//waitJob 等待任务结束或者进入Restarting阶段
+func waitJob(t *testing.T, m *Manager, id string) *Job {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var job *Job
+	err := m.WatchJob(ctx, id, func(j *Job) error {
+		job = j
+		if j.Phase == PhaseRestarting {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		t.Fatal(err)
+	}
+	return job
+}
+
+var errStop = errors.New("stop")
+
+func digest(data string) []byte {
+	sum := sha256.Sum256([]byte(data))
+	return sum[:]
+}
+
+func readFile(t *testing.T, file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestUpgradeVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha := hex.EncodeToString(digest(newBinary))
+	tests := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		req       *Request
+		invalid   bool
+		//校验失败的任务
+		failed bool
+	}{
+		{
+			name: "sha256 matches",
+			req:  &Request{Image: "edgelet:v2", Sha256: sha},
+		},
+		{
+			name:    "image is passed as an argument",
+			req:     &Request{Image: "-v /:/host alpine", Sha256: sha},
+			invalid: true,
+		},
+		{
+			name:    "image with shell metacharacters",
+			req:     &Request{Image: "edgelet:v2; reboot", Sha256: sha},
+			invalid: true,
+		},
+		{
+			name:    "sha256 is required",
+			req:     &Request{Image: "edgelet:v2"},
+			invalid: true,
+		},
+		{
+			name:   "sha256 mismatch",
+			req:    &Request{Image: "edgelet:v2", Sha256: hex.EncodeToString(digest("other"))},
+			failed: true,
+		},
+		{
+			name:      "signature is valid",
+			publicKey: pub,
+			req:       &Request{Image: "edgelet:v2", Sha256: sha, Signature: ed25519.Sign(priv, digest(newBinary))},
+		},
+		{
+			name:      "signature is required",
+			publicKey: pub,
+			req:       &Request{Image: "edgelet:v2", Sha256: sha},
+			invalid:   true,
+		},
+		{
+			name:      "signature of another binary",
+			publicKey: pub,
+			req:       &Request{Image: "edgelet:v2", Sha256: sha, Signature: ed25519.Sign(priv, digest("other"))},
+			failed:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, c := newTestManager(t, tt.publicKey)
+			started, err := m.Start(c, tt.req)
+			if tt.invalid {
+				if !errdefs.IsInvalidInput(err) {
+					t.Fatalf("want invalid input error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			job := waitJob(t, m, started.ID)
+			if tt.failed {
+				if job.Phase != PhaseFailed {
+					t.Errorf("phase = %s, want %s", job.Phase, PhaseFailed)
+				}
+				if got := readFile(t, c.BinaryPath); got != "old binary" {
+					t.Errorf("binary is replaced by %q after failed verification", got)
+				}
+				return
+			}
+			if job.Phase != PhaseRestarting {
+				t.Errorf("phase = %s, want %s", job.Phase, PhaseRestarting)
+			}
+			if got := readFile(t, c.BinaryPath); got != newBinary {
+				t.Errorf("binary = %q, want %q", got, newBinary)
+			}
+			if got := readFile(t, c.BinaryPath+backupSuffix); got != "old binary" {
+				t.Errorf("backup = %q, want the old binary", got)
+			}
+		})
+	}
+}
+
+func TestUpgradeWatcherFailed(t *testing.T) {
+	m, c := newTestManager(t, nil)
+	m.startWatcher = func(c Component, jobPath string) error { return errors.New("no systemd") }
+	started, err := m.Start(c, &Request{Image: "edgelet:v2", Sha256: hex.EncodeToString(digest(newBinary))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := waitJob(t, m, started.ID); job.Phase != PhaseRolledBack {
+		t.Errorf("phase = %s, want %s", job.Phase, PhaseRolledBack)
+	}
+	if got := readFile(t, c.BinaryPath); got != "old binary" {
+		t.Errorf("binary = %q, want rolled back to the old binary", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	tests := []struct {
+		name string
+		//新版本在重启几次后健康
+		healthyAfterRestarts int
+		wantPhase            string
+		wantBinary           string
+	}{
+		{
+			name:                 "new version is healthy",
+			healthyAfterRestarts: 1,
+			wantPhase:            PhaseVerified,
+			wantBinary:           newBinary,
+		},
+		{
+			name:                 "rolled back",
+			healthyAfterRestarts: 2,
+			wantPhase:            PhaseRolledBack,
+			wantBinary:           "old binary",
+		},
+		{
+			name:                 "previous version is not healthy either",
+			healthyAfterRestarts: 3,
+			wantPhase:            PhaseRollbackFailed,
+			wantBinary:           "old binary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, c := newTestManager(t, nil)
+			restarts := 0
+			runCommand := m.runCommand
+			m.runCommand = func(ctx context.Context, cmd string) error {
+				if cmd == c.RestartCmd {
+					restarts++
+				}
+				return runCommand(ctx, cmd)
+			}
+			m.healthCheck = func(ctx context.Context, address string) error {
+				if restarts >= tt.healthyAfterRestarts {
+					return nil
+				}
+				return errors.New("not serving")
+			}
+			started, err := m.Start(c, &Request{Image: "edgelet:v2", Sha256: hex.EncodeToString(digest(newBinary))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			waitJob(t, m, started.ID)
+			err = m.Watch(m.jobPath(started.ID))
+			if (err == nil) != (tt.wantPhase == PhaseVerified) {
+				t.Errorf("Watch() err = %v", err)
+			}
+			job, err := m.GetJob(started.ID)
+			if err != nil || job.Phase != tt.wantPhase {
+				t.Errorf("job = %+v, err = %v, want phase %s", job, err, tt.wantPhase)
+			}
+			if got := readFile(t, c.BinaryPath); got != tt.wantBinary {
+				t.Errorf("binary = %q, want %q", got, tt.wantBinary)
+			}
+		})
+	}
+}
+
+func TestStartConflict(t *testing.T) {
+	m, c := newTestManager(t, nil)
+	req := &Request{Image: "edgelet:v2", Sha256: hex.EncodeToString(digest(newBinary))}
+	started, err := m.Start(c, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, m, started.ID)
+	if _, err := m.Start(c, req); !errdefs.IsInvalidInput(err) {
+		t.Errorf("want invalid input error while job is %s, got %v", PhaseRestarting, err)
+	}
+	if job, err := m.GetJob(""); err != nil || job.ID != started.ID {
+		t.Errorf("latest job = %+v, err = %v, want %s", job, err, started.ID)
+	}
+	if _, err := m.GetJob("not-exist"); !errdefs.IsNotFound(err) {
+		t.Errorf("want not found error, got %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	m, c := newTestManager(t, nil)
+	now := time.Now()
+	jobs := []*Job{
+		{ID: "pulling", Component: c.Name, Phase: PhasePulling, CreatedAt: now},
+		{ID: "restarting", Component: c.Name, Phase: PhaseRestarting, CreatedAt: now, Deadline: now.Add(time.Minute)},
+		{ID: "lost-watcher", Component: c.Name, Phase: PhaseRestarting, CreatedAt: now, Deadline: now.Add(-time.Minute)},
+		{ID: "verified", Component: c.Name, Phase: PhaseVerified, CreatedAt: now},
+	}
+	for _, job := range jobs {
+		if err := saveJob(m.jobPath(job.ID), job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Recover()
+	want := map[string]string{
+		"pulling":      PhaseFailed,
+		"restarting":   PhaseRestarting,
+		"lost-watcher": PhaseFailed,
+		"verified":     PhaseVerified,
+	}
+	for id, phase := range want {
+		job, err := m.GetJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Phase != phase {
+			t.Errorf("job %s phase = %s, want %s", id, job.Phase, phase)
+		}
+	}
+}
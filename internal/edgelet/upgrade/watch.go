@@ -0,0 +1,114 @@
+package upgrade
+
+import (
+	"context"
+	"edge/api/edge-proto/pb"
+	"edge/pkg/grpcauth"
+	"edge/pkg/util"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+//startWatcher 重启edgelet会杀死它的所有子进程, 所以用systemd-run在单独的unit中运行备份的旧版本作为watcher
+func startWatcher(c Component, jobPath string) error {
+	unit := fmt.Sprintf("%s-upgrade-%d", c.Name, time.Now().Unix())
+	ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+	defer cancel()
+	cmd := exec.Command("systemd-run", "--unit="+unit, "--collect",
+		c.BinaryPath+backupSuffix, "--upgrade-watch="+jobPath)
+	result, err := util.RunCommand(ctx, cmd, nil)
+	if err != nil {
+		return fmt.Errorf("systemd-run failed, err=%v", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("systemd-run exited with %d, output=%s%s", result.ExitCode, result.Stdout, result.Stderr)
+	}
+	return nil
+}
+
+//Watch watcher进程的入口, 重启组件并等待健康检测通过, 超时后回滚到备份的版本, 结果记录在任务中
+func (m *Manager) Watch(jobPath string) error {
+	job, err := loadJob(jobPath)
+	if err != nil {
+		return err
+	}
+	c, ok := m.components[job.Component]
+	if !ok {
+		return fmt.Errorf("unknown component %s", job.Component)
+	}
+	//等待升级请求的响应返回给调用者
+	time.Sleep(m.restartDelay)
+	err = m.restartAndWait(c, job.Deadline)
+	if err == nil {
+		m.updateJob(job, PhaseVerified, "")
+		return nil
+	}
+
+	logrus.Errorf("%s is not healthy after upgrade, roll back, err=%v", c.Name, err)
+	phase, message := PhaseRolledBack, err.Error()
+	if rerr := m.Rollback(c); rerr != nil {
+		phase = PhaseRollbackFailed
+		message = fmt.Sprintf("%s; rollback failed: %v", message, rerr)
+	} else if rerr := m.restartAndWait(c, time.Now().Add(job.HealthTimeout)); rerr != nil {
+		phase = PhaseRollbackFailed
+		message = fmt.Sprintf("%s; previous version is not healthy: %v", message, rerr)
+	}
+	m.updateJob(job, phase, message)
+	return fmt.Errorf("upgrade %s %s: %s", c.Name, phase, message)
+}
+
+func (m *Manager) restartAndWait(c Component, deadline time.Time) error {
+	restartCtx, restartCancel := context.WithTimeout(context.Background(), restartTimeout)
+	err := m.runCommand(restartCtx, c.RestartCmd)
+	restartCancel()
+	if err != nil {
+		return fmt.Errorf("restart failed, err=%v", err)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	var lastErr error
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+	for {
+		checkCtx, checkCancel := context.WithTimeout(ctx, m.checkInterval)
+		lastErr = m.healthCheck(checkCtx, c.HealthAddress)
+		checkCancel()
+		if lastErr == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not healthy before %s, last err=%v", deadline.Format(time.RFC3339), lastErr)
+		case <-ticker.C:
+		}
+	}
+}
+
+//checkHealth 通过本机的unix socket访问grpc健康检测服务, 健康检测服务在grpc启动后就返回SERVING,
+//所以再调用一次GetPods, 确认新版本可以通过podmanager访问容器运行时
+func checkHealth(ctx context.Context, address string) error {
+	conn, err := grpcauth.Dial(address, grpcauth.Config{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status is %s", resp.Status)
+	}
+	pods, err := pb.NewEdgeletClient(conn).GetPods(ctx, &pb.GetPodsRequest{})
+	if err != nil {
+		return fmt.Errorf("GetPods failed, err=%v", err)
+	}
+	if pods.Error != nil {
+		return fmt.Errorf("GetPods failed, err=%s", pods.Error.Msg)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+/*
+	grpc连接的认证: TLS双向认证(mTLS)和bearer token
+	证书为空时使用明文连接, 兼容没有配置证书的旧节点; unix socket只能本机访问, 始终使用明文且不校验token
+*/
+
+package grpcauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	UnixPrefix = "unix://"
+
+	authorizationHeader = "authorization"
+	bearerPrefix        = "Bearer "
+	//健康检查不需要认证
+	healthServicePrefix = "/grpc.health.v1.Health/"
+
+	//NodeOrganization edge-registry签发的节点证书的Organization, 节点证书只代表节点自己
+	NodeOrganization = "edge:nodes"
+)
+
+//Config 同一个集群的证书由同一个CA签发, 证书同时用于服务端和客户端
+type Config struct {
+	//CA证书, 服务端用于校验客户端证书, 客户端用于校验服务端证书
+	CAFile   string `json:"caFile"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	//服务端要求/客户端携带的bearer token, 为空时不校验
+	Token string `json:"token"`
+}
+
+func (c Config) TLSEnabled() bool {
+	return c.CAFile != "" || c.CertFile != ""
+}
+
+//Authenticated 服务端会校验客户端证书或token, 只配置了服务端证书时任何客户端都可以连接
+func (c Config) Authenticated() bool {
+	return (c.CAFile != "" && c.CertFile != "") || c.Token != ""
+}
+
+func (c Config) certPool() (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+	}
+	return pool, nil
+}
+
+//ServerTLSConfig 配置了CA时要求并校验客户端证书
+func (c Config) ServerTLSConfig() (*tls.Config, error) {
+	if c.CertFile == "" || c.KeyFile == "" {
+		return nil, fmt.Errorf("server certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pool, err := c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+//ClientTLSConfig 没有配置CA时使用系统的CA, 配置了证书时向服务端出示客户端证书
+func (c Config) ClientTLSConfig() (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		pool, err := c.certPool()
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+//AuthOption ServerOptions的可选配置
+type AuthOption func(a *authorizer)
+
+//PublicMethods 这些方法不经过认证(如使用bootstrap token的join), 此时TLS握手不强制要求客户端证书, 由拦截器校验其他方法
+func PublicMethods(methods ...string) AuthOption {
+	return func(a *authorizer) {
+		for _, method := range methods {
+			a.publicMethods[method] = true
+		}
+	}
+}
+
+//DenyNodes 拒绝使用节点证书的调用者, 节点证书由同一个CA签发, 不能用来访问其他节点
+func DenyNodes() AuthOption {
+	return func(a *authorizer) {
+		a.denyNodes = true
+	}
+}
+
+//ServerOptions grpc.NewServer的认证选项
+func ServerOptions(c Config, authOpts ...AuthOption) ([]grpc.ServerOption, error) {
+	a := &authorizer{config: c, publicMethods: map[string]bool{}}
+	for _, opt := range authOpts {
+		opt(a)
+	}
+	var opts []grpc.ServerOption
+	if c.TLSEnabled() {
+		tlsConfig, err := c.ServerTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		if len(a.publicMethods) > 0 && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if c.Token != "" || len(a.publicMethods) > 0 || a.denyNodes {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(a.unaryInterceptor),
+			grpc.ChainStreamInterceptor(a.streamInterceptor),
+		)
+	}
+	return opts, nil
+}
+
+//DialOptions grpc.Dial的认证选项, unix socket使用明文
+func DialOptions(address string, c Config) ([]grpc.DialOption, error) {
+	if strings.HasPrefix(address, UnixPrefix) {
+		return []grpc.DialOption{grpc.WithInsecure()}, nil
+	}
+	var opts []grpc.DialOption
+	if c.TLSEnabled() {
+		tlsConfig, err := c.ClientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	if c.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: c.Token, secure: c.TLSEnabled()}))
+	}
+	return opts, nil
+}
+
+//Dial 按照Config建立grpc连接
+func Dial(address string, c Config, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	authOpts, err := DialOptions(address, c)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(address, append(authOpts, opts...)...)
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{authorizationHeader: bearerPrefix + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+//Authorize 校验客户端证书和token, publicMethods中的方法可以调用它判断调用者是否已经认证
+func Authorize(ctx context.Context, c Config) error {
+	if c.CAFile != "" && c.CertFile != "" && PeerCertificate(ctx) == nil {
+		return status.Error(codes.Unauthenticated, "client certificate required")
+	}
+	if c.Token != "" {
+		return checkToken(ctx, c.Token)
+	}
+	return nil
+}
+
+//PeerCertificate 客户端出示并通过校验的证书, 没有时返回nil
+func PeerCertificate(ctx context.Context) *x509.Certificate {
+	p, _ := peer.FromContext(ctx)
+	if p == nil {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+//NodeName 证书是edge-registry签发的节点证书时返回节点名称
+func NodeName(cert *x509.Certificate) (string, bool) {
+	if cert == nil {
+		return "", false
+	}
+	for _, o := range cert.Subject.Organization {
+		if o == NodeOrganization {
+			return cert.Subject.CommonName, true
+		}
+	}
+	return "", false
+}
+
+//PeerNodeName 客户端使用节点证书时返回节点名称
+func PeerNodeName(ctx context.Context) (string, bool) {
+	return NodeName(PeerCertificate(ctx))
+}
+
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(authorizationHeader) {
+		if strings.HasPrefix(value, bearerPrefix) &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(value, bearerPrefix)), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing bearer token")
+}
+
+type authorizer struct {
+	config        Config
+	publicMethods map[string]bool
+	denyNodes     bool
+}
+
+func (a *authorizer) authorize(ctx context.Context, method string) error {
+	if strings.HasPrefix(method, healthServicePrefix) {
+		return nil
+	}
+	if nodeName, ok := PeerNodeName(ctx); ok && a.denyNodes {
+		return status.Errorf(codes.PermissionDenied, "node %s is not allowed to call %s", nodeName, method)
+	}
+	if a.publicMethods[method] {
+		return nil
+	}
+	return Authorize(ctx, a.config)
+}
+
+func (a *authorizer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authorizer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.authorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
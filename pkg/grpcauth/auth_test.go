@@ -0,0 +1,231 @@
+package grpcauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+//writeCerts 生成CA和由CA签发的127.0.0.1证书, 证书同时可以用于服务端和客户端.
+//同时签发一个edge-registry签发的节点证书, 使用nodeCerts获取
+func writeCerts(t *testing.T, dir string) Config {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "edge-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := Config{
+		CAFile:   filepath.Join(dir, "ca.crt"),
+		CertFile: filepath.Join(dir, "node.crt"),
+		KeyFile:  filepath.Join(dir, "node.key"),
+	}
+	writePEM(t, conf.CAFile, &pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	issueCert(t, caTemplate, caKey, pkix.Name{CommonName: "node1"}, conf)
+	issueCert(t, caTemplate, caKey, pkix.Name{CommonName: "node2", Organization: []string{NodeOrganization}}, nodeCerts(conf))
+	return conf
+}
+
+//nodeCerts writeCerts签发的节点证书
+func nodeCerts(c Config) Config {
+	dir := filepath.Dir(c.CAFile)
+	return Config{
+		CAFile:   c.CAFile,
+		CertFile: filepath.Join(dir, "edge-node.crt"),
+		KeyFile:  filepath.Join(dir, "edge-node.key"),
+		Token:    c.Token,
+	}
+}
+
+func issueCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, subject pkix.Name, conf Config) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM(t, conf.CertFile, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	writePEM(t, conf.KeyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writePEM(t *testing.T, path string, block *pem.Block) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//健康检查不校验token, 用其他的服务名称注册一个需要认证的服务
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: "edge.Test",
+	HandlerType: (*healthpb.HealthServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Check",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(healthpb.HealthCheckRequest)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(healthpb.HealthServer).Check(ctx, req.(*healthpb.HealthCheckRequest))
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/edge.Test/Check"}, handler)
+		},
+	}},
+}
+
+func serve(t *testing.T, conf Config, authOpts ...AuthOption) string {
+	opts, err := ServerOptions(conf, authOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(opts...)
+	server.RegisterService(&testServiceDesc, health.NewServer())
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listen)
+	t.Cleanup(server.Stop)
+	return listen.Addr().String()
+}
+
+func call(conf Config, address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Dial(address, conf)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Invoke(ctx, "/edge.Test/Check", &healthpb.HealthCheckRequest{}, &healthpb.HealthCheckResponse{})
+}
+
+func Test_Auth(t *testing.T) {
+	certs := writeCerts(t, t.TempDir())
+	withToken := certs
+	withToken.Token = "secret"
+	address := serve(t, withToken)
+
+	tests := []struct {
+		name string
+		conf Config
+		code codes.Code
+	}{
+		{"valid cert and token", withToken, codes.OK},
+		{"missing token", certs, codes.Unauthenticated},
+		{"wrong token", Config{CAFile: certs.CAFile, CertFile: certs.CertFile, KeyFile: certs.KeyFile, Token: "wrong"}, codes.Unauthenticated},
+		{"missing client cert", Config{CAFile: certs.CAFile, Token: "secret"}, codes.Unavailable},
+		{"plaintext", Config{Token: "secret"}, codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := call(tt.conf, address)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v, err=%v", code, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestConfig_Authenticated(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+		want bool
+	}{
+		{"empty", Config{}, false},
+		{"server certificate only", Config{CertFile: "server.crt", KeyFile: "server.key"}, false},
+		{"client certificate required", Config{CAFile: "ca.crt", CertFile: "server.crt", KeyFile: "server.key"}, true},
+		{"token", Config{Token: "secret"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.Authenticated(); got != tt.want {
+				t.Errorf("Authenticated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authorize(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		md     metadata.MD
+		ok     bool
+	}{
+		{"health is not checked", "/grpc.health.v1.Health/Check", nil, true},
+		{"public method", "/pb.EdgeRegistryService/CreateNode", nil, true},
+		{"valid", "/pb.Edgelet/CreatePod", metadata.Pairs(authorizationHeader, "Bearer secret"), true},
+		{"no bearer prefix", "/pb.Edgelet/CreatePod", metadata.Pairs(authorizationHeader, "secret"), false},
+		{"missing", "/pb.Edgelet/CreatePod", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			a := &authorizer{config: Config{Token: "secret"}, publicMethods: map[string]bool{"/pb.EdgeRegistryService/CreateNode": true}}
+			if err := a.authorize(ctx, tt.method); (err == nil) != tt.ok {
+				t.Errorf("authorize() err=%v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
+
+//节点证书和edgelet由同一个CA签发, 不能用来访问其他节点的edgelet
+func Test_DenyNodes(t *testing.T) {
+	certs := writeCerts(t, t.TempDir())
+	certs.Token = "secret"
+	tests := []struct {
+		name     string
+		authOpts []AuthOption
+		conf     Config
+		code     codes.Code
+	}{
+		{"admin", []AuthOption{DenyNodes()}, certs, codes.OK},
+		{"node to node", []AuthOption{DenyNodes()}, nodeCerts(certs), codes.PermissionDenied},
+		{"node allowed without DenyNodes", nil, nodeCerts(certs), codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := serve(t, certs, tt.authOpts...)
+			err := call(tt.conf, address)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v, err=%v", code, tt.code, err)
+			}
+		})
+	}
+}
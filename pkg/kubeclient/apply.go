@@ -0,0 +1,296 @@
+package kubeclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultFieldManager is the field manager of server-side apply when ApplyOptions.FieldManager is empty
+	DefaultFieldManager = "edge"
+	// ApplySetLabel marks objects applied with ApplyOptions.PruneSet, objects carrying it but missing from
+	// the manifest are pruned on the next apply of the same set
+	ApplySetLabel = "edge.io/apply-set"
+)
+
+var documentSeparator = regexp.MustCompile(`(?m)^-{3,}$`)
+
+// Applier applies and deletes manifests of any kind, including CRDs, through the dynamic client.
+// Kinds are resolved by a discovery based RESTMapper, which is refreshed when a kind is unknown
+// so that CRDs installed after the Applier was created can be used.
+type Applier struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+// ApplyOptions controls Applier.Apply
+type ApplyOptions struct {
+	FieldManager string
+	// Force takes ownership of fields conflicting with other field managers
+	Force bool
+	// DryRun sends the requests with dryRun=All, nothing is persisted
+	DryRun bool
+	// PruneSet labels the applied objects with ApplySetLabel, and deletes objects of the same set
+	// that are no longer in the manifest. Only the kinds in the manifest and PruneKinds are checked.
+	PruneSet   string
+	PruneKinds []schema.GroupVersionKind
+}
+
+// ApplyResult lists the objects applied by Applier.Apply, it is returned with the error
+// when applying stops halfway so that the caller can roll back the objects it created
+type ApplyResult struct {
+	Applied []*unstructured.Unstructured
+	// Created are the objects that did not exist before this apply
+	Created []*unstructured.Unstructured
+}
+
+// DeleteOptions controls Applier.Delete
+type DeleteOptions struct {
+	DryRun bool
+}
+
+// NewApplier creates an Applier with the given dynamic client and RESTMapper
+func NewApplier(client dynamic.Interface, mapper meta.RESTMapper) *Applier {
+	return &Applier{client: client, mapper: mapper}
+}
+
+// NewApplierForConfig creates an Applier with a cached discovery RESTMapper
+func NewApplierForConfig(config *rest.Config) (*Applier, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
+	return NewApplier(client, mapper), nil
+}
+
+// DecodeManifest splits a multi-document yaml, documents without kind are skipped
+func DecodeManifest(manifest []byte) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	for _, doc := range documentSeparator.Split(string(manifest), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		data, err := yaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid yaml document")
+		}
+		obj := &unstructured.Unstructured{}
+		//使用apimachinery的json保持整数为int64
+		if err := utiljson.Unmarshal(data, &obj.Object); err != nil {
+			return nil, errors.Wrap(err, "invalid object")
+		}
+		if obj.GetKind() == "" {
+			continue
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// Apply applies every object in the manifest with server-side apply
+func (a *Applier) Apply(ctx context.Context, manifest []byte, opts ApplyOptions) (*ApplyResult, error) {
+	result := &ApplyResult{}
+	objs, err := DecodeManifest(manifest)
+	if err != nil {
+		return result, err
+	}
+	if opts.FieldManager == "" {
+		opts.FieldManager = DefaultFieldManager
+	}
+	applied := map[string]bool{}
+	pruneKinds := map[schema.GroupVersionKind]bool{}
+	for _, gvk := range opts.PruneKinds {
+		pruneKinds[gvk] = true
+	}
+	for _, obj := range objs {
+		if opts.PruneSet != "" {
+			labels := obj.GetLabels()
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[ApplySetLabel] = opts.PruneSet
+			obj.SetLabels(labels)
+		}
+		ri, err := a.resourceFor(obj)
+		if err != nil {
+			return result, err
+		}
+		//server-side apply的结果不区分创建和更新, 先查询对象是否存在
+		_, err = ri.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return result, errors.Wrapf(err, "get %s %s", obj.GetKind(), objectKey(obj))
+		}
+		created := apierrors.IsNotFound(err)
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return result, err
+		}
+		force := opts.Force
+		_, err = ri.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+			FieldManager: opts.FieldManager,
+			Force:        &force,
+			DryRun:       dryRun(opts.DryRun),
+		})
+		if err != nil {
+			return result, errors.Wrapf(err, "apply %s %s", obj.GetKind(), objectKey(obj))
+		}
+		klog.V(4).Infof("Applied %s %s", obj.GetKind(), objectKey(obj))
+		result.Applied = append(result.Applied, obj)
+		if created {
+			result.Created = append(result.Created, obj)
+		}
+		applied[appliedKey(obj.GroupVersionKind().GroupKind(), obj.GetNamespace(), obj.GetName())] = true
+		pruneKinds[obj.GroupVersionKind()] = true
+	}
+	if opts.PruneSet == "" {
+		return result, nil
+	}
+	for gvk := range pruneKinds {
+		if err := a.prune(ctx, gvk, opts, applied); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Delete deletes every object in the manifest in reverse order, missing objects and kinds are ignored
+func (a *Applier) Delete(ctx context.Context, manifest []byte, opts DeleteOptions) error {
+	objs, err := DecodeManifest(manifest)
+	if err != nil {
+		return err
+	}
+	return a.DeleteObjects(ctx, objs, opts)
+}
+
+// DeleteObjects deletes the objects in reverse order, missing objects and kinds are ignored
+func (a *Applier) DeleteObjects(ctx context.Context, objs []*unstructured.Unstructured, opts DeleteOptions) error {
+	for i := len(objs) - 1; i >= 0; i-- {
+		obj := objs[i]
+		ri, err := a.resourceFor(obj)
+		if meta.IsNoMatchError(err) {
+			klog.V(4).Infof("Skip deleting %s %s, kind is not installed", obj.GetKind(), objectKey(obj))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := deleteObject(ctx, ri, obj.GetName(), opts.DryRun); err != nil {
+			return errors.Wrapf(err, "delete %s %s", obj.GetKind(), objectKey(obj))
+		}
+	}
+	return nil
+}
+
+// resourceFor maps the object to its resource, the RESTMapper is refreshed once if the kind is unknown
+func (a *Applier) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	mapping, err := a.mapping(obj.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return a.client.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+	return a.client.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+func (a *Applier) mapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	mapping, err := a.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		//DeferredDiscoveryRESTMapper重新获取discovery信息, 例如新安装的CRD
+		if m, ok := a.mapper.(interface{ Reset() }); ok {
+			m.Reset()
+			mapping, err = a.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	return mapping, err
+}
+
+func (a *Applier) prune(ctx context.Context, gvk schema.GroupVersionKind, opts ApplyOptions, applied map[string]bool) error {
+	mapping, err := a.mapping(gvk)
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	//namespace为空时列出所有namespace中的对象
+	list, err := a.client.Resource(mapping.Resource).List(ctx, metav1.ListOptions{
+		LabelSelector: ApplySetLabel + "=" + opts.PruneSet,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "list %s to prune", gvk.Kind)
+	}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		if applied[appliedKey(gvk.GroupKind(), obj.GetNamespace(), obj.GetName())] {
+			continue
+		}
+		var ri dynamic.ResourceInterface = a.client.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			ri = a.client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+		if err := deleteObject(ctx, ri, obj.GetName(), opts.DryRun); err != nil {
+			return errors.Wrapf(err, "prune %s %s", gvk.Kind, objectKey(obj))
+		}
+		klog.V(4).Infof("Pruned %s %s", gvk.Kind, objectKey(obj))
+	}
+	return nil
+}
+
+func deleteObject(ctx context.Context, ri dynamic.ResourceInterface, name string, isDryRun bool) error {
+	propagation := metav1.DeletePropagationBackground
+	err := ri.Delete(ctx, name, metav1.DeleteOptions{
+		PropagationPolicy: &propagation,
+		DryRun:            dryRun(isDryRun),
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func dryRun(isDryRun bool) []string {
+	if isDryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func appliedKey(gk schema.GroupKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gk.String(), namespace, name)
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
@@ -0,0 +1,210 @@
+package kubeclient
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapGVK = schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+)
+
+const testManifest = `
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: edge-test
+---
+# comment only
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: keep
+  namespace: edge-test
+data:
+  replicas: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: default-ns
+`
+
+func newTestApplier(objects ...runtime.Object) (*Applier, *dynamicfake.FakeDynamicClient) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+	mapper.Add(namespaceGVK, meta.RESTScopeRoot)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "configmaps"}: "ConfigMapList",
+		{Version: "v1", Resource: "namespaces"}: "NamespaceList",
+	}, objects...)
+	//fake client不支持server-side apply, 返回请求中的对象
+	client.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		err := obj.UnmarshalJSON(patch.GetPatch())
+		return true, obj, err
+	})
+	return NewApplier(client, mapper), client
+}
+
+func testConfigMap(namespace, name, set string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(configMapGVK)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	obj.SetLabels(map[string]string{ApplySetLabel: set})
+	return obj
+}
+
+//actionsOf 返回指定类型的请求, 格式为 namespace/name
+func actionsOf(client *dynamicfake.FakeDynamicClient, verb string) []string {
+	var names []string
+	for _, action := range client.Actions() {
+		if action.GetVerb() != verb {
+			continue
+		}
+		var name string
+		switch a := action.(type) {
+		case clienttesting.PatchAction:
+			name = a.GetName()
+		case clienttesting.DeleteAction:
+			name = a.GetName()
+		default:
+			continue
+		}
+		names = append(names, action.GetNamespace()+"/"+name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestApplier_Apply(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newTestApplier(
+		testConfigMap("edge-test", "keep", "set1"),
+		testConfigMap("edge-test", "removed", "set1"),
+		testConfigMap("other", "removed", "set1"),
+		testConfigMap("edge-test", "other-set", "set2"),
+	)
+	result, err := applier.Apply(ctx, []byte(testManifest), ApplyOptions{PruneSet: "set1", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Applied) != 3 || len(result.Created) != 2 {
+		t.Errorf("applied %d objects, created %d, want 3 and 2", len(result.Applied), len(result.Created))
+	}
+	if got, want := actionsOf(client, "patch"), []string{"/edge-test", "default/default-ns", "edge-test/keep"}; !equalStrings(got, want) {
+		t.Errorf("applied %v, want %v", got, want)
+	}
+	if got, want := actionsOf(client, "delete"), []string{"edge-test/removed", "other/removed"}; !equalStrings(got, want) {
+		t.Errorf("pruned %v, want %v", got, want)
+	}
+	for _, action := range client.Actions() {
+		if patch, ok := action.(clienttesting.PatchActionImpl); ok {
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+				t.Fatal(err)
+			}
+			if obj.GetLabels()[ApplySetLabel] != "set1" {
+				t.Errorf("%s labels = %v", obj.GetName(), obj.GetLabels())
+			}
+		}
+	}
+
+	//没有PruneSet时不删除
+	client.ClearActions()
+	if _, err := applier.Apply(ctx, []byte(testManifest), ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := actionsOf(client, "delete"); len(deleted) != 0 {
+		t.Errorf("pruned %v without PruneSet", deleted)
+	}
+
+	//失败时返回已经创建的对象, 调用者只回滚这些对象
+	result, err = applier.Apply(ctx, []byte(testManifest+"---\napiVersion: edge.io/v1\nkind: Unknown\nmetadata:\n  name: x\n"), ApplyOptions{})
+	if !meta.IsNoMatchError(err) {
+		t.Errorf("Apply() unknown kind err=%v, want no match", err)
+	}
+	if len(result.Applied) != 3 || len(result.Created) != 2 {
+		t.Errorf("applied %d objects, created %d before the error, want 3 and 2", len(result.Applied), len(result.Created))
+	}
+}
+
+//模板中删除了某种资源时, 通过PruneKinds清理之前创建的对象
+func TestApplier_ApplyPruneKinds(t *testing.T) {
+	ctx := context.Background()
+	applier, client := newTestApplier(testConfigMap("edge-test", "removed", "set1"))
+	manifest := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: edge-test\n"
+	if _, err := applier.Apply(ctx, []byte(manifest), ApplyOptions{PruneSet: "set1"}); err != nil {
+		t.Fatal(err)
+	}
+	if deleted := actionsOf(client, "delete"); len(deleted) != 0 {
+		t.Errorf("pruned %v, kinds not in the manifest should not be checked", deleted)
+	}
+	if _, err := applier.Apply(ctx, []byte(manifest), ApplyOptions{PruneSet: "set1", PruneKinds: []schema.GroupVersionKind{configMapGVK}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actionsOf(client, "delete"), []string{"edge-test/removed"}; !equalStrings(got, want) {
+		t.Errorf("pruned %v, want %v", got, want)
+	}
+}
+
+func TestApplier_DeleteObjects(t *testing.T) {
+	applier, client := newTestApplier(testConfigMap("edge-test", "created", ""), testConfigMap("edge-test", "existing", ""))
+	if err := applier.DeleteObjects(context.Background(), []*unstructured.Unstructured{testConfigMap("edge-test", "created", "")}, DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actionsOf(client, "delete"), []string{"edge-test/created"}; !equalStrings(got, want) {
+		t.Errorf("deleted %v, want %v", got, want)
+	}
+}
+
+func TestApplier_Delete(t *testing.T) {
+	applier, client := newTestApplier(testConfigMap("edge-test", "keep", ""))
+	manifest := testManifest + `
+---
+apiVersion: edge.io/v1
+kind: Unknown
+metadata:
+  name: x
+`
+	if err := applier.Delete(context.Background(), []byte(manifest), DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actionsOf(client, "delete"), []string{"/edge-test", "default/default-ns", "edge-test/keep"}; !equalStrings(got, want) {
+		t.Errorf("deleted %v, want %v", got, want)
+	}
+	if _, err := client.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).
+		Namespace("edge-test").Get(context.Background(), "keep", metav1.GetOptions{}); err == nil {
+		t.Errorf("configmap edge-test/keep is not deleted")
+	}
+}
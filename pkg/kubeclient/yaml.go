@@ -3,7 +3,6 @@ package kubeclient
 import (
 	"edge/pkg/util"
 
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 )
 
@@ -19,8 +18,8 @@ func ReadYaml(inputPath, defaults string) string {
 	return yaml
 }
 
-func CreateByYamlFile(clientSet kubernetes.Interface, yamlFile string) error {
-	err := CreateResourceWithFile(clientSet, yamlFile, nil)
+func CreateByYamlFile(applier *Applier, yamlFile string) error {
+	err := CreateResourceWithFile(applier, yamlFile, nil)
 	if err != nil {
 		klog.Errorf("Apply yaml: %s, error: %v", yamlFile, err)
 		return err
@@ -28,8 +27,8 @@ func CreateByYamlFile(clientSet kubernetes.Interface, yamlFile string) error {
 	return nil
 }
 
-func DeleteByYamlFile(clientSet kubernetes.Interface, yamlFile string) error {
-	err := DeleteResourceWithFile(clientSet, yamlFile, nil)
+func DeleteByYamlFile(applier *Applier, yamlFile string) error {
+	err := DeleteResourceWithFile(applier, yamlFile, nil)
 	if err != nil {
 		klog.Errorf("Delete yaml: %s, error: %v", yamlFile, err)
 		return err
@@ -0,0 +1,154 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	dialTimeout = 10 * time.Second
+	//重连的等待时间从1s开始翻倍, 最长1分钟
+	initialBackoff = time.Second
+	maxBackoff     = time.Minute
+)
+
+//Target 返回tunnel server的地址和本节点的名称, 任意一个为空时不建立连接(例如还没有join)
+type Target func() (address, nodeName string)
+
+//Agent 运行在边缘节点, 保持一条到云端的长连接, 云端通过这条连接访问grpcServer上注册的服务
+type Agent struct {
+	target     Target
+	grpcServer *grpc.Server
+	//连接tunnel server使用的TLS配置, 为空时使用明文
+	tlsConfig *tls.Config
+	//没有节点证书时tunnel server校验的bearer token
+	token string
+}
+
+func NewAgent(target Target, grpcServer *grpc.Server, tlsConfig *tls.Config, token string) *Agent {
+	return &Agent{
+		target:     target,
+		grpcServer: grpcServer,
+		tlsConfig:  tlsConfig,
+		token:      token,
+	}
+}
+
+func newBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: initialBackoff,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    int(^uint(0) >> 1),
+		Cap:      maxBackoff,
+	}
+}
+
+//Run 断开后按指数退避重连, 直到stopCh关闭
+func (a *Agent) Run(stopCh <-chan struct{}) {
+	backoff := newBackoff()
+	for {
+		address, nodeName := a.target()
+		if address != "" && nodeName != "" {
+			start := time.Now()
+			err := a.serve(address, nodeName, stopCh)
+			//连接保持了足够长的时间, 说明不是持续性的失败, 重新开始退避
+			if time.Since(start) > maxBackoff {
+				backoff = newBackoff()
+			}
+			logrus.Warnf("tunnel to %s disconnected, err=%v", address, err)
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+//serve 建立一条反向连接并在上面提供grpc服务, 连接断开时返回
+func (a *Agent) serve(address, nodeName string, stopCh <-chan struct{}) error {
+	conn, err := dial(address, nodeName, a.tlsConfig, a.token)
+	if err != nil {
+		return err
+	}
+	session, err := yamux.Server(conn, yamux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	logrus.Infof("tunnel to %s established, node=%s", address, nodeName)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stopCh:
+			session.Close()
+		case <-done:
+		}
+	}()
+	//session实现了net.Listener, 云端打开的每个stream都是一个grpc连接
+	return a.grpcServer.Serve(session)
+}
+
+//dial 连接tunnel server并通过HTTP Upgrade切换为tunnel协议
+func dial(address, nodeName string, tlsConfig *tls.Config, token string) (net.Conn, error) {
+	conn, err := dialConn(context.Background(), address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+address+ConnectPath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", UpgradeProtocol)
+	req.Header.Set(NodeNameHeader, nodeName)
+	setToken(req, token)
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("tunnel server %s refused: %s", address, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return newBufferedConn(conn, reader), nil
+}
+
+//dialConn tlsConfig不为空时使用TLS连接tunnel server
+func dialConn(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if tlsConfig == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+	conf := tlsConfig.Clone()
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		conf.ServerName = host
+	}
+	return (&tls.Dialer{NetDialer: dialer, Config: conf}).DialContext(ctx, "tcp", address)
+}
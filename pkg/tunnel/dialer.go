@@ -0,0 +1,49 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+//Dialer 返回通过tunnel server访问节点的dial函数, address为节点名称, 可以用于grpc.WithContextDialer
+//tlsConfig为连接tunnel server使用的TLS配置, 为空时使用明文, 没有客户端证书时需要token
+func Dialer(serverAddress string, tlsConfig *tls.Config, token string) func(ctx context.Context, address string) (net.Conn, error) {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		conn, err := dialConn(ctx, serverAddress, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "http://"+address, nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		req.Host = address
+		setToken(req, token)
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("connect to %s through tunnel %s failed: %s", address, serverAddress, resp.Status)
+		}
+		conn.SetDeadline(time.Time{})
+		return newBufferedConn(conn, reader), nil
+	}
+}
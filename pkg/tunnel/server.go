@@ -0,0 +1,212 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/x509"
+	"edge/pkg/grpcauth"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+)
+
+//Server 运行在云端, 接受edgelet的反向连接, 并通过HTTP CONNECT把请求转发到对应节点
+//调用者需要出示客户端证书或者bearer token, token为空时只接受客户端证书
+type Server struct {
+	token    string
+	sessions map[string]*yamux.Session
+	mutex    sync.RWMutex
+}
+
+func NewServer(token string) *Server {
+	return &Server{
+		token:    token,
+		sessions: map[string]*yamux.Session{},
+	}
+}
+
+//ServeHTTP ConnectPath上的请求是edgelet建立反向通道, CONNECT请求访问节点, host是节点名称
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == ConnectPath:
+		s.handleAgent(w, r)
+	case r.Method == http.MethodConnect:
+		s.handleConnect(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+//peerCertificate 经过校验的客户端证书, 明文或没有校验客户端证书时返回nil
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+//checkToken 没有配置token时拒绝所有没有客户端证书的请求
+func (s *Server) checkToken(r *http.Request) bool {
+	value := r.Header.Get(authorizationHeader)
+	return s.token != "" && strings.HasPrefix(value, bearerPrefix) &&
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(value, bearerPrefix)), []byte(s.token)) == 1
+}
+
+func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
+	nodeName := r.Header.Get(NodeNameHeader)
+	//有客户端证书时反向通道只能由节点自己建立, 节点名称以节点证书为准, 防止冒充其他节点的通道
+	//没有客户端证书时只信任持有token的节点
+	if cert := peerCertificate(r); cert != nil {
+		certNode, ok := grpcauth.NodeName(cert)
+		if !ok {
+			http.Error(w, "tunnel requires a node certificate", http.StatusForbidden)
+			return
+		}
+		if nodeName != "" && nodeName != certNode {
+			http.Error(w, fmt.Sprintf("certificate of node %s can not open the tunnel of node %s", certNode, nodeName), http.StatusForbidden)
+			return
+		}
+		nodeName = certNode
+	} else if !s.checkToken(r) {
+		http.Error(w, "tunnel requires a node certificate or bearer token", http.StatusUnauthorized)
+		return
+	}
+	if nodeName == "" {
+		http.Error(w, "missing "+NodeNameHeader, http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), UpgradeProtocol) {
+		http.Error(w, "expect upgrade to "+UpgradeProtocol, http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := hijack(w)
+	if err != nil {
+		logrus.Errorf("tunnel hijack failed, node=%s, err=%v", nodeName, err)
+		return
+	}
+	_, err = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + UpgradeProtocol + "\r\n\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		logrus.Errorf("tunnel handshake failed, node=%s, err=%v", nodeName, err)
+		return
+	}
+	session, err := yamux.Client(newBufferedConn(conn, rw.Reader), yamux.DefaultConfig())
+	if err != nil {
+		conn.Close()
+		logrus.Errorf("tunnel session failed, node=%s, err=%v", nodeName, err)
+		return
+	}
+	s.addSession(nodeName, session)
+	logrus.Infof("tunnel of node %s connected from %s", nodeName, r.RemoteAddr)
+	go func() {
+		<-session.CloseChan()
+		s.removeSession(nodeName, session)
+		logrus.Infof("tunnel of node %s disconnected", nodeName)
+	}()
+}
+
+func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
+	//CONNECT的host为 节点名称:端口, 端口没有意义, 所有请求都转发到edgelet的grpc服务
+	nodeName := r.Host
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		nodeName = host
+	}
+	//只有管理员和virtual-kubelet可以访问节点, 节点证书不能访问其他节点
+	if cert := peerCertificate(r); cert != nil {
+		if certNode, ok := grpcauth.NodeName(cert); ok {
+			http.Error(w, fmt.Sprintf("node %s is not allowed to connect to node %s", certNode, nodeName), http.StatusForbidden)
+			return
+		}
+	} else if !s.checkToken(r) {
+		http.Error(w, "client certificate or bearer token is required", http.StatusUnauthorized)
+		return
+	}
+	stream, err := s.DialNode(r.Context(), nodeName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := hijack(w)
+	if err != nil {
+		stream.Close()
+		logrus.Errorf("tunnel hijack failed, node=%s, err=%v", nodeName, err)
+		return
+	}
+	if _, err := rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil || rw.Flush() != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+	pipe(newBufferedConn(conn, rw.Reader), stream)
+}
+
+//DialNode 在节点的反向通道上打开一个连接
+func (s *Server) DialNode(ctx context.Context, nodeName string) (net.Conn, error) {
+	s.mutex.RLock()
+	session, ok := s.sessions[nodeName]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("node %s has no tunnel", nodeName)
+	}
+	return session.Open()
+}
+
+//Connected 节点当前是否有反向通道
+func (s *Server) Connected(nodeName string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	_, ok := s.sessions[nodeName]
+	return ok
+}
+
+//addSession 同一个节点重连时旧的连接已经不可用, 关闭旧的连接
+func (s *Server) addSession(nodeName string, session *yamux.Session) {
+	s.mutex.Lock()
+	old, ok := s.sessions[nodeName]
+	s.sessions[nodeName] = session
+	s.mutex.Unlock()
+	if ok {
+		old.Close()
+	}
+}
+
+func (s *Server) removeSession(nodeName string, session *yamux.Session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sessions[nodeName] == session {
+		delete(s.sessions, nodeName)
+	}
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijack")
+	}
+	return hj.Hijack()
+}
+
+//pipe 双向复制数据, 任意一端关闭时关闭两端
+func pipe(a, b net.Conn) {
+	var once sync.Once
+	closeBoth := func() {
+		a.Close()
+		b.Close()
+	}
+	copyAndClose := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		once.Do(closeBoth)
+	}
+	go copyAndClose(a, b)
+	copyAndClose(b, a)
+}
@@ -0,0 +1,49 @@
+/*
+	反向通道: 边缘节点通常在NAT后面, 云端无法直接访问edgelet
+	edgelet主动连接云端的tunnel server并升级为yamux会话, 云端在会话上打开stream访问edgelet的grpc服务
+	virtual-kubelet通过tunnel server的HTTP CONNECT按节点名称访问edgelet
+*/
+
+package tunnel
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+const (
+	//edgelet建立反向通道的路径
+	ConnectPath = "/tunnel/connect"
+	//HTTP Upgrade的协议名称
+	UpgradeProtocol = "edge-tunnel"
+	//edgelet在升级请求中携带的节点名称
+	NodeNameHeader = "X-Edge-Node-Name"
+
+	authorizationHeader = "Authorization"
+	bearerPrefix        = "Bearer "
+)
+
+//bufferedConn 握手时bufio.Reader可能已经读取了后续的数据, 需要从reader中继续读取
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+//setToken 没有客户端证书时tunnel server校验bearer token
+func setToken(req *http.Request, token string) {
+	if token != "" {
+		req.Header.Set(authorizationHeader, bearerPrefix+token)
+	}
+}
+
+func newBufferedConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	if reader.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, reader: reader}
+}
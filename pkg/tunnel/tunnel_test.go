@@ -0,0 +1,227 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edge/pkg/grpcauth"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_tunnel(t *testing.T) {
+	server := NewServer("secret")
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	address := strings.TrimPrefix(httpServer.URL, "http://")
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go NewAgent(func() (string, string) { return address, "node1" }, grpcServer, nil, "secret").Run(stopCh)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.Connected("node1") {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "node1:10350", grpc.WithInsecure(), grpc.WithBlock(), grpc.WithContextDialer(Dialer(address, nil, "secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status = %v, want SERVING", resp.Status)
+	}
+
+	if _, err := server.DialNode(ctx, "node2"); err == nil {
+		t.Error("expect error for node without tunnel")
+	}
+}
+
+//没有客户端证书时必须携带token, 否则任何人都可以冒充节点或者访问节点
+func Test_tunnelToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverToken string
+		token       string
+		ok          bool
+	}{
+		{"valid token", "secret", "secret", true},
+		{"missing token", "secret", "", false},
+		{"wrong token", "secret", "guess", false},
+		{"token not configured", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(tt.serverToken)
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			address := strings.TrimPrefix(httpServer.URL, "http://")
+
+			conn, err := dial(address, "node1", nil, tt.token)
+			if (err == nil) != tt.ok {
+				t.Fatalf("agent err=%v, want ok=%v", err, tt.ok)
+			}
+			if conn != nil {
+				defer conn.Close()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			//节点没有反向通道时通过认证的CONNECT返回502
+			_, err = Dialer(address, nil, tt.token)(ctx, "node2:10350")
+			if err == nil {
+				t.Fatal("expect connect to node2 to fail")
+			}
+			if refused := strings.Contains(err.Error(), "401"); refused == tt.ok {
+				t.Errorf("connect err=%v, want refused=%v", err, !tt.ok)
+			}
+		})
+	}
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "edge-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, subject pkix.Name) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) clientTLS(t *testing.T, subject pkix.Name) *tls.Config {
+	return &tls.Config{RootCAs: ca.pool, Certificates: []tls.Certificate{ca.issue(t, subject)}}
+}
+
+//使用TLS时节点名称以节点证书为准, 节点证书不能访问其他节点
+func Test_tunnelIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	server := NewServer("")
+	httpServer := httptest.NewUnstartedServer(server)
+	httpServer.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, pkix.Name{CommonName: "edge-registry"})},
+		ClientCAs:    ca.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	httpServer.StartTLS()
+	defer httpServer.Close()
+	address := strings.TrimPrefix(httpServer.URL, "https://")
+
+	node1TLS := ca.clientTLS(t, pkix.Name{CommonName: "node1", Organization: []string{grpcauth.NodeOrganization}})
+	node2TLS := ca.clientTLS(t, pkix.Name{CommonName: "node2", Organization: []string{grpcauth.NodeOrganization}})
+	adminTLS := ca.clientTLS(t, pkix.Name{CommonName: "admin"})
+
+	grpcServer := grpc.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go NewAgent(func() (string, string) { return address, "node1" }, grpcServer, node1TLS, "").Run(stopCh)
+	deadline := time.Now().Add(5 * time.Second)
+	for !server.Connected("node1") {
+		if time.Now().After(deadline) {
+			t.Fatal("agent did not connect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	agentTests := []struct {
+		name      string
+		nodeName  string
+		tlsConfig *tls.Config
+	}{
+		{"hijack another node", "node1", node2TLS},
+		{"agent without node certificate", "node1", adminTLS},
+	}
+	for _, tt := range agentTests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := dial(address, tt.nodeName, tt.tlsConfig, "")
+			if err == nil {
+				conn.Close()
+				t.Fatal("expect the tunnel to be refused")
+			}
+			if !server.Connected("node1") {
+				t.Error("tunnel of node1 is closed")
+			}
+		})
+	}
+
+	connectTests := []struct {
+		name      string
+		tlsConfig *tls.Config
+		ok        bool
+	}{
+		{"admin", adminTLS, true},
+		{"node to node", node2TLS, false},
+	}
+	for _, tt := range connectTests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := Dialer(address, tt.tlsConfig, "")(ctx, "node1:10350")
+			if (err == nil) != tt.ok {
+				t.Fatalf("connect err=%v, want ok=%v", err, tt.ok)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+		})
+	}
+}
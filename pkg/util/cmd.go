@@ -1,36 +1,147 @@
 package util
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
-func RunLinuxCommand(command string) (string, string, error) {
-	var outBuff, errBuff bytes.Buffer
-	cmd := exec.Command("/bin/bash", "-c", command)
-	cmd.Stdout, cmd.Stderr = &outBuff, &errBuff
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+	//CommandResult中保留的stdout/stderr的最大长度, 超过的部分只传给OutputFunc
+	MaxCommandOutput = 64 * 1024
+)
 
-	defer func() {
-		logrus.Infof("Run command: '%s' \n "+
-			"stdout: %s\n stderr: %s\n", command, outBuff.String(), errBuff.String())
-	}()
+//OutputFunc 命令每输出一行调用一次, stream为StreamStdout或StreamStderr, line不包含换行符
+type OutputFunc func(stream, line string)
+
+//CommandResult 命令执行的结果
+type CommandResult struct {
+	ExitCode int
+	Duration time.Duration
+	Stdout   string
+	Stderr   string
+}
 
-	//Run cmd
+//RunCommand 执行cmd直到结束或ctx取消, 取消或超时时杀死整个进程组.
+//命令返回非0时不作为错误, 由调用者检查ExitCode; ctx取消时返回ctx的错误.
+//cmd的Stdout, Stderr必须为空, 由RunCommand读取
+func RunCommand(ctx context.Context, cmd *exec.Cmd, onOutput OutputFunc) (*CommandResult, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	//子进程(例如bash启动的docker)在同一个进程组, 取消时一起杀死
+	cmd.SysProcAttr.Setpgid = true
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
 	if err := cmd.Start(); err != nil {
-		logrus.Warningf("Exec command: %s, error: %v", command, err)
-		return "", "", err
+		return nil, err
 	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			//脱离进程组的子进程可能还持有pipe, 关闭后不再等待它的输出
+			stdoutPipe.Close()
+			stderrPipe.Close()
+		case <-done:
+		}
+	}()
 
-	//Wait cmd run finish
-	if err := cmd.Wait(); err != nil {
-		logrus.Warningf("Wait command: %s exec finish error: %v", command, err)
-		return "", "", err
+	var stdout, stderr bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go readLines(&wg, &mu, stdoutPipe, &stdout, StreamStdout, onOutput)
+	go readLines(&wg, &mu, stderrPipe, &stderr, StreamStderr, onOutput)
+	//Wait会关闭pipe, 必须在读取完之后调用
+	wg.Wait()
+	err = cmd.Wait()
+
+	result := &CommandResult{Duration: time.Since(start), Stdout: stdout.String(), Stderr: stderr.String()}
+	if ctx.Err() != nil {
+		result.ExitCode = -1
+		return result, fmt.Errorf("command %s killed after %s, err=%w", cmd.Path, result.Duration, ctx.Err())
 	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	return result, err
+}
 
-	return outBuff.String(), errBuff.String(), nil
+//readLines 按行读取输出, 不使用bufio.Scanner, 避免超长的行导致停止读取
+func readLines(wg *sync.WaitGroup, mu *sync.Mutex, r io.Reader, buf *bytes.Buffer, stream string, onOutput OutputFunc) {
+	defer wg.Done()
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if n := MaxCommandOutput - buf.Len(); n > 0 {
+				if len(line) > n {
+					buf.WriteString(line[:n])
+				} else {
+					buf.WriteString(line)
+				}
+			}
+			if onOutput != nil {
+				//两个stream的回调不并发, 调用者不需要加锁
+				mu.Lock()
+				onOutput(stream, strings.TrimRight(line, "\r\n"))
+				mu.Unlock()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+//RunShell 使用bash执行command, 输出逐行记录到日志, 命令返回非0时返回包含stderr的错误
+func RunShell(ctx context.Context, command string) (*CommandResult, error) {
+	log := logrus.WithField("command", command)
+	result, err := RunCommand(ctx, exec.Command("/bin/bash", "-c", command), func(stream, line string) {
+		log.Infof("%s: %s", stream, line)
+	})
+	if err != nil {
+		log.Warnf("run command failed, err=%v", err)
+		return result, err
+	}
+	log.Infof("command exited with %d after %s", result.ExitCode, result.Duration)
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("command %q exited with %d: %s", command, result.ExitCode, strings.TrimSpace(result.Stderr))
+	}
+	return result, nil
+}
+
+func RunLinuxCommand(command string) (string, string, error) {
+	result, err := RunShell(context.Background(), command)
+	if err != nil {
+		return "", "", err
+	}
+	return result.Stdout, result.Stderr, nil
 }
 
 func RunLinuxCommands(returnStderr bool, commands ...string) error {
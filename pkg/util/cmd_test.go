@@ -0,0 +1,92 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      string
+		timeout      time.Duration
+		wantExitCode int
+		wantLines    []string
+		wantErr      error
+	}{
+		{
+			name:      "stream lines",
+			command:   "echo one; echo two >&2; printf three",
+			wantLines: []string{"stdout: one", "stderr: two", "stdout: three"},
+		},
+		{
+			name:         "exit code",
+			command:      "echo failed >&2; exit 3",
+			wantExitCode: 3,
+			wantLines:    []string{"stderr: failed"},
+		},
+		{
+			//后台的子进程也要被杀死, 否则会一直持有pipe
+			name:         "kill process group on timeout",
+			command:      "sleep 10 & echo started; wait",
+			timeout:      200 * time.Millisecond,
+			wantExitCode: -1,
+			wantLines:    []string{"stdout: started"},
+			wantErr:      context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			var lines []string
+			start := time.Now()
+			result, err := RunCommand(ctx, exec.Command("/bin/sh", "-c", tt.command), func(stream, line string) {
+				lines = append(lines, stream+": "+line)
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Errorf("command is not killed in time")
+			}
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("exit code = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			//stdout和stderr之间的顺序不确定, 只比较各自的行
+			if !reflect.DeepEqual(filterLines(lines, "stdout"), filterLines(tt.wantLines, "stdout")) ||
+				!reflect.DeepEqual(filterLines(lines, "stderr"), filterLines(tt.wantLines, "stderr")) {
+				t.Errorf("lines = %q, want %q", lines, tt.wantLines)
+			}
+		})
+	}
+}
+
+func filterLines(lines []string, stream string) []string {
+	var filtered []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, stream+":") {
+			filtered = append(filtered, line)
+		}
+	}
+	return filtered
+}
+
+func TestRunShell(t *testing.T) {
+	result, err := RunShell(context.Background(), "echo ok")
+	if err != nil || result.Stdout != "ok\n" {
+		t.Errorf("result = %+v, err = %v", result, err)
+	}
+	if _, err := RunShell(context.Background(), "echo bad >&2; exit 1"); err == nil {
+		t.Errorf("want error for non-zero exit code")
+	}
+}